@@ -5,6 +5,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"sso/internal/domain/models"
 	"sso/internal/storage/sqlite"
 
 	"github.com/golang-migrate/migrate/v4"
@@ -54,6 +55,11 @@ func main() {
 		panic(err)
 	}
 
+	// seeded admin is saved after migrations, so it gets its role here rather than from the admins table
+	if err := storage.AssignRole(context.Background(), "admin", 0, models.RoleSuperadmin); err != nil {
+		panic(err)
+	}
+
 	fmt.Println("migrations applied successfully")
 }
 
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"net/http"
 	"sso/internal/config"
+	"sso/internal/http/handler/oauth"
 
 	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/status"
 
 	gw "github.com/dedmouze/protos/gen/go/sso"
 )
@@ -24,7 +26,7 @@ func run() error {
 	grpcServerEndpoint := flag.String("grpc-server-endpoint", fmt.Sprintf("localhost:%v", cfg.GRPC.Port), "gRPC server endpoint")
 	flag.Parse()
 
-	mux := runtime.NewServeMux()
+	mux := runtime.NewServeMux(runtime.WithErrorHandler(errorHandler))
 	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
 
 	err := gw.RegisterAuthHandlerFromEndpoint(ctx, mux, *grpcServerEndpoint, opts)
@@ -39,10 +41,31 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	err = gw.RegisterRelationHandlerFromEndpoint(ctx, mux, *grpcServerEndpoint, opts)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(*grpcServerEndpoint, opts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = oauth.New(gw.NewAuthClient(conn), cfg.JWT.Issuer).Register(mux)
+	if err != nil {
+		return err
+	}
 
 	return http.ListenAndServe(fmt.Sprintf(":%v", cfg.HTTP.Port), mux)
 }
 
+// errorHandler is the default gateway error handler, which also tells throttled clients when to retry
+func errorHandler(ctx context.Context, mux *runtime.ServeMux, marshaler runtime.Marshaler, w http.ResponseWriter, r *http.Request, err error) {
+	oauth.SetRetryAfter(w, status.Convert(err))
+	runtime.DefaultHTTPErrorHandler(ctx, mux, marshaler, w, r, err)
+}
+
 func main() {
 	if err := run(); err != nil {
 		grpclog.Fatal(err)
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"os/signal"
@@ -24,17 +25,22 @@ func main() {
 	log.Info("starting SSO", slog.String("env", cfg.Env), slog.String("version", "1"))
 	log.Debug("debug messages are enabled")
 
-	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL, scr.UserKey)
+	application := app.New(log, cfg.GRPC.Port, cfg.StoragePath, cfg.TokenTTL, cfg.RefreshTokenTTL, cfg.JWT, cfg.MFA, cfg.Passkey, cfg.Mail, cfg.Argon2, cfg.Apps, cfg.Lockout, cfg.PasswordPolicy, cfg.Authz, cfg.Relations, cfg.Policies, scr.SigningKeyPath, scr.SMTPPassword, scr.MailSigningKey, scr.PasswordPepper)
 	go func() {
 		application.GRPCServer.MustRun()
 	}()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	go application.Keys.Run(ctx)
+	go application.Policies.Run(ctx)
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
 
 	sign := <-stop
 	log.Info("stopping application", slog.String("signal", sign.String()))
 
+	cancel()
 	application.GRPCServer.Stop()
 
 	log.Info("application stopped")
@@ -1,18 +1,34 @@
 package app
 
 import (
+	"context"
+	"crypto/rand"
 	"log/slog"
 	"time"
 
 	"sso/internal/app/grpcapp"
+	"sso/internal/config"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/passhash"
+	"sso/internal/lib/passpolicy"
+	"sso/internal/lib/policy"
+	"sso/internal/lib/rebac"
 	"sso/internal/service/auth"
+	"sso/internal/service/keys"
 	"sso/internal/service/permission"
+	"sso/internal/service/relation"
 	"sso/internal/service/userInfo"
 	"sso/internal/storage/sqlite"
+
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 type App struct {
 	GRPCServer *grpcapp.App
+	Keys       *keys.Keys
+	Policies   *policy.Engine
 }
 
 func New(
@@ -20,19 +36,187 @@ func New(
 	grpcPort int,
 	storagePath string,
 	tokenTTL time.Duration,
-	userKey string,
+	refreshTTL time.Duration,
+	jwtConfig config.JWTConfig,
+	mfaConfig config.MFAConfig,
+	passkeyConfig config.PasskeyConfig,
+	mailConfig config.MailConfig,
+	argon2Config config.Argon2Config,
+	appsConfig config.AppsConfig,
+	lockoutConfig config.LockoutConfig,
+	policyConfig config.PolicyConfig,
+	authzConfig config.AuthzConfig,
+	relationsConfig config.RelationsConfig,
+	policiesConfig config.PoliciesConfig,
+	signingKeyPath string,
+	smtpPassword string,
+	mailSigningKey string,
+	passwordPepper string,
 ) *App {
 	storage, err := sqlite.New(storagePath)
 	if err != nil {
 		panic(err)
 	}
 
-	authService := auth.New(log, storage, storage, storage, storage, storage, tokenTTL, userKey)
+	keyRing := jwt.NewKeyRing()
+	keysService := keys.New(log, storage, storage, keyRing, jwtConfig.Algorithm, jwtConfig.RotationPeriod, jwtConfig.Overlap)
+	if err := keysService.Init(context.Background(), signingKeyPath); err != nil {
+		panic(err)
+	}
+
+	// tokens issued for any app are accepted by the SSO itself,
+	// introspection requires the audience of the calling app
+	tokenParams := jwt.Params{
+		Issuer: jwtConfig.Issuer,
+		Leeway: jwtConfig.Leeway,
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          passkeyConfig.RPID,
+		RPDisplayName: passkeyConfig.RPDisplayName,
+		RPOrigins:     passkeyConfig.RPOrigins,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	verification := auth.VerificationParams{
+		URL:      mailConfig.VerificationURL,
+		TTL:      mailConfig.VerificationTTL,
+		Key:      []byte(mailSigningKey),
+		Required: mailConfig.RequireVerified,
+	}
+	if mailSigningKey == "" {
+		log.Warn("mail signing key isn't set, emailed links won't survive restart")
+
+		verification.Key = make([]byte, 32)
+		if _, err := rand.Read(verification.Key); err != nil {
+			panic(err)
+		}
+	}
+
+	passwordReset := auth.PasswordResetParams{
+		URL: mailConfig.ResetURL,
+		TTL: mailConfig.ResetTTL,
+	}
+
+	passwordHasher := passhash.New(passhash.Params{
+		Memory:  argon2Config.Memory,
+		Time:    argon2Config.Time,
+		Threads: argon2Config.Threads,
+	}, []byte(passwordPepper))
+
+	lockout := auth.LockoutParams{
+		MaxFailures:   lockoutConfig.MaxFailures,
+		IPMaxFailures: lockoutConfig.IPMaxFailures,
+		BaseDelay:     lockoutConfig.BaseDelay,
+		Duration:      lockoutConfig.Duration,
+		Window:        lockoutConfig.Window,
+		Conceal:       lockoutConfig.Conceal,
+	}
+
+	trustedProxies, err := clientip.ParsePrefixes(lockoutConfig.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+
+	passwordPolicy := newPasswordPolicy(log, policyConfig)
+
+	authService := auth.New(log, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, tokenTTL, refreshTTL, keyRing, tokenParams, mfaConfig.Issuer, webAuthn, newMailer(log, mailConfig, smtpPassword), verification, passwordReset, passwordHasher, lockout, passwordPolicy, appsConfig.Scopes)
 	userInfoService := userInfo.New(log, storage)
-	permissionService := permission.New(log, storage, storage)
+	permissionService := permission.New(log, storage, storage, storage, storage, storage, storage, storage, storage, storage, storage, tokenTTL, jwtConfig.Leeway, permission.CacheParams{
+		TTL:  authzConfig.CacheTTL,
+		Size: authzConfig.CacheSize,
+	})
+
+	relationService := relation.New(log, storage, storage, newSchema(log, relationsConfig), relation.CacheParams{
+		TTL:  relationsConfig.CacheTTL,
+		Size: relationsConfig.CacheSize,
+	})
+
+	policies := newPolicyEngine(log, policiesConfig)
 
-	grpcApp := grpcapp.New(log, authService, userInfoService, permissionService, storage, grpcPort, userKey)
+	grpcApp := grpcapp.New(log, authService, keysService, userInfoService, permissionService, relationService, storage, storage, grpcPort, keyRing, tokenParams, trustedProxies, policies)
 	return &App{
 		GRPCServer: grpcApp,
+		Keys:       keysService,
+		Policies:   policies,
+	}
+}
+
+// newPasswordPolicy returns password policy of config, breached passwords are loaded if path is set
+func newPasswordPolicy(log *slog.Logger, cfg config.PolicyConfig) *passpolicy.Policy {
+	var breached *passpolicy.Breached
+	if cfg.BreachedPath != "" {
+		var err error
+		breached, err = passpolicy.LoadBreached(cfg.BreachedPath)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		log.Warn("breached passwords path isn't set, passwords aren't checked against breaches")
+	}
+
+	return passpolicy.New(passpolicy.Params{
+		MinLength:     cfg.MinLength,
+		MaxLength:     cfg.MaxLength,
+		RequireLower:  cfg.RequireLower,
+		RequireUpper:  cfg.RequireUpper,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+		AllowEmail:    cfg.AllowEmail,
+		MinScore:      cfg.MinScore,
+	}, breached)
+}
+
+// newSchema returns namespace config of relation tuples, it's empty if path isn't set
+func newSchema(log *slog.Logger, cfg config.RelationsConfig) *rebac.Schema {
+	if cfg.NamespacesPath == "" {
+		log.Warn("namespaces path isn't set, relation tuples can't be written")
+		schema, _ := rebac.Parse("")
+		return schema
+	}
+
+	schema, err := rebac.Load(cfg.NamespacesPath)
+	if err != nil {
+		panic(err)
+	}
+	log.Info("namespace config loaded", slog.Int("namespaces", schema.Namespaces()))
+
+	return schema
+}
+
+// newPolicyEngine returns engine of policies of config, it has no policies if paths aren't set
+func newPolicyEngine(log *slog.Logger, cfg config.PoliciesConfig) *policy.Engine {
+	location, err := time.LoadLocation(cfg.Location)
+	if err != nil {
+		panic(err)
+	}
+
+	engine, err := policy.NewEngine(log, policy.Params{
+		Paths:          cfg.Paths,
+		ReloadInterval: cfg.ReloadInterval,
+		DryRun:         cfg.DryRun,
+		Explain:        cfg.Explain,
+		Location:       location,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return engine
+}
+
+// newMailer returns mail sender chosen by config
+func newMailer(log *slog.Logger, cfg config.MailConfig, smtpPassword string) auth.Mailer {
+	switch cfg.Sender {
+	case "smtp":
+		return mail.NewSMTP(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, smtpPassword, cfg.From)
+	case "file":
+		return mail.NewFile(cfg.Path, cfg.From)
+	case "log":
+		return mail.NewLog(log)
+	default:
+		panic("unknown mail sender: " + cfg.Sender)
 	}
 }
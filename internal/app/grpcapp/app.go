@@ -4,12 +4,16 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 
 	"sso/internal/grpc/handler/auth"
 	"sso/internal/grpc/handler/permission"
+	"sso/internal/grpc/handler/relation"
 	"sso/internal/grpc/handler/userInfo"
 	authInterceptor "sso/internal/grpc/interceptor/auth"
+	"sso/internal/grpc/interceptor/clientip"
 	"sso/internal/grpc/interceptor/validation"
+	"sso/internal/lib/jwt"
 
 	"google.golang.org/grpc"
 )
@@ -23,16 +27,23 @@ type App struct {
 func New(
 	log *slog.Logger,
 	authService auth.Auth,
+	keysService auth.Keys,
 	userInfoService userInfo.UserInfo,
 	permissionService permission.Permission,
+	relationService relation.Relation,
 	appProvider authInterceptor.AppProvider,
+	tokenProvider authInterceptor.TokenProvider,
 	port int,
-	userKey string,
+	keys *jwt.KeyRing,
+	tokenParams jwt.Params,
+	trustedProxies []netip.Prefix,
+	policies authInterceptor.PolicyEvaluator,
 ) *App {
 	gRPCServer := grpc.NewServer(
 		grpc.ChainUnaryInterceptor(
+			clientip.UnaryClientIPInterceptor(trustedProxies),
 			validation.UnaryValidationInterceptor(log),
-			authInterceptor.UnaryAuthenticationInterceptor(log, appProvider, userKey),
+			authInterceptor.UnaryAuthenticationInterceptor(log, appProvider, tokenProvider, keys, tokenParams, policies),
 		),
 	)
 
@@ -41,9 +52,10 @@ func New(
 	// 	grpc.UnaryInterceptor(validation.UnaryValidationInterceptor(log)),
 	// )
 
-	auth.Register(gRPCServer, authService)
+	auth.Register(gRPCServer, authService, keysService)
 	userInfo.Register(gRPCServer, userInfoService)
 	permission.Register(gRPCServer, permissionService)
+	relation.Register(gRPCServer, relationService)
 
 	return &App{
 		log:        log,
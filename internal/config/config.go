@@ -11,11 +11,151 @@ import (
 )
 
 type Config struct {
-	Env         string        `yaml:"env" env-default:"local"`
-	StoragePath string        `yaml:"storage_path" env-required:"true"`
-	TokenTTL    time.Duration `yaml:"token_ttl" env-required:"true"`
-	GRPC        gRPCConfig    `yaml:"grpc"`
-	HTTP        HTTPServer    `yaml:"http"`
+	Env             string          `yaml:"env" env-default:"local"`
+	StoragePath     string          `yaml:"storage_path" env-required:"true"`
+	TokenTTL        time.Duration   `yaml:"token_ttl" env-required:"true"`
+	RefreshTokenTTL time.Duration   `yaml:"refresh_token_ttl" env-default:"720h"`
+	JWT             JWTConfig       `yaml:"jwt"`
+	MFA             MFAConfig       `yaml:"mfa"`
+	Passkey         PasskeyConfig   `yaml:"passkey"`
+	Mail            MailConfig      `yaml:"mail"`
+	Argon2          Argon2Config    `yaml:"argon2"`
+	Apps            AppsConfig      `yaml:"apps"`
+	Lockout         LockoutConfig   `yaml:"lockout"`
+	PasswordPolicy  PolicyConfig    `yaml:"password_policy"`
+	Authz           AuthzConfig     `yaml:"authz"`
+	Relations       RelationsConfig `yaml:"relations"`
+	Policies        PoliciesConfig  `yaml:"policies"`
+	GRPC            gRPCConfig      `yaml:"grpc"`
+	HTTP            HTTPServer      `yaml:"http"`
+}
+
+type JWTConfig struct {
+	// Algorithm is used to generate new signing keys
+	Algorithm string `yaml:"algorithm" env-default:"RS256"`
+	// RotationPeriod is how long a key stays active, zero disables scheduled rotation
+	RotationPeriod time.Duration `yaml:"rotation_period" env-default:"720h"`
+	// Overlap is how long a rotated key is still accepted, should be no less than token_ttl
+	Overlap time.Duration `yaml:"overlap" env-default:"1h"`
+	// Issuer is stamped into tokens and enforced on parsing
+	Issuer string `yaml:"issuer" env-default:"sso"`
+	// Leeway is allowed clock skew when validating exp, nbf and iat
+	Leeway time.Duration `yaml:"leeway" env-default:"30s"`
+}
+
+type MFAConfig struct {
+	// Issuer is the account issuer shown by authenticator apps
+	Issuer string `yaml:"issuer" env-default:"sso"`
+}
+
+type PasskeyConfig struct {
+	// RPID is domain passkeys are bound to, it can't be changed without losing registered passkeys
+	RPID string `yaml:"rp_id" env-default:"localhost"`
+	// RPDisplayName is shown by authenticators when passkey is created
+	RPDisplayName string `yaml:"rp_display_name" env-default:"SSO"`
+	// RPOrigins are origins of pages allowed to run passkey ceremonies
+	RPOrigins []string `yaml:"rp_origins" env-default:"http://localhost:8089"`
+}
+
+type MailConfig struct {
+	// Sender is smtp, file or log, file and log senders are meant for development
+	Sender string `yaml:"sender" env-default:"log"`
+	// From is address emails are sent from
+	From string `yaml:"from" env-default:"sso@localhost"`
+	// Path is file emails are appended to by file sender
+	Path string     `yaml:"path" env-default:"./storage/mail.log"`
+	SMTP SMTPConfig `yaml:"smtp"`
+	// VerificationURL is sent to verify email, it's VerifyEmail endpoint of the proxy
+	VerificationURL string        `yaml:"verification_url" env-default:"http://localhost:8089/email/verify"`
+	VerificationTTL time.Duration `yaml:"verification_ttl" env-default:"24h"`
+	// RequireVerified blocks login until email is verified
+	RequireVerified bool `yaml:"require_verified" env-default:"false"`
+	// ResetURL is sent to reset password, it's password reset page of the proxy
+	ResetURL string        `yaml:"reset_url" env-default:"http://localhost:8089/reset_password"`
+	ResetTTL time.Duration `yaml:"reset_ttl" env-default:"30m"`
+}
+
+type SMTPConfig struct {
+	Host string `yaml:"host" env-default:"localhost"`
+	Port int    `yaml:"port" env-default:"25"`
+	// Username is optional, password is read from SMTP_PASSWORD env
+	Username string `yaml:"username"`
+}
+
+// Argon2Config is parameters of new password hashes, hashes with other params are replaced on login
+type Argon2Config struct {
+	// Memory is in KiB
+	Memory  uint32 `yaml:"memory" env-default:"65536"`
+	Time    uint32 `yaml:"time" env-default:"3"`
+	Threads uint8  `yaml:"threads" env-default:"4"`
+}
+
+// AppsConfig is registration of apps
+type AppsConfig struct {
+	// Scopes are scopes apps may request on registration, which is open to anyone, so it's empty by default
+	Scopes []string `yaml:"scopes"`
+}
+
+// LockoutConfig is throttling of failed logins by account and client IP
+type LockoutConfig struct {
+	MaxFailures   int `yaml:"max_failures" env-default:"5"`
+	IPMaxFailures int `yaml:"ip_max_failures" env-default:"50"`
+	// BaseDelay is how long account is blocked after the first failure, it doubles with each next one
+	BaseDelay time.Duration `yaml:"base_delay" env-default:"1s"`
+	Duration  time.Duration `yaml:"duration" env-default:"15m"`
+	// Window is how long failures are remembered since the last one
+	Window time.Duration `yaml:"window" env-default:"15m"`
+	// Conceal reports throttled logins as invalid credentials instead of revealing lockout
+	Conceal bool `yaml:"conceal" env-default:"false"`
+	// TrustedProxies are addresses or CIDR prefixes X-Forwarded-For is accepted from
+	TrustedProxies []string `yaml:"trusted_proxies" env-default:"127.0.0.1/8,::1/128"`
+}
+
+// PolicyConfig is password policy new passwords must follow
+type PolicyConfig struct {
+	MinLength int `yaml:"min_length" env-default:"8"`
+	// MaxLength bounds work spent on hashing and scoring
+	MaxLength     int  `yaml:"max_length" env-default:"128"`
+	RequireLower  bool `yaml:"require_lower" env-default:"false"`
+	RequireUpper  bool `yaml:"require_upper" env-default:"false"`
+	RequireDigit  bool `yaml:"require_digit" env-default:"false"`
+	RequireSymbol bool `yaml:"require_symbol" env-default:"false"`
+	// AllowEmail permits email or its local part as password
+	AllowEmail bool `yaml:"allow_email" env-default:"false"`
+	// MinScore is minimal strength score from 0 to 4, estimated like zxcvbn does
+	MinScore int `yaml:"min_score" env-default:"2"`
+	// BreachedPath is optional file of SHA-1 hashes of breached passwords from Pwned Passwords
+	BreachedPath string `yaml:"breached_path"`
+}
+
+// AuthzConfig is caching of permission check decisions
+type AuthzConfig struct {
+	// CacheTTL bounds how long a decision may outlive change made on other instance, zero disables the cache
+	CacheTTL  time.Duration `yaml:"cache_ttl" env-default:"30s"`
+	CacheSize int           `yaml:"cache_size" env-default:"10000"`
+}
+
+// RelationsConfig is namespace config of relation tuples and caching of their checks
+type RelationsConfig struct {
+	// NamespacesPath is file with namespace config, without it no tuples can be written
+	NamespacesPath string `yaml:"namespaces_path"`
+	// CacheTTL bounds staleness of checks made without consistency token, zero disables the cache
+	CacheTTL  time.Duration `yaml:"cache_ttl" env-default:"10s"`
+	CacheSize int           `yaml:"cache_size" env-default:"10000"`
+}
+
+// PoliciesConfig is attribute-based access policies, evaluated for every request after authentication
+type PoliciesConfig struct {
+	// Paths are YAML files with policies, policies of all of them apply
+	Paths []string `yaml:"paths"`
+	// ReloadInterval is how often files are checked for changes, zero disables reloading
+	ReloadInterval time.Duration `yaml:"reload_interval" env-default:"10s"`
+	// DryRun logs requests policies would deny instead of denying them
+	DryRun bool `yaml:"dry_run" env-default:"false"`
+	// Explain logs result of every policy evaluated for a request
+	Explain bool `yaml:"explain" env-default:"false"`
+	// Location is time zone of the now variable of conditions, like Europe/Moscow
+	Location string `yaml:"location" env-default:"UTC"`
 }
 
 type gRPCConfig struct {
@@ -28,7 +168,13 @@ type HTTPServer struct {
 }
 
 type Secret struct {
-	UserKey string `env:"USER_KEY" env-required:"true"`
+	// SigningKeyPath is an optional PEM key imported as the first signing key
+	SigningKeyPath string `env:"SIGNING_KEY_PATH"`
+	SMTPPassword   string `env:"SMTP_PASSWORD"`
+	// MailSigningKey signs links sent by email, if it's empty random key is used, so links don't survive restart
+	MailSigningKey string `env:"MAIL_SIGNING_KEY"`
+	// PasswordPepper is an optional key of password hashes, hashes with other pepper can't be verified
+	PasswordPepper string `env:"PASSWORD_PEPPER"`
 }
 
 func MustLoad() (*Config, *Secret) {
@@ -43,7 +189,7 @@ func MustLoad() (*Config, *Secret) {
 
 	scr := &Secret{}
 	if err := cleanenv.ReadEnv(scr); err != nil {
-		log.Fatalf("failed to get user key env")
+		log.Fatalf("failed to get secret env")
 	}
 
 	return MustLoadConfigPath(configPath), scr
@@ -1,7 +1,16 @@
 package models
 
+import "slices"
+
+// Admin is a user with at least one role, ID is ID of the user
 type Admin struct {
-	ID    int64
-	Email string
-	Level int8
+	ID          int64
+	Email       string
+	Roles       []string
+	Permissions []string
+}
+
+// HasPermission reports whether any role of the admin grants the permission
+func (a Admin) HasPermission(permission string) bool {
+	return slices.Contains(a.Permissions, permission)
 }
@@ -1,7 +1,23 @@
 package models
 
+import (
+	"strconv"
+	"time"
+)
+
 type App struct {
 	ID     int
 	Name   string
 	ApiKey string
+	// TokenTTL overrides default lifetime of tokens issued for the app, zero means default
+	TokenTTL time.Duration
+	// SecretHash is a hash of the client secret, empty if app has no secret
+	SecretHash string
+	// Scopes can be granted to the app by the client_credentials grant
+	Scopes []string
+}
+
+// ClientID returns identifier of the app used as token audience
+func (a App) ClientID() string {
+	return strconv.Itoa(a.ID)
 }
@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// AuthCode is a single-use OAuth 2.0 authorization code, user is authenticated when it's created
+//
+// Refresh tokens issued for the code start Family, so they can be revoked if the code is reused
+type AuthCode struct {
+	ID            int64
+	Hash          string
+	Family        string
+	AppID         int
+	UserID        int64
+	RedirectURI   string
+	CodeChallenge string
+	Scope         string
+	Nonce         string
+	ExpiresAt     time.Time
+	Used          bool
+	CreatedAt     time.Time
+}
+
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a pending authorization of OAuth 2.0 device authorization grant
+//
+// Device polls for tokens with the device code while the user approves or denies it
+// on the verification page by the user code. UserID and ApprovedAt are set once the user responds
+type DeviceCode struct {
+	ID           int64
+	Hash         string
+	UserCode     string
+	AppID        int
+	Scope        string
+	Status       string
+	UserID       int64
+	ApprovedAt   time.Time
+	Interval     time.Duration
+	LastPolledAt time.Time
+	ExpiresAt    time.Time
+	CreatedAt    time.Time
+}
+
+// DeviceAuthorization is returned to the device that starts device authorization grant
+type DeviceAuthorization struct {
+	DeviceCode string
+	UserCode   string
+	ExpiresIn  time.Duration
+	Interval   time.Duration
+}
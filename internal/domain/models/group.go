@@ -0,0 +1,42 @@
+package models
+
+// Group is a set of users in namespace of the app, members hold roles assigned to the group
+type Group struct {
+	ID          int64
+	AppID       int
+	Name        string
+	Description string
+}
+
+// ResourceGrant gives permission on a single resource to either a user by Email or a Group
+type ResourceGrant struct {
+	AppID      int
+	Permission string
+	Resource   string
+	Email      string
+	Group      string
+}
+
+// Grant is a way the user holds a permission, as found by permission check
+type Grant struct {
+	// Role gives the permission, it's empty for resource grants
+	Role string
+	// Group is what the role or resource grant is held through, it's empty for direct ones
+	Group string
+	// Resource is the resource of resource grant, it's empty for roles
+	Resource string
+}
+
+// Check asks whether Subject, that is ID of the user, has Permission, optionally on Resource
+type Check struct {
+	AppID      int
+	Subject    string
+	Permission string
+	Resource   string
+}
+
+// Decision is result of permission check, Reason explains it to humans
+type Decision struct {
+	Allowed bool
+	Reason  string
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey []byte
+	State      string
+	CreatedAt  time.Time
+	RotatedAt  time.Time
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// MFA is TOTP second factor of the user, it's enabled once the first code is confirmed
+//
+// LastStep is time step of the last accepted code, codes of the same or earlier steps are rejected
+type MFA struct {
+	UserID    int64
+	Secret    string
+	Enabled   bool
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+// MFAChallenge is issued by Login to user with MFA enabled and is exchanged for tokens with TOTP or recovery code
+type MFAChallenge struct {
+	ID        int64
+	Hash      string
+	UserID    int64
+	AppID     int
+	Attempts  int
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// RecoveryCode is a single-use code that replaces TOTP code, only its salted hash is stored
+type RecoveryCode struct {
+	ID   int64
+	Hash string
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// Passkey ceremonies, session of one ceremony can't be finished as another
+const (
+	PasskeyRegistration = "registration"
+	PasskeyLogin        = "login"
+)
+
+// Passkey is WebAuthn credential of the user
+//
+// SignCount is signature counter reported by the authenticator on the last use,
+// authenticators that don't implement it always report zero
+type Passkey struct {
+	ID              int64
+	UserID          int64
+	CredentialID    []byte
+	PublicKey       []byte
+	AttestationType string
+	AAGUID          []byte
+	SignCount       uint32
+	Transports      []string
+	BackupEligible  bool
+	BackupState     bool
+	CreatedAt       time.Time
+	LastUsedAt      time.Time
+}
+
+// PasskeySession keeps state of WebAuthn ceremony between its begin and finish requests
+//
+// UserID is zero for passwordless login until the passkey identifies the user,
+// MFAChallengeID is set when passkey is used as second factor
+type PasskeySession struct {
+	ID             int64
+	Hash           string
+	Ceremony       string
+	UserID         int64
+	AppID          int
+	MFAChallengeID int64
+	Data           []byte
+	ExpiresAt      time.Time
+	CreatedAt      time.Time
+}
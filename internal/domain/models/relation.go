@@ -0,0 +1,25 @@
+package models
+
+// Subject is a user, like user:2, or, with Relation, a set of subjects holding the relation
+// of other object, like group:eng#member
+type Subject struct {
+	Namespace string
+	ID        string
+	Relation  string
+}
+
+// RelationTuple states that Subject holds Relation of the object ObjectID of Namespace
+type RelationTuple struct {
+	Namespace string
+	ObjectID  string
+	Relation  string
+	Subject   Subject
+}
+
+// TupleFilter selects relation tuples of Namespace, empty fields match anything
+type TupleFilter struct {
+	Namespace string
+	ObjectID  string
+	Relation  string
+	Subject   Subject
+}
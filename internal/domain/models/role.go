@@ -0,0 +1,57 @@
+package models
+
+// Permissions checked by the service itself, other permissions are meaningful only to apps
+const (
+	PermissionUsersRead        = "users:read"
+	PermissionUsersUnlock      = "users:unlock"
+	PermissionUsersImpersonate = "users:impersonate"
+	PermissionMFAReset         = "mfa:reset"
+	PermissionKeysRotate       = "keys:rotate"
+	PermissionAdminsManage     = "admins:manage"
+	PermissionRolesManage      = "roles:manage"
+)
+
+// Roles seeded by migration, RoleAdmin is role granted by AddAdmin and RoleSuperadmin holds every built-in permission
+const (
+	RoleAdmin      = "admin"
+	RoleSuperadmin = "superadmin"
+)
+
+// IsBuiltInRole reports whether the role is seeded and relied on by the service, such roles can't be deleted
+func IsBuiltInRole(name string) bool {
+	return name == RoleAdmin || name == RoleSuperadmin
+}
+
+// IsBuiltInPermission reports whether the service itself checks the permission, such permissions can't be deleted
+func IsBuiltInPermission(name string) bool {
+	switch name {
+	case PermissionUsersRead, PermissionUsersUnlock, PermissionUsersImpersonate, PermissionMFAReset,
+		PermissionKeysRotate, PermissionAdminsManage, PermissionRolesManage:
+		return true
+	}
+	return false
+}
+
+// Role is a named set of permissions assigned to users. Roles of the service itself have zero AppID,
+// others are defined by the app and granted only permissions of the app
+type Role struct {
+	ID          int64
+	AppID       int
+	Name        string
+	Description string
+	Permissions []string
+}
+
+type Permission struct {
+	ID          int64
+	AppID       int
+	Name        string
+	Description string
+}
+
+// AppRoles are roles of the user defined by the app and permissions granted by them
+type AppRoles struct {
+	AppID       int
+	Roles       []string
+	Permissions []string
+}
@@ -0,0 +1,66 @@
+package models
+
+import "time"
+
+type RefreshToken struct {
+	ID        int64
+	Hash      string
+	Family    string
+	UserID    int64
+	AppID     int
+	Scope     string
+	ExpiresAt time.Time
+	Used      bool
+	Revoked   bool
+	CreatedAt time.Time
+}
+
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// Tokens are issued by the token endpoint
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	IDToken      string
+	ExpiresIn    time.Duration
+	Scope        string
+}
+
+// TokenInfo is a result of token introspection
+type TokenInfo struct {
+	Active    bool
+	TokenType string
+	ID        string
+	Subject   string
+	Email     string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Roles     []string
+	// Permissions are granted by Roles
+	Permissions []string
+	ClientID    string
+	Scope       string
+	// AppRoles and AppPermissions are defined by the app in ClientID
+	AppRoles       []string
+	AppPermissions []string
+}
+
+// TokenExchange is an audit record of token issued by token exchange
+//
+// ActorSubject is subject of the actor token, or client_id of the requesting app if there was none.
+// Impersonation is set when an admin obtained the token without the user's token
+type TokenExchange struct {
+	ID            int64
+	TokenID       string
+	ClientID      string
+	Audience      string
+	UserID        int64
+	ActorSubject  string
+	Scope         string
+	Impersonation bool
+	ExpiresAt     time.Time
+	CreatedAt     time.Time
+}
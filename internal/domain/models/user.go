@@ -1,11 +1,44 @@
 package models
 
-import "time"
+import (
+	"strings"
+	"time"
+)
 
 type User struct {
+	ID            int64
+	Email         string
+	PassHash      []byte
+	EmailVerified bool
+	CreatedAt     time.Time
+	VisitedAt     time.Time
+}
+
+// PasswordReset is issued to the owner of email and is exchanged for a new password once
+type PasswordReset struct {
 	ID        int64
-	Email     string
-	PassHash  []byte
+	Hash      string
+	UserID    int64
+	ExpiresAt time.Time
 	CreatedAt time.Time
-	VisitedAt time.Time
+}
+
+// LoginAttempts counts recent failed logins by account or client IP, see LoginAttemptsKey
+//
+// Failures are forgotten at ExpiresAt, logins by the key are rejected until BlockedUntil
+type LoginAttempts struct {
+	Key          string
+	Failures     int
+	BlockedUntil time.Time
+	ExpiresAt    time.Time
+}
+
+// LoginAttemptsKey returns key of failed logins with given email
+func LoginAttemptsKey(email string) string {
+	return "email:" + strings.ToLower(email)
+}
+
+// LoginAttemptsIPKey returns key of failed logins from given client IP
+func LoginAttemptsIPKey(ip string) string {
+	return "ip:" + ip
 }
@@ -2,14 +2,26 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"time"
 
+	"sso/internal/domain/models"
+	authInterceptor "sso/internal/grpc/interceptor/auth"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/oauth"
 	"sso/internal/service"
 
 	ssov1 "github.com/dedmouze/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/api/httpbody"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 type Auth interface {
@@ -17,7 +29,23 @@ type Auth interface {
 		ctx context.Context,
 		email string,
 		password string,
-	) (token string, err error)
+		appID int,
+	) (token, refreshToken, mfaToken string, err error)
+	Refresh(
+		ctx context.Context,
+		refreshToken string,
+	) (token, newRefreshToken string, err error)
+	Logout(
+		ctx context.Context,
+		token *jwt.Token,
+		refreshToken string,
+	) error
+	Introspect(
+		ctx context.Context,
+		app models.App,
+		token string,
+		tokenTypeHint string,
+	) (info models.TokenInfo, err error)
 	RegisterNewUser(
 		ctx context.Context,
 		email string,
@@ -26,32 +54,634 @@ type Auth interface {
 	RegisterNewApp(
 		ctx context.Context,
 		name string,
-	) (apiKey, userKey string, err error)
+		tokenTTL time.Duration,
+		redirectURIs []string,
+		scopes []string,
+	) (app models.App, clientSecret string, err error)
+	Authorize(
+		ctx context.Context,
+		email string,
+		password string,
+		clientID string,
+		redirectURI string,
+		codeChallenge string,
+		scope string,
+		nonce string,
+		mfaCode string,
+	) (code string, err error)
+	ExchangeCode(
+		ctx context.Context,
+		code string,
+		clientID string,
+		redirectURI string,
+		codeVerifier string,
+	) (tokens models.Tokens, err error)
+	ClientCredentials(
+		ctx context.Context,
+		clientID string,
+		clientSecret string,
+		scope string,
+	) (tokens models.Tokens, err error)
+	DeviceAuthorize(
+		ctx context.Context,
+		clientID string,
+		scope string,
+	) (authorization models.DeviceAuthorization, err error)
+	VerifyDevice(
+		ctx context.Context,
+		email string,
+		password string,
+		userCode string,
+		approve bool,
+		mfaCode string,
+	) error
+	ExchangeDeviceCode(
+		ctx context.Context,
+		deviceCode string,
+		clientID string,
+	) (tokens models.Tokens, err error)
+	ExchangeToken(
+		ctx context.Context,
+		clientID string,
+		clientSecret string,
+		subjectToken string,
+		subjectTokenType string,
+		actorToken string,
+		audience string,
+		scope string,
+	) (tokens models.Tokens, err error)
+	EnrollMFA(
+		ctx context.Context,
+		token *jwt.Token,
+	) (secret, uri string, err error)
+	ConfirmMFA(
+		ctx context.Context,
+		token *jwt.Token,
+		code string,
+	) (recoveryCodes []string, err error)
+	VerifyMFA(
+		ctx context.Context,
+		mfaToken string,
+		code string,
+	) (token, refreshToken string, err error)
+	BeginPasskeyRegistration(
+		ctx context.Context,
+		token *jwt.Token,
+	) (sessionID string, options []byte, err error)
+	FinishPasskeyRegistration(
+		ctx context.Context,
+		token *jwt.Token,
+		sessionID string,
+		credential []byte,
+	) error
+	BeginPasskeyLogin(
+		ctx context.Context,
+		appID int,
+		mfaToken string,
+	) (sessionID string, options []byte, err error)
+	FinishPasskeyLogin(
+		ctx context.Context,
+		sessionID string,
+		credential []byte,
+	) (token, refreshToken string, err error)
+	VerifyEmail(
+		ctx context.Context,
+		token string,
+	) error
+	RequestPasswordReset(
+		ctx context.Context,
+		email string,
+	) error
+	ResetPassword(
+		ctx context.Context,
+		token string,
+		password string,
+	) error
+	ChangePassword(
+		ctx context.Context,
+		token *jwt.Token,
+		currentPassword string,
+		newPassword string,
+	) error
+	ChangeEmail(
+		ctx context.Context,
+		token *jwt.Token,
+		password string,
+		email string,
+	) error
+}
+
+type Keys interface {
+	JWKS(ctx context.Context) jwt.JWKS
+	Rotate(ctx context.Context) (kid string, err error)
 }
 
 type serverAPI struct {
 	ssov1.UnimplementedAuthServer
 	auth Auth
+	keys Keys
 }
 
-func Register(gRPC *grpc.Server, auth Auth) {
-	ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth})
+func Register(gRPC *grpc.Server, auth Auth, keys Keys) {
+	ssov1.RegisterAuthServer(gRPC, &serverAPI{auth: auth, keys: keys})
 }
 
 func (s *serverAPI) Login(ctx context.Context, req *ssov1.LoginRequest) (*ssov1.LoginResponse, error) {
-	token, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword())
+	token, refreshToken, mfaToken, err := s.auth.Login(ctx, req.GetEmail(), req.GetPassword(), int(req.GetAppId()))
 	if err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
 		if errors.Is(err, service.ErrInvalidCredentials) || errors.Is(err, service.ErrAppNotFound) {
 			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
 		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if mfaToken != "" {
+		return &ssov1.LoginResponse{MfaRequired: true, MfaToken: mfaToken}, nil
+	}
+	return &ssov1.LoginResponse{Token: token, RefreshToken: refreshToken}, nil
+}
+
+func (s *serverAPI) Refresh(ctx context.Context, req *ssov1.RefreshRequest) (*ssov1.RefreshResponse, error) {
+	token, refreshToken, err := s.auth.Refresh(ctx, req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.RefreshResponse{Token: token, RefreshToken: refreshToken}, nil
+}
+
+func (s *serverAPI) Logout(ctx context.Context, req *ssov1.LogoutRequest) (*emptypb.Empty, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	err := s.auth.Logout(ctx, token, req.GetRefreshToken())
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid refresh token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) Introspect(ctx context.Context, req *ssov1.IntrospectRequest) (*ssov1.IntrospectResponse, error) {
+	app, ok := authInterceptor.AppFromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid key")
+	}
+
+	info, err := s.auth.Introspect(ctx, app, req.GetToken(), req.GetTokenTypeHint())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if !info.Active {
+		return &ssov1.IntrospectResponse{Active: false}, nil
+	}
+	return &ssov1.IntrospectResponse{
+		Active:      true,
+		Sub:         info.Subject,
+		Email:       info.Email,
+		Exp:         info.ExpiresAt.Unix(),
+		Iat:         info.IssuedAt.Unix(),
+		Roles:       info.Roles,
+		ClientId:    info.ClientID,
+		Scope:       info.Scope,
+		TokenType:   info.TokenType,
+		Jti:         info.ID,
+		Permissions: info.Permissions,
+		// app roles are introspected as they are, apps check whether the token was issued to them
+		AppRoles:       info.AppRoles,
+		AppPermissions: info.AppPermissions,
+	}, nil
+}
+
+func (s *serverAPI) Authorize(ctx context.Context, req *ssov1.AuthorizeRequest) (*ssov1.AuthorizeResponse, error) {
+	code, err := s.auth.Authorize(
+		ctx,
+		req.GetEmail(),
+		req.GetPassword(),
+		req.GetClientId(),
+		req.GetRedirectUri(),
+		req.GetCodeChallenge(),
+		req.GetScope(),
+		req.GetNonce(),
+		req.GetMfaCode(),
+	)
+	if err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrAppNotFound) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidClient, "invalid client")
+		}
+		if errors.Is(err, service.ErrInvalidRedirectURI) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidRequest, "invalid redirect uri")
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidScope, "invalid scope")
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		if errors.Is(err, service.ErrMFARequired) {
+			return nil, status.Error(codes.InvalidArgument, "mfa code required")
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.AuthorizeResponse{Code: code}, nil
+}
+
+func (s *serverAPI) Token(ctx context.Context, req *ssov1.TokenRequest) (*ssov1.TokenResponse, error) {
+	switch req.GetGrantType() {
+	case oauth.GrantTypeAuthorizationCode:
+		return s.exchangeCode(ctx, req)
+	case oauth.GrantTypeClientCredentials:
+		return s.clientCredentials(ctx, req)
+	case oauth.GrantTypeDeviceCode:
+		return s.exchangeDeviceCode(ctx, req)
+	case oauth.GrantTypeTokenExchange:
+		return s.exchangeToken(ctx, req)
+	default:
+		return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorUnsupportedGrantType, "unsupported grant type")
+	}
+}
+
+func (s *serverAPI) exchangeCode(ctx context.Context, req *ssov1.TokenRequest) (*ssov1.TokenResponse, error) {
+	tokens, err := s.auth.ExchangeCode(
+		ctx,
+		req.GetCode(),
+		req.GetClientId(),
+		req.GetRedirectUri(),
+		req.GetCodeVerifier(),
+	)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidGrant) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidGrant, "invalid grant")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return tokenResponse(tokens), nil
+}
+
+func (s *serverAPI) clientCredentials(ctx context.Context, req *ssov1.TokenRequest) (*ssov1.TokenResponse, error) {
+	tokens, err := s.auth.ClientCredentials(
+		ctx,
+		req.GetClientId(),
+		req.GetClientSecret(),
+		req.GetScope(),
+	)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidClient) {
+			return nil, oauth.Error(codes.Unauthenticated, oauth.ErrorInvalidClient, "invalid client")
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidScope, "invalid scope")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return tokenResponse(tokens), nil
+}
+
+func (s *serverAPI) exchangeDeviceCode(ctx context.Context, req *ssov1.TokenRequest) (*ssov1.TokenResponse, error) {
+	tokens, err := s.auth.ExchangeDeviceCode(ctx, req.GetDeviceCode(), req.GetClientId())
+	if err != nil {
+		if errors.Is(err, service.ErrAuthorizationPending) {
+			return nil, oauth.Error(codes.FailedPrecondition, oauth.ErrorAuthorizationPending, "authorization pending")
+		}
+		if errors.Is(err, service.ErrSlowDown) {
+			return nil, oauth.Error(codes.FailedPrecondition, oauth.ErrorSlowDown, "slow down")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, oauth.Error(codes.PermissionDenied, oauth.ErrorAccessDenied, "access denied")
+		}
+		if errors.Is(err, service.ErrExpiredToken) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorExpiredToken, "device code expired")
+		}
+		if errors.Is(err, service.ErrInvalidGrant) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidGrant, "invalid grant")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return tokenResponse(tokens), nil
+}
+
+func (s *serverAPI) exchangeToken(ctx context.Context, req *ssov1.TokenRequest) (*ssov1.TokenResponse, error) {
+	tokens, err := s.auth.ExchangeToken(
+		ctx,
+		req.GetClientId(),
+		req.GetClientSecret(),
+		req.GetSubjectToken(),
+		req.GetSubjectTokenType(),
+		req.GetActorToken(),
+		req.GetAudience(),
+		req.GetScope(),
+	)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidClient) {
+			return nil, oauth.Error(codes.Unauthenticated, oauth.ErrorInvalidClient, "invalid client")
+		}
+		if errors.Is(err, service.ErrInvalidTarget) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidTarget, "invalid audience")
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidScope, "invalid scope")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, oauth.Error(codes.PermissionDenied, oauth.ErrorAccessDenied, "impersonation is not allowed")
+		}
+		if errors.Is(err, service.ErrInvalidGrant) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidGrant, "invalid grant")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := tokenResponse(tokens)
+	resp.IssuedTokenType = oauth.TokenTypeAccessToken
+
+	return resp, nil
+}
+
+func tokenResponse(tokens models.Tokens) *ssov1.TokenResponse {
+	return &ssov1.TokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    oauth.TokenTypeBearer,
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        tokens.Scope,
+		IdToken:      tokens.IDToken,
+	}
+}
+
+func (s *serverAPI) DeviceAuthorize(ctx context.Context, req *ssov1.DeviceAuthorizeRequest) (*ssov1.DeviceAuthorizeResponse, error) {
+	authorization, err := s.auth.DeviceAuthorize(ctx, req.GetClientId(), req.GetScope())
+	if err != nil {
+		if errors.Is(err, service.ErrAppNotFound) {
+			return nil, oauth.Error(codes.Unauthenticated, oauth.ErrorInvalidClient, "invalid client")
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			return nil, oauth.Error(codes.InvalidArgument, oauth.ErrorInvalidScope, "invalid scope")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.DeviceAuthorizeResponse{
+		DeviceCode: authorization.DeviceCode,
+		UserCode:   authorization.UserCode,
+		ExpiresIn:  int64(authorization.ExpiresIn.Seconds()),
+		Interval:   int64(authorization.Interval.Seconds()),
+	}, nil
+}
+
+func (s *serverAPI) VerifyDevice(ctx context.Context, req *ssov1.VerifyDeviceRequest) (*emptypb.Empty, error) {
+	err := s.auth.VerifyDevice(ctx, req.GetEmail(), req.GetPassword(), req.GetUserCode(), req.GetApprove(), req.GetMfaCode())
+	if err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrInvalidUserCode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired user code")
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credentials")
+		}
+		if errors.Is(err, service.ErrMFARequired) {
+			return nil, status.Error(codes.InvalidArgument, "mfa code required")
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) EnrollMFA(ctx context.Context, _ *emptypb.Empty) (*ssov1.EnrollMFAResponse, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	secret, uri, err := s.auth.EnrollMFA(ctx, token)
+	if err != nil {
+		if errors.Is(err, service.ErrMFAEnabled) {
+			return nil, status.Error(codes.AlreadyExists, "mfa already enabled")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't manage mfa")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.EnrollMFAResponse{Secret: secret, Uri: uri}, nil
+}
+
+func (s *serverAPI) ConfirmMFA(ctx context.Context, req *ssov1.ConfirmMFARequest) (*ssov1.ConfirmMFAResponse, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	recoveryCodes, err := s.auth.ConfirmMFA(ctx, token, req.GetCode())
+	if err != nil {
+		if errors.Is(err, service.ErrMFAEnabled) {
+			return nil, status.Error(codes.AlreadyExists, "mfa already enabled")
+		}
+		if errors.Is(err, service.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.FailedPrecondition, "mfa isn't enrolled")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't manage mfa")
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.ConfirmMFAResponse{RecoveryCodes: recoveryCodes}, nil
+}
+
+func (s *serverAPI) VerifyMFA(ctx context.Context, req *ssov1.VerifyMFARequest) (*ssov1.VerifyMFAResponse, error) {
+	token, refreshToken, err := s.auth.VerifyMFA(ctx, req.GetMfaToken(), req.GetCode())
+	if err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			return nil, status.Error(codes.InvalidArgument, "invalid mfa code")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.VerifyMFAResponse{Token: token, RefreshToken: refreshToken}, nil
+}
+
+func (s *serverAPI) BeginPasskeyRegistration(ctx context.Context, _ *emptypb.Empty) (*ssov1.PasskeyOptionsResponse, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	sessionID, options, err := s.auth.BeginPasskeyRegistration(ctx, token)
+	if err != nil {
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't manage passkeys")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return passkeyOptionsResponse(sessionID, options)
+}
+
+func (s *serverAPI) FinishPasskeyRegistration(ctx context.Context, req *ssov1.FinishPasskeyRegistrationRequest) (*emptypb.Empty, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	credential, err := protojson.Marshal(req.GetCredential())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	err = s.auth.FinishPasskeyRegistration(ctx, token, req.GetSessionId(), credential)
+	if err != nil {
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't manage passkeys")
+		}
+		if errors.Is(err, service.ErrInvalidPasskeySession) {
+			return nil, status.Error(codes.InvalidArgument, "invalid passkey session")
+		}
+		if errors.Is(err, service.ErrInvalidPasskey) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credential")
+		}
+		if errors.Is(err, service.ErrPasskeyExists) {
+			return nil, status.Error(codes.AlreadyExists, "passkey already registered")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) BeginPasskeyLogin(ctx context.Context, req *ssov1.BeginPasskeyLoginRequest) (*ssov1.PasskeyOptionsResponse, error) {
+	sessionID, options, err := s.auth.BeginPasskeyLogin(ctx, int(req.GetAppId()), req.GetMfaToken())
+	if err != nil {
+		if errors.Is(err, service.ErrAppNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "app not found")
+		}
+		if errors.Is(err, service.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, service.ErrNoPasskeys) {
+			return nil, status.Error(codes.FailedPrecondition, "user has no passkeys")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return passkeyOptionsResponse(sessionID, options)
+}
+
+func (s *serverAPI) FinishPasskeyLogin(ctx context.Context, req *ssov1.FinishPasskeyLoginRequest) (*ssov1.FinishPasskeyLoginResponse, error) {
+	credential, err := protojson.Marshal(req.GetCredential())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid credential")
+	}
+
+	token, refreshToken, err := s.auth.FinishPasskeyLogin(ctx, req.GetSessionId(), credential)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPasskeySession) {
+			return nil, status.Error(codes.InvalidArgument, "invalid passkey session")
+		}
+		if errors.Is(err, service.ErrInvalidPasskey) {
+			return nil, status.Error(codes.InvalidArgument, "invalid credential")
+		}
+		if errors.Is(err, service.ErrInvalidMFAToken) {
+			return nil, status.Error(codes.Unauthenticated, "invalid mfa token")
+		}
+		if errors.Is(err, service.ErrAppNotFound) {
+			return nil, status.Error(codes.InvalidArgument, "app not found")
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			return nil, status.Error(codes.FailedPrecondition, "email not verified")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.FinishPasskeyLoginResponse{Token: token, RefreshToken: refreshToken}, nil
+}
+
+// throttleStatus returns status of throttled login, which tells client when to retry
+func throttleStatus(err error) (error, bool) {
+	var throttleErr *service.ThrottleError
+	if !errors.As(err, &throttleErr) {
+		return nil, false
+	}
+
+	st := status.New(codes.ResourceExhausted, "too many login attempts")
+	if errors.Is(throttleErr, service.ErrAccountLocked) {
+		st = status.New(codes.FailedPrecondition, "account temporarily locked")
+	}
+
+	withRetry, detailsErr := st.WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(throttleErr.RetryAfter)})
+	if detailsErr != nil {
+		return st.Err(), true
+	}
+	return withRetry.Err(), true
+}
+
+// policyStatus returns status of password failing password policy, every failed rule
+// is a violation of the request field
+func policyStatus(err error, field string) (error, bool) {
+	var policyErr *service.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return nil, false
+	}
+
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: field + " " + violation,
+		})
+	}
+
+	st := status.New(codes.InvalidArgument, "password doesn't meet policy")
+	withViolations, detailsErr := st.WithDetails(badRequest)
+	if detailsErr != nil {
+		return st.Err(), true
+	}
+	return withViolations.Err(), true
+}
+
+// passkeyOptionsResponse wraps WebAuthn options encoded in JSON, so they reach browsers as JSON object
+func passkeyOptionsResponse(sessionID string, options []byte) (*ssov1.PasskeyOptionsResponse, error) {
+	var decoded structpb.Struct
+	if err := protojson.Unmarshal(options, &decoded); err != nil {
 		return nil, status.Error(codes.Internal, "internal error")
 	}
-	return &ssov1.LoginResponse{Token: token}, nil
+	return &ssov1.PasskeyOptionsResponse{SessionId: sessionID, Options: &decoded}, nil
 }
 
 func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*ssov1.RegisterResponse, error) {
 	userID, err := s.auth.RegisterNewUser(ctx, req.GetEmail(), req.GetPassword())
 	if err != nil {
+		if st, ok := policyStatus(err, "password"); ok {
+			return nil, st
+		}
 		if errors.Is(err, service.ErrUserExists) {
 			return nil, status.Error(codes.AlreadyExists, "user already exists")
 		}
@@ -60,13 +690,129 @@ func (s *serverAPI) Register(ctx context.Context, req *ssov1.RegisterRequest) (*
 	return &ssov1.RegisterResponse{UserId: userID}, nil
 }
 
+func (s *serverAPI) VerifyEmail(ctx context.Context, req *ssov1.VerifyEmailRequest) (*emptypb.Empty, error) {
+	if err := s.auth.VerifyEmail(ctx, req.GetToken()); err != nil {
+		if errors.Is(err, service.ErrInvalidVerificationToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired verification token")
+		}
+		if errors.Is(err, service.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RequestPasswordReset(ctx context.Context, req *ssov1.RequestPasswordResetRequest) (*emptypb.Empty, error) {
+	if err := s.auth.RequestPasswordReset(ctx, req.GetEmail()); err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ResetPassword(ctx context.Context, req *ssov1.ResetPasswordRequest) (*emptypb.Empty, error) {
+	if err := s.auth.ResetPassword(ctx, req.GetToken(), req.GetPassword()); err != nil {
+		if st, ok := policyStatus(err, "password"); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			return nil, status.Error(codes.InvalidArgument, "invalid or expired reset token")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ChangePassword(ctx context.Context, req *ssov1.ChangePasswordRequest) (*emptypb.Empty, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if err := s.auth.ChangePassword(ctx, token, req.GetCurrentPassword(), req.GetNewPassword()); err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
+		if st, ok := policyStatus(err, "new_password"); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't change password")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ChangeEmail(ctx context.Context, req *ssov1.ChangeEmailRequest) (*emptypb.Empty, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	if err := s.auth.ChangeEmail(ctx, token, req.GetPassword(), req.GetEmail()); err != nil {
+		if st, ok := throttleStatus(err); ok {
+			return nil, st
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return nil, status.Error(codes.InvalidArgument, "invalid password")
+		}
+		if errors.Is(err, service.ErrUserExists) {
+			return nil, status.Error(codes.AlreadyExists, "email already taken")
+		}
+		if errors.Is(err, service.ErrAccessDenied) {
+			return nil, status.Error(codes.PermissionDenied, "delegated token can't change email")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
 func (s *serverAPI) RegisterApp(ctx context.Context, req *ssov1.RegisterAppRequest) (*ssov1.RegisterAppResponse, error) {
-	apiKey, userKey, err := s.auth.RegisterNewApp(ctx, req.GetName())
+	app, clientSecret, err := s.auth.RegisterNewApp(
+		ctx,
+		req.GetName(),
+		req.GetTokenTtl().AsDuration(),
+		req.GetRedirectUris(),
+		req.GetScopes(),
+	)
 	if err != nil {
 		if errors.Is(err, service.ErrAppAlreadyExists) {
 			return nil, status.Error(codes.AlreadyExists, "app already exists")
 		}
+		if errors.Is(err, service.ErrInvalidTokenTTL) {
+			return nil, status.Error(codes.InvalidArgument, "invalid token ttl")
+		}
+		if errors.Is(err, service.ErrInvalidRedirectURI) {
+			return nil, status.Error(codes.InvalidArgument, "invalid redirect uri")
+		}
+		if errors.Is(err, service.ErrInvalidScope) {
+			return nil, status.Error(codes.InvalidArgument, "invalid scope")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &ssov1.RegisterAppResponse{
+		ApiKey:       app.ApiKey,
+		ClientId:     app.ClientID(),
+		ClientSecret: clientSecret,
+	}, nil
+}
+
+func (s *serverAPI) Keys(ctx context.Context, _ *emptypb.Empty) (*httpbody.HttpBody, error) {
+	data, err := json.Marshal(s.keys.JWKS(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &httpbody.HttpBody{ContentType: "application/json", Data: data}, nil
+}
+
+func (s *serverAPI) RotateKeys(ctx context.Context, _ *emptypb.Empty) (*ssov1.RotateKeysResponse, error) {
+	kid, err := s.keys.Rotate(ctx)
+	if err != nil {
 		return nil, status.Error(codes.Internal, "internal error")
 	}
-	return &ssov1.RegisterAppResponse{ApiKey: apiKey, UserKey: userKey}, nil
+	return &ssov1.RotateKeysResponse{Kid: kid}, nil
 }
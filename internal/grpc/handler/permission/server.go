@@ -3,6 +3,8 @@ package permission
 import (
 	"context"
 	"errors"
+	"sso/internal/domain/models"
+	authInterceptor "sso/internal/grpc/interceptor/auth"
 	"sso/internal/service"
 
 	ssov1 "github.com/dedmouze/protos/gen/go/sso"
@@ -15,6 +17,30 @@ import (
 type Permission interface {
 	AddAdmin(ctx context.Context, email string) error
 	DeleteAdmin(ctx context.Context, email string) error
+	ResetMFA(ctx context.Context, email string) error
+	UnlockUser(ctx context.Context, email string) error
+	CreateRole(ctx context.Context, appID int, name string, description string) error
+	UpdateRole(ctx context.Context, appID int, name string, description string) error
+	DeleteRole(ctx context.Context, appID int, name string) error
+	Roles(ctx context.Context, appID int) ([]models.Role, error)
+	CreatePermission(ctx context.Context, appID int, name string, description string) error
+	UpdatePermission(ctx context.Context, appID int, name string, description string) error
+	DeletePermission(ctx context.Context, appID int, name string) error
+	Permissions(ctx context.Context, appID int) ([]models.Permission, error)
+	GrantPermission(ctx context.Context, appID int, role string, permission string) error
+	RevokePermission(ctx context.Context, appID int, role string, permission string) error
+	AssignRole(ctx context.Context, appID int, email string, role string) error
+	UnassignRole(ctx context.Context, appID int, email string, role string) error
+	Check(ctx context.Context, check models.Check) (models.Decision, error)
+	CheckMany(ctx context.Context, checks []models.Check) ([]models.Decision, error)
+	CreateGroup(ctx context.Context, appID int, name string, description string) error
+	DeleteGroup(ctx context.Context, appID int, name string) error
+	AddGroupMember(ctx context.Context, appID int, group string, email string) error
+	RemoveGroupMember(ctx context.Context, appID int, group string, email string) error
+	AssignGroupRole(ctx context.Context, appID int, group string, role string) error
+	UnassignGroupRole(ctx context.Context, appID int, group string, role string) error
+	GrantResource(ctx context.Context, grant models.ResourceGrant) error
+	RevokeResource(ctx context.Context, grant models.ResourceGrant) error
 }
 
 type serverAPI struct {
@@ -32,6 +58,9 @@ func (s *serverAPI) AddAdmin(ctx context.Context, req *ssov1.AddAdminRequest) (*
 		if errors.Is(err, service.ErrAdminExists) {
 			return nil, status.Error(codes.AlreadyExists, "admin already exists")
 		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
 		return nil, status.Error(codes.Internal, "internal error")
 	}
 	return &emptypb.Empty{}, nil
@@ -47,3 +76,479 @@ func (s *serverAPI) DeleteAdmin(ctx context.Context, req *ssov1.DeleteAdminReque
 	}
 	return &emptypb.Empty{}, nil
 }
+
+func (s *serverAPI) ResetMFA(ctx context.Context, req *ssov1.ResetMFARequest) (*emptypb.Empty, error) {
+	err := s.permission.ResetMFA(ctx, req.GetEmail())
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, service.ErrMFANotEnrolled) {
+			return nil, status.Error(codes.NotFound, "mfa not enrolled")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) UnlockUser(ctx context.Context, req *ssov1.UnlockUserRequest) (*emptypb.Empty, error) {
+	err := s.permission.UnlockUser(ctx, req.GetEmail())
+	if err != nil {
+		if errors.Is(err, service.ErrNotLocked) {
+			return nil, status.Error(codes.NotFound, "user not locked")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) CreateRole(ctx context.Context, req *ssov1.CreateRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.CreateRole(ctx, appID, req.GetName(), req.GetDescription())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleExists) {
+			return nil, status.Error(codes.AlreadyExists, "role already exists")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) UpdateRole(ctx context.Context, req *ssov1.UpdateRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.UpdateRole(ctx, appID, req.GetName(), req.GetDescription())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) DeleteRole(ctx context.Context, req *ssov1.DeleteRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.DeleteRole(ctx, appID, req.GetName())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, service.ErrBuiltIn) {
+			return nil, status.Error(codes.FailedPrecondition, "built-in role can't be deleted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ListRoles(ctx context.Context, req *ssov1.ListRolesRequest) (*ssov1.ListRolesResponse, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	roles, err := s.permission.Roles(ctx, appID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &ssov1.ListRolesResponse{Roles: make([]*ssov1.RoleInfo, 0, len(roles))}
+	for _, role := range roles {
+		resp.Roles = append(resp.Roles, &ssov1.RoleInfo{
+			Name:        role.Name,
+			Description: role.Description,
+			Permissions: role.Permissions,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) CreatePermission(ctx context.Context, req *ssov1.CreatePermissionRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.CreatePermission(ctx, appID, req.GetName(), req.GetDescription())
+	if err != nil {
+		if errors.Is(err, service.ErrPermissionExists) {
+			return nil, status.Error(codes.AlreadyExists, "permission already exists")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) UpdatePermission(ctx context.Context, req *ssov1.UpdatePermissionRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.UpdatePermission(ctx, appID, req.GetName(), req.GetDescription())
+	if err != nil {
+		if errors.Is(err, service.ErrPermissionNotFound) {
+			return nil, status.Error(codes.NotFound, "permission not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) DeletePermission(ctx context.Context, req *ssov1.DeletePermissionRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.DeletePermission(ctx, appID, req.GetName())
+	if err != nil {
+		if errors.Is(err, service.ErrPermissionNotFound) {
+			return nil, status.Error(codes.NotFound, "permission not found")
+		}
+		if errors.Is(err, service.ErrBuiltIn) {
+			return nil, status.Error(codes.FailedPrecondition, "built-in permission can't be deleted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) ListPermissions(ctx context.Context, req *ssov1.ListPermissionsRequest) (*ssov1.ListPermissionsResponse, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.permission.Permissions(ctx, appID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &ssov1.ListPermissionsResponse{Permissions: make([]*ssov1.PermissionInfo, 0, len(permissions))}
+	for _, permission := range permissions {
+		resp.Permissions = append(resp.Permissions, &ssov1.PermissionInfo{
+			Name:        permission.Name,
+			Description: permission.Description,
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) GrantPermission(ctx context.Context, req *ssov1.GrantPermissionRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.GrantPermission(ctx, appID, req.GetRole(), req.GetPermission())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, service.ErrPermissionNotFound) {
+			return nil, status.Error(codes.NotFound, "permission not found")
+		}
+		if errors.Is(err, service.ErrPermissionGranted) {
+			return nil, status.Error(codes.AlreadyExists, "permission already granted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RevokePermission(ctx context.Context, req *ssov1.RevokePermissionRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.RevokePermission(ctx, appID, req.GetRole(), req.GetPermission())
+	if err != nil {
+		if errors.Is(err, service.ErrPermissionNotGranted) {
+			return nil, status.Error(codes.NotFound, "permission not granted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) AssignRole(ctx context.Context, req *ssov1.AssignRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.AssignRole(ctx, appID, req.GetEmail(), req.GetRole())
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, service.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, service.ErrRoleAssigned) {
+			return nil, status.Error(codes.AlreadyExists, "role already assigned")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) UnassignRole(ctx context.Context, req *ssov1.UnassignRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.UnassignRole(ctx, appID, req.GetEmail(), req.GetRole())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleNotAssigned) {
+			return nil, status.Error(codes.NotFound, "role not assigned")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) Check(ctx context.Context, req *ssov1.CheckRequest) (*ssov1.CheckResponse, error) {
+	check, err := toCheck(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	decision, err := s.permission.Check(ctx, check)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	return &ssov1.CheckResponse{Allowed: decision.Allowed, Reason: decision.Reason}, nil
+}
+
+func (s *serverAPI) CheckMany(ctx context.Context, req *ssov1.CheckManyRequest) (*ssov1.CheckManyResponse, error) {
+	checks := make([]models.Check, 0, len(req.GetChecks()))
+	for _, r := range req.GetChecks() {
+		check, err := toCheck(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	decisions, err := s.permission.CheckMany(ctx, checks)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &ssov1.CheckManyResponse{Results: make([]*ssov1.CheckResponse, 0, len(decisions))}
+	for _, decision := range decisions {
+		resp.Results = append(resp.Results, &ssov1.CheckResponse{Allowed: decision.Allowed, Reason: decision.Reason})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) CreateGroup(ctx context.Context, req *ssov1.CreateGroupRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.CreateGroup(ctx, appID, req.GetName(), req.GetDescription())
+	if err != nil {
+		if errors.Is(err, service.ErrGroupExists) {
+			return nil, status.Error(codes.AlreadyExists, "group already exists")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) DeleteGroup(ctx context.Context, req *ssov1.DeleteGroupRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.DeleteGroup(ctx, appID, req.GetName())
+	if err != nil {
+		if errors.Is(err, service.ErrGroupNotFound) {
+			return nil, status.Error(codes.NotFound, "group not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) AddGroupMember(ctx context.Context, req *ssov1.AddGroupMemberRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.AddGroupMember(ctx, appID, req.GetGroup(), req.GetEmail())
+	if err != nil {
+		if errors.Is(err, service.ErrGroupNotFound) {
+			return nil, status.Error(codes.NotFound, "group not found")
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, service.ErrMemberExists) {
+			return nil, status.Error(codes.AlreadyExists, "user already in group")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RemoveGroupMember(ctx context.Context, req *ssov1.RemoveGroupMemberRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.RemoveGroupMember(ctx, appID, req.GetGroup(), req.GetEmail())
+	if err != nil {
+		if errors.Is(err, service.ErrMemberNotFound) {
+			return nil, status.Error(codes.NotFound, "user not in group")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) AssignGroupRole(ctx context.Context, req *ssov1.AssignGroupRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.AssignGroupRole(ctx, appID, req.GetGroup(), req.GetRole())
+	if err != nil {
+		if errors.Is(err, service.ErrGroupNotFound) {
+			return nil, status.Error(codes.NotFound, "group not found")
+		}
+		if errors.Is(err, service.ErrRoleNotFound) {
+			return nil, status.Error(codes.NotFound, "role not found")
+		}
+		if errors.Is(err, service.ErrRoleAssigned) {
+			return nil, status.Error(codes.AlreadyExists, "role already assigned")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) UnassignGroupRole(ctx context.Context, req *ssov1.UnassignGroupRoleRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.UnassignGroupRole(ctx, appID, req.GetGroup(), req.GetRole())
+	if err != nil {
+		if errors.Is(err, service.ErrRoleNotAssigned) {
+			return nil, status.Error(codes.NotFound, "role not assigned")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) GrantResource(ctx context.Context, req *ssov1.GrantResourceRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.GrantResource(ctx, models.ResourceGrant{
+		AppID:      appID,
+		Permission: req.GetPermission(),
+		Resource:   req.GetResource(),
+		Email:      req.GetEmail(),
+		Group:      req.GetGroup(),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrPermissionNotFound) {
+			return nil, status.Error(codes.NotFound, "permission not found")
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		if errors.Is(err, service.ErrGroupNotFound) {
+			return nil, status.Error(codes.NotFound, "group not found")
+		}
+		if errors.Is(err, service.ErrResourceGrantExists) {
+			return nil, status.Error(codes.AlreadyExists, "resource already granted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *serverAPI) RevokeResource(ctx context.Context, req *ssov1.RevokeResourceRequest) (*emptypb.Empty, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.permission.RevokeResource(ctx, models.ResourceGrant{
+		AppID:      appID,
+		Permission: req.GetPermission(),
+		Resource:   req.GetResource(),
+		Email:      req.GetEmail(),
+		Group:      req.GetGroup(),
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrResourceGrantNotFound) {
+			return nil, status.Error(codes.NotFound, "resource not granted")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// toCheck converts request to check in namespace the caller is allowed to check
+func toCheck(ctx context.Context, req *ssov1.CheckRequest) (models.Check, error) {
+	appID, err := namespace(ctx, req.GetAppId())
+	if err != nil {
+		return models.Check{}, err
+	}
+
+	return models.Check{
+		AppID:      appID,
+		Subject:    req.GetSubject(),
+		Permission: req.GetPermission(),
+		Resource:   req.GetResource(),
+	}, nil
+}
+
+// namespace returns ID of the app whose roles and permissions the request manages. Apps manage only
+// their own namespace, while users with permission to manage roles choose any, zero is the service itself
+func namespace(ctx context.Context, requested int32) (int, error) {
+	app, ok := authInterceptor.AppFromContext(ctx)
+	if !ok {
+		return int(requested), nil
+	}
+
+	if requested != 0 && int(requested) != app.ID {
+		return 0, status.Error(codes.PermissionDenied, "app can manage only its own roles")
+	}
+
+	return app.ID, nil
+}
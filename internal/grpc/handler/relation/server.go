@@ -0,0 +1,172 @@
+package relation
+
+import (
+	"context"
+	"errors"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/rebac"
+	"sso/internal/service"
+
+	ssov1 "github.com/dedmouze/protos/gen/go/sso"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type Relation interface {
+	WriteTuples(ctx context.Context, tuples []models.RelationTuple) (string, error)
+	DeleteTuples(ctx context.Context, tuples []models.RelationTuple) (string, error)
+	ReadTuples(ctx context.Context, filter models.TupleFilter) ([]models.RelationTuple, string, error)
+	Check(ctx context.Context, check models.RelationTuple, consistencyToken string) (bool, string, error)
+	LookupResources(ctx context.Context, namespace string, relation string, subject models.Subject, consistencyToken string) ([]string, string, error)
+}
+
+type serverAPI struct {
+	ssov1.UnimplementedRelationServer
+	relation Relation
+}
+
+func Register(gRPC *grpc.Server, relation Relation) {
+	ssov1.RegisterRelationServer(gRPC, &serverAPI{relation: relation})
+}
+
+func (s *serverAPI) WriteTuples(ctx context.Context, req *ssov1.WriteTuplesRequest) (*ssov1.WriteTuplesResponse, error) {
+	tuples, err := toTuples(req.GetTuples())
+	if err != nil {
+		return nil, err
+	}
+
+	writtenAt, err := s.relation.WriteTuples(ctx, tuples)
+	if err != nil {
+		if errors.Is(err, service.ErrTupleExists) {
+			return nil, status.Error(codes.AlreadyExists, "relation tuple already exists")
+		}
+		return nil, relationError(err)
+	}
+
+	return &ssov1.WriteTuplesResponse{WrittenAt: writtenAt}, nil
+}
+
+func (s *serverAPI) DeleteTuples(ctx context.Context, req *ssov1.DeleteTuplesRequest) (*ssov1.DeleteTuplesResponse, error) {
+	tuples, err := toTuples(req.GetTuples())
+	if err != nil {
+		return nil, err
+	}
+
+	deletedAt, err := s.relation.DeleteTuples(ctx, tuples)
+	if err != nil {
+		if errors.Is(err, service.ErrTupleNotFound) {
+			return nil, status.Error(codes.NotFound, "relation tuple not found")
+		}
+		return nil, relationError(err)
+	}
+
+	return &ssov1.DeleteTuplesResponse{DeletedAt: deletedAt}, nil
+}
+
+func (s *serverAPI) ReadTuples(ctx context.Context, req *ssov1.ReadTuplesRequest) (*ssov1.ReadTuplesResponse, error) {
+	filter := models.TupleFilter{
+		Namespace: req.GetNamespace(),
+		ObjectID:  req.GetObjectId(),
+		Relation:  req.GetRelation(),
+	}
+	if req.GetSubject() != "" {
+		subject, err := rebac.ParseSubject(req.GetSubject())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		filter.Subject = subject
+	}
+
+	tuples, readAt, err := s.relation.ReadTuples(ctx, filter)
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	resp := &ssov1.ReadTuplesResponse{Tuples: make([]*ssov1.RelationTuple, 0, len(tuples)), ReadAt: readAt}
+	for _, tuple := range tuples {
+		resp.Tuples = append(resp.Tuples, &ssov1.RelationTuple{
+			Object:   rebac.FormatObject(tuple.Namespace, tuple.ObjectID),
+			Relation: tuple.Relation,
+			Subject:  rebac.FormatSubject(tuple.Subject),
+		})
+	}
+
+	return resp, nil
+}
+
+func (s *serverAPI) Check(ctx context.Context, req *ssov1.RelationCheckRequest) (*ssov1.RelationCheckResponse, error) {
+	check, err := toTuple(req)
+	if err != nil {
+		return nil, err
+	}
+
+	allowed, checkedAt, err := s.relation.Check(ctx, check, req.GetConsistencyToken())
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	return &ssov1.RelationCheckResponse{Allowed: allowed, CheckedAt: checkedAt}, nil
+}
+
+func (s *serverAPI) LookupResources(ctx context.Context, req *ssov1.LookupResourcesRequest) (*ssov1.LookupResourcesResponse, error) {
+	subject, err := rebac.ParseSubject(req.GetSubject())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ids, checkedAt, err := s.relation.LookupResources(ctx, req.GetNamespace(), req.GetRelation(), subject, req.GetConsistencyToken())
+	if err != nil {
+		return nil, relationError(err)
+	}
+
+	return &ssov1.LookupResourcesResponse{ObjectIds: ids, CheckedAt: checkedAt}, nil
+}
+
+type requestTuple interface {
+	GetObject() string
+	GetRelation() string
+	GetSubject() string
+}
+
+// toTuple parses object and subject of the request, they are validated by interceptor beforehand
+func toTuple(req requestTuple) (models.RelationTuple, error) {
+	namespace, id, err := rebac.ParseObject(req.GetObject())
+	if err != nil {
+		return models.RelationTuple{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+	subject, err := rebac.ParseSubject(req.GetSubject())
+	if err != nil {
+		return models.RelationTuple{}, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return models.RelationTuple{Namespace: namespace, ObjectID: id, Relation: req.GetRelation(), Subject: subject}, nil
+}
+
+func toTuples(reqs []*ssov1.RelationTuple) ([]models.RelationTuple, error) {
+	tuples := make([]models.RelationTuple, 0, len(reqs))
+	for _, req := range reqs {
+		tuple, err := toTuple(req)
+		if err != nil {
+			return nil, err
+		}
+		tuples = append(tuples, tuple)
+	}
+	return tuples, nil
+}
+
+// relationError returns status of errors common to all methods
+func relationError(err error) error {
+	var schemaErr *service.SchemaError
+	if errors.As(err, &schemaErr) {
+		return status.Error(codes.InvalidArgument, schemaErr.Reason)
+	}
+	if errors.Is(err, service.ErrInvalidConsistencyToken) {
+		return status.Error(codes.InvalidArgument, "consistency token is invalid")
+	}
+	if errors.Is(err, service.ErrRelationTooDeep) {
+		return status.Error(codes.FailedPrecondition, "relation is nested too deep")
+	}
+	return status.Error(codes.Internal, "internal error")
+}
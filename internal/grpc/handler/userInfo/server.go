@@ -2,21 +2,26 @@ package userInfo
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
 	"sso/internal/service"
 
 	ssov1 "github.com/dedmouze/protos/gen/go/sso"
+	"google.golang.org/genproto/googleapis/api/httpbody"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 type UserInfo interface {
 	Admin(ctx context.Context, email string) (models.Admin, error)
 	User(ctx context.Context, email string) (models.User, error)
+	Claims(ctx context.Context, token *jwt.Token) (map[string]any, error)
 }
 
 type serverAPI struct {
@@ -54,7 +59,32 @@ func (s *serverAPI) Admin(ctx context.Context, req *ssov1.AdminRequest) (*ssov1.
 	}
 
 	return &ssov1.AdminResponse{
-		AdminID: admin.ID,
-		Email:   admin.Email,
-		Level:   int32(admin.Level)}, nil
+		AdminID:     admin.ID,
+		Email:       admin.Email,
+		Roles:       admin.Roles,
+		Permissions: admin.Permissions}, nil
+}
+
+func (s *serverAPI) UserInfo(ctx context.Context, _ *emptypb.Empty) (*httpbody.HttpBody, error) {
+	token, ok := jwt.FromContext(ctx)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	claims, err := s.userInfo.Claims(ctx, token)
+	if err != nil {
+		if errors.Is(err, service.ErrInsufficientScope) {
+			return nil, status.Error(codes.PermissionDenied, "insufficient scope")
+		}
+		if errors.Is(err, service.ErrUserNotFound) {
+			return nil, status.Error(codes.NotFound, "user not found")
+		}
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	return &httpbody.HttpBody{ContentType: "application/json", Data: data}, nil
 }
@@ -4,11 +4,16 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"slices"
 	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
 	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/policy"
 	"sso/internal/storage"
+	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -17,19 +22,107 @@ import (
 )
 
 var (
-	permissionRequired = []string{
-		"/userInfo.UserInfo/User",
-		"/userInfo.UserInfo/Admin",
-		"/permission.Permission/AddAdmin",
-		"/permission.Permission/DeleteAdmin",
+	// permissionRequired maps methods to permission the user must have
+	permissionRequired = map[string]string{
+		"/userInfo.UserInfo/User":                  models.PermissionUsersRead,
+		"/userInfo.UserInfo/Admin":                 models.PermissionUsersRead,
+		"/permission.Permission/AddAdmin":          models.PermissionAdminsManage,
+		"/permission.Permission/DeleteAdmin":       models.PermissionAdminsManage,
+		"/permission.Permission/ResetMFA":          models.PermissionMFAReset,
+		"/permission.Permission/UnlockUser":        models.PermissionUsersUnlock,
+		"/permission.Permission/CreateRole":        models.PermissionRolesManage,
+		"/permission.Permission/UpdateRole":        models.PermissionRolesManage,
+		"/permission.Permission/DeleteRole":        models.PermissionRolesManage,
+		"/permission.Permission/ListRoles":         models.PermissionRolesManage,
+		"/permission.Permission/CreatePermission":  models.PermissionRolesManage,
+		"/permission.Permission/UpdatePermission":  models.PermissionRolesManage,
+		"/permission.Permission/DeletePermission":  models.PermissionRolesManage,
+		"/permission.Permission/ListPermissions":   models.PermissionRolesManage,
+		"/permission.Permission/GrantPermission":   models.PermissionRolesManage,
+		"/permission.Permission/RevokePermission":  models.PermissionRolesManage,
+		"/permission.Permission/AssignRole":        models.PermissionRolesManage,
+		"/permission.Permission/UnassignRole":      models.PermissionRolesManage,
+		"/permission.Permission/Check":             models.PermissionUsersRead,
+		"/permission.Permission/CheckMany":         models.PermissionUsersRead,
+		"/permission.Permission/CreateGroup":       models.PermissionRolesManage,
+		"/permission.Permission/DeleteGroup":       models.PermissionRolesManage,
+		"/permission.Permission/AddGroupMember":    models.PermissionRolesManage,
+		"/permission.Permission/RemoveGroupMember": models.PermissionRolesManage,
+		"/permission.Permission/AssignGroupRole":   models.PermissionRolesManage,
+		"/permission.Permission/UnassignGroupRole": models.PermissionRolesManage,
+		"/permission.Permission/GrantResource":     models.PermissionRolesManage,
+		"/permission.Permission/RevokeResource":    models.PermissionRolesManage,
+		"/relation.Relation/WriteTuples":           models.PermissionRolesManage,
+		"/relation.Relation/DeleteTuples":          models.PermissionRolesManage,
+		"/relation.Relation/ReadTuples":            models.PermissionUsersRead,
+		"/relation.Relation/Check":                 models.PermissionUsersRead,
+		"/relation.Relation/LookupResources":       models.PermissionUsersRead,
+		"/auth.Auth/RotateKeys":                    models.PermissionKeysRotate,
+	}
+	// appAllowed are methods of permissionRequired apps may call with api key or client token as well.
+	// Their handlers confine apps to roles, groups and checks of their own namespace. Relation tuples
+	// aren't namespaced by app, so only users manage them
+	appAllowed = []string{
+		"/permission.Permission/CreateRole",
+		"/permission.Permission/UpdateRole",
+		"/permission.Permission/DeleteRole",
+		"/permission.Permission/ListRoles",
+		"/permission.Permission/CreatePermission",
+		"/permission.Permission/UpdatePermission",
+		"/permission.Permission/DeletePermission",
+		"/permission.Permission/ListPermissions",
+		"/permission.Permission/GrantPermission",
+		"/permission.Permission/RevokePermission",
+		"/permission.Permission/AssignRole",
+		"/permission.Permission/UnassignRole",
+		"/permission.Permission/Check",
+		"/permission.Permission/CheckMany",
+		"/permission.Permission/CreateGroup",
+		"/permission.Permission/DeleteGroup",
+		"/permission.Permission/AddGroupMember",
+		"/permission.Permission/RemoveGroupMember",
+		"/permission.Permission/AssignGroupRole",
+		"/permission.Permission/UnassignGroupRole",
+		"/permission.Permission/GrantResource",
+		"/permission.Permission/RevokeResource",
+	}
+	authRequired = []string{
+		"/auth.Auth/Logout",
+		"/auth.Auth/EnrollMFA",
+		"/auth.Auth/ConfirmMFA",
+		"/auth.Auth/BeginPasskeyRegistration",
+		"/auth.Auth/FinishPasskeyRegistration",
+		"/auth.Auth/ChangePassword",
+		"/auth.Auth/ChangeEmail",
+		"/userInfo.UserInfo/UserInfo",
+	}
+	appRequired = []string{
+		"/auth.Auth/Introspect",
 	}
 )
 
 type AppProvider interface {
+	AppByID(ctx context.Context, appID int) (models.App, error)
 	AppByKey(ctx context.Context, apiKey string) (models.App, error)
 }
 
-func UnaryAuthenticationInterceptor(log *slog.Logger, appProvider AppProvider, userKey string) grpc.UnaryServerInterceptor {
+type TokenProvider interface {
+	IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error)
+}
+
+// PolicyEvaluator decides on authenticated requests by attribute-based policies
+type PolicyEvaluator interface {
+	Evaluate(input policy.Input) policy.Decision
+}
+
+func UnaryAuthenticationInterceptor(
+	log *slog.Logger,
+	appProvider AppProvider,
+	tokenProvider TokenProvider,
+	keys *jwt.KeyRing,
+	params jwt.Params,
+	policies PolicyEvaluator,
+) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
 		const op = "grpc.interceptor.UnaryAuthenticationInterceptor"
 
@@ -38,18 +131,33 @@ func UnaryAuthenticationInterceptor(log *slog.Logger, appProvider AppProvider, u
 
 		log.Info("auth interceptor enabled")
 
-		var err error
-
-		for _, s := range permissionRequired {
-			if s == method {
-				md, ok := metadata.FromIncomingContext(ctx)
-				if !ok {
-					err = status.Error(codes.InvalidArgument, "missing metadata")
-					break
-				}
-				err = valid(md["authorization"], log, appProvider, userKey)
-				break
+		permission, adminOnly := permissionRequired[method]
+		userOnly := slices.Contains(authRequired, method)
+		appOnly := slices.Contains(appRequired, method)
+		if !adminOnly && !userOnly && !appOnly {
+			if err := authorize(ctx, log, policies, method, req, nil, models.App{}); err != nil {
+				return nil, err
 			}
+			return handler(ctx, req)
+		}
+
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			err := status.Error(codes.InvalidArgument, "missing metadata")
+			log.Warn("auth error", sl.Err(err))
+			return nil, err
+		}
+
+		var token *jwt.Token
+		var app models.App
+		var err error
+		switch {
+		case adminOnly:
+			token, app, err = valid(ctx, md["authorization"], permission, slices.Contains(appAllowed, method), log, appProvider, tokenProvider, keys, params)
+		case userOnly:
+			token, err = validUser(ctx, md["authorization"], log, tokenProvider, keys, params)
+		case appOnly:
+			app, err = validApp(ctx, md["authorization"], log, appProvider, tokenProvider, keys, params)
 		}
 
 		if err != nil {
@@ -59,6 +167,17 @@ func UnaryAuthenticationInterceptor(log *slog.Logger, appProvider AppProvider, u
 
 		log.Info("request authenticated")
 
+		if err := authorize(ctx, log, policies, method, req, token, app); err != nil {
+			return nil, err
+		}
+
+		if token != nil {
+			ctx = jwt.WithToken(ctx, token)
+		}
+		if token == nil {
+			ctx = context.WithValue(ctx, appKey{}, app)
+		}
+
 		return handler(ctx, req)
 	}
 }
@@ -66,48 +185,286 @@ func UnaryAuthenticationInterceptor(log *slog.Logger, appProvider AppProvider, u
 var (
 	internalErr = status.Error(codes.Internal, "internal error")
 	authErr     = status.Error(codes.Unauthenticated, "invalid token or key")
+	deniedErr   = status.Error(codes.PermissionDenied, "permission denied")
 )
 
-func valid(authorization []string, log *slog.Logger, appProvider AppProvider, userKey string) error {
+// authorize decides on the request by policies, caller is either user by token, app, or nobody
+func authorize(
+	ctx context.Context,
+	log *slog.Logger,
+	policies PolicyEvaluator,
+	method string,
+	req any,
+	token *jwt.Token,
+	app models.App,
+) error {
+	ip, _ := clientip.FromContext(ctx)
+
+	decision := policies.Evaluate(policy.Input{
+		Method:  method,
+		Subject: subjectAttributes(token, app),
+		Request: req,
+		IP:      ip,
+		Time:    time.Now(),
+	})
+	if !decision.Allowed {
+		log.Warn("request denied by policies", slog.String("reason", decision.Reason))
+		return deniedErr
+	}
+
+	return nil
+}
+
+// subjectAttributes returns attributes of the caller policies refer to as subject. All of them are set
+// for any caller, so conditions don't fail on missing ones: type is user, app or anonymous,
+// id and email are of the user, app_id is of the app the token is issued to or the app itself
+func subjectAttributes(token *jwt.Token, app models.App) map[string]any {
+	subject := map[string]any{
+		"type":            "anonymous",
+		"id":              0,
+		"email":           "",
+		"roles":           []string{},
+		"permissions":     []string{},
+		"app_roles":       []string{},
+		"app_permissions": []string{},
+		"scope":           "",
+		"app_id":          0,
+		"impersonator":    "",
+	}
+
+	switch {
+	case token != nil:
+		appID, _ := strconv.Atoi(token.ClientID)
+		subject["type"] = "user"
+		subject["id"] = token.UID
+		subject["email"] = token.Email
+		subject["roles"] = token.Roles
+		subject["permissions"] = token.Permissions
+		subject["app_roles"] = token.AppRoles
+		subject["app_permissions"] = token.AppPermissions
+		subject["scope"] = token.Scope
+		subject["app_id"] = appID
+		if token.Act != nil {
+			subject["impersonator"] = token.Act.Subject
+		}
+	case app.ID != 0:
+		subject["type"] = "app"
+		subject["app_id"] = app.ID
+	}
+
+	return subject
+}
+
+// valid authenticates either a user with given permission by token or, if apps are allowed,
+// an app by api key or client token
+//
+// For apps returned token is nil, for users returned app is empty
+func valid(
+	ctx context.Context,
+	authorization []string,
+	permission string,
+	appAllowed bool,
+	log *slog.Logger,
+	appProvider AppProvider,
+	tokenProvider TokenProvider,
+	keys *jwt.KeyRing,
+	params jwt.Params,
+) (*jwt.Token, models.App, error) {
 	const op = "interceptor.auth.valid"
 
 	log = log.With("op", op)
 
 	if len(authorization) < 1 {
-		return authErr
+		return nil, models.App{}, authErr
 	}
 
-	token := strings.TrimPrefix(authorization[0], "Bearer ")
+	raw := strings.TrimPrefix(authorization[0], "Bearer ")
+
+	if strings.ContainsRune(raw, '.') {
+		token, err := parse(ctx, raw, log, tokenProvider, keys, params)
+		if err != nil {
+			return nil, models.App{}, err
+		}
+
+		if token.IsClient() {
+			app, err := clientApp(ctx, token, appProvider)
+			if err != nil {
+				return nil, models.App{}, err
+			}
+			if !appAllowed {
+				log.Info("app is not allowed", slog.Int("app_id", app.ID))
+				return nil, models.App{}, deniedErr
+			}
+			log.Info("app is authenticated", slog.Int("app_id", app.ID))
+			return nil, app, nil
+		}
+
+		if !token.HasPermission(permission) {
+			log.Info("permission denied", slog.String("permission", permission))
+			return nil, models.App{}, deniedErr
+		}
+
+		log.Info("user is authenticated")
 
-	isUser := false
-	for _, c := range token {
-		if c == rune('.') {
-			isUser = true
-			break
+		return token, models.App{}, nil
+	}
+
+	app, err := appProvider.AppByKey(ctx, raw)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return nil, models.App{}, authErr
 		}
+		return nil, models.App{}, internalErr
+	}
+	if !appAllowed {
+		log.Info("app is not allowed", slog.Int("app_id", app.ID))
+		return nil, models.App{}, deniedErr
 	}
+	log.Info("app is authenticated", slog.Int("app_id", app.ID))
 
-	if isUser {
-		token, err := jwt.Parse(token, userKey)
+	return nil, app, nil
+}
+
+// validUser authenticates any user by token
+func validUser(
+	ctx context.Context,
+	authorization []string,
+	log *slog.Logger,
+	tokenProvider TokenProvider,
+	keys *jwt.KeyRing,
+	params jwt.Params,
+) (*jwt.Token, error) {
+	const op = "interceptor.auth.validUser"
+
+	log = log.With("op", op)
+
+	if len(authorization) < 1 {
+		return nil, authErr
+	}
+
+	token, err := parse(ctx, strings.TrimPrefix(authorization[0], "Bearer "), log, tokenProvider, keys, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if token.IsClient() {
+		log.Info("client token is not allowed")
+		return nil, authErr
+	}
+
+	log.Info("user is authenticated")
+
+	return token, nil
+}
+
+// validApp authenticates an app by api key or client token
+func validApp(
+	ctx context.Context,
+	authorization []string,
+	log *slog.Logger,
+	appProvider AppProvider,
+	tokenProvider TokenProvider,
+	keys *jwt.KeyRing,
+	params jwt.Params,
+) (models.App, error) {
+	const op = "interceptor.auth.validApp"
+
+	log = log.With("op", op)
+
+	if len(authorization) < 1 {
+		return models.App{}, authErr
+	}
+
+	raw := strings.TrimPrefix(authorization[0], "Bearer ")
+
+	if strings.ContainsRune(raw, '.') {
+		token, err := parse(ctx, raw, log, tokenProvider, keys, params)
 		if err != nil {
-			return internalErr
+			return models.App{}, err
 		}
 
-		if token.Level < 2 {
-			return authErr
+		if !token.IsClient() {
+			log.Info("user token is not allowed")
+			return models.App{}, authErr
 		}
 
-		log.Info("user is authenticated")
-	} else {
-		_, err := appProvider.AppByKey(context.Background(), token)
+		app, err := clientApp(ctx, token, appProvider)
 		if err != nil {
-			if errors.Is(err, storage.ErrAppNotFound) {
-				return authErr
-			}
-			return internalErr
+			return models.App{}, err
 		}
-		log.Info("app is authenticated")
+
+		log.Info("app is authenticated", slog.Int("app_id", app.ID))
+
+		return app, nil
 	}
 
-	return nil
+	app, err := appProvider.AppByKey(ctx, raw)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, authErr
+		}
+		return models.App{}, internalErr
+	}
+
+	log.Info("app is authenticated", slog.Int("app_id", app.ID))
+
+	return app, nil
+}
+
+// clientApp returns the app client token is issued to
+func clientApp(ctx context.Context, token *jwt.Token, appProvider AppProvider) (models.App, error) {
+	appID, err := strconv.Atoi(token.ClientID)
+	if err != nil {
+		return models.App{}, authErr
+	}
+
+	app, err := appProvider.AppByID(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, authErr
+		}
+		return models.App{}, internalErr
+	}
+
+	return app, nil
+}
+
+// parse parses the token and checks that it has not been revoked
+func parse(
+	ctx context.Context,
+	raw string,
+	log *slog.Logger,
+	tokenProvider TokenProvider,
+	keys *jwt.KeyRing,
+	params jwt.Params,
+) (*jwt.Token, error) {
+	token, err := jwt.Parse(raw, keys, params)
+	if err != nil {
+		if errors.Is(err, jwt.ErrInvalidToken) || errors.Is(err, jwt.ErrExpiredToken) || errors.Is(err, jwt.ErrInvalidClaims) {
+			log.Info("invalid token", sl.Err(err))
+			return nil, authErr
+		}
+		log.Error("failed to parse token", sl.Err(err))
+		return nil, internalErr
+	}
+
+	revoked, err := tokenProvider.IsTokenRevoked(ctx, token.ID, token.UID, token.IssuedAt)
+	if err != nil {
+		log.Error("failed to check token revocation", sl.Err(err))
+		return nil, internalErr
+	}
+	if revoked {
+		log.Info("token is revoked")
+		return nil, authErr
+	}
+
+	return token, nil
+}
+
+type appKey struct{}
+
+// AppFromContext returns the app authenticated by api key or client token, if any
+func AppFromContext(ctx context.Context) (models.App, bool) {
+	app, ok := ctx.Value(appKey{}).(models.App)
+	return app, ok
 }
@@ -0,0 +1,18 @@
+package clientip
+
+import (
+	"context"
+	"net/netip"
+
+	"sso/internal/lib/clientip"
+
+	"google.golang.org/grpc"
+)
+
+// UnaryClientIPInterceptor stores address of the client in request context,
+// forwarded addresses are accepted from trusted proxies only
+func UnaryClientIPInterceptor(trusted []netip.Prefix) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(clientip.WithIP(ctx, clientip.Resolve(ctx, trusted)), req)
+	}
+}
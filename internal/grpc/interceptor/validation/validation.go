@@ -3,12 +3,18 @@ package validation
 import (
 	"context"
 	"log/slog"
+	"net/mail"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/rebac"
+	"strings"
+	"unicode"
 
 	ssov1 "github.com/dedmouze/protos/gen/go/sso"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 func UnaryValidationInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
@@ -24,19 +30,117 @@ func UnaryValidationInterceptor(log *slog.Logger) grpc.UnaryServerInterceptor {
 
 		switch method {
 		case "/auth.Auth/Login":
-			err = validateEmailPassword(req.(*ssov1.LoginRequest))
+			err = validateLogin(req.(*ssov1.LoginRequest))
+		case "/auth.Auth/Refresh":
+			err = validateRefresh(req.(*ssov1.RefreshRequest))
+		case "/auth.Auth/Logout":
+			// refresh token is optional
+		case "/auth.Auth/Keys", "/auth.Auth/RotateKeys":
+			// nothing to validate
+		case "/auth.Auth/Introspect":
+			err = validateIntrospect(req.(*ssov1.IntrospectRequest))
+		case "/auth.Auth/Authorize":
+			err = validateAuthorize(req.(*ssov1.AuthorizeRequest))
+		case "/auth.Auth/Token":
+			err = validateToken(req.(*ssov1.TokenRequest))
+		case "/auth.Auth/DeviceAuthorize":
+			err = validateDeviceAuthorize(req.(*ssov1.DeviceAuthorizeRequest))
+		case "/auth.Auth/VerifyDevice":
+			err = validateVerifyDevice(req.(*ssov1.VerifyDeviceRequest))
+		case "/auth.Auth/EnrollMFA":
+			// nothing to validate
+		case "/auth.Auth/ConfirmMFA":
+			err = validateConfirmMFA(req.(*ssov1.ConfirmMFARequest))
+		case "/auth.Auth/VerifyMFA":
+			err = validateVerifyMFA(req.(*ssov1.VerifyMFARequest))
+		case "/auth.Auth/BeginPasskeyRegistration":
+			// nothing to validate
+		case "/auth.Auth/FinishPasskeyRegistration":
+			err = validatePasskeyCredential(req.(*ssov1.FinishPasskeyRegistrationRequest))
+		case "/auth.Auth/BeginPasskeyLogin":
+			err = validateBeginPasskeyLogin(req.(*ssov1.BeginPasskeyLoginRequest))
+		case "/auth.Auth/FinishPasskeyLogin":
+			err = validatePasskeyCredential(req.(*ssov1.FinishPasskeyLoginRequest))
 		case "/auth.Auth/Register":
-			err = validateEmailPassword(req.(*ssov1.RegisterRequest))
+			err = validateRegister(req.(*ssov1.RegisterRequest))
+		case "/auth.Auth/VerifyEmail":
+			err = validateVerifyEmail(req.(*ssov1.VerifyEmailRequest))
+		case "/auth.Auth/RequestPasswordReset":
+			err = validateEmail(req.(*ssov1.RequestPasswordResetRequest))
+		case "/auth.Auth/ResetPassword":
+			err = validateResetPassword(req.(*ssov1.ResetPasswordRequest))
+		case "/auth.Auth/ChangePassword":
+			err = validateChangePassword(req.(*ssov1.ChangePasswordRequest))
+		case "/auth.Auth/ChangeEmail":
+			err = validateChangeEmail(req.(*ssov1.ChangeEmailRequest))
 		case "/auth.Auth/RegisterApp":
 			err = validateRegisterApp(req.(*ssov1.RegisterAppRequest))
 		case "/userInfo.UserInfo/User":
 			err = validateEmail(req.(*ssov1.UserRequest))
 		case "/userInfo.UserInfo/Admin":
 			err = validateEmail(req.(*ssov1.AdminRequest))
+		case "/userInfo.UserInfo/UserInfo":
+			// nothing to validate
 		case "/permission.Permission/AddAdmin":
 			err = validateEmail(req.(*ssov1.AddAdminRequest))
 		case "/permission.Permission/DeleteAdmin":
 			err = validateEmail(req.(*ssov1.DeleteAdminRequest))
+		case "/permission.Permission/ResetMFA":
+			err = validateEmail(req.(*ssov1.ResetMFARequest))
+		case "/permission.Permission/UnlockUser":
+			err = validateEmail(req.(*ssov1.UnlockUserRequest))
+		case "/permission.Permission/CreateRole":
+			err = validateName(req.(*ssov1.CreateRoleRequest))
+		case "/permission.Permission/UpdateRole":
+			err = validateName(req.(*ssov1.UpdateRoleRequest))
+		case "/permission.Permission/DeleteRole":
+			err = validateName(req.(*ssov1.DeleteRoleRequest))
+		case "/permission.Permission/CreatePermission":
+			err = validateName(req.(*ssov1.CreatePermissionRequest))
+		case "/permission.Permission/UpdatePermission":
+			err = validateName(req.(*ssov1.UpdatePermissionRequest))
+		case "/permission.Permission/DeletePermission":
+			err = validateName(req.(*ssov1.DeletePermissionRequest))
+		case "/permission.Permission/ListRoles", "/permission.Permission/ListPermissions":
+			// nothing to validate
+		case "/permission.Permission/GrantPermission":
+			err = validateRolePermission(req.(*ssov1.GrantPermissionRequest))
+		case "/permission.Permission/RevokePermission":
+			err = validateRolePermission(req.(*ssov1.RevokePermissionRequest))
+		case "/permission.Permission/AssignRole":
+			err = validateEmailRole(req.(*ssov1.AssignRoleRequest))
+		case "/permission.Permission/UnassignRole":
+			err = validateEmailRole(req.(*ssov1.UnassignRoleRequest))
+		case "/permission.Permission/Check":
+			err = validateCheck(req.(*ssov1.CheckRequest))
+		case "/permission.Permission/CheckMany":
+			err = validateCheckMany(req.(*ssov1.CheckManyRequest))
+		case "/permission.Permission/CreateGroup":
+			err = validateName(req.(*ssov1.CreateGroupRequest))
+		case "/permission.Permission/DeleteGroup":
+			err = validateName(req.(*ssov1.DeleteGroupRequest))
+		case "/permission.Permission/AddGroupMember":
+			err = validateGroupMember(req.(*ssov1.AddGroupMemberRequest))
+		case "/permission.Permission/RemoveGroupMember":
+			err = validateGroupMember(req.(*ssov1.RemoveGroupMemberRequest))
+		case "/permission.Permission/AssignGroupRole":
+			err = validateGroupRole(req.(*ssov1.AssignGroupRoleRequest))
+		case "/permission.Permission/UnassignGroupRole":
+			err = validateGroupRole(req.(*ssov1.UnassignGroupRoleRequest))
+		case "/permission.Permission/GrantResource":
+			err = validateResourceGrant(req.(*ssov1.GrantResourceRequest))
+		case "/permission.Permission/RevokeResource":
+			err = validateResourceGrant(req.(*ssov1.RevokeResourceRequest))
+		case "/relation.Relation/WriteTuples":
+			err = validateTuples(req.(*ssov1.WriteTuplesRequest).GetTuples())
+		case "/relation.Relation/DeleteTuples":
+			err = validateTuples(req.(*ssov1.DeleteTuplesRequest).GetTuples())
+		case "/relation.Relation/ReadTuples":
+			err = validateReadTuples(req.(*ssov1.ReadTuplesRequest))
+		case "/relation.Relation/Check":
+			err = validateTuple(req.(*ssov1.RelationCheckRequest))
+		case "/relation.Relation/LookupResources":
+			err = validateLookupResources(req.(*ssov1.LookupResourcesRequest))
 		default:
 			err = status.Error(codes.Unimplemented, "method not found")
 		}
@@ -55,6 +159,62 @@ var (
 	emailRequired    = "email is required"
 	nameRequired     = "name is required"
 	passwordRequired = "password is required"
+	refreshRequired  = "refresh token is required"
+	tokenRequired    = "token is required"
+	appIDRequired    = "app_id is required"
+
+	clientIDRequired     = "client_id is required"
+	redirectURIRequired  = "redirect_uri is required"
+	codeRequired         = "code is required"
+	invalidCodeChallenge = "code_challenge is required with S256 method"
+	invalidCodeVerifier  = "code_verifier is invalid"
+	grantTypeRequired    = "grant_type is required"
+
+	clientCredentialsRequired = "client_id and client_secret are required"
+
+	deviceCodeRequired = "device_code is required"
+	userCodeRequired   = "user_code is required"
+
+	subjectTokenRequired       = "subject_token is required"
+	invalidSubjectTokenType    = "subject_token_type is invalid"
+	invalidActorTokenType      = "actor_token_type is invalid"
+	invalidRequestedTokenType  = "requested_token_type is not supported"
+	impersonationActorRequired = "actor_token is required for impersonation"
+
+	mfaTokenRequired = "mfa_token is required"
+
+	sessionIDRequired       = "session_id is required"
+	credentialRequired      = "credential is required"
+	appIDOrMFATokenRequired = "app_id or mfa_token is required"
+
+	invalidEmail = "email is invalid"
+	invalidName  = "name must not contain spaces"
+
+	currentPasswordRequired = "current_password is required"
+	newPasswordRequired     = "new_password is required"
+
+	roleRequired       = "role is required"
+	permissionRequired = "permission is required"
+
+	subjectRequired      = "subject is required"
+	checksRequired       = "checks are required"
+	tooManyChecks        = "at most 100 checks are allowed"
+	groupRequired        = "group is required"
+	resourceRequired     = "resource is required"
+	emailOrGroupRequired = "exactly one of email and group is required"
+
+	tuplesRequired    = "tuples are required"
+	tooManyTuples     = "at most 100 tuples are allowed"
+	namespaceRequired = "namespace is required"
+	relationRequired  = "relation is required"
+	invalidRelation   = "relation must consist of letters, digits and underscores"
+)
+
+const (
+	// maxChecks bounds CheckMany, so a single request can't hold the storage for long
+	maxChecks = 100
+	// maxTuples bounds tuples written or deleted in a single transaction
+	maxTuples = 100
 )
 
 type requestEmail interface {
@@ -83,9 +243,407 @@ func validateEmailPassword(req requestEmailPassword) error {
 	return nil
 }
 
+func validateLogin(req *ssov1.LoginRequest) error {
+	if err := validateEmailPassword(req); err != nil {
+		return err
+	}
+	if req.GetAppId() == 0 {
+		return status.Error(codes.InvalidArgument, appIDRequired)
+	}
+	return nil
+}
+
+func validateRefresh(req *ssov1.RefreshRequest) error {
+	if req.GetRefreshToken() == "" {
+		return status.Error(codes.InvalidArgument, refreshRequired)
+	}
+	return nil
+}
+
+func validateIntrospect(req *ssov1.IntrospectRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, tokenRequired)
+	}
+	return nil
+}
+
+// validateRegister also checks email format, since verification link is sent there
+func validateRegister(req *ssov1.RegisterRequest) error {
+	if err := validateEmailPassword(req); err != nil {
+		return err
+	}
+	return validateEmailFormat(req.GetEmail())
+}
+
+func validateChangePassword(req *ssov1.ChangePasswordRequest) error {
+	if req.GetCurrentPassword() == "" {
+		return status.Error(codes.InvalidArgument, currentPasswordRequired)
+	}
+	if req.GetNewPassword() == "" {
+		return status.Error(codes.InvalidArgument, newPasswordRequired)
+	}
+	return nil
+}
+
+func validateChangeEmail(req *ssov1.ChangeEmailRequest) error {
+	if err := validateEmailPassword(req); err != nil {
+		return err
+	}
+	return validateEmailFormat(req.GetEmail())
+}
+
+// validateEmailFormat accepts only bare addresses, without display name or comments
+func validateEmailFormat(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return status.Error(codes.InvalidArgument, invalidEmail)
+	}
+	return nil
+}
+
+func validateVerifyEmail(req *ssov1.VerifyEmailRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, tokenRequired)
+	}
+	return nil
+}
+
+func validateResetPassword(req *ssov1.ResetPasswordRequest) error {
+	if req.GetToken() == "" {
+		return status.Error(codes.InvalidArgument, tokenRequired)
+	}
+	if req.GetPassword() == "" {
+		return status.Error(codes.InvalidArgument, passwordRequired)
+	}
+	return nil
+}
+
 func validateRegisterApp(req *ssov1.RegisterAppRequest) error {
 	if req.GetName() == "" {
 		return status.Error(codes.InvalidArgument, nameRequired)
 	}
 	return nil
 }
+
+type requestName interface {
+	GetName() string
+}
+
+type requestRolePermission interface {
+	GetRole() string
+	GetPermission() string
+}
+
+type requestEmailRole interface {
+	requestEmail
+	GetRole() string
+}
+
+// validateName also checks name has no spaces, since permissions are carried in space separated claims
+func validateName(req requestName) error {
+	if req.GetName() == "" {
+		return status.Error(codes.InvalidArgument, nameRequired)
+	}
+	if strings.ContainsFunc(req.GetName(), unicode.IsSpace) {
+		return status.Error(codes.InvalidArgument, invalidName)
+	}
+	return nil
+}
+
+func validateRolePermission(req requestRolePermission) error {
+	if req.GetRole() == "" {
+		return status.Error(codes.InvalidArgument, roleRequired)
+	}
+	if req.GetPermission() == "" {
+		return status.Error(codes.InvalidArgument, permissionRequired)
+	}
+	return nil
+}
+
+func validateEmailRole(req requestEmailRole) error {
+	if req.GetEmail() == "" {
+		return status.Error(codes.InvalidArgument, emailRequired)
+	}
+	if req.GetRole() == "" {
+		return status.Error(codes.InvalidArgument, roleRequired)
+	}
+	return nil
+}
+
+func validateCheck(req *ssov1.CheckRequest) error {
+	if req.GetSubject() == "" {
+		return status.Error(codes.InvalidArgument, subjectRequired)
+	}
+	if req.GetPermission() == "" {
+		return status.Error(codes.InvalidArgument, permissionRequired)
+	}
+	return nil
+}
+
+func validateCheckMany(req *ssov1.CheckManyRequest) error {
+	if len(req.GetChecks()) == 0 {
+		return status.Error(codes.InvalidArgument, checksRequired)
+	}
+	if len(req.GetChecks()) > maxChecks {
+		return status.Error(codes.InvalidArgument, tooManyChecks)
+	}
+	for _, check := range req.GetChecks() {
+		if err := validateCheck(check); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type requestGroupMember interface {
+	requestEmail
+	GetGroup() string
+}
+
+func validateGroupMember(req requestGroupMember) error {
+	if req.GetGroup() == "" {
+		return status.Error(codes.InvalidArgument, groupRequired)
+	}
+	if req.GetEmail() == "" {
+		return status.Error(codes.InvalidArgument, emailRequired)
+	}
+	return nil
+}
+
+type requestGroupRole interface {
+	GetGroup() string
+	GetRole() string
+}
+
+func validateGroupRole(req requestGroupRole) error {
+	if req.GetGroup() == "" {
+		return status.Error(codes.InvalidArgument, groupRequired)
+	}
+	if req.GetRole() == "" {
+		return status.Error(codes.InvalidArgument, roleRequired)
+	}
+	return nil
+}
+
+type requestResourceGrant interface {
+	requestGroupMember
+	GetPermission() string
+	GetResource() string
+}
+
+func validateResourceGrant(req requestResourceGrant) error {
+	if req.GetPermission() == "" {
+		return status.Error(codes.InvalidArgument, permissionRequired)
+	}
+	if req.GetResource() == "" {
+		return status.Error(codes.InvalidArgument, resourceRequired)
+	}
+	if (req.GetEmail() == "") == (req.GetGroup() == "") {
+		return status.Error(codes.InvalidArgument, emailOrGroupRequired)
+	}
+	return nil
+}
+
+type requestTuple interface {
+	GetObject() string
+	GetRelation() string
+	GetSubject() string
+}
+
+func validateTuple(req requestTuple) error {
+	if _, _, err := rebac.ParseObject(req.GetObject()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := validateRelation(req.GetRelation()); err != nil {
+		return err
+	}
+	if _, err := rebac.ParseSubject(req.GetSubject()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func validateTuples(tuples []*ssov1.RelationTuple) error {
+	if len(tuples) == 0 {
+		return status.Error(codes.InvalidArgument, tuplesRequired)
+	}
+	if len(tuples) > maxTuples {
+		return status.Error(codes.InvalidArgument, tooManyTuples)
+	}
+	for _, tuple := range tuples {
+		if err := validateTuple(tuple); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateReadTuples(req *ssov1.ReadTuplesRequest) error {
+	if req.GetNamespace() == "" {
+		return status.Error(codes.InvalidArgument, namespaceRequired)
+	}
+	if req.GetSubject() != "" {
+		if _, err := rebac.ParseSubject(req.GetSubject()); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+func validateLookupResources(req *ssov1.LookupResourcesRequest) error {
+	if req.GetNamespace() == "" {
+		return status.Error(codes.InvalidArgument, namespaceRequired)
+	}
+	if err := validateRelation(req.GetRelation()); err != nil {
+		return err
+	}
+	if _, err := rebac.ParseSubject(req.GetSubject()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func validateRelation(relation string) error {
+	if relation == "" {
+		return status.Error(codes.InvalidArgument, relationRequired)
+	}
+	if !rebac.IsName(relation) {
+		return status.Error(codes.InvalidArgument, invalidRelation)
+	}
+	return nil
+}
+
+func validateAuthorize(req *ssov1.AuthorizeRequest) error {
+	if err := validateEmailPassword(req); err != nil {
+		return err
+	}
+	if req.GetClientId() == "" {
+		return status.Error(codes.InvalidArgument, clientIDRequired)
+	}
+	if req.GetRedirectUri() == "" {
+		return status.Error(codes.InvalidArgument, redirectURIRequired)
+	}
+	if req.GetCodeChallenge() == "" || req.GetCodeChallengeMethod() != oauth.CodeChallengeS256 {
+		return status.Error(codes.InvalidArgument, invalidCodeChallenge)
+	}
+	return nil
+}
+
+func validateToken(req *ssov1.TokenRequest) error {
+	switch req.GetGrantType() {
+	case "":
+		return status.Error(codes.InvalidArgument, grantTypeRequired)
+	case oauth.GrantTypeAuthorizationCode:
+		if req.GetCode() == "" {
+			return status.Error(codes.InvalidArgument, codeRequired)
+		}
+		if req.GetClientId() == "" {
+			return status.Error(codes.InvalidArgument, clientIDRequired)
+		}
+		if req.GetRedirectUri() == "" {
+			return status.Error(codes.InvalidArgument, redirectURIRequired)
+		}
+		if !oauth.ValidCodeVerifier(req.GetCodeVerifier()) {
+			return status.Error(codes.InvalidArgument, invalidCodeVerifier)
+		}
+	case oauth.GrantTypeClientCredentials:
+		if req.GetClientId() == "" || req.GetClientSecret() == "" {
+			return oauth.Error(codes.Unauthenticated, oauth.ErrorInvalidClient, clientCredentialsRequired)
+		}
+	case oauth.GrantTypeDeviceCode:
+		if req.GetDeviceCode() == "" {
+			return status.Error(codes.InvalidArgument, deviceCodeRequired)
+		}
+		if req.GetClientId() == "" {
+			return status.Error(codes.InvalidArgument, clientIDRequired)
+		}
+	case oauth.GrantTypeTokenExchange:
+		return validateTokenExchange(req)
+	default:
+		// unsupported grant type is reported by handler
+	}
+	return nil
+}
+
+func validateDeviceAuthorize(req *ssov1.DeviceAuthorizeRequest) error {
+	if req.GetClientId() == "" {
+		return status.Error(codes.InvalidArgument, clientIDRequired)
+	}
+	return nil
+}
+
+func validateVerifyDevice(req *ssov1.VerifyDeviceRequest) error {
+	if err := validateEmailPassword(req); err != nil {
+		return err
+	}
+	if req.GetUserCode() == "" {
+		return status.Error(codes.InvalidArgument, userCodeRequired)
+	}
+	return nil
+}
+
+func validateTokenExchange(req *ssov1.TokenRequest) error {
+	if req.GetClientId() == "" || req.GetClientSecret() == "" {
+		return oauth.Error(codes.Unauthenticated, oauth.ErrorInvalidClient, clientCredentialsRequired)
+	}
+	if req.GetSubjectToken() == "" {
+		return status.Error(codes.InvalidArgument, subjectTokenRequired)
+	}
+
+	subjectType := req.GetSubjectTokenType()
+	if !oauth.IsAccessTokenType(subjectType) && subjectType != oauth.TokenTypeEmail {
+		return status.Error(codes.InvalidArgument, invalidSubjectTokenType)
+	}
+
+	if req.GetActorToken() != "" && !oauth.IsAccessTokenType(req.GetActorTokenType()) {
+		return status.Error(codes.InvalidArgument, invalidActorTokenType)
+	}
+	if req.GetActorToken() == "" && subjectType == oauth.TokenTypeEmail {
+		return status.Error(codes.InvalidArgument, impersonationActorRequired)
+	}
+
+	if requested := req.GetRequestedTokenType(); requested != "" && !oauth.IsAccessTokenType(requested) {
+		return status.Error(codes.InvalidArgument, invalidRequestedTokenType)
+	}
+
+	return nil
+}
+
+func validateConfirmMFA(req *ssov1.ConfirmMFARequest) error {
+	if req.GetCode() == "" {
+		return status.Error(codes.InvalidArgument, codeRequired)
+	}
+	return nil
+}
+
+func validateVerifyMFA(req *ssov1.VerifyMFARequest) error {
+	if req.GetMfaToken() == "" {
+		return status.Error(codes.InvalidArgument, mfaTokenRequired)
+	}
+	if req.GetCode() == "" {
+		return status.Error(codes.InvalidArgument, codeRequired)
+	}
+	return nil
+}
+
+type requestPasskeyCredential interface {
+	GetSessionId() string
+	GetCredential() *structpb.Struct
+}
+
+func validatePasskeyCredential(req requestPasskeyCredential) error {
+	if req.GetSessionId() == "" {
+		return status.Error(codes.InvalidArgument, sessionIDRequired)
+	}
+	if len(req.GetCredential().GetFields()) == 0 {
+		return status.Error(codes.InvalidArgument, credentialRequired)
+	}
+	return nil
+}
+
+func validateBeginPasskeyLogin(req *ssov1.BeginPasskeyLoginRequest) error {
+	if req.GetAppId() == 0 && req.GetMfaToken() == "" {
+		return status.Error(codes.InvalidArgument, appIDOrMFATokenRequired)
+	}
+	return nil
+}
@@ -0,0 +1,609 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/jwt"
+	oauthLib "sso/internal/lib/oauth"
+	"sso/internal/lib/oidc"
+
+	ssov1 "github.com/dedmouze/protos/gen/go/sso"
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// loginPage is a minimal hosted login page of the authorization endpoint
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Form}}
+<form method="post" action="/authorize">
+	<input type="hidden" name="client_id" value="{{.ClientID}}">
+	<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+	<input type="hidden" name="state" value="{{.State}}">
+	<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+	<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+	<input type="hidden" name="scope" value="{{.Scope}}">
+	<input type="hidden" name="nonce" value="{{.Nonce}}">
+	<p><input type="text" name="email" placeholder="Email" required></p>
+	<p><input type="password" name="password" placeholder="Password" required></p>
+	<p><input type="text" name="mfa_code" placeholder="Authentication code, if enabled" autocomplete="one-time-code"></p>
+	<p><button type="submit">Sign in</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// devicePage is a verification page of the device authorization grant, where the user
+// signs in and approves or denies the device by user code
+var devicePage = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Connect a device</title></head>
+<body>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Message}}<p>{{.Message}}</p>{{else}}
+<form method="post" action="/device">
+	<p><input type="text" name="user_code" value="{{.UserCode}}" placeholder="Code shown on your device" required></p>
+	<p><input type="text" name="email" placeholder="Email" required></p>
+	<p><input type="password" name="password" placeholder="Password" required></p>
+	<p><input type="text" name="mfa_code" placeholder="Authentication code, if enabled" autocomplete="one-time-code"></p>
+	<p>
+		<button type="submit" name="action" value="approve">Approve</button>
+		<button type="submit" name="action" value="deny">Deny</button>
+	</p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+// resetPage is a page of the password reset link, where the user sets a new password
+var resetPage = template.Must(template.New("reset").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Reset password</title></head>
+<body>
+{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+{{if .Violations}}<ul style="color: red">{{range .Violations}}<li>{{.}}</li>{{end}}</ul>{{end}}
+{{if .Message}}<p>{{.Message}}</p>{{else}}
+<form method="post" action="/reset_password">
+	<input type="hidden" name="token" value="{{.Token}}">
+	<p><input type="password" name="password" placeholder="New password" autocomplete="new-password" required></p>
+	<p><input type="password" name="password_confirm" placeholder="Repeat new password" autocomplete="new-password" required></p>
+	<p><button type="submit">Set password</button></p>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type page struct {
+	Error               string
+	Form                bool
+	ClientID            string
+	RedirectURI         string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Scope               string
+	Nonce               string
+}
+
+type deviceVerification struct {
+	Error    string
+	Message  string
+	UserCode string
+}
+
+type passwordReset struct {
+	Error   string
+	Message string
+	Token   string
+	// Violations are rules the submitted password fails
+	Violations []string
+}
+
+type deviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// discovery is OpenID Connect discovery document
+type discovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserInfoEndpoint                  string   `json:"userinfo_endpoint"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+}
+
+// grantTypes are grant types supported by the token endpoint
+var grantTypes = []string{
+	oauthLib.GrantTypeAuthorizationCode,
+	oauthLib.GrantTypeClientCredentials,
+	oauthLib.GrantTypeDeviceCode,
+	oauthLib.GrantTypeTokenExchange,
+}
+
+// Handler serves OAuth 2.0 and OpenID Connect endpoints on top of the Auth service
+type Handler struct {
+	auth            ssov1.AuthClient
+	discovery       discovery
+	verificationURI string
+}
+
+// New returns a new instance of the Handler, issuer is the public URL of the proxy
+func New(auth ssov1.AuthClient, issuer string) *Handler {
+	base := strings.TrimSuffix(issuer, "/")
+
+	return &Handler{
+		auth:            auth,
+		verificationURI: base + "/device",
+		discovery: discovery{
+			Issuer:                            issuer,
+			AuthorizationEndpoint:             base + "/authorize",
+			DeviceAuthorizationEndpoint:       base + "/device_authorization",
+			TokenEndpoint:                     base + "/token",
+			UserInfoEndpoint:                  base + "/userinfo",
+			IntrospectionEndpoint:             base + "/introspect",
+			JWKSURI:                           base + "/.well-known/jwks.json",
+			ResponseTypesSupported:            []string{oauthLib.ResponseTypeCode},
+			GrantTypesSupported:               grantTypes,
+			SubjectTypesSupported:             []string{"public"},
+			IDTokenSigningAlgValuesSupported:  []string{jwt.AlgorithmRS256, jwt.AlgorithmES256, jwt.AlgorithmEdDSA},
+			ScopesSupported:                   oidc.Scopes,
+			ClaimsSupported:                   append([]string{"iss", "aud", "exp", "iat", "auth_time", "nonce"}, oidc.Claims...),
+			TokenEndpointAuthMethodsSupported: []string{"none", "client_secret_basic", "client_secret_post"},
+			CodeChallengeMethodsSupported:     []string{oauthLib.CodeChallengeS256},
+		},
+	}
+}
+
+// Register adds OAuth 2.0 and OpenID Connect endpoints to the gateway mux
+func (h *Handler) Register(mux *runtime.ServeMux) error {
+	if err := mux.HandlePath(http.MethodGet, "/.well-known/openid-configuration", h.Discovery); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodGet, "/authorize", h.AuthorizePage); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodPost, "/authorize", h.Authorize); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodPost, "/device_authorization", h.DeviceAuthorize); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodGet, "/device", h.DevicePage); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodPost, "/device", h.VerifyDevice); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodGet, "/reset_password", h.ResetPasswordPage); err != nil {
+		return err
+	}
+	if err := mux.HandlePath(http.MethodPost, "/reset_password", h.ResetPassword); err != nil {
+		return err
+	}
+	return mux.HandlePath(http.MethodPost, "/token", h.Token)
+}
+
+// Discovery serves OpenID Connect discovery document
+func (h *Handler) Discovery(w http.ResponseWriter, _ *http.Request, _ map[string]string) {
+	writeJSON(w, http.StatusOK, h.discovery)
+}
+
+// AuthorizePage renders login page for authorization request
+//
+// Redirect URI isn't verified yet, so errors are shown on the page instead of redirecting
+func (h *Handler) AuthorizePage(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	q := r.URL.Query()
+
+	p := page{
+		ClientID:            q.Get("client_id"),
+		RedirectURI:         q.Get("redirect_uri"),
+		State:               q.Get("state"),
+		CodeChallenge:       q.Get("code_challenge"),
+		CodeChallengeMethod: q.Get("code_challenge_method"),
+		Scope:               q.Get("scope"),
+		Nonce:               q.Get("nonce"),
+	}
+
+	switch {
+	case q.Get("response_type") != oauthLib.ResponseTypeCode:
+		p.Error = "unsupported response_type"
+	case p.ClientID == "" || p.RedirectURI == "":
+		p.Error = "client_id and redirect_uri are required"
+	case p.CodeChallenge == "" || p.CodeChallengeMethod != oauthLib.CodeChallengeS256:
+		p.Error = "code_challenge is required with S256 method"
+	}
+	if p.Error != "" {
+		render(w, http.StatusBadRequest, p)
+		return
+	}
+
+	p.Form = true
+	render(w, http.StatusOK, p)
+}
+
+// Authorize checks submitted credentials and redirects back to the app with authorization code
+func (h *Handler) Authorize(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if err := r.ParseForm(); err != nil {
+		render(w, http.StatusBadRequest, page{Error: "invalid form"})
+		return
+	}
+
+	p := page{
+		Form:                true,
+		ClientID:            r.PostForm.Get("client_id"),
+		RedirectURI:         r.PostForm.Get("redirect_uri"),
+		State:               r.PostForm.Get("state"),
+		CodeChallenge:       r.PostForm.Get("code_challenge"),
+		CodeChallengeMethod: r.PostForm.Get("code_challenge_method"),
+		Scope:               r.PostForm.Get("scope"),
+		Nonce:               r.PostForm.Get("nonce"),
+	}
+
+	resp, err := h.auth.Authorize(forwarded(r), &ssov1.AuthorizeRequest{
+		Email:               r.PostForm.Get("email"),
+		Password:            r.PostForm.Get("password"),
+		ClientId:            p.ClientID,
+		RedirectUri:         p.RedirectURI,
+		CodeChallenge:       p.CodeChallenge,
+		CodeChallengeMethod: p.CodeChallengeMethod,
+		Scope:               p.Scope,
+		Nonce:               p.Nonce,
+		MfaCode:             r.PostForm.Get("mfa_code"),
+	})
+	if err != nil {
+		st := status.Convert(err)
+		p.Error = st.Message()
+
+		httpStatus := pageStatus(w, st)
+
+		render(w, httpStatus, p)
+		return
+	}
+
+	redirect, err := url.Parse(p.RedirectURI)
+	if err != nil {
+		render(w, http.StatusBadRequest, page{Error: "invalid redirect uri"})
+		return
+	}
+
+	query := redirect.Query()
+	query.Set("code", resp.GetCode())
+	if p.State != "" {
+		query.Set("state", p.State)
+	}
+	redirect.RawQuery = query.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// Token exchanges grant for tokens, request is form encoded as required by RFC 6749
+//
+// Client credentials are accepted either in the form or with HTTP Basic authentication
+func (h *Handler) Token(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: oauthLib.ErrorInvalidRequest})
+		return
+	}
+
+	clientID, clientSecret, ok := clientCredentials(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: oauthLib.ErrorInvalidRequest})
+		return
+	}
+
+	resp, err := h.auth.Token(r.Context(), &ssov1.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectUri:  r.PostForm.Get("redirect_uri"),
+		ClientId:     clientID,
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		ClientSecret: clientSecret,
+		Scope:        r.PostForm.Get("scope"),
+		DeviceCode:   r.PostForm.Get("device_code"),
+
+		SubjectToken:       r.PostForm.Get("subject_token"),
+		SubjectTokenType:   r.PostForm.Get("subject_token_type"),
+		ActorToken:         r.PostForm.Get("actor_token"),
+		ActorTokenType:     r.PostForm.Get("actor_token_type"),
+		Audience:           r.PostForm.Get("audience"),
+		RequestedTokenType: r.PostForm.Get("requested_token_type"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, tokenResponse{
+		AccessToken:  resp.GetAccessToken(),
+		TokenType:    resp.GetTokenType(),
+		ExpiresIn:    resp.GetExpiresIn(),
+		RefreshToken: resp.GetRefreshToken(),
+		Scope:        resp.GetScope(),
+		IDToken:      resp.GetIdToken(),
+
+		IssuedTokenType: resp.GetIssuedTokenType(),
+	})
+}
+
+// DeviceAuthorize starts device authorization grant, request is form encoded as required by RFC 8628
+func (h *Handler) DeviceAuthorize(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: oauthLib.ErrorInvalidRequest})
+		return
+	}
+
+	clientID, _, ok := clientCredentials(r)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: oauthLib.ErrorInvalidRequest})
+		return
+	}
+
+	resp, err := h.auth.DeviceAuthorize(r.Context(), &ssov1.DeviceAuthorizeRequest{
+		ClientId: clientID,
+		Scope:    r.PostForm.Get("scope"),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	complete, err := url.Parse(h.verificationURI)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: oauthLib.ErrorServerError})
+		return
+	}
+	complete.RawQuery = url.Values{"user_code": {resp.GetUserCode()}}.Encode()
+
+	writeJSON(w, http.StatusOK, deviceAuthorizationResponse{
+		DeviceCode:              resp.GetDeviceCode(),
+		UserCode:                resp.GetUserCode(),
+		VerificationURI:         h.verificationURI,
+		VerificationURIComplete: complete.String(),
+		ExpiresIn:               resp.GetExpiresIn(),
+		Interval:                resp.GetInterval(),
+	})
+}
+
+// DevicePage renders verification page, user code is prefilled if given in the query
+func (h *Handler) DevicePage(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	renderDevice(w, http.StatusOK, deviceVerification{UserCode: r.URL.Query().Get("user_code")})
+}
+
+// VerifyDevice checks submitted credentials and approves or denies the device
+func (h *Handler) VerifyDevice(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if err := r.ParseForm(); err != nil {
+		renderDevice(w, http.StatusBadRequest, deviceVerification{Error: "invalid form"})
+		return
+	}
+
+	p := deviceVerification{UserCode: r.PostForm.Get("user_code")}
+	approve := r.PostForm.Get("action") == "approve"
+
+	_, err := h.auth.VerifyDevice(forwarded(r), &ssov1.VerifyDeviceRequest{
+		Email:    r.PostForm.Get("email"),
+		Password: r.PostForm.Get("password"),
+		UserCode: p.UserCode,
+		Approve:  approve,
+		MfaCode:  r.PostForm.Get("mfa_code"),
+	})
+	if err != nil {
+		st := status.Convert(err)
+		p.Error = st.Message()
+
+		httpStatus := pageStatus(w, st)
+
+		renderDevice(w, httpStatus, p)
+		return
+	}
+
+	p.Message = "Access denied, you can close this page"
+	if approve {
+		p.Message = "Device connected, you can return to your device"
+	}
+
+	renderDevice(w, http.StatusOK, p)
+}
+
+// ResetPasswordPage renders page of the password reset link, token is taken from the query
+func (h *Handler) ResetPasswordPage(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		renderReset(w, http.StatusBadRequest, passwordReset{Error: "reset link is invalid", Message: "Request a new link to reset your password"})
+		return
+	}
+
+	renderReset(w, http.StatusOK, passwordReset{Token: token})
+}
+
+// ResetPassword sets submitted password by token of the password reset link
+func (h *Handler) ResetPassword(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	if err := r.ParseForm(); err != nil {
+		renderReset(w, http.StatusBadRequest, passwordReset{Error: "invalid form"})
+		return
+	}
+
+	p := passwordReset{Token: r.PostForm.Get("token")}
+
+	password := r.PostForm.Get("password")
+	if password != r.PostForm.Get("password_confirm") {
+		p.Error = "passwords don't match"
+		renderReset(w, http.StatusBadRequest, p)
+		return
+	}
+
+	_, err := h.auth.ResetPassword(r.Context(), &ssov1.ResetPasswordRequest{
+		Token:    p.Token,
+		Password: password,
+	})
+	if err != nil {
+		st := status.Convert(err)
+		p.Error = st.Message()
+		p.Violations = violations(st)
+
+		httpStatus := http.StatusBadRequest
+		if st.Code() != codes.InvalidArgument {
+			httpStatus = http.StatusInternalServerError
+		}
+
+		renderReset(w, httpStatus, p)
+		return
+	}
+
+	renderReset(w, http.StatusOK, passwordReset{Message: "Password changed, sign in with the new password"})
+}
+
+// clientCredentials returns client credentials from the form or HTTP Basic authentication
+//
+// Reports false if Basic credentials aren't properly encoded
+func clientCredentials(r *http.Request) (clientID, clientSecret string, ok bool) {
+	user, password, basic := r.BasicAuth()
+	if !basic {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), true
+	}
+
+	clientID, errID := url.QueryUnescape(user)
+	clientSecret, errSecret := url.QueryUnescape(password)
+	if errID != nil || errSecret != nil {
+		return "", "", false
+	}
+
+	return clientID, clientSecret, true
+}
+
+// forwarded returns request context passing client address to gRPC server, so logins are throttled by it
+func forwarded(r *http.Request) context.Context {
+	return clientip.AppendForwarded(r.Context(), r.Header.Get("X-Forwarded-For"), r.RemoteAddr)
+}
+
+// pageStatus returns HTTP status of page showing gRPC error, Retry-After is set for throttled requests
+func pageStatus(w http.ResponseWriter, st *status.Status) int {
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.FailedPrecondition:
+		SetRetryAfter(w, st)
+		return http.StatusBadRequest
+	case codes.ResourceExhausted:
+		SetRetryAfter(w, st)
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// violations returns descriptions of field violations of gRPC status
+func violations(st *status.Status) []string {
+	var descriptions []string
+	for _, d := range st.Details() {
+		if badRequest, ok := d.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				descriptions = append(descriptions, violation.GetDescription())
+			}
+		}
+	}
+	return descriptions
+}
+
+// SetRetryAfter sets Retry-After header if gRPC status tells when to retry
+func SetRetryAfter(w http.ResponseWriter, st *status.Status) {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			seconds := int64(math.Ceil(info.GetRetryDelay().AsDuration().Seconds()))
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			return
+		}
+	}
+}
+
+// writeError writes OAuth error response for gRPC error
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	code := oauthLib.ErrorCode(st)
+
+	httpStatus := http.StatusBadRequest
+	switch code {
+	case oauthLib.ErrorInvalidClient:
+		httpStatus = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", `Basic realm="sso"`)
+	case oauthLib.ErrorServerError:
+		httpStatus = http.StatusInternalServerError
+	}
+
+	writeJSON(w, httpStatus, errorResponse{Error: code, ErrorDescription: st.Message()})
+}
+
+func renderDevice(w http.ResponseWriter, status int, p deviceVerification) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = devicePage.Execute(w, p)
+}
+
+func renderReset(w http.ResponseWriter, status int, p passwordReset) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = resetPage.Execute(w, p)
+}
+
+func render(w http.ResponseWriter, status int, p page) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	_ = loginPage.Execute(w, p)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
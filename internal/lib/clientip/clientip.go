@@ -0,0 +1,114 @@
+package clientip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// ForwardedForKey is metadata key the gateway passes client address in, like X-Forwarded-For header
+const ForwardedForKey = "x-forwarded-for"
+
+// ParsePrefixes parses trusted proxies given as CIDR prefixes or single addresses
+func ParsePrefixes(values []string) ([]netip.Prefix, error) {
+	const op = "lib.clientip.ParsePrefixes"
+
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", op, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, nil
+}
+
+// Resolve returns address of the client that made the request. Forwarded addresses are taken
+// into account only while the hop that added them is trusted, so clients can't spoof them
+//
+// If peer address is unknown, returns empty string
+func Resolve(ctx context.Context, trusted []netip.Prefix) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	addrPort, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+	addr := addrPort.Addr().Unmap()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var forwarded []string
+	for _, value := range md.Get(ForwardedForKey) {
+		for _, hop := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(hop))
+		}
+	}
+
+	// the rightmost address is added by the nearest proxy, the leftmost by the client itself
+	for i := len(forwarded) - 1; i >= 0 && isTrusted(addr, trusted); i-- {
+		hop, err := netip.ParseAddr(forwarded[i])
+		if err != nil {
+			break
+		}
+		addr = hop.Unmap()
+	}
+
+	return addr.String()
+}
+
+// AppendForwarded returns outgoing context passing remote address of HTTP request to gRPC server
+// the same way the gateway does
+func AppendForwarded(ctx context.Context, forwardedFor string, remoteAddr string) context.Context {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	if forwardedFor != "" {
+		host = forwardedFor + ", " + host
+	}
+
+	return metadata.AppendToOutgoingContext(ctx, ForwardedForKey, host)
+}
+
+func isTrusted(addr netip.Addr, trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type ipKey struct{}
+
+// WithIP returns a copy of ctx that carries client address
+func WithIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, ipKey{}, ip)
+}
+
+// FromContext returns client address stored in ctx, if any
+func FromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(ipKey{}).(string)
+	return ip, ok && ip != ""
+}
@@ -1,59 +1,391 @@
 package jwt
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strconv"
 	"time"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/oidc"
+	"sso/internal/lib/secret"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	// ErrInvalidToken is returned for malformed tokens and tokens with invalid signature
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for tokens that are expired or not valid yet
+	ErrExpiredToken = errors.New("token is expired")
+	// ErrInvalidClaims is returned for tokens with missing, malformed or unexpected claims
+	ErrInvalidClaims = errors.New("invalid token claims")
+)
+
+func init() {
+	// iat is compared with instants of revocation, so a token issued right after revocation
+	// within the same second must not look issued before it. NumericDate may be fractional (RFC 7519, section 2)
+	jwt.TimePrecision = time.Millisecond
+}
+
+// Params are issuer-wide settings of tokens
+type Params struct {
+	Issuer string
+	// Audience is required on parsing if not empty
+	Audience string
+	// Leeway is allowed clock skew when validating exp, nbf and iat
+	Leeway time.Duration
+}
+
+// Actor is the act claim of token issued by token exchange (RFC 8693, section 4.1).
+// It identifies the party acting on behalf of the subject, prior actors of the chain are nested
+type Actor struct {
+	Subject  string `json:"sub"`
+	ClientID string `json:"client_id,omitempty"`
+	Act      *Actor `json:"act,omitempty"`
+}
+
+// Claims are registered claims of the token plus our custom ones
+//
+// Tokens issued to apps by the client_credentials grant have no uid and email,
+// their subject is client_id
+type Claims struct {
+	jwt.RegisteredClaims
+	UID      int64  `json:"uid,omitempty"`
+	Email    string `json:"email,omitempty"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope,omitempty"`
+	Act      *Actor `json:"act,omitempty"`
+	// Roles of the user and Permissions granted by them, tokens of users without roles have neither
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"permissions,omitempty"`
+	// AppRoles and AppPermissions are defined by the app in client_id, roles of other apps are never included
+	AppRoles       []string `json:"app_roles,omitempty"`
+	AppPermissions []string `json:"app_permissions,omitempty"`
+}
+
+// Validate checks claims that are required, but are not checked by the parser itself
+func (c Claims) Validate() error {
+	switch {
+	case c.ID == "":
+		return errors.New("jti is required")
+	case c.IssuedAt == nil:
+		return errors.New("iat is required")
+	case c.ClientID == "":
+		return errors.New("client_id is required")
+	case !slices.Contains(c.Audience, c.ClientID):
+		return errors.New("aud doesn't contain client_id")
+	}
+
+	for act := c.Act; act != nil; act = act.Act {
+		if act.Subject == "" {
+			return errors.New("act.sub is required")
+		}
+	}
+
+	if c.UID == 0 {
+		if c.Subject != c.ClientID {
+			return errors.New("sub doesn't match client_id")
+		}
+		return nil
+	}
+
+	switch {
+	case c.UID < 0:
+		return errors.New("uid is invalid")
+	case c.Subject != strconv.FormatInt(c.UID, 10):
+		return errors.New("sub doesn't match uid")
+	case c.Email == "":
+		return errors.New("email is required")
+	}
+
+	return nil
+}
+
 type Token struct {
+	ID         string
+	Subject    string
 	UID        int64
 	Email      string
+	Audience   []string
+	ClientID   string
+	IssuedAt   time.Time
 	Expiration time.Time
-	Level      int8
+	Roles      []string
+	// Permissions are granted by Roles
+	Permissions []string
+	// AppRoles and AppPermissions are defined by the app the token is issued to
+	AppRoles       []string
+	AppPermissions []string
+	Scope          string
+	Act            *Actor
+}
+
+// IsClient reports whether token was issued to the app itself rather than to a user
+func (t *Token) IsClient() bool {
+	return t.UID == 0
+}
+
+// HasPermission reports whether roles of the user grant the permission
+func (t *Token) HasPermission(permission string) bool {
+	return slices.Contains(t.Permissions, permission)
 }
 
 // TODO: add tests
-func NewToken(user models.User, admin models.Admin, duration time.Duration, userKey string) (string, error) {
+func NewToken(
+	user models.User,
+	admin models.Admin,
+	appRoles models.AppRoles,
+	app models.App,
+	scope string,
+	duration time.Duration,
+	keys *KeyRing,
+	params Params,
+) (string, error) {
 	const op = "lib.jwt.NewToken"
 
-	token := jwt.New(jwt.SigningMethodHS256)
+	registered, err := registeredClaims(strconv.FormatInt(user.ID, 10), app, duration, params)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["uid"] = user.ID
-	claims["email"] = user.Email
-	claims["exp"] = time.Now().Add(duration).Unix()
-	claims["level"] = admin.Level
+	claims := Claims{
+		RegisteredClaims: registered,
+		UID:              user.ID,
+		Email:            user.Email,
+		Roles:            admin.Roles,
+		Permissions:      admin.Permissions,
+		AppRoles:         appRoles.Roles,
+		AppPermissions:   appRoles.Permissions,
+		ClientID:         app.ClientID(),
+		Scope:            scope,
+	}
 
-	tokenString, err := token.SignedString([]byte(userKey))
+	token, err := sign(claims, keys)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	return tokenString, nil
+	return token, nil
+}
+
+// NewClientToken returns token issued to the app itself with granted scope
+func NewClientToken(
+	app models.App,
+	scope string,
+	duration time.Duration,
+	keys *KeyRing,
+	params Params,
+) (string, error) {
+	const op = "lib.jwt.NewClientToken"
+
+	registered, err := registeredClaims(app.ClientID(), app, duration, params)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims := Claims{
+		RegisteredClaims: registered,
+		ClientID:         app.ClientID(),
+		Scope:            scope,
+	}
+
+	token, err := sign(claims, keys)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// NewExchangedToken returns token of the user for the app issued by token exchange, act identifies
+// the party acting on behalf of the user. Such token never carries roles of the user.
+// Also returns jti of the token, so the exchange can be audited
+func NewExchangedToken(
+	user models.User,
+	app models.App,
+	scope string,
+	act *Actor,
+	duration time.Duration,
+	keys *KeyRing,
+	params Params,
+) (string, string, error) {
+	const op = "lib.jwt.NewExchangedToken"
+
+	registered, err := registeredClaims(strconv.FormatInt(user.ID, 10), app, duration, params)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims := Claims{
+		RegisteredClaims: registered,
+		UID:              user.ID,
+		Email:            user.Email,
+		ClientID:         app.ClientID(),
+		Scope:            scope,
+		Act:              act,
+	}
+
+	token, err := sign(claims, keys)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, registered.ID, nil
+}
+
+// NewIDToken returns OpenID Connect ID token for the app with user claims released for granted scopes
+//
+// ID token has no client_id claim, so it can't be used as access token
+func NewIDToken(
+	user models.User,
+	app models.App,
+	scopes []string,
+	authTime time.Time,
+	nonce string,
+	duration time.Duration,
+	keys *KeyRing,
+	params Params,
+) (string, error) {
+	const op = "lib.jwt.NewIDToken"
+
+	registered, err := registeredClaims(strconv.FormatInt(user.ID, 10), app, duration, params)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	claims := jwt.MapClaims(oidc.UserClaims(user, scopes))
+	claims["iss"] = registered.Issuer
+	claims["sub"] = registered.Subject
+	claims["aud"] = registered.Audience
+	claims["iat"] = registered.IssuedAt
+	claims["exp"] = registered.ExpiresAt
+	claims["jti"] = registered.ID
+	claims["auth_time"] = jwt.NewNumericDate(authTime)
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+
+	token, err := sign(claims, keys)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// registeredClaims returns registered claims with a new jti of token issued for the app
+func registeredClaims(subject string, app models.App, duration time.Duration, params Params) (jwt.RegisteredClaims, error) {
+	jti, err := secret.GenerateSecret()
+	if err != nil {
+		return jwt.RegisteredClaims{}, err
+	}
+
+	currentTime := time.Now()
+
+	return jwt.RegisteredClaims{
+		ID:        jti,
+		Issuer:    params.Issuer,
+		Subject:   subject,
+		Audience:  jwt.ClaimStrings{app.ClientID()},
+		IssuedAt:  jwt.NewNumericDate(currentTime),
+		NotBefore: jwt.NewNumericDate(currentTime),
+		ExpiresAt: jwt.NewNumericDate(currentTime.Add(duration)),
+	}, nil
+}
+
+// sign signs claims with the active key
+func sign(claims jwt.Claims, keys *KeyRing) (string, error) {
+	key, err := keys.Active()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Private)
 }
 
 // TODO: add tests
-func Parse(raw, secret string) (*Token, error) {
+//
+// Parse verifies the token and its claims.
+// Returned error wraps ErrInvalidToken, ErrExpiredToken or ErrInvalidClaims
+func Parse(raw string, keys *KeyRing, params Params) (*Token, error) {
 	const op = "lib.jwt.Parse"
 
-	claims := jwt.MapClaims{}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{AlgorithmRS256, AlgorithmES256, AlgorithmEdDSA}),
+		jwt.WithIssuer(params.Issuer),
+		jwt.WithLeeway(params.Leeway),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	}
+	if params.Audience != "" {
+		opts = append(opts, jwt.WithAudience(params.Audience))
+	}
+
+	claims := Claims{}
 	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+		kid, _ := t.Header["kid"].(string)
+
+		key, err := keys.Key(kid)
+		if err != nil {
+			return nil, err
+		}
+		if t.Method.Alg() != key.Algorithm {
+			return nil, jwt.ErrTokenSignatureInvalid
+		}
+
+		return key.Public(), nil
+	}, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, classify(err))
 	}
 
 	token := &Token{
-		UID:        int64(claims["uid"].(float64)),
-		Email:      claims["email"].(string),
-		Expiration: time.Unix(int64(claims["exp"].(float64)), 0),
-		Level:      int8(claims["level"].(float64)),
+		ID:             claims.ID,
+		Subject:        claims.Subject,
+		UID:            claims.UID,
+		Email:          claims.Email,
+		Audience:       claims.Audience,
+		ClientID:       claims.ClientID,
+		IssuedAt:       claims.IssuedAt.Time,
+		Expiration:     claims.ExpiresAt.Time,
+		Roles:          claims.Roles,
+		Permissions:    claims.Permissions,
+		AppRoles:       claims.AppRoles,
+		AppPermissions: claims.AppPermissions,
+		Scope:          claims.Scope,
+		Act:            claims.Act,
 	}
 
 	return token, nil
 }
+
+// classify wraps parser error into one of the package errors
+func classify(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired), errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return fmt.Errorf("%w: %w", ErrExpiredToken, err)
+	case errors.Is(err, jwt.ErrTokenInvalidClaims), errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return fmt.Errorf("%w: %w", ErrInvalidClaims, err)
+	default:
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+}
+
+type tokenKey struct{}
+
+// WithToken returns a copy of ctx that carries the authenticated token
+func WithToken(ctx context.Context, token *Token) context.Context {
+	return context.WithValue(ctx, tokenKey{}, token)
+}
+
+// FromContext returns the authenticated token stored in ctx, if any
+func FromContext(ctx context.Context) (*Token, bool) {
+	token, ok := ctx.Value(tokenKey{}).(*Token)
+	return token, ok
+}
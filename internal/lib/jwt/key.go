@@ -0,0 +1,235 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmES256 = "ES256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+type KeyState string
+
+const (
+	// KeyActive is used to sign new tokens
+	KeyActive KeyState = "active"
+	// KeyRetiring is not used for signing anymore, but still verifies tokens issued before rotation
+	KeyRetiring KeyState = "retiring"
+	// KeyRetired is neither used for signing nor for verification
+	KeyRetired KeyState = "retired"
+)
+
+var ErrUnsupportedKey = errors.New("unsupported signing key")
+
+// Key is a private signing key, its public part is published as JWK
+type Key struct {
+	ID        string
+	Algorithm string
+	State     KeyState
+	Private   crypto.Signer
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a set of public keys
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKey generates a new signing key for the algorithm
+func NewKey(algorithm string) (*Key, error) {
+	const op = "lib.jwt.NewKey"
+
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%s: %w: algorithm %q", op, ErrUnsupportedKey, algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := newKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// ParseKey parses PKCS #8 private key in PEM
+func ParseKey(data []byte) (*Key, error) {
+	const op = "lib.jwt.ParseKey"
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w: no PEM data", op, ErrUnsupportedKey)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedKey)
+	}
+
+	key, err := newKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// MarshalPEM encodes private key as PKCS #8 PEM
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Public returns public key
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// JWK returns public key in JWK format
+func (k *Key) JWK() JWK {
+	jwk := publicJWK(k.Public())
+	jwk.Use = "sig"
+	jwk.Kid = k.ID
+	jwk.Alg = k.Algorithm
+
+	return jwk
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func newKey(private crypto.Signer) (*Key, error) {
+	var algorithm string
+
+	switch pub := private.Public().(type) {
+	case *rsa.PublicKey:
+		algorithm = AlgorithmRS256
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return nil, ErrUnsupportedKey
+		}
+		algorithm = AlgorithmES256
+	case ed25519.PublicKey:
+		algorithm = AlgorithmEdDSA
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	return &Key{
+		ID:        thumbprint(publicJWK(private.Public())),
+		Algorithm: algorithm,
+		State:     KeyActive,
+		Private:   private,
+	}, nil
+}
+
+func publicJWK(public crypto.PublicKey) JWK {
+	enc := base64.RawURLEncoding.EncodeToString
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   enc(pub.N.Bytes()),
+			E:   enc(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   enc(pub.X.FillBytes(make([]byte, size))),
+			Y:   enc(pub.Y.FillBytes(make([]byte, size))),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   enc(pub),
+		}
+	}
+
+	return JWK{}
+}
+
+// thumbprint returns JWK thumbprint (RFC 7638) used as key ID
+func thumbprint(jwk JWK) string {
+	var members any
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
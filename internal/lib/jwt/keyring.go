@@ -0,0 +1,105 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrNoActiveKey = errors.New("no active signing key")
+	ErrKeyNotFound = errors.New("signing key not found")
+)
+
+// KeyRing holds the active signing key and retiring keys that are still accepted for verification
+//
+// KeyRing is safe for concurrent use
+type KeyRing struct {
+	mu     sync.RWMutex
+	active *Key
+	keys   map[string]*Key
+}
+
+// NewKeyRing returns an empty key ring, keys are loaded with Set
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: map[string]*Key{}}
+}
+
+// Set replaces keys of the ring
+//
+// Exactly one key must be active, retired keys are skipped
+func (r *KeyRing) Set(keys []*Key) error {
+	const op = "lib.jwt.KeyRing.Set"
+
+	var active *Key
+	verification := make(map[string]*Key, len(keys))
+
+	for _, key := range keys {
+		switch key.State {
+		case KeyActive:
+			if active != nil {
+				return fmt.Errorf("%s: more than one active key", op)
+			}
+			active = key
+		case KeyRetiring:
+		default:
+			continue
+		}
+		verification[key.ID] = key
+	}
+
+	if active == nil {
+		return fmt.Errorf("%s: %w", op, ErrNoActiveKey)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.active = active
+	r.keys = verification
+
+	return nil
+}
+
+// Active returns the key used to sign new tokens
+func (r *KeyRing) Active() (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.active == nil {
+		return nil, ErrNoActiveKey
+	}
+
+	return r.active, nil
+}
+
+// Key returns active or retiring key by its ID
+func (r *KeyRing) Key(kid string) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// JWKS returns public parts of all keys accepted for verification
+func (r *KeyRing) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jwks := JWKS{Keys: make([]JWK, 0, len(r.keys))}
+	if r.active != nil {
+		jwks.Keys = append(jwks.Keys, r.active.JWK())
+	}
+	for _, key := range r.keys {
+		if key != r.active {
+			jwks.Keys = append(jwks.Keys, key.JWK())
+		}
+	}
+
+	return jwks
+}
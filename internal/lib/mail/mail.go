@@ -0,0 +1,176 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// SMTP sends messages through SMTP server, STARTTLS is used if the server supports it
+type SMTP struct {
+	addr     string
+	host     string
+	username string
+	password string
+	from     string
+}
+
+// NewSMTP returns sender that delivers messages through SMTP server,
+// empty username disables authentication
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	return &SMTP{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		host:     host,
+		username: username,
+		password: password,
+		from:     from,
+	}
+}
+
+// Send delivers message, it's aborted when ctx is done
+func (s *SMTP) Send(ctx context.Context, msg Message) error {
+	const op = "lib.mail.SMTP.Send"
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	client, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer client.Close()
+
+	if err := s.send(client, msg); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (s *SMTP) send(client *smtp.Client, msg Message) error {
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: s.host}); err != nil {
+			return err
+		}
+	}
+
+	if s.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.username, s.password, s.host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(s.from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(msg.To); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(format(s.from, msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// File appends messages to a file instead of sending them, it's meant for development
+type File struct {
+	mu   sync.Mutex
+	path string
+	from string
+}
+
+// NewFile returns sender that appends messages to file at path
+func NewFile(path, from string) *File {
+	return &File{path: path, from: from}
+}
+
+// Send appends message to the file
+func (f *File) Send(_ context.Context, msg Message) error {
+	const op = "lib.mail.File.Send"
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(format(f.from, msg), "\r\n"...)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Log writes messages to the log instead of sending them, it's meant for development
+type Log struct {
+	log *slog.Logger
+}
+
+// NewLog returns sender that writes messages to log
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log}
+}
+
+// Send writes message to the log
+func (l *Log) Send(_ context.Context, msg Message) error {
+	l.log.Info("email sent",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
+
+// format returns message with headers as required by RFC 5322
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
@@ -0,0 +1,232 @@
+package mail
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// session is what fake SMTP server received from the client
+type session struct {
+	commands []string
+	auth     string
+	data     string
+}
+
+// fakeSMTP is an in-process SMTP server that accepts one session. It never offers STARTTLS,
+// so the client has to talk in plain text
+type fakeSMTP struct {
+	ln       net.Listener
+	sessions chan session
+}
+
+func newFakeSMTP(t *testing.T) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{ln: ln, sessions: make(chan session, 1)}
+	go s.serve()
+
+	return s
+}
+
+func (s *fakeSMTP) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var got session
+	defer func() { s.sessions <- got }()
+
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		_, _ = conn.Write([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		got.commands = append(got.commands, command)
+
+		switch verb, arg, _ := strings.Cut(command, " "); strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-fake", "250 AUTH PLAIN")
+		case "AUTH":
+			got.auth = arg
+			reply("235 2.7.0 authenticated")
+		case "MAIL", "RCPT":
+			reply("250 2.1.0 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			got.data = data.String()
+			reply("250 2.0.0 queued")
+		case "QUIT":
+			reply("221 2.0.0 bye")
+			return
+		default:
+			reply("502 5.5.2 not implemented")
+		}
+	}
+}
+
+// sender returns SMTP sender for the fake server, which is known to the client under host name
+func (s *fakeSMTP) sender(host, username, password string) *SMTP {
+	return &SMTP{
+		addr:     s.ln.Addr().String(),
+		host:     host,
+		username: username,
+		password: password,
+		from:     "sso@example.com",
+	}
+}
+
+func (s *fakeSMTP) session(t *testing.T) session {
+	t.Helper()
+
+	select {
+	case got := <-s.sessions:
+		return got
+	case <-time.After(5 * time.Second):
+		t.Fatal("smtp session didn't finish")
+		return session{}
+	}
+}
+
+func TestSMTPSend(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	msg := Message{
+		To:      "user@example.com",
+		Subject: "Verify your email",
+		Body:    "Follow the link:\n\nhttps://sso.example.com/verify\n.hidden line",
+	}
+	if err := server.sender("127.0.0.1", "", "").Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := server.session(t)
+
+	for _, command := range got.commands {
+		if strings.HasPrefix(command, "STARTTLS") || strings.HasPrefix(command, "AUTH") {
+			t.Errorf("unexpected command %q", command)
+		}
+	}
+	if want := "MAIL FROM:<sso@example.com>"; !slices.Contains(got.commands, want) {
+		t.Errorf("commands %q don't contain %q", got.commands, want)
+	}
+	if want := "RCPT TO:<user@example.com>"; !slices.Contains(got.commands, want) {
+		t.Errorf("commands %q don't contain %q", got.commands, want)
+	}
+
+	header, body, ok := strings.Cut(got.data, "\r\n\r\n")
+	if !ok {
+		t.Fatalf("message has no header separator: %q", got.data)
+	}
+
+	lines := strings.Split(header, "\r\n")
+	wantLines := []string{
+		"From: sso@example.com",
+		"To: user@example.com",
+		"Subject: Verify your email",
+		"", // Date
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	if len(lines) != len(wantLines) {
+		t.Fatalf("header lines = %q, want %d lines", lines, len(wantLines))
+	}
+	for i, want := range wantLines {
+		if want == "" {
+			date, ok := strings.CutPrefix(lines[i], "Date: ")
+			if !ok {
+				t.Errorf("header line %d = %q, want Date", i, lines[i])
+				continue
+			}
+			if _, err := time.Parse(time.RFC1123Z, date); err != nil {
+				t.Errorf("invalid Date header: %v", err)
+			}
+			continue
+		}
+		if lines[i] != want {
+			t.Errorf("header line %d = %q, want %q", i, lines[i], want)
+		}
+	}
+
+	if want := "Follow the link:\r\n\r\nhttps://sso.example.com/verify\r\n.hidden line\r\n"; body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSMTPSendAuth(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	msg := Message{To: "user@example.com", Subject: "Hello", Body: "Hello"}
+	if err := server.sender("127.0.0.1", "sso", "secret").Send(context.Background(), msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	got := server.session(t)
+
+	method, response, _ := strings.Cut(got.auth, " ")
+	if method != "PLAIN" {
+		t.Fatalf("auth method = %q, want PLAIN", method)
+	}
+	credentials, err := base64.StdEncoding.DecodeString(response)
+	if err != nil {
+		t.Fatalf("invalid auth response: %v", err)
+	}
+	if want := "\x00sso\x00secret"; string(credentials) != want {
+		t.Errorf("auth credentials = %q, want %q", credentials, want)
+	}
+	if got.data == "" {
+		t.Error("message wasn't sent after auth")
+	}
+}
+
+func TestSMTPSendRefusesPlainAuthWithoutTLS(t *testing.T) {
+	server := newFakeSMTP(t)
+
+	// remote server that doesn't offer STARTTLS must not get the password in plain text
+	msg := Message{To: "user@example.com", Subject: "Hello", Body: "Hello"}
+	if err := server.sender("mail.example.com", "sso", "secret").Send(context.Background(), msg); err == nil {
+		t.Fatal("Send succeeded, want error")
+	}
+
+	got := server.session(t)
+
+	if got.auth != "" {
+		t.Errorf("credentials were sent: %q", got.auth)
+	}
+	if got.data != "" {
+		t.Error("message was sent without auth")
+	}
+}
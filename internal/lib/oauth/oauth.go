@@ -0,0 +1,188 @@
+package oauth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	GrantTypeAuthorizationCode = "authorization_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	ResponseTypeCode = "code"
+
+	CodeChallengeS256 = "S256"
+
+	TokenTypeBearer = "Bearer"
+)
+
+// Token type identifiers of token exchange (RFC 8693, section 3)
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"
+	// TokenTypeEmail is our own subject token type for impersonation, subject token is email of the user
+	TokenTypeEmail = "urn:sso:params:oauth:token-type:email"
+)
+
+// Error codes from RFC 6749, section 5.2
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorInvalidClient        = "invalid_client"
+	ErrorInvalidGrant         = "invalid_grant"
+	ErrorUnsupportedGrantType = "unsupported_grant_type"
+	ErrorInvalidScope         = "invalid_scope"
+	ErrorServerError          = "server_error"
+)
+
+// Error codes from RFC 8628, section 3.5
+const (
+	ErrorAuthorizationPending = "authorization_pending"
+	ErrorSlowDown             = "slow_down"
+	ErrorAccessDenied         = "access_denied"
+	ErrorExpiredToken         = "expired_token"
+)
+
+// Error code from RFC 8693, section 2.2.2
+const ErrorInvalidTarget = "invalid_target"
+
+// errorDomain is the domain of errdetails.ErrorInfo carrying OAuth error codes
+const errorDomain = "oauth2"
+
+// Error returns gRPC status error carrying OAuth error code
+func Error(code codes.Code, oauthCode string, msg string) error {
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason: oauthCode,
+		Domain: errorDomain,
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// ErrorCode returns OAuth error code of gRPC status
+//
+// If status doesn't carry one, it is derived from status code
+func ErrorCode(st *status.Status) string {
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok && info.GetDomain() == errorDomain {
+			return info.GetReason()
+		}
+	}
+
+	switch st.Code() {
+	case codes.InvalidArgument:
+		return ErrorInvalidRequest
+	case codes.Unauthenticated:
+		return ErrorInvalidClient
+	default:
+		return ErrorServerError
+	}
+}
+
+// ValidCodeVerifier reports whether verifier is a valid PKCE code verifier (RFC 7636, section 4.1)
+func ValidCodeVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, c := range verifier {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_', c == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// VerifyCodeChallenge reports whether verifier matches S256 code challenge
+func VerifyCodeChallenge(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// ValidRedirectURI reports whether uri can be registered as redirect URI:
+// it must be absolute and must not contain a fragment
+func ValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	return u.IsAbs() && !strings.Contains(uri, "#")
+}
+
+// ValidScope reports whether scope is a valid scope token (RFC 6749, section 3.3)
+func ValidScope(scope string) bool {
+	if scope == "" {
+		return false
+	}
+	for _, c := range scope {
+		if c < 0x21 || c > 0x7e || c == '"' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// userCodeCharset contains no vowels and no easily confused characters (RFC 8628, section 6.1)
+const userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+
+// userCodeLength is number of characters in user code, without separator
+const userCodeLength = 8
+
+// GenerateUserCode returns a random user code of device authorization grant in XXXX-XXXX form
+func GenerateUserCode() (string, error) {
+	const op = "lib.oauth.GenerateUserCode"
+
+	max := big.NewInt(int64(len(userCodeCharset)))
+
+	code := make([]byte, 0, userCodeLength+1)
+	for i := 0; i < userCodeLength; i++ {
+		if i == userCodeLength/2 {
+			code = append(code, '-')
+		}
+
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+		code = append(code, userCodeCharset[n.Int64()])
+	}
+
+	return string(code), nil
+}
+
+// NormalizeUserCode converts user code entered by the user to the form returned by GenerateUserCode,
+// so it's case insensitive and separators and spaces may be omitted
+func NormalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		if c == '-' || c == ' ' {
+			continue
+		}
+		if b.Len() == userCodeLength/2 {
+			b.WriteByte('-')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// IsAccessTokenType reports whether token type identifies our access token, which is a JWT
+func IsAccessTokenType(tokenType string) bool {
+	return tokenType == TokenTypeAccessToken || tokenType == TokenTypeJWT
+}
@@ -0,0 +1,38 @@
+package oidc
+
+import (
+	"slices"
+	"strconv"
+
+	"sso/internal/domain/models"
+)
+
+const (
+	ScopeOpenID  = "openid"
+	ScopeEmail   = "email"
+	ScopeProfile = "profile"
+)
+
+// Scopes are OpenID Connect scopes supported by the SSO
+var Scopes = []string{ScopeOpenID, ScopeEmail, ScopeProfile}
+
+// Claims are standard claims about the user supported by the SSO
+var Claims = []string{"sub", "email", "email_verified", "preferred_username"}
+
+// UserClaims returns claims about the user released for granted scopes
+func UserClaims(user models.User, scopes []string) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.FormatInt(user.ID, 10),
+	}
+
+	if slices.Contains(scopes, ScopeEmail) {
+		claims["email"] = user.Email
+		claims["email_verified"] = user.EmailVerified
+	}
+	if slices.Contains(scopes, ScopeProfile) {
+		// email is the only name users have
+		claims["preferred_username"] = user.Email
+	}
+
+	return claims
+}
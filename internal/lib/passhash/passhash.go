@@ -0,0 +1,207 @@
+package passhash
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Sizes of generated salt and hash in bytes, as recommended by RFC 9106, section 4
+const (
+	saltSize = 16
+	keySize  = 32
+)
+
+// keyIDSize is size of pepper fingerprint stored in hashes, PHC format allows up to 8 bytes
+const keyIDSize = 6
+
+var encoding = base64.RawStdEncoding
+
+var (
+	// ErrUnknownPepper is returned for hashes computed with pepper other than the configured one
+	ErrUnknownPepper = errors.New("hash computed with unknown pepper")
+	// ErrInvalidHash is returned for hashes of neither supported format
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// Params are Argon2id parameters of new hashes
+type Params struct {
+	// Memory is in KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// Hasher hashes passwords with Argon2id into PHC strings like
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//
+// If pepper is set, password is keyed with HMAC-SHA256 before hashing and pepper fingerprint
+// is recorded as keyid parameter, so hashes with other or no pepper are recognized.
+// Bcrypt hashes of earlier versions are verified too
+type Hasher struct {
+	params Params
+	pepper []byte
+	keyID  string
+}
+
+// New returns hasher producing hashes with given params, pepper is optional
+func New(params Params, pepper []byte) *Hasher {
+	h := &Hasher{params: params, pepper: pepper}
+	if len(pepper) > 0 {
+		sum := sha256.Sum256(pepper)
+		h.keyID = encoding.EncodeToString(sum[:keyIDSize])
+	}
+
+	return h
+}
+
+// Hash returns PHC string of the password
+func (h *Hasher) Hash(password string) ([]byte, error) {
+	const op = "lib.passhash.Hash"
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key := argon2.IDKey(h.input(password), salt, h.params.Time, h.params.Memory, h.params.Threads, keySize)
+
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", h.params.Memory, h.params.Time, h.params.Threads)
+	if h.keyID != "" {
+		params += ",keyid=" + h.keyID
+	}
+
+	return []byte(fmt.Sprintf("$argon2id$v=%d$%s$%s$%s", argon2.Version, params, encoding.EncodeToString(salt), encoding.EncodeToString(key))), nil
+}
+
+// Verify reports whether password matches the hash, and whether the hash should be replaced
+// with a new one, because it's bcrypt or was computed with other params or without pepper
+//
+// If hash is malformed or computed with other pepper, returns error
+func (h *Hasher) Verify(hash []byte, password string) (ok bool, rehash bool, err error) {
+	const op = "lib.passhash.Verify"
+
+	if isBcrypt(hash) {
+		err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, false, nil
+		}
+		if err != nil {
+			return false, false, fmt.Errorf("%s: %w", op, err)
+		}
+		return true, true, nil
+	}
+
+	parsed, err := parse(string(hash))
+	if err != nil {
+		return false, false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	input := []byte(password)
+	switch parsed.keyID {
+	case "":
+	case h.keyID:
+		input = h.input(password)
+	default:
+		return false, false, fmt.Errorf("%s: %w", op, ErrUnknownPepper)
+	}
+
+	key := argon2.IDKey(input, parsed.salt, parsed.params.Time, parsed.params.Memory, parsed.params.Threads, uint32(len(parsed.key)))
+	if subtle.ConstantTimeCompare(key, parsed.key) != 1 {
+		return false, false, nil
+	}
+
+	rehash = parsed.params != h.params || parsed.keyID != h.keyID || len(parsed.key) != keySize
+
+	return true, rehash, nil
+}
+
+// input returns password keyed with pepper, if it's set
+func (h *Hasher) input(password string) []byte {
+	if len(h.pepper) == 0 {
+		return []byte(password)
+	}
+
+	mac := hmac.New(sha256.New, h.pepper)
+	mac.Write([]byte(password))
+
+	return mac.Sum(nil)
+}
+
+type argon2Hash struct {
+	params Params
+	keyID  string
+	salt   []byte
+	key    []byte
+}
+
+// parse decodes Argon2id PHC string
+func parse(hash string) (argon2Hash, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return argon2Hash{}, ErrInvalidHash
+	}
+
+	if parts[2] != "v="+strconv.Itoa(argon2.Version) {
+		return argon2Hash{}, ErrInvalidHash
+	}
+
+	var parsed argon2Hash
+	for _, param := range strings.Split(parts[3], ",") {
+		name, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return argon2Hash{}, ErrInvalidHash
+		}
+
+		var err error
+		switch name {
+		case "m":
+			parsed.params.Memory, err = parseUint[uint32](value, 32)
+		case "t":
+			parsed.params.Time, err = parseUint[uint32](value, 32)
+		case "p":
+			parsed.params.Threads, err = parseUint[uint8](value, 8)
+		case "keyid":
+			parsed.keyID = value
+		default:
+			err = ErrInvalidHash
+		}
+		if err != nil {
+			return argon2Hash{}, ErrInvalidHash
+		}
+	}
+	if parsed.params.Memory == 0 || parsed.params.Time == 0 || parsed.params.Threads == 0 {
+		return argon2Hash{}, ErrInvalidHash
+	}
+
+	var err error
+	if parsed.salt, err = encoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, ErrInvalidHash
+	}
+	if parsed.key, err = encoding.DecodeString(parts[5]); err != nil || len(parsed.key) == 0 {
+		return argon2Hash{}, ErrInvalidHash
+	}
+
+	return parsed, nil
+}
+
+func parseUint[T uint8 | uint32](value string, bitSize int) (T, error) {
+	n, err := strconv.ParseUint(value, 10, bitSize)
+	return T(n), err
+}
+
+// isBcrypt reports whether hash is in modular crypt format of bcrypt
+func isBcrypt(hash []byte) bool {
+	s := string(hash)
+	return strings.HasPrefix(s, "$2a$") || strings.HasPrefix(s, "$2b$") || strings.HasPrefix(s, "$2y$")
+}
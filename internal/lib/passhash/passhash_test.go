@@ -0,0 +1,215 @@
+package passhash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams are weak, so tests run fast
+var testParams = Params{Memory: 64, Time: 1, Threads: 1}
+
+func TestHashVerify(t *testing.T) {
+	for _, pepper := range []string{"", "pepper"} {
+		h := New(testParams, []byte(pepper))
+
+		hash, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("Hash: %v", err)
+		}
+
+		if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=64,t=1,p=1") {
+			t.Errorf("hash %q isn't PHC string with params", hash)
+		}
+		if hasKeyID := strings.Contains(string(hash), ",keyid="); hasKeyID != (pepper != "") {
+			t.Errorf("hash %q has keyid = %t with pepper %q", hash, hasKeyID, pepper)
+		}
+
+		ok, rehash, err := h.Verify(hash, "correct horse")
+		if err != nil || !ok || rehash {
+			t.Errorf("Verify(correct) with pepper %q = %t, %t, %v, want true, false, nil", pepper, ok, rehash, err)
+		}
+
+		ok, rehash, err = h.Verify(hash, "correct horse ")
+		if err != nil || ok || rehash {
+			t.Errorf("Verify(wrong) with pepper %q = %t, %t, %v, want false, false, nil", pepper, ok, rehash, err)
+		}
+	}
+}
+
+func TestHashIsSalted(t *testing.T) {
+	h := New(testParams, nil)
+
+	first, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	second, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+
+	if string(first) == string(second) {
+		t.Error("hashes of the same password are equal")
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	tests := []struct {
+		name string
+		// hashed is hasher the hash is computed with, and verified is hasher verifying it
+		hashed      *Hasher
+		verified    *Hasher
+		wantRehash  bool
+		wantErr     error
+		wantMatches bool
+	}{
+		{
+			name:        "same params",
+			hashed:      New(testParams, nil),
+			verified:    New(testParams, nil),
+			wantMatches: true,
+		},
+		{
+			name:        "memory changed",
+			hashed:      New(testParams, nil),
+			verified:    New(Params{Memory: 128, Time: 1, Threads: 1}, nil),
+			wantMatches: true,
+			wantRehash:  true,
+		},
+		{
+			name:        "time changed",
+			hashed:      New(testParams, nil),
+			verified:    New(Params{Memory: 64, Time: 2, Threads: 1}, nil),
+			wantMatches: true,
+			wantRehash:  true,
+		},
+		{
+			name:        "threads changed",
+			hashed:      New(testParams, nil),
+			verified:    New(Params{Memory: 64, Time: 1, Threads: 2}, nil),
+			wantMatches: true,
+			wantRehash:  true,
+		},
+		{
+			name:        "pepper added",
+			hashed:      New(testParams, nil),
+			verified:    New(testParams, []byte("pepper")),
+			wantMatches: true,
+			wantRehash:  true,
+		},
+		{
+			name:     "wrong pepper",
+			hashed:   New(testParams, []byte("pepper")),
+			verified: New(testParams, []byte("other pepper")),
+			wantErr:  ErrUnknownPepper,
+		},
+		{
+			name:     "pepper removed",
+			hashed:   New(testParams, []byte("pepper")),
+			verified: New(testParams, nil),
+			wantErr:  ErrUnknownPepper,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash, err := tt.hashed.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+
+			ok, rehash, err := tt.verified.Verify(hash, "password")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+			if ok != tt.wantMatches || rehash != tt.wantRehash {
+				t.Errorf("Verify() = %t, %t, want %t, %t", ok, rehash, tt.wantMatches, tt.wantRehash)
+			}
+
+			if !tt.wantRehash {
+				return
+			}
+			// the new hash is up to date
+			hash, err = tt.verified.Hash("password")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if ok, rehash, err := tt.verified.Verify(hash, "password"); err != nil || !ok || rehash {
+				t.Errorf("Verify(new hash) = %t, %t, %v, want true, false, nil", ok, rehash, err)
+			}
+		})
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to generate bcrypt hash: %v", err)
+	}
+
+	h := New(testParams, []byte("pepper"))
+
+	ok, rehash, err := h.Verify(hash, "password")
+	if err != nil || !ok || !rehash {
+		t.Errorf("Verify(correct) = %t, %t, %v, want true, true, nil", ok, rehash, err)
+	}
+
+	ok, rehash, err = h.Verify(hash, "wrong")
+	if err != nil || ok || rehash {
+		t.Errorf("Verify(wrong) = %t, %t, %v, want false, false, nil", ok, rehash, err)
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	h := New(testParams, nil)
+
+	valid, err := h.Hash("password")
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	parts := strings.Split(string(valid), "$")
+	salt, key := parts[4], parts[5]
+
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "empty", hash: ""},
+		{name: "plain text", hash: "password"},
+		{name: "other algorithm", hash: "$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key},
+		{name: "other version", hash: "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{name: "no version", hash: "$argon2id$m=64,t=1,p=1$" + salt + "$" + key},
+		{name: "missing key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{name: "empty key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{name: "extra part", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{name: "no leading dollar", hash: "argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "$"},
+		{name: "missing param", hash: "$argon2id$v=19$m=64,t=1$" + salt + "$" + key},
+		{name: "zero param", hash: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{name: "unknown param", hash: "$argon2id$v=19$m=64,t=1,p=1,x=1$" + salt + "$" + key},
+		{name: "param without value", hash: "$argon2id$v=19$m=64,t,p=1$" + salt + "$" + key},
+		{name: "non numeric param", hash: "$argon2id$v=19$m=lots,t=1,p=1$" + salt + "$" + key},
+		{name: "threads overflow", hash: "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key},
+		{name: "invalid salt", hash: "$argon2id$v=19$m=64,t=1,p=1$!!$" + key},
+		{name: "padded key", hash: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + key + "="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := h.Verify([]byte(tt.hash), "password")
+			if !errors.Is(err, ErrInvalidHash) {
+				t.Fatalf("Verify(%q) error = %v, want %v", tt.hash, err, ErrInvalidHash)
+			}
+			if ok || rehash {
+				t.Errorf("Verify(%q) = %t, %t, want false, false", tt.hash, ok, rehash)
+			}
+		})
+	}
+
+	// malformed bcrypt hash isn't a mismatch, it's an error too
+	if ok, _, err := h.Verify([]byte("$2a$10$short"), "password"); err == nil || ok {
+		t.Errorf("Verify(malformed bcrypt) = %t, %v, want error", ok, err)
+	}
+}
@@ -0,0 +1,87 @@
+package passpolicy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// prefixSize is length of hash prefix in hex characters the corpus is partitioned by,
+// it's the prefix k-anonymity range queries of Pwned Passwords are made with
+const prefixSize = 5
+
+// hashSuffix is part of hash following whole bytes of the prefix
+type hashSuffix [sha1.Size - prefixSize/2]byte
+
+// Breached is a corpus of SHA-1 hashes of breached passwords, partitioned by hash prefix
+type Breached struct {
+	// ranges maps hash prefix to sorted suffixes of hashes starting with it
+	ranges map[string][]hashSuffix
+}
+
+// LoadBreached reads corpus from file in Pwned Passwords format: a line per hash, which is
+// hex SHA-1 of password in any case, optionally followed by colon and number of occurrences
+func LoadBreached(path string) (*Breached, error) {
+	const op = "lib.passpolicy.LoadBreached"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer f.Close()
+
+	b := &Breached{ranges: make(map[string][]hashSuffix)}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		hexHash, _, _ := bytes.Cut(bytes.TrimSpace(scanner.Bytes()), []byte(":"))
+		if len(hexHash) == 0 {
+			continue
+		}
+
+		var sum [sha1.Size]byte
+		if hex.DecodedLen(len(hexHash)) != sha1.Size {
+			return nil, fmt.Errorf("%s: line %d: invalid hash length", op, line)
+		}
+		if _, err := hex.Decode(sum[:], hexHash); err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", op, line, err)
+		}
+
+		prefix, suffix := split(sum)
+		b.ranges[prefix] = append(b.ranges[prefix], suffix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, suffixes := range b.ranges {
+		slices.SortFunc(suffixes, compareSuffix)
+	}
+
+	return b, nil
+}
+
+// Contains reports whether password is in the corpus, only the range of its hash prefix is searched
+func (b *Breached) Contains(password string) bool {
+	prefix, suffix := split(sha1.Sum([]byte(password)))
+
+	_, found := slices.BinarySearchFunc(b.ranges[prefix], suffix, compareSuffix)
+
+	return found
+}
+
+// split returns hex prefix of the hash and the rest of it, the byte with the last prefix character is kept in the rest
+func split(sum [sha1.Size]byte) (string, hashSuffix) {
+	var suffix hashSuffix
+	copy(suffix[:], sum[prefixSize/2:])
+
+	return hex.EncodeToString(sum[:prefixSize/2+1])[:prefixSize], suffix
+}
+
+func compareSuffix(a, b hashSuffix) int {
+	return bytes.Compare(a[:], b[:])
+}
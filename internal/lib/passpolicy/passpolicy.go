@@ -0,0 +1,93 @@
+package passpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Params are rules new passwords must follow, zero values disable rules
+type Params struct {
+	MinLength int
+	MaxLength int
+	// Require* demand at least one character of the class
+	RequireLower  bool
+	RequireUpper  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// AllowEmail permits using email or its local part as password
+	AllowEmail bool
+	// MinScore is minimal strength score from 0 to 4, see Score
+	MinScore int
+}
+
+// Policy checks new passwords, existing ones are never checked, so policy can be tightened anytime
+type Policy struct {
+	params   Params
+	breached *Breached
+}
+
+// New returns policy with given rules, breached corpus is optional
+func New(params Params, breached *Breached) *Policy {
+	return &Policy{params: params, breached: breached}
+}
+
+// Check returns descriptions of all rules the password fails, email of the user is known
+// to attackers, so it's considered too. Length is counted in characters, not bytes
+func (p *Policy) Check(password string, email string) []string {
+	var violations []string
+
+	length := utf8.RuneCountInString(password)
+	if length < p.params.MinLength {
+		violations = append(violations, fmt.Sprintf("must be at least %d characters long", p.params.MinLength))
+	}
+	tooLong := p.params.MaxLength > 0 && length > p.params.MaxLength
+	if tooLong {
+		violations = append(violations, fmt.Sprintf("must be at most %d characters long", p.params.MaxLength))
+	}
+
+	if p.params.RequireLower && !strings.ContainsFunc(password, unicode.IsLower) {
+		violations = append(violations, "must contain a lowercase letter")
+	}
+	if p.params.RequireUpper && !strings.ContainsFunc(password, unicode.IsUpper) {
+		violations = append(violations, "must contain an uppercase letter")
+	}
+	if p.params.RequireDigit && !strings.ContainsFunc(password, unicode.IsDigit) {
+		violations = append(violations, "must contain a digit")
+	}
+	if p.params.RequireSymbol && !strings.ContainsFunc(password, isSymbol) {
+		violations = append(violations, "must contain a symbol")
+	}
+
+	if !p.params.AllowEmail && isEmail(password, email) {
+		violations = append(violations, "must not be the email")
+	}
+
+	// scoring is linear in length, too long passwords are rejected anyway
+	if !tooLong && p.params.MinScore > 0 && Score(password, email) < p.params.MinScore {
+		violations = append(violations, "is too easy to guess")
+	}
+
+	if p.breached != nil && p.breached.Contains(password) {
+		violations = append(violations, "appeared in a data breach")
+	}
+
+	return violations
+}
+
+// isSymbol reports whether r is neither letter, digit nor space
+func isSymbol(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+}
+
+// isEmail reports whether password is the email or its local part, ignoring case
+func isEmail(password string, email string) bool {
+	if email == "" {
+		return false
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+
+	return strings.EqualFold(password, email) || strings.EqualFold(password, local)
+}
@@ -0,0 +1,144 @@
+package passpolicy
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	const email = "john.smith@example.com"
+
+	tests := []struct {
+		name     string
+		params   Params
+		password string
+		want     []string
+	}{
+		{name: "no rules", params: Params{AllowEmail: true}, password: ""},
+		{name: "min length", params: Params{MinLength: 8}, password: "short", want: []string{"must be at least 8 characters long"}},
+		{name: "min length reached", params: Params{MinLength: 8}, password: "longenough"},
+		{name: "length in characters", params: Params{MinLength: 4, MaxLength: 4}, password: "паро"},
+		{name: "max length", params: Params{MaxLength: 8}, password: "much too long", want: []string{"must be at most 8 characters long"}},
+		{name: "max length disabled", params: Params{MaxLength: 0}, password: strings.Repeat("a", 1000)},
+		{
+			name:     "max length skips score",
+			params:   Params{MaxLength: 8, MinScore: 4},
+			password: strings.Repeat("a", 100),
+			want:     []string{"must be at most 8 characters long"},
+		},
+		{name: "lower", params: Params{RequireLower: true}, password: "PASSWORD1", want: []string{"must contain a lowercase letter"}},
+		{name: "non ASCII lower", params: Params{RequireLower: true}, password: "PAßWORD"},
+		{name: "upper", params: Params{RequireUpper: true}, password: "password1", want: []string{"must contain an uppercase letter"}},
+		{name: "digit", params: Params{RequireDigit: true}, password: "Password", want: []string{"must contain a digit"}},
+		{name: "symbol", params: Params{RequireSymbol: true}, password: "Password 1", want: []string{"must contain a symbol"}},
+		{name: "symbol present", params: Params{RequireSymbol: true}, password: "Password_1"},
+		{
+			name:     "every class",
+			params:   Params{RequireLower: true, RequireUpper: true, RequireDigit: true, RequireSymbol: true},
+			password: "",
+			want: []string{
+				"must contain a lowercase letter",
+				"must contain an uppercase letter",
+				"must contain a digit",
+				"must contain a symbol",
+			},
+		},
+		{name: "email", password: "John.Smith@Example.com", want: []string{"must not be the email"}},
+		{name: "local part of email", password: "JOHN.SMITH", want: []string{"must not be the email"}},
+		{name: "email in password", password: "john.smith@example.com!"},
+		{name: "email allowed", params: Params{AllowEmail: true}, password: "john.smith@example.com"},
+		{name: "weak", params: Params{MinScore: 3}, password: "password1", want: []string{"is too easy to guess"}},
+		{name: "weak with email", params: Params{MinScore: 2, AllowEmail: true}, password: "john.smith2024", want: []string{"is too easy to guess"}},
+		{name: "strong", params: Params{MinScore: 4}, password: "k9#Vq2!xLm"},
+		{
+			name:     "all violations",
+			params:   Params{MinLength: 12, RequireUpper: true, RequireDigit: true, MinScore: 1},
+			password: "john.smith",
+			want: []string{
+				"must be at least 12 characters long",
+				"must contain an uppercase letter",
+				"must contain a digit",
+				"must not be the email",
+				"is too easy to guess",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := New(tt.params, nil).Check(tt.password, email)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckWithoutEmail(t *testing.T) {
+	// users without email, such as ones signed up with a phone, have no email to compare with
+	if got := New(Params{}, nil).Check("", ""); got != nil {
+		t.Errorf("Check() = %q, want no violations", got)
+	}
+}
+
+func TestCheckBreached(t *testing.T) {
+	breached, err := LoadBreached(filepath.Join("testdata", "breached.txt"))
+	if err != nil {
+		t.Fatalf("LoadBreached: %v", err)
+	}
+	p := New(Params{AllowEmail: true}, breached)
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{password: "password", want: []string{"appeared in a data breach"}},
+		{password: "123456", want: []string{"appeared in a data breach"}},
+		{password: "qwerty", want: []string{"appeared in a data breach"}},
+		{password: "Password"},
+		{password: "letmein"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			if got := p.Check(tt.password, ""); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadBreachedErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "short hash", content: "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD\n", wantErr: "line 1: invalid hash length"},
+		{name: "long hash", content: "\n5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8AB\n", wantErr: "line 2: invalid hash length"},
+		{name: "not hex", content: "ZBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n", wantErr: "line 1: encoding/hex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "breached.txt")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatalf("failed to write corpus: %v", err)
+			}
+
+			_, err := LoadBreached(path)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("LoadBreached() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := LoadBreached(filepath.Join(t.TempDir(), "missing.txt")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadBreached() error = %v, want not exist", err)
+	}
+}
@@ -0,0 +1,254 @@
+package passpolicy
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Cardinalities of character classes attacker brute forces
+const (
+	lowerCardinality  = 26
+	upperCardinality  = 26
+	digitCardinality  = 10
+	symbolCardinality = 33
+	// otherCardinality is a rough guess for characters outside ASCII
+	otherCardinality = 100
+)
+
+// minSequence is minimal length of repeats, sequences and user inputs matched as patterns
+const minSequence = 3
+
+// scoreThresholds are log10 of guesses needed to reach score 1, 2, 3 and 4, as in zxcvbn:
+// under 10^3 guesses is risky even online, 10^10 resists offline attack on slow hash
+var scoreThresholds = [...]float64{3, 6, 8, 10}
+
+// sequences are alphabets and keyboard rows, runs of them in either direction are easy to guess
+var sequences = []string{
+	"abcdefghijklmnopqrstuvwxyz",
+	"01234567890",
+	"qwertyuiop",
+	"asdfghjkl",
+	"zxcvbnm",
+	"1qaz2wsx3edc4rfv5tgb6yhn7ujm8ik9ol0p",
+}
+
+// commonWords are the most used passwords and their parts, ordered by popularity
+var commonWords = []string{
+	"password", "qwerty", "dragon", "monkey", "letmein", "football", "iloveyou", "admin",
+	"welcome", "login", "master", "hello", "freedom", "whatever", "princess", "sunshine",
+	"shadow", "baseball", "superman", "trustno", "starwars", "michael", "jennifer", "jordan",
+	"hunter", "ranger", "buster", "soccer", "hockey", "killer", "george", "charlie",
+	"andrew", "thomas", "robert", "daniel", "pepper", "ginger", "summer", "winter",
+	"spring", "autumn", "secret", "access", "flower", "cookie", "batman", "matrix",
+	"passw0rd", "p@ssword", "changeme", "default", "root", "test", "guest", "user",
+	"love", "angel", "lovely", "family", "friend", "computer", "internet", "google",
+}
+
+// Score estimates how hard the password is to guess, from 0 (too guessable) to 4 (very unguessable),
+// like zxcvbn does. Password is split into common words, user inputs, repeats, sequences and years,
+// guessed as patterns, and the rest is brute forced. User inputs such as email are known to attacker
+func Score(password string, userInputs ...string) int {
+	guesses := log10Guesses(password, inputTokens(userInputs))
+
+	score := 0
+	for _, threshold := range scoreThresholds {
+		if guesses < threshold {
+			break
+		}
+		score++
+	}
+
+	return score
+}
+
+// log10Guesses returns log10 of guesses needed to find the password, matching patterns greedily
+func log10Guesses(password string, inputs []string) float64 {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	if len(lower) != len(runes) {
+		// lowercasing changed length, don't match words then
+		lower = runes
+	}
+	bruteforce := math.Log10(float64(cardinality(runes)))
+
+	var guesses float64
+	for i := 0; i < len(runes); {
+		n, patternGuesses := matchPattern(runes[i:], lower[i:], inputs)
+		if n == 0 {
+			guesses += bruteforce
+			i++
+			continue
+		}
+
+		guesses += patternGuesses
+		i += n
+	}
+
+	return guesses
+}
+
+// matchPattern returns length and log10 guesses of the longest pattern at the start of s, or zero length
+func matchPattern(s []rune, lower []rune, inputs []string) (int, float64) {
+	var (
+		best        int
+		bestGuesses float64
+	)
+	try := func(n int, guesses float64) {
+		if n > best {
+			best, bestGuesses = n, guesses
+		}
+	}
+
+	for rank, word := range inputs {
+		if hasPrefix(lower, word) {
+			try(len([]rune(word)), math.Log10(float64(rank+2))+caseGuesses(s[:len([]rune(word))]))
+		}
+	}
+	for rank, word := range commonWords {
+		if hasPrefix(lower, word) {
+			try(len(word), math.Log10(float64(rank+2))+caseGuesses(s[:len(word)]))
+		}
+	}
+
+	if n := repeatLength(s); n >= minSequence {
+		try(n, math.Log10(float64(cardinality(s[:1])))+math.Log10(float64(n)))
+	}
+	if n := sequenceLength(lower); n >= minSequence {
+		try(n, math.Log10(float64(len(sequences)*2))+math.Log10(float64(n))+caseGuesses(s[:n]))
+	}
+	if isYear(s) {
+		// years within two centuries around now
+		try(4, math.Log10(200))
+	}
+
+	return best, bestGuesses
+}
+
+// caseGuesses returns log10 of guesses of capitalization: none or only the first letter capitalized
+// is guessed first, other mixes add a guess per letter
+func caseGuesses(s []rune) float64 {
+	upper := 0
+	for _, r := range s {
+		if unicode.IsUpper(r) {
+			upper++
+		}
+	}
+
+	switch {
+	case upper == 0:
+		return 0
+	case upper == 1 && unicode.IsUpper(s[0]):
+		return math.Log10(2)
+	default:
+		return float64(len(s)) * math.Log10(2)
+	}
+}
+
+// cardinality returns size of alphabet of all character classes present in s
+func cardinality(s []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r <= unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	total := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{
+		{lower, lowerCardinality},
+		{upper, upperCardinality},
+		{digit, digitCardinality},
+		{symbol, symbolCardinality},
+		{other, otherCardinality},
+	} {
+		if class.present {
+			total += class.size
+		}
+	}
+
+	return max(total, 1)
+}
+
+// repeatLength returns length of run of the first character
+func repeatLength(s []rune) int {
+	n := 1
+	for n < len(s) && s[n] == s[0] {
+		n++
+	}
+	return n
+}
+
+// sequenceLength returns length of the longest run at the start of s that follows
+// one of sequences forward or backward
+func sequenceLength(s []rune) int {
+	longest := 0
+	for _, seq := range sequences {
+		seqRunes := []rune(seq)
+		for start := range seqRunes {
+			for _, step := range []int{1, -1} {
+				n := 0
+				for j := start; n < len(s) && j >= 0 && j < len(seqRunes) && s[n] == seqRunes[j]; j += step {
+					n++
+				}
+				longest = max(longest, n)
+			}
+		}
+	}
+	return longest
+}
+
+// isYear reports whether s starts with year from 1900 to 2099
+func isYear(s []rune) bool {
+	if len(s) < 4 {
+		return false
+	}
+	for _, r := range s[:4] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return (s[0] == '1' && s[1] == '9') || (s[0] == '2' && s[1] == '0')
+}
+
+// inputTokens splits user inputs into lowercased tokens worth matching: whole inputs,
+// and parts of them separated by punctuation, such as name parts of email
+func inputTokens(userInputs []string) []string {
+	var tokens []string
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		parts := strings.FieldsFunc(input, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, token := range append([]string{input}, parts...) {
+			if len([]rune(token)) >= minSequence {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+// hasPrefix reports whether s starts with prefix
+func hasPrefix(s []rune, prefix string) bool {
+	i := 0
+	for _, r := range prefix {
+		if i >= len(s) || s[i] != r {
+			return false
+		}
+		i++
+	}
+	return true
+}
@@ -0,0 +1,50 @@
+package passpolicy
+
+import "testing"
+
+func TestScore(t *testing.T) {
+	tests := []struct {
+		name       string
+		password   string
+		userInputs []string
+		want       int
+	}{
+		{name: "empty", password: "", want: 0},
+		{name: "repeat", password: "aaaaaaaa", want: 0},
+		{name: "alphabet sequence", password: "abcdefgh", want: 0},
+		{name: "reversed sequence", password: "hgfedcba", want: 0},
+		{name: "keyboard row", password: "qwertyuiop", want: 0},
+		{name: "common word", password: "password", want: 0},
+		{name: "capitalized common word", password: "Password1", want: 0},
+		{name: "year", password: "1990", want: 0},
+		{name: "short random", password: "x7Gp", want: 2},
+		{name: "user input", password: "john.smith2024", userInputs: []string{"john.smith@example.com"}, want: 1},
+		{name: "without user input", password: "john.smith2024", want: 4},
+		{name: "random", password: "k9#Vq2!xLm", want: 4},
+		{name: "passphrase", password: "correct horse battery staple", want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Score(tt.password, tt.userInputs...); got != tt.want {
+				t.Errorf("Score(%q) = %d, want %d", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScoreIncreasesWithLength(t *testing.T) {
+	const random = "k9#Vq2!xLmR4"
+
+	prev := 0
+	for n := 1; n <= len(random); n++ {
+		score := Score(random[:n])
+		if score < prev {
+			t.Fatalf("Score(%q) = %d, less than %d of shorter password", random[:n], score, prev)
+		}
+		prev = score
+	}
+	if prev != 4 {
+		t.Errorf("Score(%q) = %d, want 4", random, prev)
+	}
+}
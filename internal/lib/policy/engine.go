@@ -0,0 +1,142 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"sso/internal/lib/logger/sl"
+)
+
+type Params struct {
+	Paths []string
+	// ReloadInterval is how often files are checked for changes, zero disables reloading
+	ReloadInterval time.Duration
+	// DryRun makes every decision allowing, the decision policies would make is only logged
+	DryRun bool
+	// Explain logs result of every policy targeting the method of each request
+	Explain bool
+	// Location is time zone of the now variable
+	Location *time.Location
+}
+
+// Engine evaluates policies of files, which are reloaded when they change.
+// If reloaded files are invalid, policies loaded before are kept
+type Engine struct {
+	log    *slog.Logger
+	params Params
+	set    atomic.Pointer[Set]
+	// versions are modification times and sizes of files the set is loaded from
+	versions []string
+}
+
+// NewEngine loads policies of files, it fails if any file is invalid
+func NewEngine(log *slog.Logger, params Params) (*Engine, error) {
+	const op = "lib.policy.NewEngine"
+
+	if params.Location == nil {
+		params.Location = time.UTC
+	}
+
+	e := &Engine{log: log, params: params}
+	if err := e.reload(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return e, nil
+}
+
+// Evaluate decides on the request, in dry run mode it's always allowed
+func (e *Engine) Evaluate(input Input) Decision {
+	const op = "lib.policy.Engine.Evaluate"
+
+	decision := e.set.Load().Evaluate(input, e.params.Location)
+
+	log := e.log.With(
+		slog.String("op", op),
+		slog.String("method", input.Method),
+	)
+
+	if e.params.Explain && len(decision.Results) > 0 {
+		log.Info("policies evaluated",
+			slog.Bool("allowed", decision.Allowed),
+			slog.String("reason", decision.Reason),
+			slog.String("explain", decision.Explain()),
+		)
+	}
+
+	if e.params.DryRun && !decision.Allowed {
+		log.Warn("request would be denied by policies (dry run)", slog.String("reason", decision.Reason))
+		decision.Allowed = true
+	}
+
+	return decision
+}
+
+// Run checks files for changes every reload interval until ctx is done
+func (e *Engine) Run(ctx context.Context) {
+	const op = "lib.policy.Engine.Run"
+
+	log := e.log.With(
+		slog.String("op", op),
+	)
+
+	if e.params.ReloadInterval <= 0 || len(e.params.Paths) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(e.params.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if slices.Equal(e.fileVersions(), e.versions) {
+				continue
+			}
+			if err := e.reload(); err != nil {
+				log.Error("failed to reload policies, previous ones are kept", sl.Err(err))
+				continue
+			}
+			log.Info("policies reloaded", slog.Int("policies", e.set.Load().Len()))
+		}
+	}
+}
+
+// reload loads policies and remembers versions of files they are loaded from. Versions are taken
+// before reading, so a change made while loading is picked up by the next check
+func (e *Engine) reload() error {
+	versions := e.fileVersions()
+
+	set, err := Load(e.params.Paths)
+	if err != nil {
+		// failed versions are remembered too, so the same error isn't logged on every check
+		e.versions = versions
+		return err
+	}
+
+	e.set.Store(set)
+	e.versions = versions
+
+	return nil
+}
+
+// fileVersions returns modification time and size of each file, or error of stat
+func (e *Engine) fileVersions() []string {
+	versions := make([]string, 0, len(e.params.Paths))
+	for _, path := range e.params.Paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			versions = append(versions, err.Error())
+			continue
+		}
+		versions = append(versions, fmt.Sprintf("%d/%d", info.ModTime().UnixNano(), info.Size()))
+	}
+	return versions
+}
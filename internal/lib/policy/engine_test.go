@@ -0,0 +1,274 @@
+package policy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"sso/internal/lib/logger/handlers/slogdiscard"
+)
+
+const denyAll = `policies:
+  - name: deny-all
+    effect: deny
+    condition: "true"`
+
+func TestEngineDryRun(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+
+	e, err := NewEngine(log, Params{
+		Paths:   []string{writePolicies(t, t.TempDir(), "policies.yaml", denyAll)},
+		DryRun:  true,
+		Explain: true,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	decision := e.Evaluate(Input{Method: "/userInfo.UserInfo/User"})
+	if !decision.Allowed {
+		t.Fatal("request is denied in dry run mode")
+	}
+	// reason is the decision policies would make
+	if decision.Reason != "denied by policy deny-all" {
+		t.Errorf("reason = %q, want denied by policy deny-all", decision.Reason)
+	}
+
+	for _, want := range []string{
+		"policies evaluated",
+		`explain="deny-all (deny): true"`,
+		"request would be denied by policies (dry run)",
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("logs %q don't contain %q", logs.String(), want)
+		}
+	}
+}
+
+func TestEngineEnforces(t *testing.T) {
+	var logs bytes.Buffer
+	log := slog.New(slog.NewTextHandler(&logs, nil))
+
+	e, err := NewEngine(log, Params{
+		Paths: []string{writePolicies(t, t.TempDir(), "policies.yaml", denyAll)},
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	if decision := e.Evaluate(Input{Method: "/userInfo.UserInfo/User"}); decision.Allowed {
+		t.Fatal("request is allowed, want denied")
+	}
+	// nothing is logged unless explain is enabled
+	if logs.Len() != 0 {
+		t.Errorf("unexpected logs: %q", logs.String())
+	}
+}
+
+func TestNewEngineFailsOnInvalidFile(t *testing.T) {
+	_, err := NewEngine(slogdiscard.NewDiscardLogger(), Params{
+		Paths: []string{writePolicies(t, t.TempDir(), "policies.yaml", "policies: [")},
+	})
+	if err == nil {
+		t.Fatal("NewEngine succeeded, want error")
+	}
+}
+
+// rewritePolicies replaces the policy file with the content at once, like an editor or a config map
+// update does, and moves its modification time forward, so the change is seen on file systems with
+// coarse timestamps
+func rewritePolicies(t *testing.T, filePath, content string, modTime time.Time) {
+	t.Helper()
+
+	tmp := writePolicies(t, filepath.Dir(filePath), "."+filepath.Base(filePath)+".tmp", content)
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		t.Fatalf("failed to set modification time: %v", err)
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		t.Fatalf("failed to replace policies: %v", err)
+	}
+}
+
+func TestEngineReload(t *testing.T) {
+	filePath := writePolicies(t, t.TempDir(), "policies.yaml", denyAll)
+
+	e, err := NewEngine(slogdiscard.NewDiscardLogger(), Params{Paths: []string{filePath}})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	input := Input{Method: "/userInfo.UserInfo/User"}
+
+	modTime := time.Now()
+	steps := []struct {
+		name        string
+		content     string
+		wantErr     bool
+		wantAllowed bool
+		wantReason  string
+	}{
+		{
+			name: "valid change",
+			content: `policies:
+  - name: allow-all
+    effect: allow
+    condition: "true"`,
+			wantAllowed: true,
+			wantReason:  "allowed by policy allow-all",
+		},
+		{
+			name:        "invalid change keeps previous policies",
+			content:     denyAll + "\n    methods: [",
+			wantErr:     true,
+			wantAllowed: true,
+			wantReason:  "allowed by policy allow-all",
+		},
+		{
+			name:        "fixed change",
+			content:     denyAll,
+			wantAllowed: false,
+			wantReason:  "denied by policy deny-all",
+		},
+	}
+
+	for _, step := range steps {
+		modTime = modTime.Add(time.Second)
+		rewritePolicies(t, filePath, step.content, modTime)
+
+		err := e.reload()
+		if (err != nil) != step.wantErr {
+			t.Fatalf("%s: reload() error = %v, want error %t", step.name, err, step.wantErr)
+		}
+
+		decision := e.Evaluate(input)
+		if decision.Allowed != step.wantAllowed || decision.Reason != step.wantReason {
+			t.Fatalf("%s: Evaluate() = %t %q, want %t %q",
+				step.name, decision.Allowed, decision.Reason, step.wantAllowed, step.wantReason)
+		}
+	}
+}
+
+func TestEngineRunReloadsChangedFiles(t *testing.T) {
+	filePath := writePolicies(t, t.TempDir(), "policies.yaml", denyAll)
+
+	e, err := NewEngine(slogdiscard.NewDiscardLogger(), Params{
+		Paths:          []string{filePath},
+		ReloadInterval: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		e.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	rewritePolicies(t, filePath, `policies:
+  - name: allow-all
+    effect: allow
+    condition: "true"`, time.Now().Add(time.Second))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !e.Evaluate(Input{Method: "/userInfo.UserInfo/User"}).Allowed {
+		if time.Now().After(deadline) {
+			t.Fatal("changed policies weren't reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestEngineReloadIsAtomic evaluates requests while files are reloaded between two sets of policies,
+// every decision has to be made by one of the sets as a whole
+func TestEngineReloadIsAtomic(t *testing.T) {
+	set := func(version string) string {
+		var b strings.Builder
+		b.WriteString("policies:\n")
+		for i := 0; i < 20; i++ {
+			fmt.Fprintf(&b, "  - name: %s-%d\n    effect: deny\n    condition: \"false\"\n", version, i)
+		}
+		return b.String()
+	}
+
+	filePath := writePolicies(t, t.TempDir(), "policies.yaml", set("a"))
+
+	e, err := NewEngine(slogdiscard.NewDiscardLogger(), Params{
+		Paths:          []string{filePath},
+		ReloadInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.Run(ctx)
+	}()
+
+	// reloaded is set once a decision is made by the second set
+	var reloaded atomic.Bool
+	errs := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				results := e.Evaluate(Input{Method: "/userInfo.UserInfo/User"}).Results
+				if len(results) != 20 {
+					errs <- fmt.Sprintf("decision has %d results, want 20", len(results))
+					return
+				}
+				version, _, _ := strings.Cut(results[0].Policy, "-")
+				if version == "b" {
+					reloaded.Store(true)
+				}
+				for _, result := range results {
+					if !strings.HasPrefix(result.Policy, version+"-") {
+						errs <- fmt.Sprintf("decision mixes policies %s and %s", results[0].Policy, result.Policy)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	modTime := time.Now()
+	for i := 0; i < 50; i++ {
+		version := "a"
+		if i%2 == 0 {
+			version = "b"
+		}
+		modTime = modTime.Add(time.Second)
+		rewritePolicies(t, filePath, set(version), modTime)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	cancel()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if !reloaded.Load() {
+		t.Error("policies weren't reloaded")
+	}
+}
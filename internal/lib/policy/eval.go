@@ -0,0 +1,448 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type node interface {
+	eval(vars map[string]any) (any, error)
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n *literalNode) eval(map[string]any) (any, error) {
+	return n.value, nil
+}
+
+type identNode struct {
+	name string
+}
+
+func (n *identNode) eval(vars map[string]any) (any, error) {
+	v, ok := vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared variable %s", n.name)
+	}
+	return normalize(v), nil
+}
+
+type selectNode struct {
+	operand node
+	field   string
+}
+
+func (n *selectNode) eval(vars map[string]any) (any, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("can't select field %s of %s", n.field, typeName(v))
+	}
+	field, ok := m[n.field]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", n.field)
+	}
+	return normalize(field), nil
+}
+
+type indexNode struct {
+	operand node
+	index   node
+}
+
+func (n *indexNode) eval(vars map[string]any) (any, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be string, got %s", typeName(index))
+		}
+		field, ok := v[key]
+		if !ok {
+			return nil, fmt.Errorf("no such key: %s", key)
+		}
+		return normalize(field), nil
+	case []any:
+		i, ok := index.(float64)
+		if !ok || i != math.Trunc(i) {
+			return nil, fmt.Errorf("list index must be integer, got %s", typeName(index))
+		}
+		if i < 0 || int(i) >= len(v) {
+			return nil, fmt.Errorf("index %d out of range", int(i))
+		}
+		return normalize(v[int(i)]), nil
+	}
+	return nil, fmt.Errorf("can't index %s", typeName(v))
+}
+
+type listNode struct {
+	items []node
+}
+
+func (n *listNode) eval(vars map[string]any) (any, error) {
+	list := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(vars map[string]any) (any, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case bool:
+		if n.op == "!" {
+			return !v, nil
+		}
+	case float64:
+		if n.op == "-" {
+			return -v, nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s%s", n.op, typeName(v))
+}
+
+type conditionalNode struct {
+	cond      node
+	then      node
+	otherwise node
+}
+
+func (n *conditionalNode) eval(vars map[string]any) (any, error) {
+	cond, err := n.cond.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := cond.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition must be bool, got %s", typeName(cond))
+	}
+	if b {
+		return n.then.eval(vars)
+	}
+	return n.otherwise.eval(vars)
+}
+
+type binaryNode struct {
+	op    string
+	left  node
+	right node
+}
+
+func (n *binaryNode) eval(vars map[string]any) (any, error) {
+	if n.op == "&&" || n.op == "||" {
+		return n.logical(vars)
+	}
+
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		return contains(left, right)
+	case "<", "<=", ">", ">=":
+		return compare(n.op, left, right)
+	}
+	return arithmetic(n.op, left, right)
+}
+
+// logical evaluates && and ||, a decisive operand wins over an error of the other one
+func (n *binaryNode) logical(vars map[string]any) (any, error) {
+	decisive := n.op == "||"
+
+	left, leftErr := evalBool(n.left, vars)
+	if leftErr == nil && left == decisive {
+		return decisive, nil
+	}
+	right, rightErr := evalBool(n.right, vars)
+	if rightErr == nil && right == decisive {
+		return decisive, nil
+	}
+
+	if leftErr != nil {
+		return nil, leftErr
+	}
+	if rightErr != nil {
+		return nil, rightErr
+	}
+	return !decisive, nil
+}
+
+func evalBool(n node, vars map[string]any) (bool, error) {
+	v, err := n.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %s", typeName(v))
+	}
+	return b, nil
+}
+
+func contains(elem, container any) (bool, error) {
+	switch container := container.(type) {
+	case []any:
+		for _, item := range container {
+			if reflect.DeepEqual(normalize(item), elem) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]any:
+		key, ok := elem.(string)
+		if !ok {
+			return false, nil
+		}
+		_, ok = container[key]
+		return ok, nil
+	}
+	return false, fmt.Errorf("no such overload: %s in %s", typeName(elem), typeName(container))
+}
+
+func compare(op string, left, right any) (bool, error) {
+	var cmp int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false, fmt.Errorf("no such overload: %s %s %s", typeName(left), op, typeName(right))
+		}
+		switch {
+		case l < r:
+			cmp = -1
+		case l > r:
+			cmp = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false, fmt.Errorf("no such overload: %s %s %s", typeName(left), op, typeName(right))
+		}
+		cmp = strings.Compare(l, r)
+	default:
+		return false, fmt.Errorf("no such overload: %s %s %s", typeName(left), op, typeName(right))
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+func arithmetic(op string, left, right any) (any, error) {
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			break
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		case "/", "%":
+			if r == 0 {
+				return nil, fmt.Errorf("division by zero")
+			}
+			if op == "/" {
+				return l / r, nil
+			}
+			return math.Mod(l, r), nil
+		}
+	case string:
+		if r, ok := right.(string); ok && op == "+" {
+			return l + r, nil
+		}
+	case []any:
+		if r, ok := right.([]any); ok && op == "+" {
+			return append(append([]any{}, l...), r...), nil
+		}
+	}
+	return nil, fmt.Errorf("no such overload: %s %s %s", typeName(left), op, typeName(right))
+}
+
+type callNode struct {
+	name string
+	args []node
+	// re is compiled pattern of matches, if it's a literal
+	re *regexp.Regexp
+}
+
+func (n *callNode) eval(vars map[string]any) (any, error) {
+	if n.name == "has" {
+		sel := n.args[0].(*selectNode)
+		v, err := sel.operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("can't select field %s of %s", sel.field, typeName(v))
+		}
+		_, ok = m[sel.field]
+		return ok, nil
+	}
+
+	args := make([]any, 0, len(n.args))
+	for _, arg := range n.args {
+		v, err := arg.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, v)
+	}
+
+	switch n.name {
+	case "size":
+		switch v := args[0].(type) {
+		case string:
+			return float64(len([]rune(v))), nil
+		case []any:
+			return float64(len(v)), nil
+		case map[string]any:
+			return float64(len(v)), nil
+		}
+	case "int":
+		switch v := args[0].(type) {
+		case float64:
+			return math.Trunc(v), nil
+		case string:
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("int: %q isn't integer", v)
+			}
+			return float64(i), nil
+		}
+	case "string":
+		switch v := args[0].(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		}
+	case "lowerAscii":
+		if s, ok := args[0].(string); ok {
+			return strings.ToLower(s), nil
+		}
+	case "startsWith", "endsWith", "contains", "matches":
+		s, ok1 := args[0].(string)
+		arg, ok2 := args[1].(string)
+		if !ok1 || !ok2 {
+			break
+		}
+		switch n.name {
+		case "startsWith":
+			return strings.HasPrefix(s, arg), nil
+		case "endsWith":
+			return strings.HasSuffix(s, arg), nil
+		case "contains":
+			return strings.Contains(s, arg), nil
+		}
+		re := n.re
+		if re == nil {
+			var err error
+			if re, err = regexp.Compile(arg); err != nil {
+				return nil, fmt.Errorf("matches: %w", err)
+			}
+		}
+		return re.MatchString(s), nil
+	}
+
+	types := make([]string, 0, len(args))
+	for _, arg := range args {
+		types = append(types, typeName(arg))
+	}
+	return nil, fmt.Errorf("no such overload: %s(%s)", n.name, strings.Join(types, ", "))
+}
+
+// normalize converts values of variables to types of the language, numbers become doubles
+// and typed slices and maps become generic ones
+func normalize(v any) any {
+	switch v := v.(type) {
+	case nil, bool, float64, string, []any, map[string]any:
+		return v
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case []string:
+		list := make([]any, 0, len(v))
+		for _, s := range v {
+			list = append(list, s)
+		}
+		return list
+	}
+	return v
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []any:
+		return "list"
+	case map[string]any:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
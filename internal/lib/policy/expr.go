@@ -0,0 +1,440 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Expression is compiled condition of a policy. The language is a subset of CEL:
+//
+//   - literals: numbers, strings in single or double quotes, true, false, null and lists [a, b]
+//   - variables with fields and indexes: subject.roles, request["email"], subject.roles[0]
+//   - operators by CEL precedence: ! and unary -, * / %, + -, == != < <= > >= in, &&, ||, and a ? b : c
+//   - functions: size(x), has(a.b), int(x), string(x), and methods of strings s.startsWith(p),
+//     s.endsWith(p), s.contains(p), s.matches(regexp), s.lowerAscii() and x.size()
+//
+// Values are nulls, bools, numbers (all of them are doubles), strings, lists and maps.
+// Selecting missing field is an error, has tests for it. As in CEL, && and || are commutative
+// for errors: false && error is false, true || error is true
+type Expression struct {
+	source string
+	root   node
+}
+
+// Compile parses source of expression
+func Compile(source string) (*Expression, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &exprParser{tokens: tokens}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.peek().text)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Eval evaluates the expression with given variables
+func (e *Expression) Eval(vars map[string]any) (any, error) {
+	return e.root.eval(vars)
+}
+
+type tokenKind int
+
+const (
+	tokenOp tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+)
+
+type exprToken struct {
+	kind tokenKind
+	text string
+	// value is parsed value of number and string literals
+	value any
+	pos   int
+}
+
+// operators are ordered so longer ones are matched first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "!", "<", ">", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]"}
+
+func lex(src string) ([]exprToken, error) {
+	var tokens []exprToken
+	for pos := 0; pos < len(src); {
+		c := src[pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			pos++
+		case c >= '0' && c <= '9':
+			end := pos
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			n, err := strconv.ParseFloat(src[pos:end], 64)
+			if err != nil {
+				return nil, fmt.Errorf("position %d: invalid number %q", pos, src[pos:end])
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: src[pos:end], value: n, pos: pos})
+			pos = end
+		case c == '"' || c == '\'':
+			s, end, err := lexString(src, pos)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: src[pos:end], value: s, pos: pos})
+			pos = end
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			end := pos
+			for end < len(src) && (src[end] == '_' || src[end] >= 'a' && src[end] <= 'z' || src[end] >= 'A' && src[end] <= 'Z' || src[end] >= '0' && src[end] <= '9') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: src[pos:end], pos: pos})
+			pos = end
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(src[pos:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("position %d: unexpected character %q", pos, c)
+			}
+			tokens = append(tokens, exprToken{kind: tokenOp, text: op, pos: pos})
+			pos += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// lexString reads string literal starting at pos, returns its value and position after it
+func lexString(src string, pos int) (string, int, error) {
+	quote := src[pos]
+	var b strings.Builder
+	for i := pos + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '\\', '"', '\'':
+				b.WriteByte(src[i])
+			default:
+				return "", 0, fmt.Errorf("position %d: invalid escape \\%c", i-1, src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("position %d: unterminated string", pos)
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *exprParser) peek() exprToken {
+	if p.done() {
+		return exprToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+// accept consumes operator or keyword if it's next
+func (p *exprParser) accept(text string) bool {
+	if t := p.peek(); !p.done() && (t.kind == tokenOp || t.kind == tokenIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(text string) error {
+	if !p.accept(text) {
+		if p.done() {
+			return p.errorf("expected %q", text)
+		}
+		return p.errorf("expected %q, got %q", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) errorf(format string, args ...any) error {
+	if p.done() {
+		return fmt.Errorf("unexpected end: "+format, args...)
+	}
+	return fmt.Errorf("position %d: "+format, append([]any{p.peek().pos}, args...)...)
+}
+
+// expr parses: or [? expr : expr]
+func (p *exprParser) expr() (node, error) {
+	cond, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	then, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	return &conditionalNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+func (p *exprParser) or() (node, error) {
+	return p.binary(p.and, "||")
+}
+
+func (p *exprParser) and() (node, error) {
+	return p.binary(p.relation, "&&")
+}
+
+func (p *exprParser) relation() (node, error) {
+	return p.binary(p.addition, "==", "!=", "<", "<=", ">", ">=", "in")
+}
+
+func (p *exprParser) addition() (node, error) {
+	return p.binary(p.multiplication, "+", "-")
+}
+
+func (p *exprParser) multiplication() (node, error) {
+	return p.binary(p.unary, "*", "/", "%")
+}
+
+// binary parses left associative chain of operands separated by any of ops
+func (p *exprParser) binary(operand func() (node, error), ops ...string) (node, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, candidate := range ops {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *exprParser) unary() (node, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			operand, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryNode{op: op, operand: operand}, nil
+		}
+	}
+	return p.member()
+}
+
+// member parses primary followed by fields, indexes and method calls
+func (p *exprParser) member() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("."):
+			name := p.peek()
+			if name.kind != tokenIdent {
+				return nil, p.errorf("expected field name")
+			}
+			p.pos++
+
+			if !p.accept("(") {
+				n = &selectNode{operand: n, field: name.text}
+				continue
+			}
+			args, err := p.args(")")
+			if err != nil {
+				return nil, err
+			}
+			n, err = newCall(name.text, n, args)
+			if err != nil {
+				return nil, err
+			}
+		case p.accept("["):
+			index, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{operand: n, index: index}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *exprParser) primary() (node, error) {
+	t := p.peek()
+	if p.done() {
+		return nil, p.errorf("expected operand")
+	}
+
+	switch t.kind {
+	case tokenNumber, tokenString:
+		p.pos++
+		return &literalNode{value: t.value}, nil
+	case tokenIdent:
+		p.pos++
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if !p.accept("(") {
+			return &identNode{name: t.text}, nil
+		}
+		args, err := p.args(")")
+		if err != nil {
+			return nil, err
+		}
+		return newCall(t.text, nil, args)
+	}
+
+	switch {
+	case p.accept("("):
+		n, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return n, p.expect(")")
+	case p.accept("["):
+		items, err := p.args("]")
+		if err != nil {
+			return nil, err
+		}
+		return &listNode{items: items}, nil
+	}
+
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+// args parses comma separated expressions up to closing token
+func (p *exprParser) args(closing string) ([]node, error) {
+	var args []node
+	if p.accept(closing) {
+		return args, nil
+	}
+	for {
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+
+		if p.accept(closing) {
+			return args, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// newCall checks function exists and gets right arguments. Regular expressions given by literals
+// are compiled once here
+func newCall(name string, target node, args []node) (node, error) {
+	arity, ok := functions[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if target != nil {
+		args = append([]node{target}, args...)
+	}
+	if len(args) != arity {
+		return nil, fmt.Errorf("function %s takes %d arguments, got %d", name, arity, len(args))
+	}
+
+	call := &callNode{name: name, args: args}
+	switch name {
+	case "has":
+		if _, ok := args[0].(*selectNode); !ok {
+			return nil, fmt.Errorf("has takes field selection, like has(a.b)")
+		}
+	case "matches":
+		if lit, ok := args[1].(*literalNode); ok {
+			pattern, ok := lit.value.(string)
+			if !ok {
+				return nil, fmt.Errorf("matches takes string pattern")
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("matches: %w", err)
+			}
+			call.re = re
+		}
+	}
+
+	return call, nil
+}
+
+// functions maps names of functions to number of arguments, target of method call is the first one
+var functions = map[string]int{
+	"size":       1,
+	"has":        1,
+	"int":        1,
+	"string":     1,
+	"startsWith": 2,
+	"endsWith":   2,
+	"contains":   2,
+	"matches":    2,
+	"lowerAscii": 1,
+}
@@ -0,0 +1,165 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testVars() map[string]any {
+	return map[string]any{
+		"subject": map[string]any{
+			"email": "Support@Example.com",
+			"roles": []string{"support", "editor"},
+			"uid":   int64(7),
+		},
+		"request": map[string]any{
+			"email": "User@Example.com",
+			"count": float64(3),
+		},
+		"method": "/userInfo.UserInfo/User",
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   any
+	}{
+		// precedence
+		{name: "multiplication before addition", source: "1 + 2 * 3", want: float64(7)},
+		{name: "parentheses", source: "(1 + 2) * 3", want: float64(9)},
+		{name: "left associative subtraction", source: "10 - 2 - 3", want: float64(5)},
+		{name: "modulo with multiplication", source: "2 * 3 % 4", want: float64(2)},
+		{name: "unary minus binds tighter", source: "-2 * 3", want: float64(-6)},
+		{name: "arithmetic before comparison", source: "1 + 1 == 2", want: true},
+		{name: "comparisons are left associative", source: "1 < 2 == true", want: true},
+		{name: "and before or", source: "true || false && false", want: true},
+		{name: "not before or", source: "!true || true", want: true},
+		{name: "not of group", source: "!(true || true)", want: false},
+		{name: "conditional is lowest", source: "true ? 1 : 2 + 3", want: float64(1)},
+		{name: "conditional is right associative", source: "false ? 1 : true ? 2 : 3", want: float64(2)},
+		{name: "in before and", source: `"support" in subject.roles && "editor" in subject.roles`, want: true},
+
+		// values and functions
+		{name: "string concatenation", source: `'a' + "b" == "ab"`, want: true},
+		{name: "escapes", source: `"a\"b\n"`, want: "a\"b\n"},
+		{name: "list concatenation", source: "[1, 2] + [3]", want: []any{float64(1), float64(2), float64(3)}},
+		{name: "not in list", source: `"admin" in subject.roles`, want: false},
+		{name: "key in map", source: `"email" in request`, want: true},
+		{name: "int variable is double", source: "subject.uid == 7", want: true},
+		{name: "index of list", source: "subject.roles[1]", want: "editor"},
+		{name: "index of map", source: `request["count"] > 2`, want: true},
+		{name: "size function", source: "size(subject.roles)", want: float64(2)},
+		{name: "size method", source: "subject.email.size()", want: float64(19)},
+		{name: "has field", source: "has(subject.email)", want: true},
+		{name: "has missing field", source: "has(subject.missing)", want: false},
+		{name: "int of string", source: "int('42') + 1", want: float64(43)},
+		{name: "int truncates", source: "int(2.7)", want: float64(2)},
+		{name: "string of number", source: "string(1.5)", want: "1.5"},
+		{name: "lowerAscii", source: "request.email.lowerAscii()", want: "user@example.com"},
+		{name: "startsWith", source: "method.startsWith('/userInfo.')", want: true},
+		{name: "endsWith", source: "method.endsWith('/User')", want: true},
+		{name: "contains", source: "subject.email.contains('@')", want: true},
+		{name: "matches", source: `request.email.matches("^[A-Z][a-z]+@")`, want: true},
+		{name: "string comparison", source: "'abc' < 'abd'", want: true},
+		{name: "null equality", source: "null == null", want: true},
+
+		// errors are absorbed by decisive operands of && and ||
+		{name: "false and error", source: "false && subject.missing", want: false},
+		{name: "error and false", source: "subject.missing && false", want: false},
+		{name: "true or error", source: "subject.missing || true", want: true},
+		{name: "error in untaken branch", source: "true ? 1 : subject.missing", want: float64(1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.source, err)
+			}
+
+			got, err := expr.Eval(testVars())
+			if err != nil {
+				t.Fatalf("Eval(%q): %v", tt.source, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Eval(%q) = %#v, want %#v", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "missing field", source: "subject.missing", wantErr: "no such key: missing"},
+		{name: "undeclared variable", source: "user.email", wantErr: "undeclared variable user"},
+		{name: "select of string", source: "method.name", wantErr: "can't select field name of string"},
+		{name: "add number and string", source: "1 + 'a'", wantErr: "no such overload: double + string"},
+		{name: "compare number and string", source: "1 < 'a'", wantErr: "no such overload: double < string"},
+		{name: "division by zero", source: "1 / 0", wantErr: "division by zero"},
+		{name: "index out of range", source: "subject.roles[5]", wantErr: "index 5 out of range"},
+		{name: "fractional index", source: "subject.roles[0.5]", wantErr: "list index must be integer"},
+		{name: "not of number", source: "!1", wantErr: "no such overload: !double"},
+		{name: "in of string", source: "'a' in 'abc'", wantErr: "no such overload: string in string"},
+		{name: "non bool conditional", source: "1 ? 2 : 3", wantErr: "condition must be bool"},
+		{name: "non bool operand of and", source: "1 && true", wantErr: "expected bool, got double"},
+		{name: "both operands of or fail", source: "subject.missing || subject.other", wantErr: "no such key: missing"},
+		{name: "size of number", source: "size(1)", wantErr: "no such overload: size(double)"},
+		{name: "int of non integer string", source: "int('x')", wantErr: `int: "x" isn't integer`},
+		{name: "invalid dynamic pattern", source: "method.matches(request.email + '[')", wantErr: "matches:"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := Compile(tt.source)
+			if err != nil {
+				t.Fatalf("Compile(%q): %v", tt.source, err)
+			}
+
+			_, err = expr.Eval(testVars())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Eval(%q) error = %v, want %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		wantErr string
+	}{
+		{name: "empty", source: "", wantErr: "unexpected end: expected operand"},
+		{name: "missing operand", source: "1 +", wantErr: "unexpected end: expected operand"},
+		{name: "unclosed group", source: "(1", wantErr: `unexpected end: expected ")"`},
+		{name: "unclosed list", source: "[1, 2", wantErr: `unexpected end: expected ","`},
+		{name: "missing else", source: "true ? 1", wantErr: `unexpected end: expected ":"`},
+		{name: "trailing operand", source: "1 2", wantErr: `position 2: unexpected "2"`},
+		{name: "unterminated string", source: "'abc", wantErr: "position 0: unterminated string"},
+		{name: "invalid escape", source: `'\q'`, wantErr: `position 1: invalid escape \q`},
+		{name: "invalid number", source: "1.2.3", wantErr: `position 0: invalid number "1.2.3"`},
+		{name: "unexpected character", source: "a # b", wantErr: "position 2: unexpected character '#'"},
+		{name: "field name expected", source: "subject.1", wantErr: "position 8: expected field name"},
+		{name: "unknown function", source: "upper('a')", wantErr: "unknown function upper"},
+		{name: "wrong arity", source: "size(1, 2)", wantErr: "function size takes 1 arguments, got 2"},
+		{name: "has without selection", source: "has(subject)", wantErr: "has takes field selection"},
+		{name: "invalid literal pattern", source: "method.matches('[')", wantErr: "matches: error parsing regexp"},
+		{name: "non string literal pattern", source: "method.matches(1)", wantErr: "matches takes string pattern"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(tt.source)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Compile(%q) error = %v, want %q", tt.source, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+type Effect string
+
+const (
+	// Deny policies deny requests their condition holds for
+	Deny Effect = "deny"
+	// Allow policies deny requests to methods they target, unless condition of any of them holds
+	Allow Effect = "allow"
+)
+
+// Policy is an attribute-based rule for requests to Methods
+type Policy struct {
+	Name        string
+	Description string
+	Effect      Effect
+	// Methods are patterns of full method names like /userInfo.UserInfo/*, empty Methods target every method
+	Methods   []string
+	Condition *Expression
+	// DryRun policies are evaluated and explained, but never decide
+	DryRun bool
+}
+
+// Targets reports whether the policy applies to the method
+func (p *Policy) Targets(method string) bool {
+	if len(p.Methods) == 0 {
+		return true
+	}
+	for _, pattern := range p.Methods {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Input is attributes of a request policies decide on
+type Input struct {
+	Method string
+	// Subject is attributes of the caller, see the auth interceptor for their names
+	Subject map[string]any
+	// Request is the request message, it's converted to map with fields named as in proto files
+	Request any
+	IP      string
+	Time    time.Time
+}
+
+// Result is outcome of a single policy
+type Result struct {
+	Policy string
+	Effect Effect
+	DryRun bool
+	// Holds is whether the condition is true, it's false when evaluation fails
+	Holds bool
+	Err   error
+}
+
+// Decision is outcome of policies targeting the method, Reason names policy the decision is made by
+type Decision struct {
+	Allowed bool
+	Reason  string
+	Results []Result
+}
+
+// Explain describes every policy result, for debugging
+func (d Decision) Explain() string {
+	if len(d.Results) == 0 {
+		return "no policies target the method"
+	}
+
+	parts := make([]string, 0, len(d.Results))
+	for _, result := range d.Results {
+		part := fmt.Sprintf("%s (%s", result.Policy, result.Effect)
+		if result.DryRun {
+			part += ", dry run"
+		}
+		switch {
+		case result.Err != nil:
+			part += "): error: " + result.Err.Error()
+		default:
+			part += fmt.Sprintf("): %t", result.Holds)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Set is policies loaded from files
+type Set struct {
+	policies []*Policy
+}
+
+// Len returns number of policies in the set
+func (s *Set) Len() int {
+	return len(s.policies)
+}
+
+// Evaluate decides on the request by policies targeting the method. Deny policies override allow ones,
+// and a policy failing to evaluate is taken as denying, so mistakes don't open access
+func (s *Set) Evaluate(input Input, location *time.Location) Decision {
+	var targeting []*Policy
+	for _, p := range s.policies {
+		if p.Targets(input.Method) {
+			targeting = append(targeting, p)
+		}
+	}
+	if len(targeting) == 0 {
+		return Decision{Allowed: true, Reason: "no policies target the method"}
+	}
+
+	vars, err := variables(input, location)
+	if err != nil {
+		return Decision{Reason: "request can't be converted: " + err.Error()}
+	}
+
+	decision := Decision{Allowed: true}
+	// allow policies decide only if no deny policy holds
+	var hasAllow bool
+	var allowedBy string
+	for _, p := range targeting {
+		result := Result{Policy: p.Name, Effect: p.Effect, DryRun: p.DryRun}
+		v, err := p.Condition.Eval(vars)
+		if err == nil {
+			holds, ok := v.(bool)
+			if !ok {
+				err = fmt.Errorf("condition must be bool, got %s", typeName(v))
+			}
+			result.Holds = holds
+		}
+		result.Err = err
+		decision.Results = append(decision.Results, result)
+
+		if p.DryRun {
+			continue
+		}
+		switch p.Effect {
+		case Deny:
+			if (result.Holds || result.Err != nil) && decision.Allowed {
+				decision.Allowed = false
+				decision.Reason = "denied by policy " + p.Name
+			}
+		case Allow:
+			hasAllow = true
+			if result.Holds && allowedBy == "" {
+				allowedBy = p.Name
+			}
+		}
+	}
+
+	if !decision.Allowed {
+		return decision
+	}
+	switch {
+	case hasAllow && allowedBy == "":
+		decision.Allowed = false
+		decision.Reason = "no allow policy holds"
+	case hasAllow:
+		decision.Reason = "allowed by policy " + allowedBy
+	default:
+		decision.Reason = "no deny policy holds"
+	}
+
+	return decision
+}
+
+// variables returns variables conditions are evaluated with
+func variables(input Input, location *time.Location) (map[string]any, error) {
+	request := map[string]any{}
+	if msg, ok := input.Request.(proto.Message); ok {
+		raw, err := protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true}.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(raw, &request); err != nil {
+			return nil, err
+		}
+	}
+
+	subject := input.Subject
+	if subject == nil {
+		subject = map[string]any{}
+	}
+
+	now := input.Time.In(location)
+	return map[string]any{
+		"method":  input.Method,
+		"subject": subject,
+		"request": request,
+		"ip":      input.IP,
+		"now": map[string]any{
+			"unix":    float64(now.Unix()),
+			"year":    float64(now.Year()),
+			"month":   float64(now.Month()),
+			"day":     float64(now.Day()),
+			"weekday": float64(now.Weekday()),
+			"hour":    float64(now.Hour()),
+			"minute":  float64(now.Minute()),
+		},
+	}, nil
+}
+
+// file is format of policy files
+type file struct {
+	Policies []struct {
+		Name        string   `yaml:"name"`
+		Description string   `yaml:"description"`
+		Effect      Effect   `yaml:"effect"`
+		Methods     []string `yaml:"methods"`
+		Condition   string   `yaml:"condition"`
+		DryRun      bool     `yaml:"dry_run"`
+	} `yaml:"policies"`
+}
+
+// Load reads policies from YAML files, names of policies must be unique across them:
+//
+//	policies:
+//	  - name: support-business-hours
+//	    description: support reads users only on workdays from 9 to 18
+//	    effect: deny
+//	    methods: ["/userInfo.UserInfo/*"]
+//	    condition: '"support" in subject.roles && (now.weekday in [0, 6] || now.hour < 9 || now.hour >= 18)'
+func Load(paths []string) (*Set, error) {
+	const op = "lib.policy.Load"
+
+	set := &Set{}
+	names := make(map[string]bool)
+	for _, filePath := range paths {
+		src, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		var f file
+		if err := yaml.Unmarshal(src, &f); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", op, filePath, err)
+		}
+
+		for _, raw := range f.Policies {
+			p, err := newPolicy(raw.Name, raw.Description, raw.Effect, raw.Methods, raw.Condition, raw.DryRun)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %w", op, filePath, err)
+			}
+			if names[p.Name] {
+				return nil, fmt.Errorf("%s: %s: policy %s is defined twice", op, filePath, p.Name)
+			}
+			names[p.Name] = true
+			set.policies = append(set.policies, p)
+		}
+	}
+
+	return set, nil
+}
+
+func newPolicy(name, description string, effect Effect, methods []string, condition string, dryRun bool) (*Policy, error) {
+	if name == "" {
+		return nil, fmt.Errorf("policy name is required")
+	}
+	if effect != Deny && effect != Allow {
+		return nil, fmt.Errorf("policy %s: effect must be deny or allow", name)
+	}
+	for _, pattern := range methods {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("policy %s: method %q: %w", name, pattern, err)
+		}
+	}
+
+	expr, err := Compile(condition)
+	if err != nil {
+		return nil, fmt.Errorf("policy %s: condition: %w", name, err)
+	}
+
+	return &Policy{
+		Name:        name,
+		Description: description,
+		Effect:      effect,
+		Methods:     methods,
+		Condition:   expr,
+		DryRun:      dryRun,
+	}, nil
+}
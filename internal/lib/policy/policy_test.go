@@ -0,0 +1,445 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ssov1 "github.com/dedmouze/protos/gen/go/sso"
+)
+
+// writePolicies writes policy file with the content to dir and returns its path
+func writePolicies(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(dir, name)
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write policies: %v", err)
+	}
+	return filePath
+}
+
+// loadPolicies loads set of the policy file content
+func loadPolicies(t *testing.T, content string) *Set {
+	t.Helper()
+
+	set, err := Load([]string{writePolicies(t, t.TempDir(), "policies.yaml", content)})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return set
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{
+			name:    "invalid yaml",
+			content: "policies: [",
+			wantErr: "yaml:",
+		},
+		{
+			name: "no name",
+			content: `policies:
+  - effect: deny
+    condition: "true"`,
+			wantErr: "policy name is required",
+		},
+		{
+			name: "unknown effect",
+			content: `policies:
+  - name: p
+    effect: permit
+    condition: "true"`,
+			wantErr: "policy p: effect must be deny or allow",
+		},
+		{
+			name: "bad method pattern",
+			content: `policies:
+  - name: p
+    effect: deny
+    methods: ["/userInfo.UserInfo/["]
+    condition: "true"`,
+			wantErr: `policy p: method "/userInfo.UserInfo/["`,
+		},
+		{
+			name: "bad condition",
+			content: `policies:
+  - name: p
+    effect: deny
+    condition: "subject.roles &&"`,
+			wantErr: "policy p: condition: unexpected end",
+		},
+		{
+			name: "duplicate name",
+			content: `policies:
+  - name: p
+    effect: deny
+    condition: "true"
+  - name: p
+    effect: allow
+    condition: "true"`,
+			wantErr: "policy p is defined twice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load([]string{writePolicies(t, t.TempDir(), "policies.yaml", tt.content)})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Load() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsNamesDuplicatedAcrossFiles(t *testing.T) {
+	dir := t.TempDir()
+	content := `policies:
+  - name: p
+    effect: deny
+    condition: "false"`
+
+	paths := []string{
+		writePolicies(t, dir, "a.yaml", content),
+		writePolicies(t, dir, "b.yaml", content),
+	}
+	if _, err := Load(paths); err == nil || !strings.Contains(err.Error(), "policy p is defined twice") {
+		t.Fatalf("Load() error = %v, want duplicate name", err)
+	}
+}
+
+func TestSetEvaluate(t *testing.T) {
+	support := map[string]any{"email": "support@example.com", "roles": []any{"support"}}
+	admin := map[string]any{"email": "admin@example.com", "roles": []any{"admin"}}
+
+	tests := []struct {
+		name        string
+		policies    string
+		input       Input
+		wantAllowed bool
+		wantReason  string
+	}{
+		{
+			name: "no targeting policies",
+			policies: `policies:
+  - name: deny-all
+    effect: deny
+    methods: ["/auth.Auth/*"]
+    condition: "true"`,
+			input:       Input{Method: "/userInfo.UserInfo/User"},
+			wantAllowed: true,
+			wantReason:  "no policies target the method",
+		},
+		{
+			name: "deny holds",
+			policies: `policies:
+  - name: no-support
+    effect: deny
+    methods: ["/userInfo.UserInfo/*"]
+    condition: '"support" in subject.roles'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantReason:  "denied by policy no-support",
+			wantAllowed: false,
+		},
+		{
+			name: "deny doesn't hold",
+			policies: `policies:
+  - name: no-support
+    effect: deny
+    condition: '"support" in subject.roles'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: true,
+			wantReason:  "no deny policy holds",
+		},
+		{
+			name: "deny overrides allow",
+			policies: `policies:
+  - name: allow-staff
+    effect: allow
+    condition: "size(subject.roles) > 0"
+  - name: no-support
+    effect: deny
+    condition: '"support" in subject.roles'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantAllowed: false,
+			wantReason:  "denied by policy no-support",
+		},
+		{
+			name: "first failing deny is the reason",
+			policies: `policies:
+  - name: first
+    effect: deny
+    condition: "true"
+  - name: second
+    effect: deny
+    condition: "true"`,
+			input:       Input{Method: "/userInfo.UserInfo/User"},
+			wantAllowed: false,
+			wantReason:  "denied by policy first",
+		},
+		{
+			name: "allow holds",
+			policies: `policies:
+  - name: allow-admins
+    effect: allow
+    condition: '"admin" in subject.roles'
+  - name: allow-support
+    effect: allow
+    condition: '"support" in subject.roles'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantAllowed: true,
+			wantReason:  "allowed by policy allow-support",
+		},
+		{
+			name: "no allow holds",
+			policies: `policies:
+  - name: allow-admins
+    effect: allow
+    condition: '"admin" in subject.roles'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantAllowed: false,
+			wantReason:  "no allow policy holds",
+		},
+		{
+			name: "failing deny denies",
+			policies: `policies:
+  - name: by-team
+    effect: deny
+    condition: 'subject.team == "support"'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: false,
+			wantReason:  "denied by policy by-team",
+		},
+		{
+			name: "type error in deny denies",
+			policies: `policies:
+  - name: mixed
+    effect: deny
+    condition: 'subject.email + 1 == 2'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: false,
+			wantReason:  "denied by policy mixed",
+		},
+		{
+			name: "non bool deny denies",
+			policies: `policies:
+  - name: roles
+    effect: deny
+    condition: "subject.roles"`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: false,
+			wantReason:  "denied by policy roles",
+		},
+		{
+			name: "failing allow doesn't allow",
+			policies: `policies:
+  - name: by-team
+    effect: allow
+    condition: 'subject.team == "support"'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: false,
+			wantReason:  "no allow policy holds",
+		},
+		{
+			name: "non bool allow doesn't allow",
+			policies: `policies:
+  - name: email
+    effect: allow
+    condition: "subject.email"`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: admin},
+			wantAllowed: false,
+			wantReason:  "no allow policy holds",
+		},
+		{
+			name: "dry run deny doesn't decide",
+			policies: `policies:
+  - name: no-support
+    effect: deny
+    condition: '"support" in subject.roles'
+    dry_run: true`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantAllowed: true,
+			wantReason:  "no deny policy holds",
+		},
+		{
+			name: "dry run allow doesn't decide",
+			policies: `policies:
+  - name: allow-admins
+    effect: allow
+    condition: '"admin" in subject.roles'
+    dry_run: true`,
+			input:       Input{Method: "/userInfo.UserInfo/User", Subject: support},
+			wantAllowed: true,
+			wantReason:  "no deny policy holds",
+		},
+		{
+			name: "request fields",
+			policies: `policies:
+  - name: own-app
+    effect: allow
+    methods: ["/auth.Auth/Login"]
+    condition: 'request.app_id == 1 && request.email.endsWith("@example.com")'`,
+			input:       Input{Method: "/auth.Auth/Login", Request: &ssov1.LoginRequest{Email: "user@example.com", AppId: 1}},
+			wantAllowed: true,
+			wantReason:  "allowed by policy own-app",
+		},
+		{
+			name: "unpopulated request fields",
+			policies: `policies:
+  - name: app-set
+    effect: allow
+    methods: ["/auth.Auth/Login"]
+    condition: "request.app_id != 0"`,
+			input:       Input{Method: "/auth.Auth/Login", Request: &ssov1.LoginRequest{Email: "user@example.com"}},
+			wantAllowed: false,
+			wantReason:  "no allow policy holds",
+		},
+		{
+			name: "ip",
+			policies: `policies:
+  - name: internal
+    effect: deny
+    condition: '!ip.startsWith("10.")'`,
+			input:       Input{Method: "/userInfo.UserInfo/User", IP: "192.168.0.1"},
+			wantAllowed: false,
+			wantReason:  "denied by policy internal",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := loadPolicies(t, tt.policies).Evaluate(tt.input, time.UTC)
+			if decision.Allowed != tt.wantAllowed || decision.Reason != tt.wantReason {
+				t.Fatalf("Evaluate() = %t %q, want %t %q (%s)",
+					decision.Allowed, decision.Reason, tt.wantAllowed, tt.wantReason, decision.Explain())
+			}
+		})
+	}
+}
+
+func TestDecisionExplain(t *testing.T) {
+	set := loadPolicies(t, `policies:
+  - name: allow-admins
+    effect: allow
+    condition: '"admin" in subject.roles'
+  - name: no-support
+    effect: deny
+    condition: '"support" in subject.roles'
+    dry_run: true
+  - name: by-team
+    effect: deny
+    condition: 'subject.team == "support"'
+  - name: other-service
+    effect: deny
+    methods: ["/auth.Auth/*"]
+    condition: "true"`)
+
+	decision := set.Evaluate(Input{
+		Method:  "/userInfo.UserInfo/User",
+		Subject: map[string]any{"roles": []any{"admin", "support"}},
+	}, time.UTC)
+
+	want := "allow-admins (allow): true; " +
+		"no-support (deny, dry run): true; " +
+		"by-team (deny): error: no such key: team"
+	if got := decision.Explain(); got != want {
+		t.Errorf("Explain() = %q, want %q", got, want)
+	}
+
+	if got := (Decision{Allowed: true}).Explain(); got != "no policies target the method" {
+		t.Errorf("Explain() = %q, want no policies", got)
+	}
+}
+
+func TestNowInLocation(t *testing.T) {
+	set := loadPolicies(t, `policies:
+  - name: business-hours
+    effect: deny
+    condition: "now.weekday in [0, 6] || now.hour < 9 || now.hour >= 18"`)
+
+	moscow := time.FixedZone("MSK", 3*60*60)
+	losAngeles := time.FixedZone("PST", -8*60*60)
+
+	tests := []struct {
+		name        string
+		time        time.Time
+		location    *time.Location
+		wantAllowed bool
+	}{
+		// friday 07:30 UTC is 10:30 in Moscow and 23:30 of thursday in Los Angeles
+		{name: "utc", time: time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC), location: time.UTC, wantAllowed: false},
+		{name: "east of utc", time: time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC), location: moscow, wantAllowed: true},
+		{name: "west of utc", time: time.Date(2024, 3, 1, 7, 30, 0, 0, time.UTC), location: losAngeles, wantAllowed: false},
+		// friday 22:30 UTC is 01:30 of saturday in Moscow and 14:30 of friday in Los Angeles
+		{name: "day changes east", time: time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC), location: moscow, wantAllowed: false},
+		{name: "day stays west", time: time.Date(2024, 3, 1, 22, 30, 0, 0, time.UTC), location: losAngeles, wantAllowed: true},
+		// location of input time itself doesn't matter
+		{name: "input in other zone", time: time.Date(2024, 3, 1, 10, 30, 0, 0, moscow), location: time.UTC, wantAllowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decision := set.Evaluate(Input{Method: "/userInfo.UserInfo/User", Time: tt.time}, tt.location)
+			if decision.Allowed != tt.wantAllowed {
+				t.Fatalf("Evaluate() allowed = %t, want %t (%s)", decision.Allowed, tt.wantAllowed, decision.Explain())
+			}
+		})
+	}
+}
+
+func TestNowVariables(t *testing.T) {
+	location := time.FixedZone("MSK", 3*60*60)
+	input := Input{Time: time.Date(2024, 12, 31, 22, 5, 0, 0, time.UTC)}
+
+	vars, err := variables(input, location)
+	if err != nil {
+		t.Fatalf("variables: %v", err)
+	}
+
+	now := vars["now"].(map[string]any)
+	want := map[string]float64{
+		"unix":    float64(input.Time.Unix()),
+		"year":    2025,
+		"month":   1,
+		"day":     1,
+		"weekday": 3,
+		"hour":    1,
+		"minute":  5,
+	}
+	for key, value := range want {
+		if now[key] != value {
+			t.Errorf("now.%s = %v, want %v", key, now[key], value)
+		}
+	}
+}
+
+func TestPolicyTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		methods []string
+		method  string
+		want    bool
+	}{
+		{name: "no methods", method: "/auth.Auth/Login", want: true},
+		{name: "exact", methods: []string{"/auth.Auth/Login"}, method: "/auth.Auth/Login", want: true},
+		{name: "service wildcard", methods: []string{"/userInfo.UserInfo/*"}, method: "/userInfo.UserInfo/User", want: true},
+		{name: "other service", methods: []string{"/userInfo.UserInfo/*"}, method: "/auth.Auth/Login", want: false},
+		{name: "any of patterns", methods: []string{"/auth.Auth/Login", "/auth.Auth/Register"}, method: "/auth.Auth/Register", want: true},
+		{name: "wildcard doesn't cross slash", methods: []string{"/*"}, method: "/auth.Auth/Login", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Policy{Methods: tt.methods}
+			if got := p.Targets(tt.method); got != tt.want {
+				t.Errorf("Targets(%q) = %t, want %t", tt.method, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+package rebac
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Load reads namespace config from file, see Parse for its syntax
+func Load(path string) (*Schema, error) {
+	const op = "lib.rebac.Load"
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	schema, err := Parse(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return schema, nil
+}
+
+// Parse parses namespace config. It defines namespaces with their relations, a relation without rewrite
+// is held by subjects of its tuples only. Rewrite combines usersets by union |, intersection & or
+// exclusion -, different operators are mixed only with parentheses:
+//
+//	namespace user {}
+//
+//	namespace document {
+//	    relation parent
+//	    relation owner
+//	    relation banned
+//	    // editor is held by subjects of editor tuples and by owners
+//	    relation editor = this | owner
+//	    // viewer is also held by viewers of the parent, unless banned
+//	    relation viewer = (this | editor | parent->viewer) - banned
+//	    // publisher must be both editor and viewer of the parent
+//	    relation publisher = editor & parent->viewer
+//	}
+//
+// Relations referenced in the same namespace must be defined, and relation to the left of -> must be direct
+func Parse(src string) (*Schema, error) {
+	const op = "lib.rebac.Parse"
+
+	p := &parser{tokens: tokenize(src)}
+	schema := &Schema{namespaces: make(map[string]*Namespace)}
+
+	for !p.done() {
+		ns, err := p.namespace()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		if _, ok := schema.namespaces[ns.Name]; ok {
+			return nil, fmt.Errorf("%s: namespace %s is defined twice", op, ns.Name)
+		}
+		schema.namespaces[ns.Name] = ns
+	}
+
+	for _, ns := range schema.namespaces {
+		if err := validateNamespace(ns); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return schema, nil
+}
+
+type token struct {
+	text string
+	line int
+}
+
+// tokenize splits source into names and punctuation, skipping spaces and // comments
+func tokenize(src string) []token {
+	var tokens []token
+	for i, line := range strings.Split(src, "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		for rest := line; rest != ""; {
+			r := rune(rest[0])
+			switch {
+			case unicode.IsSpace(r):
+				rest = rest[1:]
+			case strings.HasPrefix(rest, "->"):
+				tokens = append(tokens, token{text: "->", line: i + 1})
+				rest = rest[2:]
+			case isNameChar(r):
+				end := strings.IndexFunc(rest, func(r rune) bool { return !isNameChar(r) })
+				if end < 0 {
+					end = len(rest)
+				}
+				tokens = append(tokens, token{text: rest[:end], line: i + 1})
+				rest = rest[end:]
+			default:
+				tokens = append(tokens, token{text: rest[:1], line: i + 1})
+				rest = rest[1:]
+			}
+		}
+	}
+	return tokens
+}
+
+func isNameChar(r rune) bool {
+	return r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+}
+
+// IsName reports whether s can name namespace or relation
+func IsName(s string) bool {
+	return s != "" && strings.IndexFunc(s, func(r rune) bool { return !isNameChar(r) }) < 0
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *parser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos].text
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	if p.done() {
+		return fmt.Errorf("unexpected end: "+format, args...)
+	}
+	return fmt.Errorf("line %d: "+format, append([]any{p.tokens[p.pos].line}, args...)...)
+}
+
+func (p *parser) expect(text string) error {
+	if p.peek() != text {
+		return p.errorf("expected %q, got %q", text, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) name() (string, error) {
+	name := p.peek()
+	if !IsName(name) || name == "this" {
+		return "", p.errorf("expected name, got %q", name)
+	}
+	p.pos++
+	return name, nil
+}
+
+// namespace parses: namespace name { relation* }
+func (p *parser) namespace() (*Namespace, error) {
+	if err := p.expect("namespace"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	ns := &Namespace{Name: name, Relations: make(map[string]*Relation)}
+	for p.peek() != "}" {
+		r, err := p.relation()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ns.Relations[r.Name]; ok {
+			return nil, fmt.Errorf("relation %s is defined twice in namespace %s", r.Name, ns.Name)
+		}
+		ns.Relations[r.Name] = r
+	}
+	p.pos++
+
+	return ns, nil
+}
+
+// relation parses: relation name [= rewrite]
+func (p *parser) relation() (*Relation, error) {
+	if err := p.expect("relation"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Relation{Name: name}
+	if p.peek() != "=" {
+		r.Rewrite = &Rewrite{Userset: Userset{Kind: This}}
+		return r, nil
+	}
+	p.pos++
+
+	r.Rewrite, err = p.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+var operations = map[string]Operation{
+	"|": Union,
+	"&": Intersection,
+	"-": Exclusion,
+}
+
+// rewrite parses: term (operator term)*, where operator is the same |, & or - throughout
+func (p *parser) rewrite() (*Rewrite, error) {
+	first, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+
+	operator := p.peek()
+	operation, ok := operations[operator]
+	if !ok {
+		return first, nil
+	}
+
+	r := &Rewrite{Operation: operation, Children: []*Rewrite{first}}
+	for {
+		next, ok := operations[p.peek()]
+		if !ok {
+			return r, nil
+		}
+		if next != operation {
+			return nil, p.errorf("%q can't follow %q without parentheses", p.peek(), operator)
+		}
+		p.pos++
+
+		child, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		r.Children = append(r.Children, child)
+	}
+}
+
+// term parses: userset | ( rewrite )
+func (p *parser) term() (*Rewrite, error) {
+	if p.peek() != "(" {
+		userset, err := p.userset()
+		if err != nil {
+			return nil, err
+		}
+		return &Rewrite{Userset: userset}, nil
+	}
+	p.pos++
+
+	r, err := p.rewrite()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// userset parses: this | relation | tupleset->relation
+func (p *parser) userset() (Userset, error) {
+	if p.peek() == "this" {
+		p.pos++
+		return Userset{Kind: This}, nil
+	}
+
+	name, err := p.name()
+	if err != nil {
+		return Userset{}, err
+	}
+	if p.peek() != "->" {
+		return Userset{Kind: Computed, Relation: name}, nil
+	}
+	p.pos++
+
+	relation, err := p.name()
+	if err != nil {
+		return Userset{}, err
+	}
+	return Userset{Kind: TupleToUserset, Relation: relation, Tupleset: name}, nil
+}
+
+// validateNamespace checks relations referenced in the namespace are defined. Relation of tuple-to-userset
+// belongs to namespaces of subjects, which aren't known until tuples are written, so it isn't checked
+func validateNamespace(ns *Namespace) error {
+	for _, r := range ns.Relations {
+		for _, userset := range r.Rewrite.Usersets() {
+			switch userset.Kind {
+			case Computed:
+				if _, ok := ns.Relations[userset.Relation]; !ok {
+					return fmt.Errorf("relation %s of namespace %s refers to undefined relation %s", r.Name, ns.Name, userset.Relation)
+				}
+			case TupleToUserset:
+				tupleset, ok := ns.Relations[userset.Tupleset]
+				if !ok {
+					return fmt.Errorf("relation %s of namespace %s refers to undefined relation %s", r.Name, ns.Name, userset.Tupleset)
+				}
+				if !tupleset.Direct() {
+					return fmt.Errorf("relation %s of namespace %s follows computed relation %s", r.Name, ns.Name, userset.Tupleset)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+package rebac
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"sso/internal/domain/models"
+)
+
+func direct() *Rewrite {
+	return &Rewrite{Userset: Userset{Kind: This}}
+}
+
+func computed(relation string) *Rewrite {
+	return &Rewrite{Userset: Userset{Kind: Computed, Relation: relation}}
+}
+
+func tupleToUserset(tupleset, relation string) *Rewrite {
+	return &Rewrite{Userset: Userset{Kind: TupleToUserset, Tupleset: tupleset, Relation: relation}}
+}
+
+func combined(operation Operation, children ...*Rewrite) *Rewrite {
+	return &Rewrite{Operation: operation, Children: children}
+}
+
+func TestParseRewrite(t *testing.T) {
+	tests := []struct {
+		name     string
+		relation string
+		want     *Rewrite
+	}{
+		{name: "no rewrite", relation: "relation viewer", want: direct()},
+		{name: "this", relation: "relation viewer = this", want: direct()},
+		{name: "computed", relation: "relation viewer = editor", want: computed("editor")},
+		{name: "tuple to userset", relation: "relation viewer = parent->viewer", want: tupleToUserset("parent", "viewer")},
+		{
+			name:     "union",
+			relation: "relation viewer = this | editor | parent->viewer",
+			want:     combined(Union, direct(), computed("editor"), tupleToUserset("parent", "viewer")),
+		},
+		{
+			name:     "intersection",
+			relation: "relation viewer = editor & parent->viewer",
+			want:     combined(Intersection, computed("editor"), tupleToUserset("parent", "viewer")),
+		},
+		{
+			name:     "exclusion",
+			relation: "relation viewer = this - banned - editor",
+			want:     combined(Exclusion, direct(), computed("banned"), computed("editor")),
+		},
+		{
+			name:     "exclusion from group",
+			relation: "relation viewer = (this | editor) - banned",
+			want:     combined(Exclusion, combined(Union, direct(), computed("editor")), computed("banned")),
+		},
+		{
+			name:     "group in union",
+			relation: "relation viewer = this | (editor & parent->viewer)",
+			want:     combined(Union, direct(), combined(Intersection, computed("editor"), tupleToUserset("parent", "viewer"))),
+		},
+		{
+			name:     "redundant parentheses",
+			relation: "relation viewer = ((editor))",
+			want:     computed("editor"),
+		},
+		{
+			name:     "spaces around arrow",
+			relation: "relation viewer = parent -> viewer",
+			want:     tupleToUserset("parent", "viewer"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Parse(`namespace document {
+    relation parent
+    relation editor
+    relation banned
+    ` + tt.relation + `
+}`)
+			if err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+
+			r, ok := schema.Relation("document", "viewer")
+			if !ok {
+				t.Fatal("relation viewer isn't defined")
+			}
+			if !reflect.DeepEqual(r.Rewrite, tt.want) {
+				t.Errorf("rewrite = %s, want %s", formatRewrite(r.Rewrite), formatRewrite(tt.want))
+			}
+		})
+	}
+}
+
+// formatRewrite returns the rewrite in the config syntax with every combination in parentheses
+func formatRewrite(r *Rewrite) string {
+	if len(r.Children) == 0 {
+		switch r.Userset.Kind {
+		case Computed:
+			return r.Userset.Relation
+		case TupleToUserset:
+			return r.Userset.Tupleset + "->" + r.Userset.Relation
+		default:
+			return "this"
+		}
+	}
+
+	operator := map[Operation]string{Union: " | ", Intersection: " & ", Exclusion: " - "}[r.Operation]
+	children := make([]string, 0, len(r.Children))
+	for _, child := range r.Children {
+		children = append(children, formatRewrite(child))
+	}
+	return "(" + strings.Join(children, operator) + ")"
+}
+
+func TestParse(t *testing.T) {
+	schema, err := Parse(`
+// users and groups
+namespace user {}
+
+namespace group {
+    relation member // direct members
+}
+
+namespace document {
+    relation parent
+    relation owner
+    relation editor = this | owner
+    relation viewer = this | editor | parent->viewer
+    relation publisher = editor & owner
+}`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got := schema.Namespaces(); got != 3 {
+		t.Errorf("Namespaces() = %d, want 3", got)
+	}
+
+	tests := []struct {
+		namespace  string
+		relation   string
+		wantDirect bool
+	}{
+		{namespace: "group", relation: "member", wantDirect: true},
+		{namespace: "document", relation: "editor", wantDirect: true},
+		{namespace: "document", relation: "viewer", wantDirect: true},
+		{namespace: "document", relation: "publisher", wantDirect: false},
+	}
+	for _, tt := range tests {
+		r, ok := schema.Relation(tt.namespace, tt.relation)
+		if !ok {
+			t.Errorf("relation %s of %s isn't defined", tt.relation, tt.namespace)
+			continue
+		}
+		if r.Direct() != tt.wantDirect {
+			t.Errorf("%s#%s Direct() = %t, want %t", tt.namespace, tt.relation, r.Direct(), tt.wantDirect)
+		}
+	}
+
+	if _, ok := schema.Relation("user", "member"); ok {
+		t.Error("undefined relation is found")
+	}
+	if _, ok := schema.Relation("folder", "viewer"); ok {
+		t.Error("relation of undefined namespace is found")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name:    "not a namespace",
+			src:     "relation viewer",
+			wantErr: `line 1: expected "namespace", got "relation"`,
+		},
+		{
+			name:    "unclosed namespace",
+			src:     "namespace document {\n    relation viewer",
+			wantErr: `unexpected end: expected "relation", got ""`,
+		},
+		{
+			name:    "invalid namespace name",
+			src:     "namespace this {}",
+			wantErr: `line 1: expected name, got "this"`,
+		},
+		{
+			name:    "namespace defined twice",
+			src:     "namespace user {}\nnamespace user {}",
+			wantErr: "namespace user is defined twice",
+		},
+		{
+			name:    "relation defined twice",
+			src:     "namespace document {\n    relation viewer\n    relation viewer\n}",
+			wantErr: "relation viewer is defined twice in namespace document",
+		},
+		{
+			name:    "missing userset",
+			src:     "namespace document {\n    relation viewer = this |\n}",
+			wantErr: `line 3: expected name, got "}"`,
+		},
+		{
+			name:    "missing relation of tupleset",
+			src:     "namespace document {\n    relation parent\n    relation viewer = parent->\n}",
+			wantErr: `line 4: expected name, got "}"`,
+		},
+		{
+			name:    "mixed operators",
+			src:     "namespace document {\n    relation editor\n    relation banned\n    relation viewer = this | editor - banned\n}",
+			wantErr: `line 4: "-" can't follow "|" without parentheses`,
+		},
+		{
+			name:    "unclosed parenthesis",
+			src:     "namespace document {\n    relation editor\n    relation viewer = (this | editor\n}",
+			wantErr: `line 4: expected ")", got "}"`,
+		},
+		{
+			name:    "undefined computed relation",
+			src:     "namespace document {\n    relation viewer = this | editor\n}",
+			wantErr: "relation viewer of namespace document refers to undefined relation editor",
+		},
+		{
+			name:    "undefined relation in group",
+			src:     "namespace document {\n    relation viewer = this - (banned & editor)\n    relation editor\n}",
+			wantErr: "relation viewer of namespace document refers to undefined relation banned",
+		},
+		{
+			name:    "undefined tupleset",
+			src:     "namespace document {\n    relation viewer = parent->viewer\n}",
+			wantErr: "relation viewer of namespace document refers to undefined relation parent",
+		},
+		{
+			name:    "computed tupleset",
+			src:     "namespace document {\n    relation owner\n    relation parent = owner\n    relation viewer = parent->viewer\n}",
+			wantErr: "relation viewer of namespace document follows computed relation parent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.src)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Parse() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTuple(t *testing.T) {
+	schema, err := Parse(`
+namespace user {}
+namespace group {
+    relation member
+}
+namespace document {
+    relation owner
+    relation banned
+    relation editor = this | owner
+    relation viewer = (this | editor) - banned
+    relation publisher = editor & owner
+}`)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		tuple   string
+		wantErr string
+	}{
+		{name: "relation with exclusion", tuple: "document:readme#viewer@user:1"},
+		{name: "subject set", tuple: "document:readme#viewer@group:eng#member"},
+		{name: "undefined namespace", tuple: "folder:docs#viewer@user:1", wantErr: "namespace folder isn't defined"},
+		{name: "undefined relation", tuple: "document:readme#reader@user:1", wantErr: "relation reader isn't defined in namespace document"},
+		{name: "computed relation", tuple: "document:readme#publisher@user:1", wantErr: "relation publisher of namespace document is computed"},
+		{name: "undefined subject namespace", tuple: "document:readme#viewer@team:eng", wantErr: "namespace team isn't defined"},
+		{name: "undefined subject relation", tuple: "document:readme#viewer@group:eng#admin", wantErr: "relation admin isn't defined in namespace group"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object, subject, _ := strings.Cut(tt.tuple, "@")
+			object, relation, _ := strings.Cut(object, "#")
+			namespace, id, err := ParseObject(object)
+			if err != nil {
+				t.Fatalf("ParseObject: %v", err)
+			}
+			s, err := ParseSubject(subject)
+			if err != nil {
+				t.Fatalf("ParseSubject: %v", err)
+			}
+
+			err = schema.ValidateTuple(models.RelationTuple{Namespace: namespace, ObjectID: id, Relation: relation, Subject: s})
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("ValidateTuple() error = %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("ValidateTuple() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseSubject(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    models.Subject
+		wantErr bool
+	}{
+		{s: "user:1", want: models.Subject{Namespace: "user", ID: "1"}},
+		{s: "group:eng#member", want: models.Subject{Namespace: "group", ID: "eng", Relation: "member"}},
+		{s: "document:docs/readme.md", want: models.Subject{Namespace: "document", ID: "docs/readme.md"}},
+		{s: "user", wantErr: true},
+		{s: "user:", wantErr: true},
+		{s: ":1", wantErr: true},
+		{s: "us-er:1", wantErr: true},
+		{s: "user:a b", wantErr: true},
+		{s: "group:eng#", wantErr: true},
+		{s: "group:eng#mem-ber", wantErr: true},
+		{s: "group:eng@x#member", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := ParseSubject(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSubject() error = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSubject() = %+v, want %+v", got, tt.want)
+			}
+			if formatted := FormatSubject(got); formatted != tt.s {
+				t.Errorf("FormatSubject() = %q, want %q", formatted, tt.s)
+			}
+		})
+	}
+}
+
+func TestToken(t *testing.T) {
+	for _, revision := range []int64{0, 1, 127, 128, 1 << 40, 1<<63 - 1} {
+		got, err := DecodeToken(EncodeToken(revision))
+		if err != nil {
+			t.Fatalf("DecodeToken(EncodeToken(%d)): %v", revision, err)
+		}
+		if got != revision {
+			t.Errorf("DecodeToken(EncodeToken(%d)) = %d", revision, got)
+		}
+	}
+
+	for _, token := range []string{"", "AQ", "!!", "AgE", "AQEB", "Af__________8B"} {
+		if _, err := DecodeToken(token); err != ErrInvalidToken {
+			t.Errorf("DecodeToken(%q) error = %v, want %v", token, err, ErrInvalidToken)
+		}
+	}
+}
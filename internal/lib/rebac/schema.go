@@ -0,0 +1,135 @@
+package rebac
+
+import (
+	"fmt"
+
+	"sso/internal/domain/models"
+)
+
+// UsersetKind is kind of userset a relation is rewritten to
+type UsersetKind int
+
+const (
+	// This is subjects of tuples written with the relation itself
+	This UsersetKind = iota
+	// Computed is subjects holding Relation of the same object
+	Computed
+	// TupleToUserset is subjects holding Relation of objects the object is related to by Tupleset
+	TupleToUserset
+)
+
+// Userset is a set of subjects holding a relation, as defined in namespace config
+type Userset struct {
+	Kind     UsersetKind
+	Relation string
+	Tupleset string
+}
+
+// Operation combines sets of subjects of a rewrite
+type Operation int
+
+const (
+	// Union is held by subjects of any child
+	Union Operation = iota
+	// Intersection is held by subjects of every child
+	Intersection
+	// Exclusion is held by subjects of the first child who are in none of the others
+	Exclusion
+)
+
+// Rewrite is a userset, or, with Children, sets of subjects they define combined by Operation
+type Rewrite struct {
+	Userset   Userset
+	Operation Operation
+	Children  []*Rewrite
+}
+
+// Usersets returns usersets the rewrite is built of
+func (r *Rewrite) Usersets() []Userset {
+	if len(r.Children) == 0 {
+		return []Userset{r.Userset}
+	}
+
+	var usersets []Userset
+	for _, child := range r.Children {
+		usersets = append(usersets, child.Usersets()...)
+	}
+	return usersets
+}
+
+// Relation is a relation of namespace, it's held by subjects of Rewrite
+type Relation struct {
+	Name    string
+	Rewrite *Rewrite
+}
+
+// Direct reports whether tuples can be written with the relation
+func (r *Relation) Direct() bool {
+	for _, userset := range r.Rewrite.Usersets() {
+		if userset.Kind == This {
+			return true
+		}
+	}
+	return false
+}
+
+// Namespace is a type of objects, like document or group, and relations they have
+type Namespace struct {
+	Name      string
+	Relations map[string]*Relation
+}
+
+// Schema is parsed namespace config, tuples and checks must fit it
+type Schema struct {
+	namespaces map[string]*Namespace
+}
+
+// Relation returns relation of the namespace, if both are defined
+func (s *Schema) Relation(namespace, relation string) (*Relation, bool) {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return nil, false
+	}
+	r, ok := ns.Relations[relation]
+	return r, ok
+}
+
+// Namespaces returns number of defined namespaces
+func (s *Schema) Namespaces() int {
+	return len(s.namespaces)
+}
+
+// ValidateRelation checks namespace and its relation are defined
+func (s *Schema) ValidateRelation(namespace, relation string) error {
+	ns, ok := s.namespaces[namespace]
+	if !ok {
+		return fmt.Errorf("namespace %s isn't defined", namespace)
+	}
+	if _, ok := ns.Relations[relation]; !ok {
+		return fmt.Errorf("relation %s isn't defined in namespace %s", relation, namespace)
+	}
+	return nil
+}
+
+// ValidateSubject checks namespace of the subject and its relation, if set, are defined
+func (s *Schema) ValidateSubject(subject models.Subject) error {
+	if subject.Relation == "" {
+		if _, ok := s.namespaces[subject.Namespace]; !ok {
+			return fmt.Errorf("namespace %s isn't defined", subject.Namespace)
+		}
+		return nil
+	}
+	return s.ValidateRelation(subject.Namespace, subject.Relation)
+}
+
+// ValidateTuple checks the tuple can be written, that is its relation is defined and direct,
+// and its subject is defined
+func (s *Schema) ValidateTuple(tuple models.RelationTuple) error {
+	if err := s.ValidateRelation(tuple.Namespace, tuple.Relation); err != nil {
+		return err
+	}
+	if r, _ := s.Relation(tuple.Namespace, tuple.Relation); !r.Direct() {
+		return fmt.Errorf("relation %s of namespace %s is computed, tuples can't be written with it", tuple.Relation, tuple.Namespace)
+	}
+	return s.ValidateSubject(tuple.Subject)
+}
@@ -0,0 +1,32 @@
+package rebac
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// tokenVersion prefixes encoded tokens, so their format can be changed later
+const tokenVersion = 1
+
+var ErrInvalidToken = errors.New("invalid consistency token")
+
+// EncodeToken returns consistency token of the revision of tuples, clients treat it as opaque
+func EncodeToken(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString(binary.AppendUvarint([]byte{tokenVersion}, uint64(revision)))
+}
+
+// DecodeToken returns revision of the consistency token
+func DecodeToken(token string) (int64, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) < 2 || raw[0] != tokenVersion {
+		return 0, ErrInvalidToken
+	}
+
+	revision, n := binary.Uvarint(raw[1:])
+	if n != len(raw)-1 || revision > 1<<63-1 {
+		return 0, ErrInvalidToken
+	}
+
+	return int64(revision), nil
+}
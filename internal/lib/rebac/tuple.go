@@ -0,0 +1,54 @@
+package rebac
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"sso/internal/domain/models"
+)
+
+// ParseObject parses object in form namespace:id, id may contain anything but spaces, # and @
+func ParseObject(s string) (namespace string, id string, err error) {
+	namespace, id, ok := strings.Cut(s, ":")
+	if !ok || !IsName(namespace) {
+		return "", "", fmt.Errorf("object %q must be namespace:id", s)
+	}
+	if id == "" || strings.ContainsAny(id, "#@") || strings.ContainsFunc(id, unicode.IsSpace) {
+		return "", "", fmt.Errorf("object %q has invalid id", s)
+	}
+	return namespace, id, nil
+}
+
+// ParseSubject parses subject in form namespace:id or namespace:id#relation
+func ParseSubject(s string) (models.Subject, error) {
+	object, relation, hasRelation := strings.Cut(s, "#")
+	if hasRelation && !IsName(relation) {
+		return models.Subject{}, fmt.Errorf("subject %q has invalid relation", s)
+	}
+
+	namespace, id, err := ParseObject(object)
+	if err != nil {
+		return models.Subject{}, fmt.Errorf("subject %q must be namespace:id or namespace:id#relation", s)
+	}
+
+	return models.Subject{Namespace: namespace, ID: id, Relation: relation}, nil
+}
+
+// FormatObject is the reverse of ParseObject
+func FormatObject(namespace, id string) string {
+	return namespace + ":" + id
+}
+
+// FormatSubject is the reverse of ParseSubject
+func FormatSubject(subject models.Subject) string {
+	if subject.Relation == "" {
+		return FormatObject(subject.Namespace, subject.ID)
+	}
+	return FormatObject(subject.Namespace, subject.ID) + "#" + subject.Relation
+}
+
+// FormatTuple returns tuple in form namespace:id#relation@subject
+func FormatTuple(tuple models.RelationTuple) string {
+	return FormatObject(tuple.Namespace, tuple.ObjectID) + "#" + tuple.Relation + "@" + FormatSubject(tuple.Subject)
+}
@@ -1,9 +1,13 @@
 package secret
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
+	"strings"
 )
 
 func GenerateSecret() (string, error) {
@@ -16,3 +20,78 @@ func GenerateSecret() (string, error) {
 
 	return hex.EncodeToString(randomBytes), nil
 }
+
+// Hash returns hex encoded SHA-256 of the secret, so it can be stored instead of the secret itself
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// SaltedHash returns hex encoded random salt and SHA-256 of the salt with the secret, joined by a dot.
+// Unlike Hash, equal secrets get different hashes, so hashes of many of them can't be attacked at once
+func SaltedHash(secret string) (string, error) {
+	const op = "lib.secret.SaltedHash"
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return saltedHash(salt, secret), nil
+}
+
+// VerifySaltedHash reports whether hash returned by SaltedHash is of the secret
+func VerifySaltedHash(hash, secret string) bool {
+	encodedSalt, _, ok := strings.Cut(hash, ".")
+	if !ok {
+		return false
+	}
+
+	salt, err := hex.DecodeString(encodedSalt)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal([]byte(saltedHash(salt, secret)), []byte(hash))
+}
+
+func saltedHash(salt []byte, secret string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+
+	return hex.EncodeToString(salt) + "." + hex.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns payload with its HMAC-SHA256 signature, both base64url encoded and joined by a dot
+func Sign(key, payload []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify returns payload of the value returned by Sign, reports false if signature doesn't match
+func Verify(key []byte, signed string) ([]byte, bool) {
+	encodedPayload, encodedSignature, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return nil, false
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, false
+	}
+
+	return payload, true
+}
@@ -5,28 +5,56 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
+	"sync"
 	"time"
 
 	"sso/internal/domain/models"
 	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/oauth"
 	"sso/internal/lib/secret"
 	"sso/internal/service"
 	"sso/internal/service/userInfo"
 	"sso/internal/storage"
 
-	"golang.org/x/crypto/bcrypt"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 type Auth struct {
-	log          *slog.Logger
-	userSaver    UserSaver
-	userProvider userInfo.UserProvider
-	userChanger  UserChanger
-	appProvider  AppProvider
-	appSaver     AppSaver
-	tokenTTL     time.Duration
-	userKey      string
+	log             *slog.Logger
+	userSaver       UserSaver
+	userProvider    userInfo.UserProvider
+	userChanger     UserChanger
+	appProvider     AppProvider
+	appSaver        AppSaver
+	tokenSaver      TokenSaver
+	tokenProvider   TokenProvider
+	mfaSaver        MFASaver
+	mfaProvider     MFAProvider
+	passkeySaver    PasskeySaver
+	passkeyProvider PasskeyProvider
+	loginLimiter    LoginLimiter
+	roleProvider    RoleProvider
+	tokenTTL        time.Duration
+	refreshTTL      time.Duration
+	keys            *jwt.KeyRing
+	tokenParams     jwt.Params
+	mfaIssuer       string
+	webAuthn        *webauthn.WebAuthn
+	mailer          Mailer
+	verification    VerificationParams
+	passwordReset   PasswordResetParams
+	passwordHasher  PasswordHasher
+	lockout         LockoutParams
+	passwordPolicy  PasswordPolicy
+	// appScopes are scopes apps may register for themselves
+	appScopes []string
+	// dummyHash is verified when user isn't found, so unknown email takes as long to reject as wrong password
+	dummyHash []byte
+	dummyOnce sync.Once
 }
 
 type UserSaver interface {
@@ -43,19 +71,135 @@ type UserChanger interface {
 		email string,
 		visitTime time.Time,
 	) error
+	VerifyEmail(
+		ctx context.Context,
+		userID int64,
+		email string,
+	) error
+	ResetPassword(
+		ctx context.Context,
+		resetID int64,
+		userID int64,
+		passHash []byte,
+		revokedAt time.Time,
+		expiresAt time.Time,
+	) error
+	UpdatePassHash(
+		ctx context.Context,
+		userID int64,
+		oldHash []byte,
+		newHash []byte,
+	) error
+	ChangePassword(
+		ctx context.Context,
+		userID int64,
+		passHash []byte,
+		revokedAt time.Time,
+		expiresAt time.Time,
+	) error
+	ChangeEmail(
+		ctx context.Context,
+		userID int64,
+		oldEmail string,
+		newEmail string,
+		revokedAt time.Time,
+		expiresAt time.Time,
+	) error
+}
+
+// LoginLimiter counts failed logins by key and blocks further logins
+type LoginLimiter interface {
+	LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error)
+	AddLoginFailure(ctx context.Context, key string, expiresAt time.Time) (failures int, err error)
+	BlockLogin(ctx context.Context, key string, until time.Time) error
+	DeleteLoginAttempts(ctx context.Context, key string) error
+}
+
+// RoleProvider returns roles the user has in the app, tokens issued for the app carry them
+type RoleProvider interface {
+	AppRoles(ctx context.Context, userID int64, appID int) (models.AppRoles, error)
+}
+
+// PasswordHasher hashes passwords and verifies them against hashes, including ones of earlier algorithms
+type PasswordHasher interface {
+	Hash(password string) ([]byte, error)
+	// Verify also reports whether hash should be replaced with a new one
+	Verify(hash []byte, password string) (ok bool, rehash bool, err error)
+}
+
+// PasswordPolicy returns descriptions of rules new password fails, email of the user is considered too
+type PasswordPolicy interface {
+	Check(password string, email string) []string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg mail.Message) error
 }
 
 type AppSaver interface {
 	SaveApp(
 		ctx context.Context,
-		name string,
-		apiKey string,
-	) error
+		app models.App,
+		redirectURIs []string,
+	) (appID int, err error)
 }
 
 type AppProvider interface {
 	AppByID(ctx context.Context, appID int) (models.App, error)
 	AppByKey(ctx context.Context, apiKey string) (models.App, error)
+	AppRedirectURIs(ctx context.Context, appID int) ([]string, error)
+}
+
+type TokenSaver interface {
+	SaveRefreshToken(ctx context.Context, token models.RefreshToken) error
+	UseRefreshToken(ctx context.Context, tokenID int64) error
+	RevokeRefreshFamily(ctx context.Context, family string) error
+	RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error
+	SaveAuthCode(ctx context.Context, code models.AuthCode) error
+	UseAuthCode(ctx context.Context, codeID int64) error
+	SaveDeviceCode(ctx context.Context, code models.DeviceCode) error
+	SetDeviceCodeStatus(ctx context.Context, codeID int64, status string, userID int64, approvedAt time.Time) error
+	PollDeviceCode(ctx context.Context, codeID int64, polledAt time.Time, interval time.Duration) error
+	DeleteDeviceCode(ctx context.Context, codeID int64) error
+	SaveTokenExchange(ctx context.Context, exchange models.TokenExchange) error
+	SavePasswordReset(ctx context.Context, reset models.PasswordReset) error
+}
+
+type TokenProvider interface {
+	RefreshToken(ctx context.Context, hash string) (models.RefreshToken, error)
+	IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error)
+	AuthCode(ctx context.Context, hash string) (models.AuthCode, error)
+	DeviceCode(ctx context.Context, hash string) (models.DeviceCode, error)
+	DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error)
+	PasswordReset(ctx context.Context, hash string) (models.PasswordReset, error)
+}
+
+type MFASaver interface {
+	SaveMFASecret(ctx context.Context, mfa models.MFA) error
+	EnableMFA(ctx context.Context, userID int64, step int64, recoveryHashes []string) error
+	UseMFAStep(ctx context.Context, userID int64, step int64) error
+	UseRecoveryCode(ctx context.Context, codeID int64) error
+	SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error
+	AttemptMFAChallenge(ctx context.Context, challengeID int64) error
+	DeleteMFAChallenge(ctx context.Context, challengeID int64) error
+}
+
+type MFAProvider interface {
+	MFA(ctx context.Context, userID int64) (models.MFA, error)
+	MFAChallenge(ctx context.Context, hash string) (models.MFAChallenge, error)
+	RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error)
+}
+
+type PasskeySaver interface {
+	SavePasskey(ctx context.Context, passkey models.Passkey) error
+	UsePasskey(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, usedAt time.Time) error
+	SavePasskeySession(ctx context.Context, session models.PasskeySession) error
+	DeletePasskeySession(ctx context.Context, sessionID int64) error
+}
+
+type PasskeyProvider interface {
+	Passkeys(ctx context.Context, userID int64) ([]models.Passkey, error)
+	PasskeySession(ctx context.Context, hash string) (models.PasskeySession, error)
 }
 
 // New returns a new instance of the Auth service
@@ -66,88 +210,527 @@ func New(
 	userChanger UserChanger,
 	appSaver AppSaver,
 	appProvider AppProvider,
+	tokenSaver TokenSaver,
+	tokenProvider TokenProvider,
+	mfaSaver MFASaver,
+	mfaProvider MFAProvider,
+	passkeySaver PasskeySaver,
+	passkeyProvider PasskeyProvider,
+	loginLimiter LoginLimiter,
+	roleProvider RoleProvider,
 	tokenTTL time.Duration,
-	userKey string,
+	refreshTTL time.Duration,
+	keys *jwt.KeyRing,
+	tokenParams jwt.Params,
+	mfaIssuer string,
+	webAuthn *webauthn.WebAuthn,
+	mailer Mailer,
+	verification VerificationParams,
+	passwordReset PasswordResetParams,
+	passwordHasher PasswordHasher,
+	lockout LockoutParams,
+	passwordPolicy PasswordPolicy,
+	appScopes []string,
 ) *Auth {
 	return &Auth{
-		log:          log,
-		userSaver:    userSaver,
-		userProvider: userProvider,
-		userChanger:  userChanger,
-		appSaver:     appSaver,
-		appProvider:  appProvider,
-		tokenTTL:     tokenTTL,
-		userKey:      userKey,
+		log:             log,
+		userSaver:       userSaver,
+		userProvider:    userProvider,
+		userChanger:     userChanger,
+		appSaver:        appSaver,
+		appProvider:     appProvider,
+		tokenSaver:      tokenSaver,
+		tokenProvider:   tokenProvider,
+		mfaSaver:        mfaSaver,
+		mfaProvider:     mfaProvider,
+		passkeySaver:    passkeySaver,
+		passkeyProvider: passkeyProvider,
+		loginLimiter:    loginLimiter,
+		roleProvider:    roleProvider,
+		tokenTTL:        tokenTTL,
+		refreshTTL:      refreshTTL,
+		keys:            keys,
+		tokenParams:     tokenParams,
+		mfaIssuer:       mfaIssuer,
+		webAuthn:        webAuthn,
+		mailer:          mailer,
+		verification:    verification,
+		passwordReset:   passwordReset,
+		passwordHasher:  passwordHasher,
+		lockout:         lockout,
+		passwordPolicy:  passwordPolicy,
+		appScopes:       appScopes,
 	}
 }
 
 // Login checks if user with given credentials exists in system
+// and returns access token with a new refresh token.
+// Tokens are issued for the app with given appID and are rejected by other apps.
+// If user has MFA enabled, returns only MFA token instead, which is exchanged for tokens by VerifyMFA
 //
 // If user exists, but password is incorrect, returns error
 // If user doesn't exist, returns error
+// If app doesn't exist, returns error
+// If email isn't verified and verified email is required, returns error
+// If there were too many failed logins with the email or from client IP, returns error
 func (a *Auth) Login(
 	ctx context.Context,
 	email string,
 	password string,
-) (string, error) {
+	appID int,
+) (string, string, string, error) {
 	const op = "services.auth.Login"
 
 	log := a.log.With(
 		slog.String("op", op),
 		slog.String("email", email), //optional
+		slog.Int("app_id", appID),
 	)
 
 	log.Info("attempting to login user")
 
-	user, err := a.userProvider.User(ctx, email)
+	app, err := a.appProvider.AppByID(ctx, appID)
 	if err != nil {
-		if errors.Is(err, storage.ErrUserNotFound) {
-			log.Info("user not found", sl.Err(err))
-			return "", fmt.Errorf("%s: %w", op, service.ErrInvalidCredentials)
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return "", "", "", fmt.Errorf("%s: %w", op, service.ErrAppNotFound)
 		}
 
-		log.Error("failed to get user", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, err)
+		log.Error("failed to get app", sl.Err(err))
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.checkCredentials(ctx, log, email, password)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfa, err := a.enabledMFA(ctx, log, user.ID)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if mfa != nil {
+		mfaToken, err := a.newMFAChallenge(ctx, user.ID, app.ID)
+		if err != nil {
+			log.Error("failed to create mfa challenge", sl.Err(err))
+			return "", "", "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("mfa required")
+
+		return "", "", mfaToken, nil
 	}
 
-	if err := bcrypt.CompareHashAndPassword(user.PassHash, []byte(password)); err != nil {
-		log.Info("invalid credentials", sl.Err(err))
-		return "", fmt.Errorf("%s: %w", op, service.ErrInvalidCredentials)
+	a.resetFailures(ctx, log, email)
+
+	token, refreshToken, err := a.loginTokens(ctx, log, user, app)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	admin, err := a.userProvider.Admin(ctx, email)
+	return token, refreshToken, "", nil
+}
+
+// tokenRoles returns roles of the user in the service itself and roles defined by the app the token is issued to
+func (a *Auth) tokenRoles(ctx context.Context, log *slog.Logger, user models.User, app models.App) (models.Admin, models.AppRoles, error) {
+	admin, err := a.userProvider.Admin(ctx, user.Email)
 	if err != nil {
-		if errors.Is(err, storage.ErrAdminNotFound) {
-			log.Info("user not admin")
-		} else {
+		if !errors.Is(err, storage.ErrAdminNotFound) {
 			log.Error("failed to get admin", sl.Err(err))
-			return "", fmt.Errorf("%s: %w", op, err)
+			return models.Admin{}, models.AppRoles{}, err
 		}
+		log.Info("user not admin")
+	}
+
+	appRoles, err := a.roleProvider.AppRoles(ctx, user.ID, app.ID)
+	if err != nil {
+		log.Error("failed to get app roles", sl.Err(err))
+		return models.Admin{}, models.AppRoles{}, err
+	}
+
+	return admin, appRoles, nil
+}
+
+// loginTokens returns access token with a new refresh token of the logged in user
+func (a *Auth) loginTokens(ctx context.Context, log *slog.Logger, user models.User, app models.App) (string, string, error) {
+	admin, appRoles, err := a.tokenRoles(ctx, log, user, app)
+	if err != nil {
+		return "", "", err
 	}
 
 	log.Info("user logged in successfully")
 
-	err = a.userChanger.UpdateUserVisitTime(ctx, email, time.Now())
+	err = a.userChanger.UpdateUserVisitTime(ctx, user.Email, time.Now())
 	if err != nil {
 		log.Warn("failed to update visit time")
 	}
 
+	token, err := jwt.NewToken(user, admin, appRoles, app, "", a.appTokenTTL(app), a.keys, a.tokenParams)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return "", "", err
+	}
+
+	log.Info("token genereted")
+
+	refreshToken, err := a.newRefreshToken(ctx, user.ID, app.ID, "", "")
 	if err != nil {
-		admin.Level = 1 // TODO: remove this brute force approach
+		log.Error("failed to generate refresh token", sl.Err(err))
+		return "", "", err
 	}
-	token, err := jwt.NewToken(user, admin, a.tokenTTL, a.userKey)
+
+	return token, refreshToken, nil
+}
+
+// Refresh exchanges refresh token for a new pair of access and refresh tokens
+//
+// Refresh token is rotated on every use. If already used token is presented again,
+// the whole token family is revoked and error is returned
+func (a *Auth) Refresh(
+	ctx context.Context,
+	refreshToken string,
+) (string, string, error) {
+	const op = "services.auth.Refresh"
+
+	log := a.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("attempting to refresh token")
+
+	stored, err := a.tokenProvider.RefreshToken(ctx, secret.Hash(refreshToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			log.Info("refresh token not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+		}
+
+		log.Error("failed to get refresh token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", stored.UserID))
+
+	if stored.Revoked {
+		log.Info("refresh token revoked")
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+	}
+
+	if stored.Used {
+		return "", "", fmt.Errorf("%s: %w", op, a.revokeFamily(ctx, log, stored.Family))
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		log.Info("refresh token expired")
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+	}
+
+	if err := a.tokenSaver.UseRefreshToken(ctx, stored.ID); err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenUsed) {
+			return "", "", fmt.Errorf("%s: %w", op, a.revokeFamily(ctx, log, stored.Family))
+		}
+
+		log.Error("failed to use refresh token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, stored.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	app, err := a.appProvider.AppByID(ctx, stored.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	admin, appRoles, err := a.tokenRoles(ctx, log, user, app)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, err := jwt.NewToken(user, admin, appRoles, app, stored.Scope, a.appTokenTTL(app), a.keys, a.tokenParams)
 	if err != nil {
 		log.Error("failed to generate token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	newRefreshToken, err := a.newRefreshToken(ctx, user.ID, app.ID, stored.Family, stored.Scope)
+	if err != nil {
+		log.Error("failed to generate refresh token", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token refreshed")
+
+	return token, newRefreshToken, nil
+}
+
+// Logout revokes the access token and, if given, the refresh token family it belongs to
+//
+// If refresh token belongs to another user, returns error
+func (a *Auth) Logout(
+	ctx context.Context,
+	token *jwt.Token,
+	refreshToken string,
+) error {
+	const op = "services.auth.Logout"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to logout user")
+
+	if refreshToken != "" {
+		stored, err := a.tokenProvider.RefreshToken(ctx, secret.Hash(refreshToken))
+		if err != nil {
+			if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+				log.Info("refresh token not found", sl.Err(err))
+				return fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+			}
+
+			log.Error("failed to get refresh token", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+
+		if stored.UserID != token.UID {
+			log.Warn("refresh token belongs to another user")
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidRefreshToken)
+		}
+
+		if err := a.tokenSaver.RevokeRefreshFamily(ctx, stored.Family); err != nil {
+			log.Error("failed to revoke refresh token", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := a.tokenSaver.RevokeToken(ctx, token.ID, token.UID, token.Expiration.Add(a.tokenParams.Leeway)); err != nil {
+		log.Error("failed to revoke token", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user logged out")
+
+	return nil
+}
+
+// Introspect returns information about the token for the calling app
+//
+// Invalid, expired or revoked token is reported as inactive rather than as error
+func (a *Auth) Introspect(
+	ctx context.Context,
+	app models.App,
+	token string,
+	tokenTypeHint string,
+) (models.TokenInfo, error) {
+	const op = "services.auth.Introspect"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", app.ID),
+	)
+
+	log.Info("introspecting token")
+
+	var info models.TokenInfo
+	var err error
+	if tokenTypeHint == models.TokenTypeRefresh {
+		info, err = a.introspectRefresh(ctx, app, token)
+		if err == nil && !info.Active {
+			info, err = a.introspectAccess(ctx, app, token)
+		}
+	} else {
+		info, err = a.introspectAccess(ctx, app, token)
+		if err == nil && !info.Active {
+			info, err = a.introspectRefresh(ctx, app, token)
+		}
+	}
+	if err != nil {
+		log.Error("failed to introspect token", sl.Err(err))
+		return models.TokenInfo{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("token introspected", slog.Bool("active", info.Active))
+
+	return info, nil
+}
+
+// introspectAccess reports access token as active only to the app it was issued for
+func (a *Auth) introspectAccess(ctx context.Context, app models.App, raw string) (models.TokenInfo, error) {
+	params := a.tokenParams
+	params.Audience = app.ClientID()
+
+	token, err := jwt.Parse(raw, a.keys, params)
+	if err != nil {
+		return models.TokenInfo{}, nil
+	}
+
+	revoked, err := a.tokenProvider.IsTokenRevoked(ctx, token.ID, token.UID, token.IssuedAt)
+	if err != nil {
+		return models.TokenInfo{}, err
+	}
+	if revoked {
+		return models.TokenInfo{}, nil
+	}
+
+	return models.TokenInfo{
+		Active:      true,
+		TokenType:   models.TokenTypeAccess,
+		ID:          token.ID,
+		Subject:     token.Subject,
+		Email:       token.Email,
+		IssuedAt:    token.IssuedAt,
+		ExpiresAt:   token.Expiration,
+		Roles:       token.Roles,
+		Permissions: token.Permissions,
+		ClientID:    token.ClientID,
+		Scope:       token.Scope,
+		// roles of the app are meaningful to the app only, but any app may introspect
+		AppRoles:       token.AppRoles,
+		AppPermissions: token.AppPermissions,
+	}, nil
+}
+
+// introspectRefresh reports refresh token as active only to the app it was issued for
+func (a *Auth) introspectRefresh(ctx context.Context, app models.App, raw string) (models.TokenInfo, error) {
+	stored, err := a.tokenProvider.RefreshToken(ctx, secret.Hash(raw))
+	if err != nil {
+		if errors.Is(err, storage.ErrRefreshTokenNotFound) {
+			return models.TokenInfo{}, nil
+		}
+		return models.TokenInfo{}, err
+	}
+
+	if stored.AppID != app.ID || stored.Used || stored.Revoked || time.Now().After(stored.ExpiresAt) {
+		return models.TokenInfo{}, nil
+	}
+
+	return models.TokenInfo{
+		Active:    true,
+		TokenType: models.TokenTypeRefresh,
+		Subject:   strconv.FormatInt(stored.UserID, 10),
+		ClientID:  app.ClientID(),
+		IssuedAt:  stored.CreatedAt,
+		ExpiresAt: stored.ExpiresAt,
+	}, nil
+}
+
+// checkCredentials returns user with given email if password matches.
+// If verified email is required, it also fails for users with unverified email.
+// Failures are counted by email and client IP, and credentials aren't checked while either is throttled.
+// Failures aren't forgotten here, callers reset them once the second factor, if any, is passed
+func (a *Auth) checkCredentials(ctx context.Context, log *slog.Logger, email, password string) (models.User, error) {
+	if err := a.checkThrottle(ctx, log, email, password); err != nil {
+		return models.User{}, err
+	}
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			a.verifyDummyPassword(log, password)
+			a.recordFailure(ctx, log, email)
+			return models.User{}, service.ErrInvalidCredentials
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, err
+	}
+
+	if err := a.verifyPassword(ctx, log, user, password); err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			a.recordFailure(ctx, log, email)
+		}
+		return models.User{}, err
+	}
+
+	if err := a.checkVerified(ctx, log, user); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// revokeFamily revokes refresh token family after reuse of a rotated token was detected
+func (a *Auth) revokeFamily(ctx context.Context, log *slog.Logger, family string) error {
+	log.Warn("refresh token reuse detected, revoking token family")
+
+	if err := a.tokenSaver.RevokeRefreshFamily(ctx, family); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+		return err
+	}
+
+	return service.ErrInvalidRefreshToken
+}
+
+// revokedUntil returns how long revocation made at currentTime must be kept, that is until the last token
+// issued before it expires, including leeway it's accepted with after exp
+func (a *Auth) revokedUntil(currentTime time.Time) time.Time {
+	return currentTime.Add(a.tokenTTL + a.tokenParams.Leeway)
+}
+
+// appTokenTTL returns lifetime of tokens issued for the app
+func (a *Auth) appTokenTTL(app models.App) time.Duration {
+	if app.TokenTTL > 0 {
+		return app.TokenTTL
+	}
+	return a.tokenTTL
+}
+
+// newRefreshToken generates and saves a new refresh token, access tokens issued for it get given scope
+//
+// If family is empty, token starts a new family
+func (a *Auth) newRefreshToken(ctx context.Context, userID int64, appID int, family string, scope string) (string, error) {
+	const op = "services.auth.newRefreshToken"
+
+	if family == "" {
+		var err error
+		family, err = secret.GenerateSecret()
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	refreshToken, err := secret.GenerateSecret()
+	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("token genereted")
+	currentTime := time.Now()
+	err = a.tokenSaver.SaveRefreshToken(ctx, models.RefreshToken{
+		Hash:      secret.Hash(refreshToken),
+		Family:    family,
+		UserID:    userID,
+		AppID:     appID,
+		Scope:     scope,
+		ExpiresAt: currentTime.Add(a.refreshTTL),
+		CreatedAt: currentTime,
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
 
-	return token, nil
+	return refreshToken, nil
 }
 
-// RegisterNewUser registers new user in the system and returns user ID
+// RegisterNewUser registers new user with unverified email in the system and returns user ID.
+// Verification link is sent to the email, failure to send it doesn't fail registration
 //
+// If password fails password policy, returns error
 // If user with given username already exists, returns error
 func (a *Auth) RegisterNewUser(
 	ctx context.Context,
@@ -163,7 +746,11 @@ func (a *Auth) RegisterNewUser(
 
 	log.Info("registering new user")
 
-	passHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := a.checkPolicy(log, password, email); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.passwordHasher.Hash(password)
 	if err != nil {
 		log.Error("failed to generate password hash", sl.Err(err))
 		return 0, fmt.Errorf("%s: %w", op, err)
@@ -182,13 +769,29 @@ func (a *Auth) RegisterNewUser(
 
 	log.Info("user registered")
 
+	if err := a.sendVerification(ctx, id, email); err != nil {
+		log.Error("failed to send verification email", sl.Err(err))
+	}
+
 	return id, nil
 }
 
-// RegisterNewApp registers new app in the system and returns apiKey
+// RegisterNewApp registers new app in the system and returns it with client secret.
+// Client secret is returned only once, only its hash is stored
+//
+// Zero tokenTTL means default token lifetime, it can't be longer than the default one.
+// Redirect URIs must be absolute and must not contain a fragment.
+// Registration is open, so scopes are limited to the configured ones
 //
+// If any of scopes isn't allowed for registered apps, returns error
 // If app with given name already exists, returns error
-func (a *Auth) RegisterNewApp(ctx context.Context, name string) (string, string, error) {
+func (a *Auth) RegisterNewApp(
+	ctx context.Context,
+	name string,
+	tokenTTL time.Duration,
+	redirectURIs []string,
+	scopes []string,
+) (models.App, string, error) {
 	const op = "service.auth.RegisterNewApp"
 
 	log := a.log.With(
@@ -198,13 +801,36 @@ func (a *Auth) RegisterNewApp(ctx context.Context, name string) (string, string,
 
 	log.Info("registering new app")
 
+	if tokenTTL < 0 || tokenTTL > a.tokenTTL {
+		log.Warn("invalid token ttl", slog.Duration("token_ttl", tokenTTL))
+		return models.App{}, "", fmt.Errorf("%s: %w", op, service.ErrInvalidTokenTTL)
+	}
+
+	for _, uri := range redirectURIs {
+		if !oauth.ValidRedirectURI(uri) {
+			log.Warn("invalid redirect uri", slog.String("redirect_uri", uri))
+			return models.App{}, "", fmt.Errorf("%s: %w", op, service.ErrInvalidRedirectURI)
+		}
+	}
+
+	for _, scope := range scopes {
+		if !oauth.ValidScope(scope) {
+			log.Warn("invalid scope", slog.String("scope", scope))
+			return models.App{}, "", fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+		}
+		if !slices.Contains(a.appScopes, scope) {
+			log.Warn("scope not allowed for registered apps", slog.String("scope", scope))
+			return models.App{}, "", fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+		}
+	}
+
 	var apiKey string
 	var err error
 	for {
 		apiKey, err = secret.GenerateSecret()
 		if err != nil {
 			log.Error("failed to generate secret")
-			return "", "", fmt.Errorf("%s: %w", op, err)
+			return models.App{}, "", fmt.Errorf("%s: %w", op, err)
 		}
 
 		_, err := a.appProvider.AppByKey(ctx, apiKey)
@@ -213,20 +839,38 @@ func (a *Auth) RegisterNewApp(ctx context.Context, name string) (string, string,
 				break
 			}
 			log.Error("failed to get app by key", sl.Err(err))
-			return "", "", fmt.Errorf("%s: %w", op, err)
+			return models.App{}, "", fmt.Errorf("%s: %w", op, err)
 		}
 	}
 
-	if err := a.appSaver.SaveApp(ctx, name, apiKey); err != nil {
+	clientSecret, err := secret.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate secret")
+		return models.App{}, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	app := models.App{
+		Name:       name,
+		ApiKey:     apiKey,
+		TokenTTL:   tokenTTL,
+		SecretHash: secret.Hash(clientSecret),
+		Scopes:     slices.Compact(scopes),
+	}
+
+	app.ID, err = a.appSaver.SaveApp(ctx, app, redirectURIs)
+	if err != nil {
 		if errors.Is(err, storage.ErrAppExists) {
 			log.Warn("app already exists")
-			return "", "", fmt.Errorf("%s: %w", op, service.ErrAppAlreadyExists)
+			return models.App{}, "", fmt.Errorf("%s: %w", op, service.ErrAppAlreadyExists)
 		}
 		log.Error("failed to save app", sl.Err(err))
-		return "", "", fmt.Errorf("%s: %w", op, err)
+		return models.App{}, "", fmt.Errorf("%s: %w", op, err)
 	}
 
 	log.Info("app registered")
 
-	return apiKey, a.userKey, nil
+	return app, clientSecret, nil
 }
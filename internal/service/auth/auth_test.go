@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/storage/sqlite"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost"
+)
+
+// newTestStorage returns storage in a temporary database with all migrations applied
+func newTestStorage(t *testing.T) *sqlite.Storage {
+	t.Helper()
+
+	storagePath := filepath.Join(t.TempDir(), "sso.db")
+
+	m, err := migrate.New("file://../../../migrations", "sqlite3://"+storagePath+"?x-migrations-table=migrations")
+	if err != nil {
+		t.Fatalf("failed to init migrations: %v", err)
+	}
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		t.Fatalf("failed to apply migrations: %v", err)
+	}
+	m.Close()
+
+	storage, err := sqlite.New(storagePath)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+
+	return storage
+}
+
+// newTestAuth returns auth service backed by the storage, which sends mail with mailer
+func newTestAuth(t *testing.T, storage *sqlite.Storage, mailer Mailer, verification VerificationParams) *Auth {
+	t.Helper()
+
+	key, err := jwt.NewKey(jwt.AlgorithmES256)
+	if err != nil {
+		t.Fatalf("failed to generate signing key: %v", err)
+	}
+	keys := jwt.NewKeyRing()
+	if err := keys.Set([]*jwt.Key{key}); err != nil {
+		t.Fatalf("failed to set signing key: %v", err)
+	}
+
+	webAuthn, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: "SSO",
+		RPOrigins:     []string{testOrigin},
+	})
+	if err != nil {
+		t.Fatalf("failed to init webauthn: %v", err)
+	}
+
+	return New(
+		slogdiscard.NewDiscardLogger(),
+		storage, storage, storage,
+		storage, storage,
+		storage, storage,
+		storage, storage,
+		storage, storage,
+		storage, storage,
+		time.Hour, 24*time.Hour,
+		keys, jwt.Params{Issuer: "sso"},
+		"SSO",
+		webAuthn,
+		mailer,
+		verification,
+		PasswordResetParams{},
+		nil,
+		LockoutParams{MaxFailures: 5, IPMaxFailures: 50, BaseDelay: time.Second, Duration: time.Minute, Window: time.Hour},
+		nil,
+		nil,
+	)
+}
+
+// newTestUser saves user with given email and returns it
+func newTestUser(t *testing.T, storage *sqlite.Storage, email string) models.User {
+	t.Helper()
+
+	ctx := context.Background()
+
+	uid, err := storage.SaveUser(ctx, email, []byte("hash"))
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+
+	user, err := storage.UserByID(ctx, uid)
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+
+	return user
+}
+
+// newTestApp saves app and returns its id
+func newTestApp(t *testing.T, storage *sqlite.Storage) int {
+	t.Helper()
+
+	appID, err := storage.SaveApp(context.Background(), models.App{Name: "test", ApiKey: "test-api-key"}, nil)
+	if err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	return appID
+}
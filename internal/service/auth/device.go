@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+const (
+	// deviceCodeTTL is how long the user has to approve the device
+	deviceCodeTTL = 10 * time.Minute
+	// devicePollInterval is minimum interval between polling requests of the device,
+	// it's increased by the same amount each time the device polls too fast
+	devicePollInterval = 5 * time.Second
+)
+
+// DeviceAuthorize starts device authorization grant for the app with given clientID.
+// Returned device code is used by the device to poll for tokens,
+// and user code is entered by the user on the verification page
+//
+// If app doesn't exist, returns error
+// If scope isn't allowed, returns error
+func (a *Auth) DeviceAuthorize(
+	ctx context.Context,
+	clientID string,
+	scope string,
+) (models.DeviceAuthorization, error) {
+	const op = "services.auth.DeviceAuthorize"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	log.Info("attempting to authorize device")
+
+	app, err := a.appByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, service.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+		} else {
+			log.Error("failed to get app", sl.Err(err))
+		}
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if !userScopeAllowed(log, app, scope) {
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+	}
+
+	deviceCode, err := secret.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate device code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	userCode, err := oauth.GenerateUserCode()
+	if err != nil {
+		log.Error("failed to generate user code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now()
+	err = a.tokenSaver.SaveDeviceCode(ctx, models.DeviceCode{
+		Hash:      secret.Hash(deviceCode),
+		UserCode:  userCode,
+		AppID:     app.ID,
+		Scope:     strings.Join(strings.Fields(scope), " "),
+		Status:    models.DeviceCodePending,
+		Interval:  devicePollInterval,
+		ExpiresAt: currentTime.Add(deviceCodeTTL),
+		CreatedAt: currentTime,
+	})
+	if err != nil {
+		log.Error("failed to save device code", sl.Err(err))
+		return models.DeviceAuthorization{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("device authorization started")
+
+	return models.DeviceAuthorization{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		ExpiresIn:  deviceCodeTTL,
+		Interval:   devicePollInterval,
+	}, nil
+}
+
+// VerifyDevice checks user credentials and approves or denies pending device authorization
+// with given userCode. If user has MFA enabled, mfaCode is required
+//
+// If user code is unknown, expired or already used, returns error
+// If credentials or MFA code are invalid, returns error
+func (a *Auth) VerifyDevice(
+	ctx context.Context,
+	email string,
+	password string,
+	userCode string,
+	approve bool,
+	mfaCode string,
+) error {
+	const op = "services.auth.VerifyDevice"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email), //optional
+	)
+
+	log.Info("attempting to verify device")
+
+	code, err := a.tokenProvider.DeviceCodeByUserCode(ctx, oauth.NormalizeUserCode(userCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("user code not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidUserCode)
+		}
+
+		log.Error("failed to get device code", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if code.Status != models.DeviceCodePending || time.Now().After(code.ExpiresAt) {
+		log.Info("device code isn't pending anymore", slog.String("status", code.Status))
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidUserCode)
+	}
+
+	user, err := a.checkCredentials(ctx, log, email, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkSecondFactor(ctx, log, user, mfaCode); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	status := models.DeviceCodeDenied
+	if approve {
+		status = models.DeviceCodeApproved
+	}
+
+	err = a.tokenSaver.SetDeviceCodeStatus(ctx, code.ID, status, user.ID, time.Now())
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("device code isn't pending anymore", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidUserCode)
+		}
+
+		log.Error("failed to set device code status", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("device verified", slog.String("status", status))
+
+	return nil
+}
+
+// ExchangeDeviceCode exchanges approved device code for access and refresh tokens.
+// If openid scope was granted, ID token is issued as well
+//
+// While the user hasn't responded, returns ErrAuthorizationPending,
+// and if the device polls faster than allowed, returns ErrSlowDown and increases polling interval.
+// If the user denied access, returns ErrAccessDenied, and if code is expired, returns ErrExpiredToken
+func (a *Auth) ExchangeDeviceCode(
+	ctx context.Context,
+	deviceCode string,
+	clientID string,
+) (models.Tokens, error) {
+	const op = "services.auth.ExchangeDeviceCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	log.Info("attempting to exchange device code")
+
+	code, err := a.tokenProvider.DeviceCode(ctx, secret.Hash(deviceCode))
+	if err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("device code not found", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+		}
+
+		log.Error("failed to get device code", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if strconv.Itoa(code.AppID) != clientID {
+		log.Warn("device code issued for another client")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+	}
+
+	currentTime := time.Now()
+	if currentTime.After(code.ExpiresAt) {
+		log.Info("device code expired")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrExpiredToken)
+	}
+
+	interval := code.Interval
+	tooFast := !code.LastPolledAt.IsZero() && currentTime.Sub(code.LastPolledAt) < interval
+	if tooFast {
+		interval += devicePollInterval
+	}
+
+	if err := a.tokenSaver.PollDeviceCode(ctx, code.ID, currentTime, interval); err != nil {
+		log.Error("failed to save polling time", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if tooFast {
+		log.Info("device polls too fast", slog.Duration("interval", interval))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrSlowDown)
+	}
+
+	switch code.Status {
+	case models.DeviceCodePending:
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrAuthorizationPending)
+	case models.DeviceCodeDenied:
+		if err := a.tokenSaver.DeleteDeviceCode(ctx, code.ID); err != nil && !errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Error("failed to delete device code", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("user denied access")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	log = log.With(slog.Int64("uid", code.UserID))
+
+	if err := a.tokenSaver.DeleteDeviceCode(ctx, code.ID); err != nil {
+		if errors.Is(err, storage.ErrDeviceCodeNotFound) {
+			log.Info("device code already exchanged", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+		}
+
+		log.Error("failed to delete device code", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.userTokens(ctx, log, code.UserID, code.AppID, "", code.Scope, code.ApprovedAt, "")
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("device code exchanged")
+
+	return tokens, nil
+}
@@ -0,0 +1,257 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// Purposes of signed verification tokens, values signed for anything else aren't accepted
+const (
+	emailVerificationUse = "verify_email"
+	emailChangeUse       = "change_email"
+)
+
+// VerificationParams configures email verification
+type VerificationParams struct {
+	// URL of the verification endpoint, token is added to its query
+	URL string
+	// TTL is how long verification link is valid
+	TTL time.Duration
+	// Key signs verification tokens
+	Key []byte
+	// Required blocks login until email is verified
+	Required bool
+}
+
+// verificationToken is payload of signed verification token, it's bound to the email,
+// so the link is invalidated when the user changes email
+type verificationToken struct {
+	UserID int64  `json:"uid"`
+	Email  string `json:"email"`
+	// From is current email of the user, it's set if token confirms change of email
+	From      string `json:"from,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+	Use       string `json:"use"`
+}
+
+// VerifyEmail marks email of the user as verified by token from verification link.
+// Verifying already verified email succeeds. If the link confirms change of email,
+// email of the user is replaced with the new one and the old address is notified
+//
+// If token is invalid or expired, or the user changed email since, returns error
+// If new email is taken by now, returns error
+func (a *Auth) VerifyEmail(ctx context.Context, token string) error {
+	const op = "services.auth.VerifyEmail"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("attempting to verify email")
+
+	payload, ok := secret.Verify(a.verification.Key, token)
+	if !ok {
+		log.Warn("invalid verification token signature")
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidVerificationToken)
+	}
+
+	var claims verificationToken
+	if err := json.Unmarshal(payload, &claims); err != nil || (claims.Use != emailVerificationUse && claims.Use != emailChangeUse) {
+		log.Warn("invalid verification token payload")
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidVerificationToken)
+	}
+
+	log = log.With(slog.Int64("uid", claims.UserID))
+
+	if time.Now().After(time.Unix(claims.ExpiresAt, 0)) {
+		log.Info("verification token expired")
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidVerificationToken)
+	}
+
+	if claims.Use == emailChangeUse {
+		if err := a.confirmEmailChange(ctx, log, claims); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		return nil
+	}
+
+	if err := a.userChanger.VerifyEmail(ctx, claims.UserID, claims.Email); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found or email changed", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidVerificationToken)
+		}
+
+		log.Error("failed to verify email", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email verified")
+
+	return nil
+}
+
+// ChangeEmail sends link confirming change of email to the new address, if password is correct.
+// Email is changed only once the link is followed, and the current address is notified about the request
+//
+// If password is incorrect, returns error
+// If there were too many failed logins with the email or from client IP, returns error
+// If new email is taken, returns error
+// If token is exchanged, returns error
+func (a *Auth) ChangeEmail(ctx context.Context, token *jwt.Token, password string, email string) error {
+	const op = "services.auth.ChangeEmail"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to change email")
+
+	if token.Act != nil {
+		log.Warn("email can't be changed with exchanged token", slog.String("actor", token.Act.Subject))
+		return fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	user, err := a.checkPassword(ctx, log, token.UID, password)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = a.userProvider.User(ctx, email)
+	if err == nil {
+		log.Info("email already taken")
+		return fmt.Errorf("%s: %w", op, service.ErrUserExists)
+	}
+	if !errors.Is(err, storage.ErrUserNotFound) {
+		log.Error("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	link, err := a.verificationLink(verificationToken{
+		UserID:    user.ID,
+		Email:     email,
+		From:      user.Email,
+		ExpiresAt: time.Now().Add(a.verification.TTL).Unix(),
+		Use:       emailChangeUse,
+	})
+	if err != nil {
+		log.Error("failed to create confirmation link", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your new email",
+		Body: "Follow the link to use this email for your account:\n\n" + link + "\n\n" +
+			"The link expires in " + a.verification.TTL.String() + ". If you didn't ask to change your email, ignore this email.",
+	})
+	if err != nil {
+		log.Error("failed to send confirmation email", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("email change requested")
+
+	a.sendInBackground(ctx, log, mail.Message{
+		To:      user.Email,
+		Subject: "Email change requested",
+		Body:    "Change of your account email to " + email + " was requested. If it wasn't you, change your password right away.",
+	})
+
+	return nil
+}
+
+// confirmEmailChange replaces email of the user by verified change token
+func (a *Auth) confirmEmailChange(ctx context.Context, log *slog.Logger, claims verificationToken) error {
+	// tokens carry email, so ones issued for the old email must not be accepted anymore
+	currentTime := time.Now()
+	err := a.userChanger.ChangeEmail(ctx, claims.UserID, claims.From, claims.Email, currentTime, a.revokedUntil(currentTime))
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found or email changed", sl.Err(err))
+			return service.ErrInvalidVerificationToken
+		}
+		if errors.Is(err, storage.ErrUserExists) {
+			log.Info("email already taken", sl.Err(err))
+			return service.ErrUserExists
+		}
+
+		log.Error("failed to change email", sl.Err(err))
+		return err
+	}
+
+	log.Info("email changed")
+
+	a.sendInBackground(ctx, log, mail.Message{
+		To:      claims.From,
+		Subject: "Your email was changed",
+		Body:    "Email of your account was changed to " + claims.Email + ". If it wasn't you, contact support right away.",
+	})
+
+	return nil
+}
+
+// checkVerified returns ErrEmailNotVerified if verified email is required to login and the user hasn't verified it yet.
+// New verification link is sent then, so the user isn't stuck if the first one is lost or expired
+func (a *Auth) checkVerified(ctx context.Context, log *slog.Logger, user models.User) error {
+	if !a.verification.Required || user.EmailVerified {
+		return nil
+	}
+
+	log.Info("email not verified")
+
+	if err := a.sendVerification(ctx, user.ID, user.Email); err != nil {
+		log.Error("failed to send verification email", sl.Err(err))
+	}
+
+	return service.ErrEmailNotVerified
+}
+
+// sendVerification sends link verifying email of the user
+func (a *Auth) sendVerification(ctx context.Context, userID int64, email string) error {
+	link, err := a.verificationLink(verificationToken{
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(a.verification.TTL).Unix(),
+		Use:       emailVerificationUse,
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.mailer.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: "Follow the link to verify your email:\n\n" + link + "\n\n" +
+			"The link expires in " + a.verification.TTL.String() + ". If you didn't sign up, ignore this email.",
+	})
+}
+
+// verificationLink returns verification endpoint URL with signed token in its query
+func (a *Auth) verificationLink(claims verificationToken) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	link, err := url.Parse(a.verification.URL)
+	if err != nil {
+		return "", err
+	}
+	query := link.Query()
+	query.Set("token", secret.Sign(a.verification.Key, payload))
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
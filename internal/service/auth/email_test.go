@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/lib/mail"
+	"sso/internal/service"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	const email = "verify@example.com"
+
+	tests := []struct {
+		name string
+		// token returns claims of the verification token for the user with email
+		token func(userID int64) verificationToken
+		// changedTo is email the user switches to after the token is issued, if not empty
+		changedTo string
+		wantErr   error
+	}{
+		{
+			name: "valid",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: email, ExpiresAt: time.Now().Add(time.Hour).Unix(), Use: emailVerificationUse}
+			},
+		},
+		{
+			name: "expired",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: email, ExpiresAt: time.Now().Add(-time.Second).Unix(), Use: emailVerificationUse}
+			},
+			wantErr: service.ErrInvalidVerificationToken,
+		},
+		{
+			name: "wrong use",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: email, ExpiresAt: time.Now().Add(time.Hour).Unix(), Use: "reset_password"}
+			},
+			wantErr: service.ErrInvalidVerificationToken,
+		},
+		{
+			name: "no use",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: email, ExpiresAt: time.Now().Add(time.Hour).Unix()}
+			},
+			wantErr: service.ErrInvalidVerificationToken,
+		},
+		{
+			name: "email changed",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: email, ExpiresAt: time.Now().Add(time.Hour).Unix(), Use: emailVerificationUse}
+			},
+			changedTo: "changed@example.com",
+			wantErr:   service.ErrInvalidVerificationToken,
+		},
+		{
+			name: "email change from changed email",
+			token: func(userID int64) verificationToken {
+				return verificationToken{UserID: userID, Email: "new@example.com", From: email, ExpiresAt: time.Now().Add(time.Hour).Unix(), Use: emailChangeUse}
+			},
+			changedTo: "changed@example.com",
+			wantErr:   service.ErrInvalidVerificationToken,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			storage := newTestStorage(t)
+			a := newTestAuth(t, storage, mail.NewLog(slogdiscard.NewDiscardLogger()), VerificationParams{
+				URL: "http://localhost/verify",
+				TTL: time.Hour,
+				Key: []byte("verification key"),
+			})
+			user := newTestUser(t, storage, email)
+
+			token := verificationTokenOf(t, a, tt.token(user.ID))
+
+			if tt.changedTo != "" {
+				if err := storage.ChangeEmail(ctx, user.ID, email, tt.changedTo, time.Now(), time.Now().Add(time.Hour)); err != nil {
+					t.Fatalf("failed to change email: %v", err)
+				}
+			}
+
+			before, err := storage.UserByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+
+			err = a.VerifyEmail(ctx, token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("VerifyEmail() error = %v, want %v", err, tt.wantErr)
+			}
+
+			after, err := storage.UserByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if after.Email != before.Email {
+				t.Errorf("email = %q, want %q", after.Email, before.Email)
+			}
+			// rejected token leaves the user as it was
+			want := before.EmailVerified
+			if tt.wantErr == nil {
+				want = true
+			}
+			if after.EmailVerified != want {
+				t.Errorf("email verified = %v, want %v", after.EmailVerified, want)
+			}
+		})
+	}
+}
+
+func TestVerifyEmailRejectsForgedToken(t *testing.T) {
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{URL: "http://localhost/verify", Key: []byte("verification key")})
+	user := newTestUser(t, storage, "forged@example.com")
+
+	forger := newTestAuth(t, storage, nil, VerificationParams{URL: "http://localhost/verify", Key: []byte("another key")})
+	token := verificationTokenOf(t, forger, verificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Use:       emailVerificationUse,
+	})
+
+	if err := a.VerifyEmail(context.Background(), token); !errors.Is(err, service.ErrInvalidVerificationToken) {
+		t.Fatalf("VerifyEmail() error = %v, want %v", err, service.ErrInvalidVerificationToken)
+	}
+}
+
+// verificationTokenOf returns token of the verification link with claims
+func verificationTokenOf(t *testing.T, a *Auth, claims verificationToken) string {
+	t.Helper()
+
+	link, err := a.verificationLink(claims)
+	if err != nil {
+		t.Fatalf("failed to create verification link: %v", err)
+	}
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("invalid verification link: %v", err)
+	}
+
+	return parsed.Query().Get("token")
+}
+
+func TestEmailChangeRevokesSessions(t *testing.T) {
+	ctx := context.Background()
+
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, mail.NewLog(slogdiscard.NewDiscardLogger()), VerificationParams{
+		URL: "http://localhost/verify",
+		Key: []byte("verification key"),
+	})
+	a.passwordHasher = &countingHasher{}
+	appID := newTestApp(t, storage)
+
+	uid, err := storage.SaveUser(ctx, "old@example.com", []byte("plain:password"))
+	if err != nil {
+		t.Fatalf("failed to save user: %v", err)
+	}
+
+	accessToken, refreshToken, _, err := a.Login(ctx, "old@example.com", "password", appID)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+
+	token := verificationTokenOf(t, a, verificationToken{
+		UserID:    uid,
+		Email:     "new@example.com",
+		From:      "old@example.com",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		Use:       emailChangeUse,
+	})
+	if err := a.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+
+	if _, _, err := a.Refresh(ctx, refreshToken); !errors.Is(err, service.ErrInvalidRefreshToken) {
+		t.Errorf("Refresh() error = %v, want %v", err, service.ErrInvalidRefreshToken)
+	}
+
+	parsed, err := jwt.Parse(accessToken, a.keys, a.tokenParams)
+	if err != nil {
+		t.Fatalf("failed to parse access token: %v", err)
+	}
+	revoked, err := storage.IsTokenRevoked(ctx, parsed.ID, parsed.UID, parsed.IssuedAt)
+	if err != nil {
+		t.Fatalf("IsTokenRevoked: %v", err)
+	}
+	if !revoked {
+		t.Error("access token issued for the old email isn't revoked")
+	}
+}
@@ -0,0 +1,294 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// ExchangeToken exchanges subject token for a down-scoped access token of the same user
+// for the app with client_id audience (RFC 8693). Requesting app must authenticate with client secret,
+// and subject and actor tokens must have been issued to it. Issued token carries act claim
+// identifying the actor, or the requesting app if there is no actor token, and never carries roles.
+// Empty audience means the requesting app itself, empty scope keeps scope of the subject token
+//
+// If subjectTokenType is oauth.TokenTypeEmail, subject token is email of the user to impersonate,
+// and actor token of an admin with users:impersonate permission is required. The user must not have
+// permissions the actor lacks, and the actor must have ones the user lacks, so admins can't impersonate their peers
+//
+// Every exchange is audited. Issued token never outlives the subject token,
+// or the actor token on impersonation
+func (a *Auth) ExchangeToken(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	subjectToken string,
+	subjectTokenType string,
+	actorToken string,
+	audience string,
+	scope string,
+) (models.Tokens, error) {
+	const op = "services.auth.ExchangeToken"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+		slog.String("audience", audience),
+	)
+
+	log.Info("attempting to exchange token")
+
+	client, err := a.authenticateClient(ctx, log, clientID, clientSecret)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	target := client
+	if audience != "" && audience != client.ClientID() {
+		target, err = a.appByClientID(ctx, audience)
+		if err != nil {
+			if errors.Is(err, service.ErrAppNotFound) {
+				log.Warn("target app not found", sl.Err(err))
+				return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidTarget)
+			}
+
+			log.Error("failed to get app", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	var actor *jwt.Token
+	if actorToken != "" {
+		actor, err = a.exchangedToken(ctx, log, client, actorToken)
+		if err != nil {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	impersonation := subjectTokenType == oauth.TokenTypeEmail
+
+	var user models.User
+	var act *jwt.Actor
+	var expiration time.Time
+	if impersonation {
+		user, err = a.impersonatedUser(ctx, log, actor, subjectToken)
+		if err != nil {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		if !userScopeAllowed(log, target, scope) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+		}
+		act = &jwt.Actor{Subject: actor.Subject, ClientID: actor.ClientID}
+		expiration = actor.Expiration
+	} else {
+		subject, err := a.exchangedToken(ctx, log, client, subjectToken)
+		if err != nil {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+		if subject.IsClient() {
+			log.Warn("subject token isn't issued to a user")
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+		}
+
+		user, err = a.userProvider.UserByID(ctx, subject.UID)
+		if err != nil {
+			if errors.Is(err, storage.ErrUserNotFound) {
+				log.Info("user not found", sl.Err(err))
+				return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+			}
+
+			log.Error("failed to get user", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		scope, err = exchangedScope(log, target, subject.Scope, scope)
+		if err != nil {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+		}
+
+		act = &jwt.Actor{Subject: client.ClientID(), ClientID: client.ClientID(), Act: subject.Act}
+		if actor != nil {
+			act.Subject, act.ClientID = actor.Subject, actor.ClientID
+		}
+		expiration = subject.Expiration
+	}
+
+	log = log.With(slog.Int64("uid", user.ID), slog.String("actor", act.Subject))
+
+	// subject or actor token may still be accepted within leeway after it expired,
+	// but the issued token must not be born expired
+	currentTime := time.Now()
+	if !expiration.After(currentTime) {
+		log.Info("token to exchange has expired")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+	}
+
+	tokens := models.Tokens{
+		ExpiresIn: min(a.appTokenTTL(target), expiration.Sub(currentTime)),
+		Scope:     scope,
+	}
+
+	var jti string
+	tokens.AccessToken, jti, err = jwt.NewExchangedToken(user, target, scope, act, tokens.ExpiresIn, a.keys, a.tokenParams)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.tokenSaver.SaveTokenExchange(ctx, models.TokenExchange{
+		TokenID:       jti,
+		ClientID:      client.ClientID(),
+		Audience:      target.ClientID(),
+		UserID:        user.ID,
+		ActorSubject:  act.Subject,
+		Scope:         scope,
+		Impersonation: impersonation,
+		ExpiresAt:     currentTime.Add(tokens.ExpiresIn),
+		CreatedAt:     currentTime,
+	})
+	if err != nil {
+		log.Error("failed to audit token exchange", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if impersonation {
+		log.Warn("user impersonated", slog.String("jti", jti))
+	} else {
+		log.Info("token exchanged", slog.String("jti", jti))
+	}
+
+	return tokens, nil
+}
+
+// exchangedToken parses subject or actor token presented for exchange,
+// it must be active and issued to the requesting app
+func (a *Auth) exchangedToken(ctx context.Context, log *slog.Logger, client models.App, raw string) (*jwt.Token, error) {
+	params := a.tokenParams
+	params.Audience = client.ClientID()
+
+	token, err := jwt.Parse(raw, a.keys, params)
+	if err != nil {
+		log.Info("invalid token", sl.Err(err))
+		return nil, service.ErrInvalidGrant
+	}
+
+	revoked, err := a.tokenProvider.IsTokenRevoked(ctx, token.ID, token.UID, token.IssuedAt)
+	if err != nil {
+		log.Error("failed to check token revocation", sl.Err(err))
+		return nil, err
+	}
+	if revoked {
+		log.Info("token is revoked")
+		return nil, service.ErrInvalidGrant
+	}
+
+	return token, nil
+}
+
+// impersonatedUser returns user with given email if actor is an admin allowed to impersonate them.
+// Actor token must be issued to the admin directly, not exchanged, and must grant users:impersonate itself,
+// so a down-scoped token doesn't regain permissions of the admin
+func (a *Auth) impersonatedUser(ctx context.Context, log *slog.Logger, actor *jwt.Token, email string) (models.User, error) {
+	if actor == nil || actor.IsClient() {
+		log.Warn("impersonation requires actor token of admin")
+		return models.User{}, service.ErrAccessDenied
+	}
+	if actor.Act != nil {
+		log.Warn("actor token is exchanged", slog.String("actor", actor.Subject))
+		return models.User{}, service.ErrAccessDenied
+	}
+	if !actor.HasPermission(models.PermissionUsersImpersonate) || !scopeAllows(actor.Scope, models.PermissionUsersImpersonate) {
+		log.Warn("actor token doesn't grant impersonation", slog.String("actor", actor.Subject))
+		return models.User{}, service.ErrAccessDenied
+	}
+
+	actorAdmin, err := a.userProvider.Admin(ctx, actor.Email)
+	if err != nil {
+		if errors.Is(err, storage.ErrAdminNotFound) {
+			log.Warn("impersonation attempted by non-admin", slog.String("actor", actor.Subject))
+			return models.User{}, service.ErrAccessDenied
+		}
+
+		log.Error("failed to get admin", sl.Err(err))
+		return models.User{}, err
+	}
+	if !actorAdmin.HasPermission(models.PermissionUsersImpersonate) {
+		log.Warn("admin not allowed to impersonate", slog.String("actor", actor.Subject))
+		return models.User{}, service.ErrAccessDenied
+	}
+
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return models.User{}, service.ErrInvalidGrant
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, err
+	}
+
+	userAdmin, err := a.userProvider.Admin(ctx, email)
+	if err != nil && !errors.Is(err, storage.ErrAdminNotFound) {
+		log.Error("failed to get admin", sl.Err(err))
+		return models.User{}, err
+	}
+	if !outranks(actorAdmin, userAdmin) {
+		log.Warn("admin can't impersonate admin with the same or other permissions", slog.String("actor", actor.Subject))
+		return models.User{}, service.ErrAccessDenied
+	}
+
+	return user, nil
+}
+
+// scopeAllows reports whether token scope allows the permission, empty scope doesn't restrict the token
+func scopeAllows(scope string, permission string) bool {
+	return scope == "" || slices.Contains(strings.Fields(scope), permission)
+}
+
+// outranks reports whether actor has every permission of the user and at least one more
+func outranks(actor models.Admin, user models.Admin) bool {
+	for _, permission := range user.Permissions {
+		if !actor.HasPermission(permission) {
+			return false
+		}
+	}
+	return len(actor.Permissions) > len(user.Permissions)
+}
+
+// exchangedScope returns scope of exchanged token: requested scope must be a subset of subject token scope,
+// or, if subject token isn't restricted by scope, it must be allowed for the target app
+func exchangedScope(log *slog.Logger, target models.App, subjectScope, requested string) (string, error) {
+	if requested == "" {
+		return subjectScope, nil
+	}
+
+	if subjectScope == "" {
+		if !userScopeAllowed(log, target, requested) {
+			return "", service.ErrInvalidScope
+		}
+		return strings.Join(strings.Fields(requested), " "), nil
+	}
+
+	granted := strings.Fields(subjectScope)
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(granted, s) {
+			log.Warn("scope exceeds subject token scope", slog.String("scope", s))
+			return "", service.ErrInvalidScope
+		}
+	}
+
+	return strings.Join(strings.Fields(requested), " "), nil
+}
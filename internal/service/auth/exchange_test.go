@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage/sqlite"
+)
+
+const testClientSecret = "client-secret"
+
+// newTestClient saves confidential app authenticated with testClientSecret
+func newTestClient(t *testing.T, storage *sqlite.Storage) models.App {
+	t.Helper()
+
+	ctx := context.Background()
+
+	appID, err := storage.SaveApp(ctx, models.App{
+		Name:       "client",
+		ApiKey:     "client-api-key",
+		SecretHash: secret.Hash(testClientSecret),
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+
+	app, err := storage.AppByID(ctx, appID)
+	if err != nil {
+		t.Fatalf("failed to get app: %v", err)
+	}
+
+	return app
+}
+
+// newTestToken returns access token of the user for the app with roles the user has in storage
+func newTestToken(t *testing.T, a *Auth, storage *sqlite.Storage, user models.User, app models.App, scope string, duration time.Duration) string {
+	t.Helper()
+
+	admin, err := storage.Admin(context.Background(), user.Email)
+	if err != nil {
+		admin = models.Admin{}
+	}
+
+	token, err := jwt.NewToken(user, admin, models.AppRoles{}, app, scope, duration, a.keys, a.tokenParams)
+	if err != nil {
+		t.Fatalf("failed to generate token: %v", err)
+	}
+
+	return token
+}
+
+func TestImpersonationActorToken(t *testing.T) {
+	ctx := context.Background()
+
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{})
+	client := newTestClient(t, storage)
+
+	admin := newTestUser(t, storage, "admin@example.com")
+	if err := storage.AssignRole(ctx, admin.Email, 0, "superadmin"); err != nil {
+		t.Fatalf("failed to assign role: %v", err)
+	}
+	user := newTestUser(t, storage, "user@example.com")
+
+	adminToken := newTestToken(t, a, storage, admin, client, "", time.Hour)
+
+	exchanged, err := a.ExchangeToken(ctx, client.ClientID(), testClientSecret, adminToken, oauth.TokenTypeAccessToken, "", "", "")
+	if err != nil {
+		t.Fatalf("failed to exchange admin token: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		actorToken string
+		wantErr    error
+	}{
+		{
+			name:       "admin token",
+			actorToken: adminToken,
+		},
+		{
+			name:       "admin token scoped to impersonation",
+			actorToken: newTestToken(t, a, storage, admin, client, models.PermissionUsersImpersonate, time.Hour),
+		},
+		{
+			name:       "exchanged admin token",
+			actorToken: exchanged.AccessToken,
+			wantErr:    service.ErrAccessDenied,
+		},
+		{
+			name:       "admin token with narrower scope",
+			actorToken: newTestToken(t, a, storage, admin, client, "openid email", time.Hour),
+			wantErr:    service.ErrAccessDenied,
+		},
+		{
+			name:       "user token",
+			actorToken: newTestToken(t, a, storage, user, client, "", time.Hour),
+			wantErr:    service.ErrAccessDenied,
+		},
+		{
+			name:    "no actor token",
+			wantErr: service.ErrAccessDenied,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := a.ExchangeToken(ctx, client.ClientID(), testClientSecret, user.Email, oauth.TokenTypeEmail, tt.actorToken, "", "")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExchangeToken() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExchangeTokenRejectsTokenExpiredWithinLeeway(t *testing.T) {
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{})
+	a.tokenParams.Leeway = time.Minute
+	client := newTestClient(t, storage)
+	user := newTestUser(t, storage, "user@example.com")
+
+	// accepted by parsing thanks to leeway
+	subjectToken := newTestToken(t, a, storage, user, client, "", -10*time.Second)
+
+	_, err := a.ExchangeToken(context.Background(), client.ClientID(), testClientSecret, subjectToken, oauth.TokenTypeAccessToken, "", "", "")
+	if !errors.Is(err, service.ErrInvalidGrant) {
+		t.Fatalf("ExchangeToken() error = %v, want %v", err, service.ErrInvalidGrant)
+	}
+}
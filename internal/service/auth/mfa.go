@@ -0,0 +1,399 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/secret"
+	"sso/internal/lib/totp"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+const (
+	// mfaChallengeTTL is how long MFA token returned by Login can be exchanged for tokens
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeAttempts is how many codes can be tried with one MFA token
+	mfaChallengeAttempts = 5
+	// recoveryCodesCount is number of recovery codes generated when MFA is enabled
+	recoveryCodesCount = 10
+	// recoveryCodeBytes is length of random part of recovery code, 80 bits
+	recoveryCodeBytes = 10
+)
+
+// EnrollMFA generates a new TOTP secret for the user of the token and returns it with otpauth:// URI.
+// MFA isn't enabled until the first code is confirmed by ConfirmMFA
+//
+// If MFA is already enabled, returns error
+// If token was obtained by token exchange, returns error
+func (a *Auth) EnrollMFA(ctx context.Context, token *jwt.Token) (string, string, error) {
+	const op = "services.auth.EnrollMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to enroll mfa")
+
+	if token.Act != nil {
+		log.Warn("mfa can't be enrolled with exchanged token", slog.String("actor", token.Act.Subject))
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	mfaSecret, err := totp.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate mfa secret", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	err = a.mfaSaver.SaveMFASecret(ctx, models.MFA{
+		UserID:    token.UID,
+		Secret:    mfaSecret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAEnabled) {
+			log.Info("mfa already enabled", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrMFAEnabled)
+		}
+
+		log.Error("failed to save mfa secret", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("mfa enrolled")
+
+	return mfaSecret, totp.URI(a.mfaIssuer, token.Email, mfaSecret), nil
+}
+
+// ConfirmMFA enables MFA of the user of the token if code matches the enrolled secret
+// and returns new recovery codes, which are shown only once
+//
+// If MFA isn't enrolled, returns error
+// If MFA is already enabled, returns error
+// If code is invalid, returns error
+func (a *Auth) ConfirmMFA(ctx context.Context, token *jwt.Token, code string) ([]string, error) {
+	const op = "services.auth.ConfirmMFA"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to confirm mfa")
+
+	if token.Act != nil {
+		log.Warn("mfa can't be confirmed with exchanged token", slog.String("actor", token.Act.Subject))
+		return nil, fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	mfa, err := a.mfaProvider.MFA(ctx, token.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMFANotFound) {
+			log.Info("mfa not enrolled", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, service.ErrMFANotEnrolled)
+		}
+
+		log.Error("failed to get mfa", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if mfa.Enabled {
+		log.Info("mfa already enabled")
+		return nil, fmt.Errorf("%s: %w", op, service.ErrMFAEnabled)
+	}
+
+	step, ok := totp.Validate(mfa.Secret, code, time.Now())
+	if !ok {
+		log.Info("invalid mfa code")
+		return nil, fmt.Errorf("%s: %w", op, service.ErrInvalidMFACode)
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		log.Error("failed to generate recovery codes", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaSaver.EnableMFA(ctx, token.UID, step, hashes); err != nil {
+		if errors.Is(err, storage.ErrMFANotFound) {
+			log.Info("mfa already enabled or reset", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, service.ErrMFANotEnrolled)
+		}
+
+		log.Error("failed to enable mfa", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("mfa enabled")
+
+	return codes, nil
+}
+
+// VerifyMFA exchanges MFA token returned by Login and TOTP or recovery code
+// for access token with a new refresh token. MFA token is single-use and allows only a few attempts.
+// Invalid codes are counted as failed logins of the user, so they lock the account out as wrong passwords do
+//
+// If MFA token is invalid, expired or has no attempts left, returns error
+// If there were too many failed logins with the email or from client IP, returns error
+// If code is invalid, returns error
+func (a *Auth) VerifyMFA(ctx context.Context, mfaToken string, code string) (string, string, error) {
+	const op = "services.auth.VerifyMFA"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("attempting to verify mfa")
+
+	challenge, err := a.mfaProvider.MFAChallenge(ctx, secret.Hash(mfaToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Info("mfa challenge not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+
+		log.Error("failed to get mfa challenge", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", challenge.UserID), slog.Int("app_id", challenge.AppID))
+
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= mfaChallengeAttempts {
+		log.Info("mfa challenge expired or has no attempts left")
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkThrottle(ctx, log, user.Email, ""); err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFACode)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaSaver.AttemptMFAChallenge(ctx, challenge.ID); err != nil {
+		log.Error("failed to count mfa attempt", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	mfa, err := a.enabledMFA(ctx, log, challenge.UserID)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+	if mfa == nil {
+		log.Info("mfa was reset")
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+	}
+
+	if err := a.checkMFACode(ctx, log, *mfa, code); err != nil {
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			a.recordFailure(ctx, log, user.Email)
+		}
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.mfaSaver.DeleteMFAChallenge(ctx, challenge.ID); err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Info("mfa challenge already passed", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+
+		log.Error("failed to delete mfa challenge", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	a.resetFailures(ctx, log, user.Email)
+
+	app, err := a.appProvider.AppByID(ctx, challenge.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, refreshToken, err := a.loginTokens(ctx, log, user, app)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, refreshToken, nil
+}
+
+// checkSecondFactor checks code of the user with MFA enabled, it's used by sign in pages
+// that ask for credentials and code at once. Invalid code is counted as failed login,
+// and failed logins are forgotten only once the second factor is passed
+//
+// If MFA is enabled and code is empty, returns ErrMFARequired
+func (a *Auth) checkSecondFactor(ctx context.Context, log *slog.Logger, user models.User, code string) error {
+	mfa, err := a.enabledMFA(ctx, log, user.ID)
+	if err != nil {
+		return err
+	}
+	if mfa == nil {
+		a.resetFailures(ctx, log, user.Email)
+		return nil
+	}
+
+	if code == "" {
+		log.Info("mfa code required")
+		return service.ErrMFARequired
+	}
+
+	if err := a.checkMFACode(ctx, log, *mfa, code); err != nil {
+		if errors.Is(err, service.ErrInvalidMFACode) {
+			a.recordFailure(ctx, log, user.Email)
+		}
+		return err
+	}
+
+	a.resetFailures(ctx, log, user.Email)
+
+	return nil
+}
+
+// enabledMFA returns MFA of the user, or nil if it isn't enabled
+func (a *Auth) enabledMFA(ctx context.Context, log *slog.Logger, userID int64) (*models.MFA, error) {
+	mfa, err := a.mfaProvider.MFA(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrMFANotFound) {
+			return nil, nil
+		}
+
+		log.Error("failed to get mfa", sl.Err(err))
+		return nil, err
+	}
+	if !mfa.Enabled {
+		return nil, nil
+	}
+
+	return &mfa, nil
+}
+
+// checkMFACode accepts either TOTP code, which can't be reused, or unused recovery code
+func (a *Auth) checkMFACode(ctx context.Context, log *slog.Logger, mfa models.MFA, code string) error {
+	if len(code) == totp.Digits {
+		step, ok := totp.Validate(mfa.Secret, code, time.Now())
+		if !ok {
+			log.Info("invalid mfa code")
+			return service.ErrInvalidMFACode
+		}
+
+		if err := a.mfaSaver.UseMFAStep(ctx, mfa.UserID, step); err != nil {
+			if errors.Is(err, storage.ErrMFAStepUsed) {
+				log.Warn("mfa code reused", sl.Err(err))
+				return service.ErrInvalidMFACode
+			}
+
+			log.Error("failed to use mfa code", sl.Err(err))
+			return err
+		}
+
+		return nil
+	}
+
+	codes, err := a.mfaProvider.RecoveryCodes(ctx, mfa.UserID)
+	if err != nil {
+		log.Error("failed to get recovery codes", sl.Err(err))
+		return err
+	}
+
+	code = normalizeRecoveryCode(code)
+	index := slices.IndexFunc(codes, func(stored models.RecoveryCode) bool {
+		return secret.VerifySaltedHash(stored.Hash, code)
+	})
+	if index < 0 {
+		log.Info("invalid recovery code")
+		return service.ErrInvalidMFACode
+	}
+
+	if err := a.mfaSaver.UseRecoveryCode(ctx, codes[index].ID); err != nil {
+		if errors.Is(err, storage.ErrRecoveryCodeNotFound) {
+			log.Info("recovery code already used", sl.Err(err))
+			return service.ErrInvalidMFACode
+		}
+
+		log.Error("failed to use recovery code", sl.Err(err))
+		return err
+	}
+
+	log.Warn("recovery code used")
+
+	return nil
+}
+
+// newMFAChallenge saves a new MFA challenge of the user for the app and returns its token
+func (a *Auth) newMFAChallenge(ctx context.Context, userID int64, appID int) (string, error) {
+	mfaToken, err := secret.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	currentTime := time.Now()
+	err = a.mfaSaver.SaveMFAChallenge(ctx, models.MFAChallenge{
+		Hash:      secret.Hash(mfaToken),
+		UserID:    userID,
+		AppID:     appID,
+		ExpiresAt: currentTime.Add(mfaChallengeTTL),
+		CreatedAt: currentTime,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return mfaToken, nil
+}
+
+// newRecoveryCodes returns recovery codes in xxxx-xxxx-xxxx-xxxx form and their salted hashes.
+// Each code has 80 random bits
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodesCount)
+	hashes := make([]string, 0, recoveryCodesCount)
+
+	b := make([]byte, recoveryCodeBytes)
+	for i := 0; i < recoveryCodesCount; i++ {
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		hash, err := secret.SaltedHash(code)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code[:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:])
+		hashes = append(hashes, hash)
+	}
+
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode makes recovery code case insensitive and allows to omit separators
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,383 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oauth"
+	"sso/internal/lib/oidc"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// authCodeTTL is how long authorization code can be exchanged for tokens
+const authCodeTTL = time.Minute
+
+// Authorize checks user credentials and returns a single-use authorization code
+// for the app with given clientID. Code is bound to redirectURI and S256 codeChallenge.
+// Scope may contain OpenID Connect scopes and scopes registered for the app,
+// nonce is passed to ID token. If user has MFA enabled, mfaCode is required
+//
+// If app doesn't exist, returns error
+// If redirectURI isn't registered for the app, returns error
+// If scope isn't allowed, returns error
+// If credentials or MFA code are invalid, returns error
+func (a *Auth) Authorize(
+	ctx context.Context,
+	email string,
+	password string,
+	clientID string,
+	redirectURI string,
+	codeChallenge string,
+	scope string,
+	nonce string,
+	mfaCode string,
+) (string, error) {
+	const op = "services.auth.Authorize"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email), //optional
+		slog.String("client_id", clientID),
+	)
+
+	log.Info("attempting to authorize user")
+
+	app, err := a.appByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, service.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+		} else {
+			log.Error("failed to get app", sl.Err(err))
+		}
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	redirectURIs, err := a.appProvider.AppRedirectURIs(ctx, app.ID)
+	if err != nil {
+		log.Error("failed to get redirect uris", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+	if !slices.Contains(redirectURIs, redirectURI) {
+		log.Warn("redirect uri not registered", slog.String("redirect_uri", redirectURI))
+		return "", fmt.Errorf("%s: %w", op, service.ErrInvalidRedirectURI)
+	}
+
+	if !userScopeAllowed(log, app, scope) {
+		return "", fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+	}
+
+	user, err := a.checkCredentials(ctx, log, email, password)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkSecondFactor(ctx, log, user, mfaCode); err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	code, err := secret.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate code", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	family, err := secret.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate code", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now()
+	err = a.tokenSaver.SaveAuthCode(ctx, models.AuthCode{
+		Hash:          secret.Hash(code),
+		Family:        family,
+		AppID:         app.ID,
+		UserID:        user.ID,
+		RedirectURI:   redirectURI,
+		CodeChallenge: codeChallenge,
+		Scope:         strings.Join(strings.Fields(scope), " "),
+		Nonce:         nonce,
+		ExpiresAt:     currentTime.Add(authCodeTTL),
+		CreatedAt:     currentTime,
+	})
+	if err != nil {
+		log.Error("failed to save code", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user authorized")
+
+	return code, nil
+}
+
+// ExchangeCode exchanges authorization code for access and refresh tokens.
+// If openid scope was granted, ID token is issued as well
+//
+// Code must be presented by the same client with the same redirectURI,
+// and codeVerifier must match code challenge. If already used code is presented again,
+// refresh tokens issued for it are revoked and error is returned
+func (a *Auth) ExchangeCode(
+	ctx context.Context,
+	code string,
+	clientID string,
+	redirectURI string,
+	codeVerifier string,
+) (models.Tokens, error) {
+	const op = "services.auth.ExchangeCode"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	log.Info("attempting to exchange code")
+
+	stored, err := a.tokenProvider.AuthCode(ctx, secret.Hash(code))
+	if err != nil {
+		if errors.Is(err, storage.ErrAuthCodeNotFound) {
+			log.Info("code not found", sl.Err(err))
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+		}
+
+		log.Error("failed to get code", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", stored.UserID))
+
+	if stored.Used {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, a.revokeCodeFamily(ctx, log, stored.Family))
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		log.Info("code expired")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+	}
+
+	if strconv.Itoa(stored.AppID) != clientID || stored.RedirectURI != redirectURI {
+		log.Warn("code issued for another client or redirect uri")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+	}
+
+	if !oauth.VerifyCodeChallenge(codeVerifier, stored.CodeChallenge) {
+		log.Warn("code verifier doesn't match code challenge")
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidGrant)
+	}
+
+	if err := a.tokenSaver.UseAuthCode(ctx, stored.ID); err != nil {
+		if errors.Is(err, storage.ErrAuthCodeUsed) {
+			return models.Tokens{}, fmt.Errorf("%s: %w", op, a.revokeCodeFamily(ctx, log, stored.Family))
+		}
+
+		log.Error("failed to use code", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	tokens, err := a.userTokens(ctx, log, stored.UserID, stored.AppID, stored.Family, stored.Scope, stored.CreatedAt, stored.Nonce)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("code exchanged")
+
+	return tokens, nil
+}
+
+// ClientCredentials authenticates the app by client secret and returns access token
+// issued to the app itself with granted scope
+//
+// Empty scope grants all scopes registered for the app.
+// If any of requested scopes isn't registered for the app, returns error
+func (a *Auth) ClientCredentials(
+	ctx context.Context,
+	clientID string,
+	clientSecret string,
+	scope string,
+) (models.Tokens, error) {
+	const op = "services.auth.ClientCredentials"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("client_id", clientID),
+	)
+
+	log.Info("attempting to authenticate client")
+
+	app, err := a.authenticateClient(ctx, log, clientID, clientSecret)
+	if err != nil {
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	granted := app.Scopes
+	if scope != "" {
+		granted = strings.Fields(scope)
+		for _, s := range granted {
+			if !slices.Contains(app.Scopes, s) {
+				log.Warn("scope not registered for the app", slog.String("scope", s))
+				return models.Tokens{}, fmt.Errorf("%s: %w", op, service.ErrInvalidScope)
+			}
+		}
+	}
+	tokens := models.Tokens{
+		ExpiresIn: a.appTokenTTL(app),
+		Scope:     strings.Join(granted, " "),
+	}
+
+	tokens.AccessToken, err = jwt.NewClientToken(app, tokens.Scope, tokens.ExpiresIn, a.keys, a.tokenParams)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return models.Tokens{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("client authenticated")
+
+	return tokens, nil
+}
+
+// userTokens issues access and refresh tokens of the user for the app
+// with granted scope, and ID token if openid scope was granted.
+// If family is empty, refresh token starts a new family
+//
+// If user or app doesn't exist anymore, returns ErrInvalidGrant
+func (a *Auth) userTokens(
+	ctx context.Context,
+	log *slog.Logger,
+	userID int64,
+	appID int,
+	family string,
+	scope string,
+	authTime time.Time,
+	nonce string,
+) (models.Tokens, error) {
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return models.Tokens{}, service.ErrInvalidGrant
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return models.Tokens{}, err
+	}
+
+	app, err := a.appProvider.AppByID(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found", sl.Err(err))
+			return models.Tokens{}, service.ErrInvalidGrant
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return models.Tokens{}, err
+	}
+
+	admin, appRoles, err := a.tokenRoles(ctx, log, user, app)
+	if err != nil {
+		return models.Tokens{}, err
+	}
+
+	tokens := models.Tokens{
+		ExpiresIn: a.appTokenTTL(app),
+		Scope:     scope,
+	}
+
+	tokens.AccessToken, err = jwt.NewToken(user, admin, appRoles, app, scope, tokens.ExpiresIn, a.keys, a.tokenParams)
+	if err != nil {
+		log.Error("failed to generate token", sl.Err(err))
+		return models.Tokens{}, err
+	}
+
+	tokens.RefreshToken, err = a.newRefreshToken(ctx, user.ID, app.ID, family, scope)
+	if err != nil {
+		log.Error("failed to generate refresh token", sl.Err(err))
+		return models.Tokens{}, err
+	}
+
+	scopes := strings.Fields(scope)
+	if slices.Contains(scopes, oidc.ScopeOpenID) {
+		tokens.IDToken, err = jwt.NewIDToken(user, app, scopes, authTime, nonce, tokens.ExpiresIn, a.keys, a.tokenParams)
+		if err != nil {
+			log.Error("failed to generate id token", sl.Err(err))
+			return models.Tokens{}, err
+		}
+	}
+
+	return tokens, nil
+}
+
+// userScopeAllowed reports whether scope requested on behalf of the user contains only
+// OpenID Connect scopes and scopes registered for the app
+func userScopeAllowed(log *slog.Logger, app models.App, scope string) bool {
+	for _, s := range strings.Fields(scope) {
+		if !slices.Contains(oidc.Scopes, s) && !slices.Contains(app.Scopes, s) {
+			log.Warn("scope not allowed", slog.String("scope", s))
+			return false
+		}
+	}
+	return true
+}
+
+// authenticateClient returns confidential app identified by clientID if clientSecret matches
+//
+// If app doesn't exist or secret doesn't match, returns ErrInvalidClient
+func (a *Auth) authenticateClient(ctx context.Context, log *slog.Logger, clientID, clientSecret string) (models.App, error) {
+	app, err := a.appByClientID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, service.ErrAppNotFound) {
+			log.Warn("app not found", sl.Err(err))
+			return models.App{}, service.ErrInvalidClient
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return models.App{}, err
+	}
+
+	if app.SecretHash == "" || subtle.ConstantTimeCompare([]byte(app.SecretHash), []byte(secret.Hash(clientSecret))) != 1 {
+		log.Warn("invalid client secret")
+		return models.App{}, service.ErrInvalidClient
+	}
+
+	return app, nil
+}
+
+// appByClientID returns app identified by OAuth client ID
+func (a *Auth) appByClientID(ctx context.Context, clientID string) (models.App, error) {
+	appID, err := strconv.Atoi(clientID)
+	if err != nil {
+		return models.App{}, service.ErrAppNotFound
+	}
+
+	app, err := a.appProvider.AppByID(ctx, appID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			return models.App{}, service.ErrAppNotFound
+		}
+		return models.App{}, err
+	}
+
+	return app, nil
+}
+
+// revokeCodeFamily revokes refresh tokens issued for authorization code after its reuse was detected
+func (a *Auth) revokeCodeFamily(ctx context.Context, log *slog.Logger, family string) error {
+	log.Warn("authorization code reuse detected, revoking issued tokens")
+
+	if err := a.tokenSaver.RevokeRefreshFamily(ctx, family); err != nil {
+		log.Error("failed to revoke token family", sl.Err(err))
+		return err
+	}
+
+	return service.ErrInvalidGrant
+}
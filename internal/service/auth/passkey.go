@@ -0,0 +1,520 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// passkeySessionTTL is how long WebAuthn ceremony can be finished after it's started
+const passkeySessionTTL = 5 * time.Minute
+
+// BeginPasskeyRegistration starts registration of a new passkey of the user of the token
+// and returns session id with credential creation options for navigator.credentials.create.
+// Passkeys are registered as discoverable credentials, so they can be used for passwordless login
+//
+// If token was obtained by token exchange, returns error
+func (a *Auth) BeginPasskeyRegistration(ctx context.Context, token *jwt.Token) (string, []byte, error) {
+	const op = "services.auth.BeginPasskeyRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to begin passkey registration")
+
+	if token.Act != nil {
+		log.Warn("passkey can't be registered with exchanged token", slog.String("actor", token.Act.Subject))
+		return "", nil, fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	user, err := a.passkeyUser(ctx, log, token.UID)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(user.passkeys))
+	for _, credential := range user.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+
+	creation, data, err := a.webAuthn.BeginRegistration(
+		user,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(exclusions),
+	)
+	if err != nil {
+		log.Error("failed to begin registration", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessionID, options, err := a.newPasskeySession(ctx, models.PasskeyRegistration, user.user.ID, 0, 0, data, creation)
+	if err != nil {
+		log.Error("failed to save passkey session", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registration started")
+
+	return sessionID, options, nil
+}
+
+// FinishPasskeyRegistration verifies credential returned by navigator.credentials.create
+// for registration started by BeginPasskeyRegistration and saves it as a passkey of the user
+//
+// If session is invalid, expired or belongs to another user, returns error
+// If credential is invalid or already registered, returns error
+func (a *Auth) FinishPasskeyRegistration(ctx context.Context, token *jwt.Token, sessionID string, credential []byte) error {
+	const op = "services.auth.FinishPasskeyRegistration"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to finish passkey registration")
+
+	if token.Act != nil {
+		log.Warn("passkey can't be registered with exchanged token", slog.String("actor", token.Act.Subject))
+		return fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	session, data, err := a.usePasskeySession(ctx, log, sessionID, models.PasskeyRegistration)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if session.UserID != token.UID {
+		log.Warn("passkey session belongs to another user")
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidPasskeySession)
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		log.Info("failed to parse credential", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+	}
+
+	user, err := a.passkeyUser(ctx, log, token.UID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	created, err := a.webAuthn.CreateCredential(user, data, parsed)
+	if err != nil {
+		log.Info("invalid credential", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+	}
+
+	transports := make([]string, 0, len(created.Transport))
+	for _, transport := range created.Transport {
+		transports = append(transports, string(transport))
+	}
+
+	err = a.passkeySaver.SavePasskey(ctx, models.Passkey{
+		UserID:          token.UID,
+		CredentialID:    created.ID,
+		PublicKey:       created.PublicKey,
+		AttestationType: created.AttestationType,
+		AAGUID:          created.Authenticator.AAGUID,
+		SignCount:       created.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  created.Flags.BackupEligible,
+		BackupState:     created.Flags.BackupState,
+		CreatedAt:       time.Now(),
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyExists) {
+			log.Info("passkey already registered", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPasskeyExists)
+		}
+
+		log.Error("failed to save passkey", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey registered")
+
+	return nil
+}
+
+// BeginPasskeyLogin starts login with passkey and returns session id with credential request options
+// for navigator.credentials.get
+//
+// If mfaToken returned by Login is given, passkey is used as second factor of that login instead of code,
+// otherwise login is passwordless for the app with given appID: the user is identified by discoverable passkey,
+// which must verify the user (PIN or biometrics), so it's not asked for a code
+//
+// If app doesn't exist, returns error
+// If MFA token is invalid, expired or has no attempts left, returns error
+// If user of MFA token has no passkeys, returns error
+func (a *Auth) BeginPasskeyLogin(ctx context.Context, appID int, mfaToken string) (string, []byte, error) {
+	const op = "services.auth.BeginPasskeyLogin"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+	)
+
+	log.Info("attempting to begin passkey login")
+
+	if mfaToken == "" {
+		if _, err := a.appProvider.AppByID(ctx, appID); err != nil {
+			if errors.Is(err, storage.ErrAppNotFound) {
+				log.Warn("app not found", sl.Err(err))
+				return "", nil, fmt.Errorf("%s: %w", op, service.ErrAppNotFound)
+			}
+
+			log.Error("failed to get app", sl.Err(err))
+			return "", nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		assertion, data, err := a.webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+		if err != nil {
+			log.Error("failed to begin login", sl.Err(err))
+			return "", nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		sessionID, options, err := a.newPasskeySession(ctx, models.PasskeyLogin, 0, appID, 0, data, assertion)
+		if err != nil {
+			log.Error("failed to save passkey session", sl.Err(err))
+			return "", nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Info("passwordless login started")
+
+		return sessionID, options, nil
+	}
+
+	challenge, err := a.mfaProvider.MFAChallenge(ctx, secret.Hash(mfaToken))
+	if err != nil {
+		if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+			log.Info("mfa challenge not found", sl.Err(err))
+			return "", nil, fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+
+		log.Error("failed to get mfa challenge", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", challenge.UserID), slog.Int("app_id", challenge.AppID))
+
+	if time.Now().After(challenge.ExpiresAt) || challenge.Attempts >= mfaChallengeAttempts {
+		log.Info("mfa challenge expired or has no attempts left")
+		return "", nil, fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+	}
+
+	if err := a.mfaSaver.AttemptMFAChallenge(ctx, challenge.ID); err != nil {
+		log.Error("failed to count mfa attempt", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	user, err := a.passkeyUser(ctx, log, challenge.UserID)
+	if err != nil {
+		if errors.Is(err, service.ErrUserNotFound) {
+			return "", nil, fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+		}
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if len(user.passkeys) == 0 {
+		log.Info("user has no passkeys")
+		return "", nil, fmt.Errorf("%s: %w", op, service.ErrNoPasskeys)
+	}
+
+	assertion, data, err := a.webAuthn.BeginLogin(user)
+	if err != nil {
+		log.Error("failed to begin login", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	sessionID, options, err := a.newPasskeySession(ctx, models.PasskeyLogin, user.user.ID, challenge.AppID, challenge.ID, data, assertion)
+	if err != nil {
+		log.Error("failed to save passkey session", sl.Err(err))
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("passkey second factor started")
+
+	return sessionID, options, nil
+}
+
+// FinishPasskeyLogin verifies credential returned by navigator.credentials.get for login
+// started by BeginPasskeyLogin and returns access token with a new refresh token.
+// If passkey was used as second factor, MFA token of the login can't be used anymore
+//
+// If session is invalid or expired, returns error
+// If credential is invalid, unknown or looks cloned, returns error
+// If email isn't verified and verified email is required, returns error
+func (a *Auth) FinishPasskeyLogin(ctx context.Context, sessionID string, credential []byte) (string, string, error) {
+	const op = "services.auth.FinishPasskeyLogin"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("attempting to finish passkey login")
+
+	session, data, err := a.usePasskeySession(ctx, log, sessionID, models.PasskeyLogin)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int("app_id", session.AppID))
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(credential))
+	if err != nil {
+		log.Info("failed to parse credential", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+	}
+
+	var user *passkeyUser
+	var validated *webauthn.Credential
+	if session.UserID != 0 {
+		user, err = a.passkeyUser(ctx, log, session.UserID)
+		if err != nil {
+			if errors.Is(err, service.ErrUserNotFound) {
+				return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+			}
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		validated, err = a.webAuthn.ValidateLogin(user, data, parsed)
+	} else {
+		validated, err = a.webAuthn.ValidateDiscoverableLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			userID, err := strconv.ParseInt(string(userHandle), 10, 64)
+			if err != nil {
+				return nil, err
+			}
+
+			user, err = a.passkeyUser(ctx, log, userID)
+			return user, err
+		}, data, parsed)
+	}
+	if err != nil {
+		log.Info("invalid credential", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+	}
+
+	log = log.With(slog.Int64("uid", user.user.ID))
+
+	if err := a.checkVerified(ctx, log, user.user); err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if validated.Authenticator.CloneWarning {
+		log.Warn("passkey signature counter didn't increase, authenticator may be cloned")
+		return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+	}
+
+	err = a.passkeySaver.UsePasskey(ctx, validated.ID, validated.Authenticator.SignCount, validated.Flags.BackupState, time.Now())
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeyNotFound) {
+			log.Info("passkey was deleted", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidPasskey)
+		}
+
+		log.Error("failed to update passkey", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if session.MFAChallengeID != 0 {
+		if err := a.mfaSaver.DeleteMFAChallenge(ctx, session.MFAChallengeID); err != nil {
+			if errors.Is(err, storage.ErrMFAChallengeNotFound) {
+				log.Info("mfa challenge already passed", sl.Err(err))
+				return "", "", fmt.Errorf("%s: %w", op, service.ErrInvalidMFAToken)
+			}
+
+			log.Error("failed to delete mfa challenge", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	app, err := a.appProvider.AppByID(ctx, session.AppID)
+	if err != nil {
+		if errors.Is(err, storage.ErrAppNotFound) {
+			log.Info("app not found", sl.Err(err))
+			return "", "", fmt.Errorf("%s: %w", op, service.ErrAppNotFound)
+		}
+
+		log.Error("failed to get app", sl.Err(err))
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	token, refreshToken, err := a.loginTokens(ctx, log, user.user, app)
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, refreshToken, nil
+}
+
+// newPasskeySession saves state of started WebAuthn ceremony and returns its id with options encoded in JSON
+func (a *Auth) newPasskeySession(
+	ctx context.Context,
+	ceremony string,
+	userID int64,
+	appID int,
+	mfaChallengeID int64,
+	data *webauthn.SessionData,
+	options any,
+) (string, []byte, error) {
+	encodedData, err := json.Marshal(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	encodedOptions, err := json.Marshal(options)
+	if err != nil {
+		return "", nil, err
+	}
+
+	sessionID, err := secret.GenerateSecret()
+	if err != nil {
+		return "", nil, err
+	}
+
+	currentTime := time.Now()
+	err = a.passkeySaver.SavePasskeySession(ctx, models.PasskeySession{
+		Hash:           secret.Hash(sessionID),
+		Ceremony:       ceremony,
+		UserID:         userID,
+		AppID:          appID,
+		MFAChallengeID: mfaChallengeID,
+		Data:           encodedData,
+		ExpiresAt:      currentTime.Add(passkeySessionTTL),
+		CreatedAt:      currentTime,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return sessionID, encodedOptions, nil
+}
+
+// usePasskeySession returns state of WebAuthn ceremony and deletes it, so it can be finished only once
+func (a *Auth) usePasskeySession(
+	ctx context.Context,
+	log *slog.Logger,
+	sessionID string,
+	ceremony string,
+) (models.PasskeySession, webauthn.SessionData, error) {
+	session, err := a.passkeyProvider.PasskeySession(ctx, secret.Hash(sessionID))
+	if err != nil {
+		if errors.Is(err, storage.ErrPasskeySessionNotFound) {
+			log.Info("passkey session not found", sl.Err(err))
+			return models.PasskeySession{}, webauthn.SessionData{}, service.ErrInvalidPasskeySession
+		}
+
+		log.Error("failed to get passkey session", sl.Err(err))
+		return models.PasskeySession{}, webauthn.SessionData{}, err
+	}
+
+	if err := a.passkeySaver.DeletePasskeySession(ctx, session.ID); err != nil {
+		if errors.Is(err, storage.ErrPasskeySessionNotFound) {
+			log.Info("passkey session already finished", sl.Err(err))
+			return models.PasskeySession{}, webauthn.SessionData{}, service.ErrInvalidPasskeySession
+		}
+
+		log.Error("failed to delete passkey session", sl.Err(err))
+		return models.PasskeySession{}, webauthn.SessionData{}, err
+	}
+
+	if session.Ceremony != ceremony || time.Now().After(session.ExpiresAt) {
+		log.Info("passkey session expired or started for another ceremony", slog.String("ceremony", session.Ceremony))
+		return models.PasskeySession{}, webauthn.SessionData{}, service.ErrInvalidPasskeySession
+	}
+
+	var data webauthn.SessionData
+	if err := json.Unmarshal(session.Data, &data); err != nil {
+		log.Error("failed to decode passkey session", sl.Err(err))
+		return models.PasskeySession{}, webauthn.SessionData{}, err
+	}
+
+	return session, data, nil
+}
+
+// passkeyUser returns user with their passkeys
+//
+// If user doesn't exist, returns ErrUserNotFound
+func (a *Auth) passkeyUser(ctx context.Context, log *slog.Logger, userID int64) (*passkeyUser, error) {
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return nil, service.ErrUserNotFound
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return nil, err
+	}
+
+	passkeys, err := a.passkeyProvider.Passkeys(ctx, userID)
+	if err != nil {
+		log.Error("failed to get passkeys", sl.Err(err))
+		return nil, err
+	}
+
+	return &passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// passkeyUser is the user as seen by WebAuthn, user handle is decimal user id
+type passkeyUser struct {
+	user     models.User
+	passkeys []models.Passkey
+}
+
+func (u *passkeyUser) WebAuthnID() []byte {
+	return []byte(strconv.FormatInt(u.user.ID, 10))
+}
+
+func (u *passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnDisplayName() string {
+	return u.user.Email
+}
+
+func (u *passkeyUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.passkeys))
+	for _, passkey := range u.passkeys {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(passkey.Transports))
+		for _, transport := range passkey.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+
+		credentials = append(credentials, webauthn.Credential{
+			ID:              passkey.CredentialID,
+			PublicKey:       passkey.PublicKey,
+			AttestationType: passkey.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: passkey.BackupEligible,
+				BackupState:    passkey.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    passkey.AAGUID,
+				SignCount: passkey.SignCount,
+			},
+		})
+	}
+
+	return credentials
+}
@@ -0,0 +1,300 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"testing"
+
+	"sso/internal/lib/jwt"
+	"sso/internal/service"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+// virtualAuthenticator is a software platform authenticator with a single ES256 credential,
+// it answers navigator.credentials.create and navigator.credentials.get options like a browser would
+type virtualAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	// userVerified sets UV flag, as if user entered PIN or used biometrics
+	userVerified bool
+}
+
+func newVirtualAuthenticator(t *testing.T, userVerified bool) *virtualAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate credential key: %v", err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatalf("failed to generate credential id: %v", err)
+	}
+
+	return &virtualAuthenticator{key: key, credentialID: credentialID, userVerified: userVerified}
+}
+
+// create makes credential for creation options and returns it as the browser serializes PublicKeyCredential
+func (v *virtualAuthenticator) create(t *testing.T, options []byte) []byte {
+	t.Helper()
+
+	var creation protocol.CredentialCreation
+	if err := json.Unmarshal(options, &creation); err != nil {
+		t.Fatalf("failed to parse creation options: %v", err)
+	}
+
+	userHandle, ok := creation.Response.User.ID.(string)
+	if !ok {
+		t.Fatalf("unexpected user id %v", creation.Response.User.ID)
+	}
+	// user id is sent base64url encoded
+	handle, err := base64.RawURLEncoding.DecodeString(userHandle)
+	if err != nil {
+		t.Fatalf("failed to decode user id: %v", err)
+	}
+	v.userHandle = handle
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{
+			KeyType:   int64(webauthncose.EllipticKey),
+			Algorithm: int64(webauthncose.AlgES256),
+		},
+		Curve:  int64(webauthncose.P256),
+		XCoord: v.key.X.FillBytes(make([]byte, 32)),
+		YCoord: v.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("failed to encode public key: %v", err)
+	}
+
+	authData := v.authenticatorData(creation.Response.RelyingParty.ID, protocol.FlagAttestedCredentialData)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(v.credentialID)))
+	authData = append(authData, v.credentialID...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode attestation object: %v", err)
+	}
+
+	return v.credential(t, map[string]string{
+		"clientDataJSON":    encode(clientData(t, protocol.CreateCeremony, creation.Response.Challenge)),
+		"attestationObject": encode(attestation),
+	})
+}
+
+// get signs assertion for request options and returns it as the browser serializes PublicKeyCredential
+func (v *virtualAuthenticator) get(t *testing.T, options []byte) []byte {
+	t.Helper()
+
+	var assertion protocol.CredentialAssertion
+	if err := json.Unmarshal(options, &assertion); err != nil {
+		t.Fatalf("failed to parse request options: %v", err)
+	}
+
+	v.signCount++
+
+	authData := v.authenticatorData(assertion.Response.RelyingPartyID, 0)
+	clientDataJSON := clientData(t, protocol.AssertCeremony, assertion.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, v.key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign assertion: %v", err)
+	}
+
+	return v.credential(t, map[string]string{
+		"clientDataJSON":    encode(clientDataJSON),
+		"authenticatorData": encode(authData),
+		"signature":         encode(signature),
+		"userHandle":        encode(v.userHandle),
+	})
+}
+
+// authenticatorData returns rpIdHash, flags and signCount, user is always present
+func (v *virtualAuthenticator) authenticatorData(rpID string, flags protocol.AuthenticatorFlags) []byte {
+	flags |= protocol.FlagUserPresent
+	if v.userVerified {
+		flags |= protocol.FlagUserVerified
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	data := append(rpIDHash[:], byte(flags))
+	return binary.BigEndian.AppendUint32(data, v.signCount)
+}
+
+func (v *virtualAuthenticator) credential(t *testing.T, response map[string]string) []byte {
+	t.Helper()
+
+	credential, err := json.Marshal(map[string]any{
+		"id":       encode(v.credentialID),
+		"rawId":    encode(v.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode credential: %v", err)
+	}
+
+	return credential
+}
+
+func clientData(t *testing.T, ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      string(ceremony),
+		"challenge": encode(challenge),
+		"origin":    testOrigin,
+	})
+	if err != nil {
+		t.Fatalf("failed to encode client data: %v", err)
+	}
+
+	return data
+}
+
+func encode(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// registerPasskey runs registration ceremony of the authenticator for the user
+func registerPasskey(t *testing.T, a *Auth, token *jwt.Token, authenticator *virtualAuthenticator) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	sessionID, options, err := a.BeginPasskeyRegistration(ctx, token)
+	if err != nil {
+		t.Fatalf("BeginPasskeyRegistration: %v", err)
+	}
+
+	if err := a.FinishPasskeyRegistration(ctx, token, sessionID, authenticator.create(t, options)); err != nil {
+		t.Fatalf("FinishPasskeyRegistration: %v", err)
+	}
+}
+
+// loginWithPasskey runs login ceremony of the authenticator, empty mfaToken means passwordless login
+func loginWithPasskey(t *testing.T, a *Auth, appID int, mfaToken string, authenticator *virtualAuthenticator) (string, string, error) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	sessionID, options, err := a.BeginPasskeyLogin(ctx, appID, mfaToken)
+	if err != nil {
+		t.Fatalf("BeginPasskeyLogin: %v", err)
+	}
+
+	return a.FinishPasskeyLogin(ctx, sessionID, authenticator.get(t, options))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{})
+	user := newTestUser(t, storage, "passkey@example.com")
+	appID := newTestApp(t, storage)
+
+	authenticator := newVirtualAuthenticator(t, true)
+	registerPasskey(t, a, &jwt.Token{UID: user.ID, Email: user.Email}, authenticator)
+
+	if got, want := string(authenticator.userHandle), strconv.FormatInt(user.ID, 10); got != want {
+		t.Fatalf("user handle = %q, want %q", got, want)
+	}
+
+	passkeys, err := storage.Passkeys(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("failed to get passkeys: %v", err)
+	}
+	if len(passkeys) != 1 {
+		t.Fatalf("got %d passkeys, want 1", len(passkeys))
+	}
+
+	token, refreshToken, err := loginWithPasskey(t, a, appID, "", authenticator)
+	if err != nil {
+		t.Fatalf("passwordless login: %v", err)
+	}
+	if token == "" || refreshToken == "" {
+		t.Fatal("passwordless login returned empty tokens")
+	}
+
+	mfaToken, err := a.newMFAChallenge(context.Background(), user.ID, appID)
+	if err != nil {
+		t.Fatalf("failed to create mfa challenge: %v", err)
+	}
+
+	if _, _, err := loginWithPasskey(t, a, appID, mfaToken, authenticator); err != nil {
+		t.Fatalf("passkey second factor: %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{})
+	user := newTestUser(t, storage, "clone@example.com")
+	appID := newTestApp(t, storage)
+
+	authenticator := newVirtualAuthenticator(t, true)
+	registerPasskey(t, a, &jwt.Token{UID: user.ID, Email: user.Email}, authenticator)
+
+	authenticator.signCount = 5
+	if _, _, err := loginWithPasskey(t, a, appID, "", authenticator); err != nil {
+		t.Fatalf("passwordless login: %v", err)
+	}
+
+	// a clone of the authenticator continues from an older counter
+	authenticator.signCount = 2
+	_, _, err := loginWithPasskey(t, a, appID, "", authenticator)
+	if !errors.Is(err, service.ErrInvalidPasskey) {
+		t.Fatalf("login with regressed sign count: got %v, want %v", err, service.ErrInvalidPasskey)
+	}
+}
+
+func TestPasswordlessLoginRequiresUserVerification(t *testing.T) {
+	storage := newTestStorage(t)
+	a := newTestAuth(t, storage, nil, VerificationParams{})
+	user := newTestUser(t, storage, "uv@example.com")
+	appID := newTestApp(t, storage)
+
+	authenticator := newVirtualAuthenticator(t, false)
+	registerPasskey(t, a, &jwt.Token{UID: user.ID, Email: user.Email}, authenticator)
+
+	_, _, err := loginWithPasskey(t, a, appID, "", authenticator)
+	if !errors.Is(err, service.ErrInvalidPasskey) {
+		t.Fatalf("passwordless login without user verification: got %v, want %v", err, service.ErrInvalidPasskey)
+	}
+
+	// as a second factor the passkey only proves possession, password was already checked
+	mfaToken, err := a.newMFAChallenge(context.Background(), user.ID, appID)
+	if err != nil {
+		t.Fatalf("failed to create mfa challenge: %v", err)
+	}
+	if _, _, err := loginWithPasskey(t, a, appID, mfaToken, authenticator); err != nil {
+		t.Fatalf("passkey second factor without user verification: %v", err)
+	}
+
+	authenticator.userVerified = true
+	if _, _, err := loginWithPasskey(t, a, appID, "", authenticator); err != nil {
+		t.Fatalf("passwordless login with user verification: %v", err)
+	}
+}
@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/mail"
+	"sso/internal/lib/secret"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// mailTimeout limits sending of emails which are sent after the response
+const mailTimeout = 30 * time.Second
+
+// PasswordResetParams configures password reset
+type PasswordResetParams struct {
+	// URL of the password reset page, token is added to its query
+	URL string
+	// TTL is how long reset link is valid
+	TTL time.Duration
+}
+
+// RequestPasswordReset sends password reset link to the user with given email.
+// Result doesn't depend on whether the user exists, and the user is looked up, the reset is saved
+// and the link is sent in background, so response time doesn't reveal it either
+//
+// If reset URL is invalid, returns error
+func (a *Auth) RequestPasswordReset(ctx context.Context, email string) error {
+	const op = "services.auth.RequestPasswordReset"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("requesting password reset")
+
+	link, err := url.Parse(a.passwordReset.URL)
+	if err != nil {
+		log.Error("failed to parse reset url", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		a.sendPasswordReset(ctx, log, email, link)
+	}()
+
+	return nil
+}
+
+// sendPasswordReset saves password reset of the user with given email and sends the link with its token.
+// It runs after the response, so failures are only logged
+func (a *Auth) sendPasswordReset(ctx context.Context, log *slog.Logger, email string, link *url.URL) {
+	user, err := a.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return
+	}
+
+	log = log.With(slog.Int64("uid", user.ID))
+
+	token, err := secret.GenerateSecret()
+	if err != nil {
+		log.Error("failed to generate reset token", sl.Err(err))
+		return
+	}
+
+	currentTime := time.Now()
+	err = a.tokenSaver.SavePasswordReset(ctx, models.PasswordReset{
+		Hash:      secret.Hash(token),
+		UserID:    user.ID,
+		ExpiresAt: currentTime.Add(a.passwordReset.TTL),
+		CreatedAt: currentTime,
+	})
+	if err != nil {
+		log.Error("failed to save password reset", sl.Err(err))
+		return
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Follow the link to set a new password:\n\n" + link.String() + "\n\n" +
+			"The link expires in " + a.passwordReset.TTL.String() + ". If you didn't ask to reset your password, ignore this email.",
+	}
+	if err := a.mailer.Send(ctx, msg); err != nil {
+		log.Error("failed to send email", sl.Err(err), slog.String("subject", msg.Subject))
+		return
+	}
+
+	log.Info("email sent", slog.String("subject", msg.Subject))
+}
+
+// ResetPassword sets new password of the user by token from password reset link.
+// Token can be used once, and all sessions of the user are revoked
+//
+// If token is invalid, expired or already used, returns error
+// If password fails password policy, returns error
+func (a *Auth) ResetPassword(ctx context.Context, token string, password string) error {
+	const op = "services.auth.ResetPassword"
+
+	log := a.log.With(slog.String("op", op))
+
+	log.Info("attempting to reset password")
+
+	reset, err := a.tokenProvider.PasswordReset(ctx, secret.Hash(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			log.Info("password reset not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidResetToken)
+		}
+
+		log.Error("failed to get password reset", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log = log.With(slog.Int64("uid", reset.UserID))
+
+	if time.Now().After(reset.ExpiresAt) {
+		log.Info("password reset expired")
+		return fmt.Errorf("%s: %w", op, service.ErrInvalidResetToken)
+	}
+
+	user, err := a.userProvider.UserByID(ctx, reset.UserID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidResetToken)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkPolicy(log, password, user.Email); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.passwordHasher.Hash(password)
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// access tokens can't outlive the service token TTL, apps may only shorten it
+	currentTime := time.Now()
+	if err := a.userChanger.ResetPassword(ctx, reset.ID, reset.UserID, passHash, currentTime, a.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			log.Info("password reset already used", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidResetToken)
+		}
+
+		log.Error("failed to reset password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password reset, sessions revoked")
+
+	return nil
+}
+
+// ChangePassword sets new password of the user the token is issued to, if current password is correct.
+// All sessions of the user are revoked, including the one of the token, and the user is notified by email
+//
+// If current password is incorrect, returns error
+// If there were too many failed logins with the email or from client IP, returns error
+// If new password fails password policy, returns error
+// If token is exchanged, returns error
+func (a *Auth) ChangePassword(ctx context.Context, token *jwt.Token, currentPassword string, newPassword string) error {
+	const op = "services.auth.ChangePassword"
+
+	log := a.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("attempting to change password")
+
+	if token.Act != nil {
+		log.Warn("password can't be changed with exchanged token", slog.String("actor", token.Act.Subject))
+		return fmt.Errorf("%s: %w", op, service.ErrAccessDenied)
+	}
+
+	user, err := a.checkPassword(ctx, log, token.UID, currentPassword)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := a.checkPolicy(log, newPassword, user.Email); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	passHash, err := a.passwordHasher.Hash(newPassword)
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now()
+	if err := a.userChanger.ChangePassword(ctx, user.ID, passHash, currentTime, a.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrInvalidCredentials)
+		}
+
+		log.Error("failed to change password", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("password changed, sessions revoked")
+
+	a.sendInBackground(ctx, log, mail.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    "Password of your account was changed and all sessions were signed out. If it wasn't you, reset your password right away.",
+	})
+
+	return nil
+}
+
+// checkPolicy returns PasswordPolicyError if new password of the user with given email fails password policy
+func (a *Auth) checkPolicy(log *slog.Logger, password string, email string) error {
+	violations := a.passwordPolicy.Check(password, email)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	log.Info("password fails policy", slog.Any("violations", violations))
+
+	return &service.PasswordPolicyError{Violations: violations}
+}
+
+// checkPassword returns the user with given ID if password is correct, it guards changes of credentials.
+// Failures are counted and throttled along with logins, so password can't be guessed here instead
+func (a *Auth) checkPassword(ctx context.Context, log *slog.Logger, userID int64, password string) (models.User, error) {
+	user, err := a.userProvider.UserByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("user not found", sl.Err(err))
+			return models.User{}, service.ErrInvalidCredentials
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return models.User{}, err
+	}
+
+	if err := a.checkThrottle(ctx, log, user.Email, password); err != nil {
+		return models.User{}, err
+	}
+
+	if err := a.verifyPassword(ctx, log, user, password); err != nil {
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			a.recordFailure(ctx, log, user.Email)
+		}
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// verifyPassword returns ErrInvalidCredentials if password doesn't match hash of the user.
+// Hashes of earlier algorithms or params are replaced once password is known to match
+func (a *Auth) verifyPassword(ctx context.Context, log *slog.Logger, user models.User, password string) error {
+	ok, rehash, err := a.passwordHasher.Verify(user.PassHash, password)
+	if err != nil {
+		log.Error("failed to verify password", sl.Err(err))
+		return err
+	}
+	if !ok {
+		log.Info("invalid password")
+		return service.ErrInvalidCredentials
+	}
+
+	if !rehash {
+		return nil
+	}
+
+	passHash, err := a.passwordHasher.Hash(password)
+	if err != nil {
+		log.Error("failed to generate password hash", sl.Err(err))
+		return nil
+	}
+
+	// failed rehash doesn't affect the current login, it's retried on the next one
+	if err := a.userChanger.UpdatePassHash(ctx, user.ID, user.PassHash, passHash); err != nil {
+		log.Warn("failed to update password hash", sl.Err(err))
+		return nil
+	}
+
+	log.Info("password rehashed")
+
+	return nil
+}
+
+// verifyDummyPassword verifies password against hash of no user, so it takes as long as verifyPassword.
+// Hash is computed on first use with the current params
+func (a *Auth) verifyDummyPassword(log *slog.Logger, password string) {
+	a.dummyOnce.Do(func() {
+		hash, err := a.passwordHasher.Hash("dummy password")
+		if err != nil {
+			log.Error("failed to generate dummy password hash", sl.Err(err))
+			return
+		}
+		a.dummyHash = hash
+	})
+
+	if a.dummyHash != nil {
+		_, _, _ = a.passwordHasher.Verify(a.dummyHash, password)
+	}
+}
+
+// sendInBackground sends email after the response, so callers don't wait for the mail server
+// and response time doesn't depend on whether email is sent. Failures are only logged
+func (a *Auth) sendInBackground(ctx context.Context, log *slog.Logger, msg mail.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailTimeout)
+		defer cancel()
+
+		if err := a.mailer.Send(ctx, msg); err != nil {
+			log.Error("failed to send email", sl.Err(err), slog.String("subject", msg.Subject))
+			return
+		}
+
+		log.Info("email sent", slog.String("subject", msg.Subject))
+	}()
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/clientip"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// LockoutParams configures throttling of failed logins
+type LockoutParams struct {
+	// MaxFailures is number of failures after which account is locked
+	MaxFailures int
+	// IPMaxFailures is number of failures after which client IP is locked, it's higher,
+	// since many users may share one address
+	IPMaxFailures int
+	// BaseDelay is how long account is blocked after the first failure, delay doubles with each next one
+	BaseDelay time.Duration
+	// Duration is how long lockout lasts
+	Duration time.Duration
+	// Window is how long failures are remembered since the last one
+	Window time.Duration
+	// Conceal reports throttled logins as invalid credentials
+	Conceal bool
+}
+
+// checkThrottle returns error if logins with given email or from client IP are blocked
+// because of recent failures, so credentials must not be checked. If throttling is concealed,
+// password, if any, is verified against dummy hash, so the response takes as long as a wrong password
+func (a *Auth) checkThrottle(ctx context.Context, log *slog.Logger, email string, password string) error {
+	currentTime := time.Now()
+
+	for _, key := range loginKeys(ctx, email) {
+		attempts, err := a.loginLimiter.LoginAttempts(ctx, key)
+		if err != nil {
+			if errors.Is(err, storage.ErrLoginAttemptsNotFound) {
+				continue
+			}
+
+			log.Error("failed to get login attempts", sl.Err(err))
+			return err
+		}
+
+		if !attempts.BlockedUntil.After(currentTime) {
+			continue
+		}
+
+		log.Warn("login throttled", slog.String("key", key), slog.Int("failures", attempts.Failures), slog.Time("blocked_until", attempts.BlockedUntil))
+
+		if a.lockout.Conceal {
+			if password != "" {
+				a.verifyDummyPassword(log, password)
+			}
+			return service.ErrInvalidCredentials
+		}
+
+		reason := service.ErrTooManyAttempts
+		if key == models.LoginAttemptsKey(email) && attempts.Failures >= a.lockout.MaxFailures {
+			reason = service.ErrAccountLocked
+		}
+
+		// rounded up, so clients retrying after the delay aren't throttled again
+		retryAfter := (attempts.BlockedUntil.Sub(currentTime) + time.Second - 1).Truncate(time.Second)
+
+		return &service.ThrottleError{Err: reason, RetryAfter: retryAfter}
+	}
+
+	return nil
+}
+
+// recordFailure counts failed login with given email and from client IP, either by password
+// or by second factor, blocking further logins with exponential backoff and locking them out after too many failures
+func (a *Auth) recordFailure(ctx context.Context, log *slog.Logger, email string) {
+	currentTime := time.Now()
+
+	for _, key := range loginKeys(ctx, email) {
+		failures, err := a.loginLimiter.AddLoginFailure(ctx, key, currentTime.Add(a.lockout.Window))
+		if err != nil {
+			log.Error("failed to record login failure", sl.Err(err))
+			continue
+		}
+
+		var delay time.Duration
+		if key == models.LoginAttemptsKey(email) {
+			delay = a.lockout.delay(failures, a.lockout.MaxFailures)
+		} else if failures >= a.lockout.IPMaxFailures {
+			// backoff isn't applied to addresses, they are shared by many users
+			delay = a.lockout.Duration
+		}
+
+		if delay == 0 {
+			continue
+		}
+
+		if err := a.loginLimiter.BlockLogin(ctx, key, currentTime.Add(delay)); err != nil {
+			log.Error("failed to block login", sl.Err(err))
+			continue
+		}
+
+		log.Info("login blocked", slog.String("key", key), slog.Int("failures", failures), slog.Duration("delay", delay))
+	}
+}
+
+// resetFailures forgets failed logins with given email after successful one, including second factor,
+// failures from client IP are kept, so valid credentials of one account don't reset them
+func (a *Auth) resetFailures(ctx context.Context, log *slog.Logger, email string) {
+	err := a.loginLimiter.DeleteLoginAttempts(ctx, models.LoginAttemptsKey(email))
+	if err != nil && !errors.Is(err, storage.ErrLoginAttemptsNotFound) {
+		log.Error("failed to reset login failures", sl.Err(err))
+	}
+}
+
+// delay returns how long logins are blocked after given number of failures
+func (p LockoutParams) delay(failures int, maxFailures int) time.Duration {
+	if failures >= maxFailures {
+		return p.Duration
+	}
+
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.Duration; i++ {
+		delay *= 2
+	}
+
+	return min(delay, p.Duration)
+}
+
+// loginKeys returns keys failed logins are counted by
+func loginKeys(ctx context.Context, email string) []string {
+	keys := []string{models.LoginAttemptsKey(email)}
+	if ip, ok := clientip.FromContext(ctx); ok {
+		keys = append(keys, models.LoginAttemptsIPKey(ip))
+	}
+
+	return keys
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/lib/mail"
+	"sso/internal/service"
+)
+
+// countingHasher keeps passwords as is and counts verifications, each of which stands for a slow hash
+type countingHasher struct {
+	verified int
+}
+
+func (h *countingHasher) Hash(password string) ([]byte, error) {
+	return []byte("plain:" + password), nil
+}
+
+func (h *countingHasher) Verify(hash []byte, password string) (bool, bool, error) {
+	h.verified++
+	return string(hash) == "plain:"+password, false, nil
+}
+
+func TestLoginThrottleTiming(t *testing.T) {
+	const email = "user@example.com"
+
+	tests := []struct {
+		name      string
+		conceal   bool
+		throttled bool
+		// unknown is whether the user doesn't exist
+		unknown      bool
+		wantErr      error
+		wantThrottle bool
+		// wantVerified is number of password verifications, which have to match ones of a wrong password
+		// when throttling is concealed
+		wantVerified int
+	}{
+		{name: "wrong password", wantErr: service.ErrInvalidCredentials, wantVerified: 1},
+		{name: "unknown email", unknown: true, wantErr: service.ErrInvalidCredentials, wantVerified: 1},
+		{name: "throttled", throttled: true, wantThrottle: true, wantVerified: 0},
+		{name: "concealed throttled", conceal: true, throttled: true, wantErr: service.ErrInvalidCredentials, wantVerified: 1},
+		{name: "concealed throttled unknown email", conceal: true, throttled: true, unknown: true, wantErr: service.ErrInvalidCredentials, wantVerified: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			storage := newTestStorage(t)
+			a := newTestAuth(t, storage, nil, VerificationParams{})
+			hasher := &countingHasher{}
+			a.passwordHasher = hasher
+			a.lockout.Conceal = tt.conceal
+			appID := newTestApp(t, storage)
+
+			if !tt.unknown {
+				if _, err := storage.SaveUser(ctx, email, []byte("plain:password")); err != nil {
+					t.Fatalf("failed to save user: %v", err)
+				}
+			}
+			if tt.throttled {
+				key := models.LoginAttemptsKey(email)
+				if _, err := storage.AddLoginFailure(ctx, key, time.Now().Add(time.Hour)); err != nil {
+					t.Fatalf("failed to add login failure: %v", err)
+				}
+				if err := storage.BlockLogin(ctx, key, time.Now().Add(time.Hour)); err != nil {
+					t.Fatalf("failed to block login: %v", err)
+				}
+			}
+			// the dummy hash is computed once, before the measured login
+			a.verifyDummyPassword(a.log, "warm up")
+			hasher.verified = 0
+
+			_, _, _, err := a.Login(ctx, email, "wrong password", appID)
+
+			var throttleErr *service.ThrottleError
+			switch {
+			case tt.wantThrottle && !errors.As(err, &throttleErr):
+				t.Fatalf("Login() error = %v, want throttle error", err)
+			case !tt.wantThrottle && !errors.Is(err, tt.wantErr):
+				t.Fatalf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+			if hasher.verified != tt.wantVerified {
+				t.Errorf("password verified %d times, want %d", hasher.verified, tt.wantVerified)
+			}
+		})
+	}
+}
+
+func TestCurrentPasswordThrottle(t *testing.T) {
+	const (
+		email    = "user@example.com"
+		password = "password"
+	)
+
+	tests := []struct {
+		name string
+		// change changes credentials of the user the token is issued to, if password is correct
+		change func(a *Auth, token *jwt.Token, password string) error
+	}{
+		{
+			name: "change password",
+			change: func(a *Auth, token *jwt.Token, password string) error {
+				return a.ChangePassword(context.Background(), token, password, "new password")
+			},
+		},
+		{
+			name: "change email",
+			change: func(a *Auth, token *jwt.Token, password string) error {
+				return a.ChangeEmail(context.Background(), token, password, "new@example.com")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			storage := newTestStorage(t)
+			a := newTestAuth(t, storage, mail.NewLog(slogdiscard.NewDiscardLogger()), VerificationParams{
+				URL: "http://localhost/verify",
+				TTL: time.Hour,
+				Key: []byte("verification key"),
+			})
+			a.passwordHasher = &countingHasher{}
+
+			uid, err := storage.SaveUser(ctx, email, []byte("plain:"+password))
+			if err != nil {
+				t.Fatalf("failed to save user: %v", err)
+			}
+			token := &jwt.Token{UID: uid}
+
+			if err := tt.change(a, token, "wrong password"); !errors.Is(err, service.ErrInvalidCredentials) {
+				t.Fatalf("error = %v, want %v", err, service.ErrInvalidCredentials)
+			}
+
+			attempts, err := storage.LoginAttempts(ctx, models.LoginAttemptsKey(email))
+			if err != nil {
+				t.Fatalf("failed to get login attempts: %v", err)
+			}
+			if attempts.Failures != 1 {
+				t.Errorf("failures = %d, want 1", attempts.Failures)
+			}
+
+			// even the correct password isn't checked until the block is over
+			var throttleErr *service.ThrottleError
+			if err := tt.change(a, token, password); !errors.As(err, &throttleErr) {
+				t.Fatalf("error = %v, want throttle error", err)
+			}
+
+			// and logins are throttled as well
+			if _, _, _, err := a.Login(ctx, email, password, newTestApp(t, storage)); !errors.As(err, &throttleErr) {
+				t.Fatalf("Login() error = %v, want throttle error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package keys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
+	"sso/internal/lib/logger/sl"
+)
+
+// checkInterval is how often Run reloads keys and checks whether rotation is due
+const checkInterval = time.Minute
+
+type Keys struct {
+	log            *slog.Logger
+	keySaver       KeySaver
+	keyProvider    KeyProvider
+	ring           *jwt.KeyRing
+	algorithm      string
+	rotationPeriod time.Duration
+	overlap        time.Duration
+}
+
+type KeySaver interface {
+	RotateSigningKey(ctx context.Context, key models.SigningKey) error
+	RetireSigningKeys(ctx context.Context, before time.Time) error
+}
+
+type KeyProvider interface {
+	SigningKeys(ctx context.Context) ([]models.SigningKey, error)
+}
+
+// New returns a new instance of the Keys service
+//
+// Retiring keys are accepted for verification during overlap after rotation,
+// so overlap should be no less than access token TTL
+func New(
+	log *slog.Logger,
+	keySaver KeySaver,
+	keyProvider KeyProvider,
+	ring *jwt.KeyRing,
+	algorithm string,
+	rotationPeriod time.Duration,
+	overlap time.Duration,
+) *Keys {
+	return &Keys{
+		log:            log,
+		keySaver:       keySaver,
+		keyProvider:    keyProvider,
+		ring:           ring,
+		algorithm:      algorithm,
+		rotationPeriod: rotationPeriod,
+		overlap:        overlap,
+	}
+}
+
+// Init loads keys from storage into the key ring
+//
+// If there are no keys yet, key from importPath is saved as active one.
+// If importPath is empty or doesn't exist, a new key is generated
+func (k *Keys) Init(ctx context.Context, importPath string) error {
+	const op = "services.keys.Init"
+
+	log := k.log.With(
+		slog.String("op", op),
+	)
+
+	stored, err := k.keyProvider.SigningKeys(ctx)
+	if err != nil {
+		log.Error("failed to get signing keys", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(stored) > 0 {
+		return k.load(ctx, stored)
+	}
+
+	var key *jwt.Key
+	if importPath != "" {
+		data, err := os.ReadFile(importPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Error("failed to read signing key", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, err)
+		}
+		if err == nil {
+			key, err = jwt.ParseKey(data)
+			if err != nil {
+				log.Error("failed to parse signing key", sl.Err(err))
+				return fmt.Errorf("%s: %w", op, err)
+			}
+			log.Info("importing signing key", slog.String("path", importPath))
+		}
+	}
+
+	if err := k.rotate(ctx, key); err != nil {
+		log.Error("failed to save initial signing key", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Rotate generates a new active signing key and returns its ID
+//
+// Previous active key becomes retiring and is still accepted for verification during overlap
+func (k *Keys) Rotate(ctx context.Context) (string, error) {
+	const op = "services.keys.Rotate"
+
+	log := k.log.With(
+		slog.String("op", op),
+	)
+
+	log.Info("rotating signing key")
+
+	key, err := jwt.NewKey(k.algorithm)
+	if err != nil {
+		log.Error("failed to generate signing key", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := k.rotate(ctx, key); err != nil {
+		log.Error("failed to rotate signing key", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("signing key rotated", slog.String("kid", key.ID))
+
+	return key.ID, nil
+}
+
+// JWKS returns public keys used to verify tokens
+func (k *Keys) JWKS(ctx context.Context) jwt.JWKS {
+	return k.ring.JWKS()
+}
+
+// Run rotates keys every rotation period and retires keys after overlap until ctx is done
+//
+// Keys are reloaded from storage on every check, so rotations made by other instances are picked up
+func (k *Keys) Run(ctx context.Context) {
+	const op = "services.keys.Run"
+
+	log := k.log.With(
+		slog.String("op", op),
+	)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.check(ctx); err != nil {
+				log.Error("failed to check signing keys", sl.Err(err))
+			}
+		}
+	}
+}
+
+func (k *Keys) check(ctx context.Context) error {
+	now := time.Now()
+
+	if err := k.keySaver.RetireSigningKeys(ctx, now.Add(-k.overlap)); err != nil {
+		return err
+	}
+
+	stored, err := k.keyProvider.SigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	if k.rotationPeriod > 0 {
+		for _, key := range stored {
+			if key.State == string(jwt.KeyActive) && now.Sub(key.CreatedAt) >= k.rotationPeriod {
+				_, err := k.Rotate(ctx)
+				return err
+			}
+		}
+	}
+
+	return k.load(ctx, stored)
+}
+
+// rotate saves key as active and reloads the ring, nil key is generated
+func (k *Keys) rotate(ctx context.Context, key *jwt.Key) error {
+	if key == nil {
+		var err error
+		key, err = jwt.NewKey(k.algorithm)
+		if err != nil {
+			return err
+		}
+	}
+
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return err
+	}
+
+	err = k.keySaver.RotateSigningKey(ctx, models.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: data,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+
+	stored, err := k.keyProvider.SigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	return k.load(ctx, stored)
+}
+
+func (k *Keys) load(_ context.Context, stored []models.SigningKey) error {
+	keys := make([]*jwt.Key, 0, len(stored))
+	for _, s := range stored {
+		key, err := jwt.ParseKey(s.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("key %s: %w", s.ID, err)
+		}
+		key.ID = s.ID
+		key.State = jwt.KeyState(s.State)
+		keys = append(keys, key)
+	}
+
+	return k.ring.Set(keys)
+}
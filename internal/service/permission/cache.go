@@ -0,0 +1,90 @@
+package permission
+
+import (
+	"sync"
+	"time"
+
+	"sso/internal/domain/models"
+)
+
+// CacheParams are limits of the cache of Check decisions
+type CacheParams struct {
+	// TTL bounds how long decision made before a change on other instance of the service is used
+	TTL  time.Duration
+	Size int
+}
+
+type cachedDecision struct {
+	decision  models.Decision
+	expiresAt time.Time
+}
+
+// decisionCache keeps recent decisions of Check. Any change of roles, groups or grants drops all of them,
+// since finding decisions affected by a change costs as much as making them again
+type decisionCache struct {
+	mu     sync.Mutex
+	params CacheParams
+	// generation is incremented on each change, decisions made across a change aren't cached
+	generation uint64
+	entries    map[models.Check]cachedDecision
+}
+
+func newDecisionCache(params CacheParams) *decisionCache {
+	return &decisionCache{
+		params:  params,
+		entries: make(map[models.Check]cachedDecision),
+	}
+}
+
+// get returns cached decision of the check, if any, and current generation to put a new decision with
+func (c *decisionCache) get(check models.Check, now time.Time) (models.Decision, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[check]
+	if !ok || !now.Before(cached.expiresAt) {
+		return models.Decision{}, c.generation, false
+	}
+
+	return cached.decision, c.generation, true
+}
+
+// put caches decision made in given generation, unless there were changes since then
+func (c *decisionCache) put(check models.Check, decision models.Decision, generation uint64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation || c.params.TTL <= 0 || c.params.Size <= 0 {
+		return
+	}
+
+	if len(c.entries) >= c.params.Size {
+		c.evict(now)
+	}
+
+	c.entries[check] = cachedDecision{decision: decision, expiresAt: now.Add(c.params.TTL)}
+}
+
+// invalidate drops all decisions, it must be called after every change affecting them
+func (c *decisionCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	clear(c.entries)
+}
+
+// evict drops expired decisions, or an arbitrary one if none has expired
+func (c *decisionCache) evict(now time.Time) {
+	for check, cached := range c.entries {
+		if !now.Before(cached.expiresAt) {
+			delete(c.entries, check)
+		}
+	}
+	for check := range c.entries {
+		if len(c.entries) < c.params.Size {
+			return
+		}
+		delete(c.entries, check)
+	}
+}
@@ -0,0 +1,107 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/storage"
+)
+
+// GrantProvider returns every way the user holds permission of the app, resource grants are considered
+// only for non-empty resource
+type GrantProvider interface {
+	PermissionGrants(ctx context.Context, userID int64, appID int, permission string, resource string) ([]models.Grant, error)
+}
+
+// Check decides whether the user with ID in subject has the permission of the app, through roles held
+// directly or by groups, or, if resource isn't empty, through grants on the resource.
+// Decisions are cached until roles, groups or grants are changed
+func (p *Permission) Check(ctx context.Context, check models.Check) (models.Decision, error) {
+	const op = "services.permission.Check"
+
+	now := time.Now()
+	decision, generation, ok := p.decisions.get(check, now)
+	if ok {
+		return decision, nil
+	}
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", check.AppID),
+		slog.String("subject", check.Subject),
+		slog.String("permission", check.Permission),
+	)
+
+	decision, err := p.decide(ctx, check)
+	if err != nil {
+		log.Error("failed to check permission", sl.Err(err))
+		return models.Decision{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	p.decisions.put(check, decision, generation, now)
+
+	log.Debug("permission checked", slog.Bool("allowed", decision.Allowed), slog.String("reason", decision.Reason))
+
+	return decision, nil
+}
+
+// CheckMany makes decisions of checks in the same order, it fails if any of them fails
+func (p *Permission) CheckMany(ctx context.Context, checks []models.Check) ([]models.Decision, error) {
+	const op = "services.permission.CheckMany"
+
+	decisions := make([]models.Decision, 0, len(checks))
+	for _, check := range checks {
+		decision, err := p.Check(ctx, check)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		decisions = append(decisions, decision)
+	}
+
+	return decisions, nil
+}
+
+// decide makes decision of the check, reason names the simplest grant found
+func (p *Permission) decide(ctx context.Context, check models.Check) (models.Decision, error) {
+	userID, err := strconv.ParseInt(check.Subject, 10, 64)
+	if err != nil || userID <= 0 {
+		return models.Decision{Reason: "subject not found"}, nil
+	}
+
+	grants, err := p.grantProvider.PermissionGrants(ctx, userID, check.AppID, check.Permission, check.Resource)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			return models.Decision{Reason: "subject not found"}, nil
+		}
+		if errors.Is(err, storage.ErrPermissionNotFound) {
+			return models.Decision{Reason: "permission not defined"}, nil
+		}
+		return models.Decision{}, err
+	}
+
+	if len(grants) == 0 {
+		return models.Decision{Reason: "no role or grant gives the permission"}, nil
+	}
+
+	return models.Decision{Allowed: true, Reason: reason(grants[0])}, nil
+}
+
+// reason describes how the grant gives permission
+func reason(grant models.Grant) string {
+	switch {
+	case grant.Role != "" && grant.Group != "":
+		return fmt.Sprintf("granted by role %s of group %s", grant.Role, grant.Group)
+	case grant.Role != "":
+		return "granted by role " + grant.Role
+	case grant.Group != "":
+		return fmt.Sprintf("granted on resource %s to group %s", grant.Resource, grant.Group)
+	default:
+		return "granted on resource " + grant.Resource
+	}
+}
@@ -0,0 +1,287 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// GroupSaver manages groups and resource grants in namespace of the app, changes taking roles away
+// revoke tokens of affected users
+type GroupSaver interface {
+	SaveGroup(ctx context.Context, group models.Group) (int64, error)
+	DeleteGroup(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error
+	AddGroupMember(ctx context.Context, appID int, group string, email string) error
+	RemoveGroupMember(ctx context.Context, appID int, group string, email string, revokedAt, expiresAt time.Time) error
+	AssignGroupRole(ctx context.Context, appID int, group string, role string) error
+	UnassignGroupRole(ctx context.Context, appID int, group string, role string, revokedAt, expiresAt time.Time) error
+	SaveResourceGrant(ctx context.Context, grant models.ResourceGrant) error
+	DeleteResourceGrant(ctx context.Context, grant models.ResourceGrant) error
+}
+
+// CreateGroup creates group without members in namespace of the app
+//
+// If group already exists, returns error
+func (p *Permission) CreateGroup(ctx context.Context, appID int, name string, description string) error {
+	const op = "services.permission.CreateGroup"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", name),
+	)
+
+	if _, err := p.groupSaver.SaveGroup(ctx, models.Group{AppID: appID, Name: name, Description: description}); err != nil {
+		if errors.Is(err, storage.ErrGroupExists) {
+			log.Warn("group already exists", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrGroupExists)
+		}
+
+		log.Error("failed to save group", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("group created")
+
+	return nil
+}
+
+// DeleteGroup deletes group with its resource grants and revokes tokens of members
+//
+// If group doesn't exist, returns error
+func (p *Permission) DeleteGroup(ctx context.Context, appID int, name string) error {
+	const op = "services.permission.DeleteGroup"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", name),
+	)
+
+	currentTime := time.Now()
+	if err := p.groupSaver.DeleteGroup(ctx, appID, name, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrGroupNotFound) {
+			log.Warn("group not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrGroupNotFound)
+		}
+
+		log.Error("failed to delete group", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("group deleted")
+
+	return nil
+}
+
+// AddGroupMember adds the user with given email to the group, tokens issued afterwards carry roles of the group
+//
+// If user or group doesn't exist, returns error
+// If the user is already a member, returns error
+func (p *Permission) AddGroupMember(ctx context.Context, appID int, group string, email string) error {
+	const op = "services.permission.AddGroupMember"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", group),
+		slog.String("email", email),
+	)
+
+	if err := p.groupSaver.AddGroupMember(ctx, appID, group, email); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrGroupNotFound):
+			log.Warn("group not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrGroupNotFound)
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		case errors.Is(err, storage.ErrMemberExists):
+			log.Warn("user already in group", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrMemberExists)
+		}
+
+		log.Error("failed to add group member", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("group member added")
+
+	return nil
+}
+
+// RemoveGroupMember removes the user with given email from the group and revokes their tokens
+//
+// If the user isn't a member, returns error
+func (p *Permission) RemoveGroupMember(ctx context.Context, appID int, group string, email string) error {
+	const op = "services.permission.RemoveGroupMember"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", group),
+		slog.String("email", email),
+	)
+
+	currentTime := time.Now()
+	if err := p.groupSaver.RemoveGroupMember(ctx, appID, group, email, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrMemberNotFound) {
+			log.Warn("user not in group", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrMemberNotFound)
+		}
+
+		log.Error("failed to remove group member", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("group member removed")
+
+	return nil
+}
+
+// AssignGroupRole assigns the role to the group, members hold it as if it were assigned to them
+//
+// If group or role doesn't exist, returns error
+// If the group already has the role, returns error
+func (p *Permission) AssignGroupRole(ctx context.Context, appID int, group string, role string) error {
+	const op = "services.permission.AssignGroupRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", group),
+		slog.String("role", role),
+	)
+
+	if err := p.groupSaver.AssignGroupRole(ctx, appID, group, role); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrGroupNotFound):
+			log.Warn("group not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrGroupNotFound)
+		case errors.Is(err, storage.ErrRoleNotFound):
+			log.Warn("role not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotFound)
+		case errors.Is(err, storage.ErrRoleAssigned):
+			log.Warn("role already assigned", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleAssigned)
+		}
+
+		log.Error("failed to assign group role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("group role assigned")
+
+	return nil
+}
+
+// UnassignGroupRole takes the role away from the group and revokes tokens of members
+//
+// If the group doesn't have the role, returns error
+func (p *Permission) UnassignGroupRole(ctx context.Context, appID int, group string, role string) error {
+	const op = "services.permission.UnassignGroupRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("group", group),
+		slog.String("role", role),
+	)
+
+	currentTime := time.Now()
+	if err := p.groupSaver.UnassignGroupRole(ctx, appID, group, role, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrRoleNotAssigned) {
+			log.Warn("role not assigned", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotAssigned)
+		}
+
+		log.Error("failed to unassign group role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("group role unassigned")
+
+	return nil
+}
+
+// GrantResource gives permission on a single resource to the user or group of the grant.
+// Resource grants are checked by Check only, tokens don't carry them
+//
+// If permission, user or group doesn't exist, returns error
+// If the grant already exists, returns error
+func (p *Permission) GrantResource(ctx context.Context, grant models.ResourceGrant) error {
+	const op = "services.permission.GrantResource"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", grant.AppID),
+		slog.String("permission", grant.Permission),
+		slog.String("resource", grant.Resource),
+	)
+
+	if err := p.groupSaver.SaveResourceGrant(ctx, grant); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrPermissionNotFound):
+			log.Warn("permission not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionNotFound)
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		case errors.Is(err, storage.ErrGroupNotFound):
+			log.Warn("group not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrGroupNotFound)
+		case errors.Is(err, storage.ErrResourceGrantExists):
+			log.Warn("resource grant already exists", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrResourceGrantExists)
+		}
+
+		log.Error("failed to save resource grant", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("resource granted")
+
+	return nil
+}
+
+// RevokeResource takes permission on the resource away from the user or group of the grant
+//
+// If there is no such grant, returns error
+func (p *Permission) RevokeResource(ctx context.Context, grant models.ResourceGrant) error {
+	const op = "services.permission.RevokeResource"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", grant.AppID),
+		slog.String("permission", grant.Permission),
+		slog.String("resource", grant.Resource),
+	)
+
+	if err := p.groupSaver.DeleteResourceGrant(ctx, grant); err != nil {
+		if errors.Is(err, storage.ErrResourceGrantNotFound) {
+			log.Warn("resource grant not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrResourceGrantNotFound)
+		}
+
+		log.Error("failed to delete resource grant", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("resource revoked")
+
+	return nil
+}
@@ -5,38 +5,100 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"time"
 
+	"sso/internal/domain/models"
 	"sso/internal/lib/logger/sl"
 	"sso/internal/service"
 	"sso/internal/storage"
 )
 
 type Permission struct {
-	log          *slog.Logger
-	adminAdder   AdminAdder
-	adminDeleter AdminDeleter
+	log           *slog.Logger
+	roleAssigner  RoleAssigner
+	adminDeleter  AdminDeleter
+	userProvider  UserProvider
+	tokenRevoker  TokenRevoker
+	mfaDeleter    MFADeleter
+	loginLimiter  LoginLimiter
+	roleSaver     RoleSaver
+	roleProvider  RoleProvider
+	groupSaver    GroupSaver
+	grantProvider GrantProvider
+	tokenTTL      time.Duration
+	leeway        time.Duration
+	decisions     *decisionCache
 }
 
-type AdminAdder interface {
-	AddAdmin(ctx context.Context, email string) error
+// RoleAssigner assigns roles defined by the app to users by email, tokens of the user are revoked when role is taken away
+type RoleAssigner interface {
+	AssignRole(ctx context.Context, email string, appID int, role string) error
+	UnassignRole(ctx context.Context, email string, appID int, role string, revokedAt, expiresAt time.Time) error
 }
 
 type AdminDeleter interface {
 	DeleteAdmin(ctx context.Context, email string) error
 }
 
+type UserProvider interface {
+	User(ctx context.Context, email string) (models.User, error)
+}
+
+type TokenRevoker interface {
+	RevokeUserTokens(ctx context.Context, userID int64, revokedAt, expiresAt time.Time) error
+}
+
+type MFADeleter interface {
+	DeleteMFA(ctx context.Context, userID int64, revokedAt, expiresAt time.Time) error
+}
+
+type LoginLimiter interface {
+	DeleteLoginAttempts(ctx context.Context, key string) error
+}
+
 func New(
 	log *slog.Logger,
-	adminAdder AdminAdder,
+	roleAssigner RoleAssigner,
 	adminDeleter AdminDeleter,
+	userProvider UserProvider,
+	tokenRevoker TokenRevoker,
+	mfaDeleter MFADeleter,
+	loginLimiter LoginLimiter,
+	roleSaver RoleSaver,
+	roleProvider RoleProvider,
+	groupSaver GroupSaver,
+	grantProvider GrantProvider,
+	tokenTTL time.Duration,
+	leeway time.Duration,
+	cacheParams CacheParams,
 ) *Permission {
 	return &Permission{
-		log:          log,
-		adminAdder:   adminAdder,
-		adminDeleter: adminDeleter,
+		log:           log,
+		roleAssigner:  roleAssigner,
+		adminDeleter:  adminDeleter,
+		userProvider:  userProvider,
+		tokenRevoker:  tokenRevoker,
+		mfaDeleter:    mfaDeleter,
+		loginLimiter:  loginLimiter,
+		roleSaver:     roleSaver,
+		roleProvider:  roleProvider,
+		groupSaver:    groupSaver,
+		grantProvider: grantProvider,
+		tokenTTL:      tokenTTL,
+		leeway:        leeway,
+		decisions:     newDecisionCache(cacheParams),
 	}
 }
 
+// revokedUntil returns how long revocation made at currentTime must be kept, that is until the last token
+// issued before it expires, including leeway it's accepted with after exp
+func (p *Permission) revokedUntil(currentTime time.Time) time.Time {
+	return currentTime.Add(p.tokenTTL + p.leeway)
+}
+
+// AddAdmin assigns the admin role to the user with given email
+//
+// If user doesn't exist or already has the role, returns error
 func (p *Permission) AddAdmin(ctx context.Context, email string) error {
 	const op = "services.permission.AddAdmin"
 
@@ -47,21 +109,30 @@ func (p *Permission) AddAdmin(ctx context.Context, email string) error {
 
 	log.Info("adding admin")
 
-	if err := p.adminAdder.AddAdmin(ctx, email); err != nil {
-		if errors.Is(err, storage.ErrAdminExists) {
+	if err := p.roleAssigner.AssignRole(ctx, email, 0, models.RoleAdmin); err != nil {
+		if errors.Is(err, storage.ErrRoleAssigned) {
 			log.Warn("admin already exists", sl.Err(err))
 			return fmt.Errorf("%s: %w", op, service.ErrAdminExists)
 		}
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		}
 
 		log.Error("failed to add admin")
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	p.decisions.invalidate()
 
 	log.Info("admin added successfully")
 
 	return nil
 }
 
+// DeleteAdmin takes away all roles of the service from the user with given email, including ones held
+// through groups of the service, and revokes tokens of the user
+//
+// If user has no roles, returns error
 func (p *Permission) DeleteAdmin(ctx context.Context, email string) error {
 	const op = "services.permission.DeleteAdmin"
 
@@ -81,8 +152,101 @@ func (p *Permission) DeleteAdmin(ctx context.Context, email string) error {
 		log.Error("failed to delete admin")
 		return fmt.Errorf("%s: %w", op, err)
 	}
+	p.decisions.invalidate()
 
 	log.Info("admin deleted")
 
+	// tokens issued before demotion still carry roles, so they must not be accepted anymore
+	user, err := p.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found, nothing to revoke", sl.Err(err))
+			return nil
+		}
+
+		log.Error("failed to get user")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now()
+	if err := p.tokenRevoker.RevokeUserTokens(ctx, user.ID, currentTime, p.revokedUntil(currentTime)); err != nil {
+		log.Error("failed to revoke user tokens")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user tokens revoked")
+
+	return nil
+}
+
+// ResetMFA removes second factor of the user with given email together with recovery codes,
+// so the user can sign in with password only and enroll MFA again. All sessions of the user are revoked,
+// since the second factor may be reset because the account is compromised
+//
+// If user doesn't exist, returns error
+// If user has no second factor, returns error
+func (p *Permission) ResetMFA(ctx context.Context, email string) error {
+	const op = "services.permission.ResetMFA"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("resetting mfa")
+
+	user, err := p.userProvider.User(ctx, email)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		}
+
+		log.Error("failed to get user")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now()
+	if err := p.mfaDeleter.DeleteMFA(ctx, user.ID, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrMFANotFound) {
+			log.Warn("mfa not enrolled", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrMFANotEnrolled)
+		}
+
+		log.Error("failed to delete mfa")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Warn("mfa reset, sessions revoked")
+
+	return nil
+}
+
+// UnlockUser lifts lockout of logins with given email and forgets their failures.
+// Failures from client addresses are kept
+//
+// If logins with the email aren't throttled, returns error
+func (p *Permission) UnlockUser(ctx context.Context, email string) error {
+	const op = "services.permission.UnlockUser"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.String("email", email),
+	)
+
+	log.Info("unlocking user")
+
+	if err := p.loginLimiter.DeleteLoginAttempts(ctx, models.LoginAttemptsKey(email)); err != nil {
+		if errors.Is(err, storage.ErrLoginAttemptsNotFound) {
+			log.Warn("user not locked", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrNotLocked)
+		}
+
+		log.Error("failed to unlock user")
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Warn("user unlocked")
+
 	return nil
 }
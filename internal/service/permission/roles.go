@@ -0,0 +1,380 @@
+package permission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+// RoleSaver manages roles and permissions in namespace of the app, zero app ID is namespace of the service itself.
+// Changes taking permissions away revoke tokens of affected users
+type RoleSaver interface {
+	SaveRole(ctx context.Context, role models.Role) (int64, error)
+	UpdateRole(ctx context.Context, appID int, name string, description string) error
+	DeleteRole(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error
+	SavePermission(ctx context.Context, permission models.Permission) (int64, error)
+	UpdatePermission(ctx context.Context, appID int, name string, description string) error
+	DeletePermission(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error
+	GrantPermission(ctx context.Context, appID int, role string, permission string) error
+	RevokePermission(ctx context.Context, appID int, role string, permission string, revokedAt, expiresAt time.Time) error
+}
+
+type RoleProvider interface {
+	Roles(ctx context.Context, appID int) ([]models.Role, error)
+	Permissions(ctx context.Context, appID int) ([]models.Permission, error)
+}
+
+// CreateRole creates role without permissions in namespace of the app, zero app ID is namespace of the service itself
+//
+// If role already exists, returns error
+func (p *Permission) CreateRole(ctx context.Context, appID int, name string, description string) error {
+	const op = "services.permission.CreateRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("role", name),
+	)
+
+	log.Info("creating role")
+
+	if _, err := p.roleSaver.SaveRole(ctx, models.Role{AppID: appID, Name: name, Description: description}); err != nil {
+		if errors.Is(err, storage.ErrRoleExists) {
+			log.Warn("role already exists", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleExists)
+		}
+
+		log.Error("failed to save role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role created")
+
+	return nil
+}
+
+// UpdateRole replaces description of the role
+//
+// If role doesn't exist, returns error
+func (p *Permission) UpdateRole(ctx context.Context, appID int, name string, description string) error {
+	const op = "services.permission.UpdateRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("role", name),
+	)
+
+	if err := p.roleSaver.UpdateRole(ctx, appID, name, description); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			log.Warn("role not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotFound)
+		}
+
+		log.Error("failed to update role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("role updated")
+
+	return nil
+}
+
+// DeleteRole deletes role and revokes tokens of users who had it
+//
+// If role doesn't exist, returns error
+// If role is built-in, returns error
+func (p *Permission) DeleteRole(ctx context.Context, appID int, name string) error {
+	const op = "services.permission.DeleteRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("role", name),
+	)
+
+	if appID == 0 && models.IsBuiltInRole(name) {
+		log.Warn("attempt to delete built-in role")
+		return fmt.Errorf("%s: %w", op, service.ErrBuiltIn)
+	}
+
+	currentTime := time.Now()
+	if err := p.roleSaver.DeleteRole(ctx, appID, name, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrRoleNotFound) {
+			log.Warn("role not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotFound)
+		}
+
+		log.Error("failed to delete role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("role deleted")
+
+	return nil
+}
+
+// Roles returns all roles of the app with their permissions
+func (p *Permission) Roles(ctx context.Context, appID int) ([]models.Role, error) {
+	const op = "services.permission.Roles"
+
+	log := p.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	roles, err := p.roleProvider.Roles(ctx, appID)
+	if err != nil {
+		log.Error("failed to get roles", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// CreatePermission creates permission in namespace of the app, it does nothing until granted to a role
+//
+// If permission already exists, returns error
+func (p *Permission) CreatePermission(ctx context.Context, appID int, name string, description string) error {
+	const op = "services.permission.CreatePermission"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("permission", name),
+	)
+
+	log.Info("creating permission")
+
+	if _, err := p.roleSaver.SavePermission(ctx, models.Permission{AppID: appID, Name: name, Description: description}); err != nil {
+		if errors.Is(err, storage.ErrPermissionExists) {
+			log.Warn("permission already exists", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionExists)
+		}
+
+		log.Error("failed to save permission", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Info("permission created")
+
+	return nil
+}
+
+// UpdatePermission replaces description of the permission
+//
+// If permission doesn't exist, returns error
+func (p *Permission) UpdatePermission(ctx context.Context, appID int, name string, description string) error {
+	const op = "services.permission.UpdatePermission"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("permission", name),
+	)
+
+	if err := p.roleSaver.UpdatePermission(ctx, appID, name, description); err != nil {
+		if errors.Is(err, storage.ErrPermissionNotFound) {
+			log.Warn("permission not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionNotFound)
+		}
+
+		log.Error("failed to update permission", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("permission updated")
+
+	return nil
+}
+
+// DeletePermission deletes permission and revokes tokens of users who had it
+//
+// If permission doesn't exist, returns error
+// If permission is checked by the service itself, returns error
+func (p *Permission) DeletePermission(ctx context.Context, appID int, name string) error {
+	const op = "services.permission.DeletePermission"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("permission", name),
+	)
+
+	if appID == 0 && models.IsBuiltInPermission(name) {
+		log.Warn("attempt to delete built-in permission")
+		return fmt.Errorf("%s: %w", op, service.ErrBuiltIn)
+	}
+
+	currentTime := time.Now()
+	if err := p.roleSaver.DeletePermission(ctx, appID, name, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrPermissionNotFound) {
+			log.Warn("permission not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionNotFound)
+		}
+
+		log.Error("failed to delete permission", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("permission deleted")
+
+	return nil
+}
+
+// Permissions returns all permissions of the app
+func (p *Permission) Permissions(ctx context.Context, appID int) ([]models.Permission, error) {
+	const op = "services.permission.Permissions"
+
+	log := p.log.With(slog.String("op", op), slog.Int("app_id", appID))
+
+	permissions, err := p.roleProvider.Permissions(ctx, appID)
+	if err != nil {
+		log.Error("failed to get permissions", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return permissions, nil
+}
+
+// GrantPermission adds permission to the role, tokens issued afterwards carry it.
+// Role can be granted only permissions of the same app
+//
+// If role or permission doesn't exist, returns error
+// If the role already has the permission, returns error
+func (p *Permission) GrantPermission(ctx context.Context, appID int, role string, permission string) error {
+	const op = "services.permission.GrantPermission"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("role", role),
+		slog.String("permission", permission),
+	)
+
+	if err := p.roleSaver.GrantPermission(ctx, appID, role, permission); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrRoleNotFound):
+			log.Warn("role not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotFound)
+		case errors.Is(err, storage.ErrPermissionNotFound):
+			log.Warn("permission not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionNotFound)
+		case errors.Is(err, storage.ErrPermissionGranted):
+			log.Warn("permission already granted", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionGranted)
+		}
+
+		log.Error("failed to grant permission", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("permission granted")
+
+	return nil
+}
+
+// RevokePermission takes permission away from the role and revokes tokens of users with the role
+//
+// If the role doesn't have the permission, returns error
+func (p *Permission) RevokePermission(ctx context.Context, appID int, role string, permission string) error {
+	const op = "services.permission.RevokePermission"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("role", role),
+		slog.String("permission", permission),
+	)
+
+	currentTime := time.Now()
+	if err := p.roleSaver.RevokePermission(ctx, appID, role, permission, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrPermissionNotGranted) {
+			log.Warn("permission not granted", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrPermissionNotGranted)
+		}
+
+		log.Error("failed to revoke permission", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("permission revoked")
+
+	return nil
+}
+
+// AssignRole assigns the role defined by the app to the user with given email, tokens issued afterwards carry it
+//
+// If user or role doesn't exist, returns error
+// If the user already has the role, returns error
+func (p *Permission) AssignRole(ctx context.Context, appID int, email string, role string) error {
+	const op = "services.permission.AssignRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("email", email),
+		slog.String("role", role),
+	)
+
+	if err := p.roleAssigner.AssignRole(ctx, email, appID, role); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Warn("user not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		case errors.Is(err, storage.ErrRoleNotFound):
+			log.Warn("role not found", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotFound)
+		case errors.Is(err, storage.ErrRoleAssigned):
+			log.Warn("role already assigned", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleAssigned)
+		}
+
+		log.Error("failed to assign role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("role assigned")
+
+	return nil
+}
+
+// UnassignRole takes the role defined by the app away from the user with given email and revokes their tokens
+//
+// If the user doesn't have the role, returns error
+func (p *Permission) UnassignRole(ctx context.Context, appID int, email string, role string) error {
+	const op = "services.permission.UnassignRole"
+
+	log := p.log.With(
+		slog.String("op", op),
+		slog.Int("app_id", appID),
+		slog.String("email", email),
+		slog.String("role", role),
+	)
+
+	currentTime := time.Now()
+	if err := p.roleAssigner.UnassignRole(ctx, email, appID, role, currentTime, p.revokedUntil(currentTime)); err != nil {
+		if errors.Is(err, storage.ErrRoleNotAssigned) {
+			log.Warn("role not assigned", sl.Err(err))
+			return fmt.Errorf("%s: %w", op, service.ErrRoleNotAssigned)
+		}
+
+		log.Error("failed to unassign role", sl.Err(err))
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	p.decisions.invalidate()
+
+	log.Warn("role unassigned")
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package relation
+
+import (
+	"sync"
+	"time"
+
+	"sso/internal/domain/models"
+)
+
+// CacheParams are limits of the cache of checks
+type CacheParams struct {
+	// TTL bounds how stale checks made without consistency token may be
+	TTL  time.Duration
+	Size int
+}
+
+type cachedCheck struct {
+	allowed bool
+	// revision is the revision of tuples the check was made at
+	revision  int64
+	expiresAt time.Time
+}
+
+// checkCache keeps recent results of Check. Writes don't drop them, instead checks with consistency token
+// use only results made at revision of the token or later, and others accept results up to TTL old
+type checkCache struct {
+	mu      sync.Mutex
+	params  CacheParams
+	entries map[models.RelationTuple]cachedCheck
+}
+
+func newCheckCache(params CacheParams) *checkCache {
+	return &checkCache{
+		params:  params,
+		entries: make(map[models.RelationTuple]cachedCheck),
+	}
+}
+
+// get returns cached result of the check made at minRevision or later, and the revision it was made at
+func (c *checkCache) get(check models.RelationTuple, minRevision int64, now time.Time) (bool, int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.entries[check]
+	if !ok || !now.Before(cached.expiresAt) || cached.revision < minRevision {
+		return false, 0, false
+	}
+
+	return cached.allowed, cached.revision, true
+}
+
+// put caches result of the check made at the revision, unless a result of later revision is cached
+func (c *checkCache) put(check models.RelationTuple, allowed bool, revision int64, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.params.TTL <= 0 || c.params.Size <= 0 {
+		return
+	}
+	if cached, ok := c.entries[check]; ok && cached.revision > revision && now.Before(cached.expiresAt) {
+		return
+	}
+
+	if len(c.entries) >= c.params.Size {
+		c.evict(now)
+	}
+
+	c.entries[check] = cachedCheck{allowed: allowed, revision: revision, expiresAt: now.Add(c.params.TTL)}
+}
+
+// evict drops expired results, or an arbitrary one if none has expired
+func (c *checkCache) evict(now time.Time) {
+	for check, cached := range c.entries {
+		if !now.Before(cached.expiresAt) {
+			delete(c.entries, check)
+		}
+	}
+	for check := range c.entries {
+		if len(c.entries) < c.params.Size {
+			return
+		}
+		delete(c.entries, check)
+	}
+}
@@ -0,0 +1,110 @@
+package relation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+)
+
+func TestCheckCache(t *testing.T) {
+	check := models.RelationTuple{Namespace: "document", ObjectID: "readme", Relation: "viewer", Subject: models.Subject{Namespace: "user", ID: "1"}}
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		// puts are results cached at revisions 1, 2, ... one second apart, starting at now
+		puts         []bool
+		at           time.Time
+		minRevision  int64
+		wantOK       bool
+		wantAllowed  bool
+		wantRevision int64
+	}{
+		{name: "empty", at: now, wantOK: false},
+		{name: "cached", puts: []bool{true}, at: now, wantOK: true, wantAllowed: true, wantRevision: 1},
+		{name: "at revision of token", puts: []bool{true}, at: now, minRevision: 1, wantOK: true, wantAllowed: true, wantRevision: 1},
+		{name: "older than token", puts: []bool{true}, at: now, minRevision: 2, wantOK: false},
+		{name: "latest put", puts: []bool{true, false}, at: now.Add(time.Second), wantOK: true, wantAllowed: false, wantRevision: 2},
+		{name: "expired", puts: []bool{true}, at: now.Add(time.Minute), wantOK: false},
+		{name: "about to expire", puts: []bool{true}, at: now.Add(time.Minute - time.Nanosecond), wantOK: true, wantAllowed: true, wantRevision: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCheckCache(CacheParams{TTL: time.Minute, Size: 10})
+			for i, allowed := range tt.puts {
+				c.put(check, allowed, int64(i+1), now.Add(time.Duration(i)*time.Second))
+			}
+
+			allowed, revision, ok := c.get(check, tt.minRevision, tt.at)
+			if ok != tt.wantOK {
+				t.Fatalf("get() ok = %t, want %t", ok, tt.wantOK)
+			}
+			if ok && (allowed != tt.wantAllowed || revision != tt.wantRevision) {
+				t.Errorf("get() = %t at %d, want %t at %d", allowed, revision, tt.wantAllowed, tt.wantRevision)
+			}
+		})
+	}
+}
+
+func TestCheckCacheKeepsLaterRevision(t *testing.T) {
+	check := models.RelationTuple{Namespace: "document", ObjectID: "readme", Relation: "viewer", Subject: models.Subject{Namespace: "user", ID: "1"}}
+	now := time.Now()
+
+	c := newCheckCache(CacheParams{TTL: time.Minute, Size: 10})
+	c.put(check, true, 5, now)
+	// a check that started before the write finishes after it
+	c.put(check, false, 4, now)
+
+	if allowed, revision, ok := c.get(check, 0, now); !ok || !allowed || revision != 5 {
+		t.Fatalf("get() = %t at %d, %t, want true at 5", allowed, revision, ok)
+	}
+
+	// once expired, the later revision gives way
+	later := now.Add(time.Minute)
+	c.put(check, false, 4, later)
+	if allowed, revision, ok := c.get(check, 0, later); !ok || allowed || revision != 4 {
+		t.Fatalf("get() = %t at %d, %t, want false at 4", allowed, revision, ok)
+	}
+}
+
+func TestCheckCacheSize(t *testing.T) {
+	now := time.Now()
+	checkOf := func(i int) models.RelationTuple {
+		return models.RelationTuple{Namespace: "document", ObjectID: fmt.Sprint(i), Relation: "viewer", Subject: models.Subject{Namespace: "user", ID: "1"}}
+	}
+
+	c := newCheckCache(CacheParams{TTL: time.Minute, Size: 3})
+	for i := 0; i < 3; i++ {
+		c.put(checkOf(i), true, 1, now)
+	}
+	// expired results are evicted first
+	c.put(checkOf(3), true, 1, now.Add(time.Minute))
+	if len(c.entries) != 1 {
+		t.Fatalf("cache has %d results, want 1", len(c.entries))
+	}
+
+	for i := 4; i < 10; i++ {
+		c.put(checkOf(i), true, 1, now.Add(time.Minute))
+		if len(c.entries) > 3 {
+			t.Fatalf("cache has %d results, want at most 3", len(c.entries))
+		}
+	}
+	if _, _, ok := c.get(checkOf(9), 0, now.Add(time.Minute)); !ok {
+		t.Error("the latest result is evicted")
+	}
+}
+
+func TestCheckCacheDisabled(t *testing.T) {
+	check := models.RelationTuple{Namespace: "document", ObjectID: "readme", Relation: "viewer", Subject: models.Subject{Namespace: "user", ID: "1"}}
+
+	for _, params := range []CacheParams{{TTL: 0, Size: 10}, {TTL: time.Minute, Size: 0}} {
+		c := newCheckCache(params)
+		c.put(check, true, 1, time.Now())
+		if _, _, ok := c.get(check, 0, time.Now()); ok {
+			t.Errorf("result is cached with %+v", params)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package relation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/rebac"
+	"sso/internal/service"
+)
+
+// maxDepth bounds nesting of usersets followed by a check, deeper graphs are most likely misconfigured
+const maxDepth = 32
+
+// Check reports whether the subject holds relation of the object, following rewrites of namespace config.
+// Without consistency token the result may be stale up to cache TTL, with it the result reflects
+// at least the write the token was returned by. Returns consistency token of the revision checked at
+//
+// If object, relation or subject isn't defined in namespace config, returns error
+func (r *Relation) Check(ctx context.Context, check models.RelationTuple, consistencyToken string) (bool, string, error) {
+	const op = "services.relation.Check"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.String("check", rebac.FormatTuple(check)),
+	)
+
+	minRevision, err := r.minRevision(consistencyToken)
+	if err != nil {
+		log.Warn("invalid consistency token", sl.Err(err))
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.validateCheck(check.Namespace, check.Relation, check.Subject); err != nil {
+		log.Warn("check doesn't fit namespace config", sl.Err(err))
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	now := time.Now()
+	if allowed, revision, ok := r.checks.get(check, minRevision, now); ok {
+		return allowed, rebac.EncodeToken(revision), nil
+	}
+
+	revision, err := r.revision(ctx, minRevision)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidConsistencyToken) {
+			log.Warn("consistency token is ahead of storage", sl.Err(err))
+			return false, "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get revision", sl.Err(err))
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	eval := r.newEvaluation(ctx, check.Subject)
+	allowed, err := eval.holds(check.Namespace, check.ObjectID, check.Relation, 0)
+	if err != nil {
+		log.Error("failed to check relation", sl.Err(err))
+		return false, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	r.checks.put(check, allowed, revision, now)
+
+	log.Debug("relation checked", slog.Bool("allowed", allowed))
+
+	return allowed, rebac.EncodeToken(revision), nil
+}
+
+// LookupResources returns IDs of objects of the namespace the subject holds relation of, they are always
+// looked up at the latest revision. Returns consistency token of the revision looked up at
+//
+// If namespace, relation or subject isn't defined in namespace config, returns error
+func (r *Relation) LookupResources(
+	ctx context.Context,
+	namespace string,
+	relation string,
+	subject models.Subject,
+	consistencyToken string,
+) ([]string, string, error) {
+	const op = "services.relation.LookupResources"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.String("namespace", namespace),
+		slog.String("relation", relation),
+		slog.String("subject", rebac.FormatSubject(subject)),
+	)
+
+	minRevision, err := r.minRevision(consistencyToken)
+	if err != nil {
+		log.Warn("invalid consistency token", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := r.validateCheck(namespace, relation, subject); err != nil {
+		log.Warn("lookup doesn't fit namespace config", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	revision, err := r.revision(ctx, minRevision)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidConsistencyToken) {
+			log.Warn("consistency token is ahead of storage", sl.Err(err))
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+
+		log.Error("failed to get revision", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	// objects without tuples hold no relations, so only objects having any are checked
+	ids, err := r.tupleProvider.ObjectIDs(ctx, namespace)
+	if err != nil {
+		log.Error("failed to get objects", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	eval := r.newEvaluation(ctx, subject)
+	var found []string
+	for _, id := range ids {
+		allowed, err := eval.holds(namespace, id, relation, 0)
+		if err != nil {
+			log.Error("failed to check relation", sl.Err(err))
+			return nil, "", fmt.Errorf("%s: %w", op, err)
+		}
+		if allowed {
+			found = append(found, id)
+		}
+	}
+
+	return found, rebac.EncodeToken(revision), nil
+}
+
+// minRevision returns revision of the consistency token, zero if it's empty
+func (r *Relation) minRevision(consistencyToken string) (int64, error) {
+	if consistencyToken == "" {
+		return 0, nil
+	}
+
+	revision, err := rebac.DecodeToken(consistencyToken)
+	if err != nil {
+		return 0, service.ErrInvalidConsistencyToken
+	}
+
+	return revision, nil
+}
+
+// revision returns the latest revision of tuples, which is the revision they are read at.
+// It can't be older than revision of a valid token, since revisions are only added
+func (r *Relation) revision(ctx context.Context, minRevision int64) (int64, error) {
+	revision, err := r.tupleProvider.RelationRevision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if revision < minRevision {
+		return 0, service.ErrInvalidConsistencyToken
+	}
+
+	return revision, nil
+}
+
+// validateCheck checks relation of the namespace and the subject are defined, computed relations
+// can be checked as well as direct ones
+func (r *Relation) validateCheck(namespace, relation string, subject models.Subject) error {
+	if err := r.schema.ValidateRelation(namespace, relation); err != nil {
+		return &service.SchemaError{Reason: err.Error()}
+	}
+	if err := r.schema.ValidateSubject(subject); err != nil {
+		return &service.SchemaError{Reason: err.Error()}
+	}
+	return nil
+}
+
+type objectRelation struct {
+	namespace string
+	id        string
+	relation  string
+}
+
+// evaluation follows rewrites of relations to find whether subject holds them
+type evaluation struct {
+	ctx           context.Context
+	schema        *rebac.Schema
+	tupleProvider TupleProvider
+	subject       models.Subject
+	// visiting holds relations on the current path, reaching one of them again is a cycle, which adds nothing
+	visiting map[objectRelation]bool
+}
+
+func (r *Relation) newEvaluation(ctx context.Context, subject models.Subject) *evaluation {
+	return &evaluation{
+		ctx:           ctx,
+		schema:        r.schema,
+		tupleProvider: r.tupleProvider,
+		subject:       subject,
+		visiting:      make(map[objectRelation]bool),
+	}
+}
+
+// holds reports whether the subject holds relation of the object, following rewrite of the relation
+func (e *evaluation) holds(namespace, id, relation string, depth int) (bool, error) {
+	if e.subject == (models.Subject{Namespace: namespace, ID: id, Relation: relation}) {
+		return true, nil
+	}
+	if depth > maxDepth {
+		return false, service.ErrRelationTooDeep
+	}
+
+	// relation of tuple-to-userset may be missing in namespace of objects the tupleset leads to
+	rel, ok := e.schema.Relation(namespace, relation)
+	if !ok {
+		return false, nil
+	}
+
+	key := objectRelation{namespace: namespace, id: id, relation: relation}
+	if e.visiting[key] {
+		return false, nil
+	}
+	e.visiting[key] = true
+	defer delete(e.visiting, key)
+
+	return e.rewrite(namespace, id, relation, rel.Rewrite, depth)
+}
+
+// rewrite reports whether the subject is in the set the rewrite defines for relation of the object
+func (e *evaluation) rewrite(namespace, id, relation string, rewrite *rebac.Rewrite, depth int) (bool, error) {
+	if len(rewrite.Children) == 0 {
+		switch rewrite.Userset.Kind {
+		case rebac.This:
+			return e.direct(namespace, id, relation, depth)
+		case rebac.Computed:
+			return e.holds(namespace, id, rewrite.Userset.Relation, depth+1)
+		case rebac.TupleToUserset:
+			return e.tupleToUserset(namespace, id, rewrite.Userset, depth)
+		}
+		return false, nil
+	}
+
+	switch rewrite.Operation {
+	case rebac.Intersection:
+		for _, child := range rewrite.Children {
+			found, err := e.rewrite(namespace, id, relation, child, depth)
+			if err != nil || !found {
+				return false, err
+			}
+		}
+		return true, nil
+	case rebac.Exclusion:
+		found, err := e.rewrite(namespace, id, relation, rewrite.Children[0], depth)
+		if err != nil || !found {
+			return false, err
+		}
+		for _, child := range rewrite.Children[1:] {
+			excluded, err := e.rewrite(namespace, id, relation, child, depth)
+			if err != nil || excluded {
+				return false, err
+			}
+		}
+		return true, nil
+	default:
+		for _, child := range rewrite.Children {
+			found, err := e.rewrite(namespace, id, relation, child, depth)
+			if err != nil || found {
+				return found, err
+			}
+		}
+		return false, nil
+	}
+}
+
+// direct looks for the subject among subjects of tuples of the relation, expanding subject sets
+func (e *evaluation) direct(namespace, id, relation string, depth int) (bool, error) {
+	tuples, err := e.tupleProvider.Tuples(e.ctx, models.TupleFilter{Namespace: namespace, ObjectID: id, Relation: relation})
+	if err != nil {
+		return false, err
+	}
+
+	for _, tuple := range tuples {
+		if tuple.Subject == e.subject {
+			return true, nil
+		}
+	}
+	for _, tuple := range tuples {
+		if tuple.Subject.Relation == "" {
+			continue
+		}
+		found, err := e.holds(tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation, depth+1)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	return false, nil
+}
+
+// tupleToUserset looks for the subject among holders of userset relation of objects related by tupleset
+func (e *evaluation) tupleToUserset(namespace, id string, userset rebac.Userset, depth int) (bool, error) {
+	tuples, err := e.tupleProvider.Tuples(e.ctx, models.TupleFilter{Namespace: namespace, ObjectID: id, Relation: userset.Tupleset})
+	if err != nil {
+		return false, err
+	}
+
+	for _, tuple := range tuples {
+		found, err := e.holds(tuple.Subject.Namespace, tuple.Subject.ID, userset.Relation, depth+1)
+		if err != nil || found {
+			return found, err
+		}
+	}
+
+	return false, nil
+}
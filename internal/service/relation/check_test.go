@@ -0,0 +1,428 @@
+package relation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/handlers/slogdiscard"
+	"sso/internal/lib/rebac"
+	"sso/internal/service"
+)
+
+const testSchema = `
+namespace user {}
+
+namespace group {
+    relation member
+}
+
+namespace folder {
+    relation parent
+    relation viewer = this | parent->viewer
+}
+
+namespace document {
+    relation parent
+    relation owner
+    relation banned
+    relation editor = this | owner
+    relation viewer = (this | editor | parent->viewer) - banned
+    relation publisher = editor & parent->viewer
+}`
+
+// memoryTuples keeps tuples in memory, each write makes a new revision
+type memoryTuples struct {
+	mu       sync.Mutex
+	tuples   []models.RelationTuple
+	revision int64
+	// reads is number of Tuples calls
+	reads int
+}
+
+func (m *memoryTuples) WriteTuples(_ context.Context, tuples []models.RelationTuple) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tuples = append(m.tuples, tuples...)
+	m.revision++
+	return m.revision, nil
+}
+
+func (m *memoryTuples) DeleteTuples(_ context.Context, tuples []models.RelationTuple) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tuples = slices.DeleteFunc(m.tuples, func(tuple models.RelationTuple) bool {
+		return slices.Contains(tuples, tuple)
+	})
+	m.revision++
+	return m.revision, nil
+}
+
+func (m *memoryTuples) Tuples(_ context.Context, filter models.TupleFilter) ([]models.RelationTuple, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reads++
+
+	var found []models.RelationTuple
+	for _, tuple := range m.tuples {
+		if filter.Namespace != "" && tuple.Namespace != filter.Namespace ||
+			filter.ObjectID != "" && tuple.ObjectID != filter.ObjectID ||
+			filter.Relation != "" && tuple.Relation != filter.Relation ||
+			filter.Subject != (models.Subject{}) && tuple.Subject != filter.Subject {
+			continue
+		}
+		found = append(found, tuple)
+	}
+	return found, nil
+}
+
+func (m *memoryTuples) ObjectIDs(_ context.Context, namespace string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for _, tuple := range m.tuples {
+		if tuple.Namespace == namespace && !slices.Contains(ids, tuple.ObjectID) {
+			ids = append(ids, tuple.ObjectID)
+		}
+	}
+	slices.Sort(ids)
+	return ids, nil
+}
+
+func (m *memoryTuples) RelationRevision(_ context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.revision, nil
+}
+
+func (m *memoryTuples) readCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.reads
+}
+
+// parseTuple parses tuple in form namespace:id#relation@subject
+func parseTuple(t *testing.T, s string) models.RelationTuple {
+	t.Helper()
+
+	object, subject, _ := strings.Cut(s, "@")
+	object, relation, _ := strings.Cut(object, "#")
+
+	namespace, id, err := rebac.ParseObject(object)
+	if err != nil {
+		t.Fatalf("invalid tuple %q: %v", s, err)
+	}
+	parsed, err := rebac.ParseSubject(subject)
+	if err != nil {
+		t.Fatalf("invalid tuple %q: %v", s, err)
+	}
+
+	return models.RelationTuple{Namespace: namespace, ObjectID: id, Relation: relation, Subject: parsed}
+}
+
+// newTestRelation returns relation service with the schema and tuples, checks are cached with cacheParams
+func newTestRelation(t *testing.T, schema string, cacheParams CacheParams, tuples ...string) (*Relation, *memoryTuples) {
+	t.Helper()
+
+	parsed, err := rebac.Parse(schema)
+	if err != nil {
+		t.Fatalf("failed to parse schema: %v", err)
+	}
+
+	store := &memoryTuples{}
+	r := New(slogdiscard.NewDiscardLogger(), store, store, parsed, cacheParams)
+
+	if len(tuples) > 0 {
+		write := make([]models.RelationTuple, 0, len(tuples))
+		for _, tuple := range tuples {
+			write = append(write, parseTuple(t, tuple))
+		}
+		if _, err := r.WriteTuples(context.Background(), write); err != nil {
+			t.Fatalf("failed to write tuples: %v", err)
+		}
+	}
+
+	return r, store
+}
+
+func TestCheck(t *testing.T) {
+	r, _ := newTestRelation(t, testSchema, CacheParams{},
+		"group:eng#member@user:1",
+		"group:eng#member@user:2",
+		"folder:root#viewer@user:3",
+		"folder:root#viewer@user:4",
+		"folder:docs#parent@folder:root",
+		"document:readme#parent@folder:docs",
+		"document:readme#owner@user:4",
+		"document:readme#editor@group:eng#member",
+		"document:readme#viewer@user:5",
+		"document:readme#banned@user:2",
+		"document:readme#banned@user:6",
+		"document:readme#viewer@user:6",
+	)
+
+	tests := []struct {
+		name  string
+		check string
+		want  bool
+	}{
+		{name: "direct", check: "document:readme#viewer@user:5", want: true},
+		{name: "no tuples", check: "document:readme#viewer@user:7", want: false},
+		{name: "relation of other object", check: "document:draft#viewer@user:5", want: false},
+		{name: "computed", check: "document:readme#editor@user:4", want: true},
+		{name: "computed twice", check: "document:readme#viewer@user:4", want: true},
+		{name: "computed doesn't go backwards", check: "document:readme#owner@user:5", want: false},
+		{name: "subject set", check: "document:readme#editor@user:1", want: true},
+		{name: "subject set through computed", check: "document:readme#viewer@user:1", want: true},
+		{name: "subject set itself", check: "document:readme#viewer@group:eng#member", want: true},
+		{name: "tuple to userset", check: "folder:docs#viewer@user:3", want: true},
+		{name: "nested tuple to userset", check: "document:readme#viewer@user:3", want: true},
+		{name: "tuple to userset of other relation", check: "document:readme#editor@user:3", want: false},
+		{name: "excluded member of union", check: "document:readme#viewer@user:2", want: false},
+		{name: "excluded direct", check: "document:readme#viewer@user:6", want: false},
+		{name: "exclusion keeps other relations", check: "document:readme#editor@user:2", want: true},
+		{name: "intersection of both", check: "document:readme#publisher@user:4", want: true},
+		{name: "intersection of first only", check: "document:readme#publisher@user:1", want: false},
+		{name: "intersection of second only", check: "document:readme#publisher@user:3", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			allowed, _, err := r.Check(context.Background(), parseTuple(t, tt.check), "")
+			if err != nil {
+				t.Fatalf("Check(%s): %v", tt.check, err)
+			}
+			if allowed != tt.want {
+				t.Errorf("Check(%s) = %t, want %t", tt.check, allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		tuples []string
+		check  string
+		want   bool
+	}{
+		{
+			name:   "subject sets of each other",
+			tuples: []string{"group:a#member@group:b#member", "group:b#member@group:a#member"},
+			check:  "group:a#member@user:1",
+			want:   false,
+		},
+		{
+			name:   "member found past cycle",
+			tuples: []string{"group:a#member@group:b#member", "group:b#member@group:a#member", "group:b#member@user:1"},
+			check:  "group:a#member@user:1",
+			want:   true,
+		},
+		{
+			name:   "subject set of itself",
+			tuples: []string{"group:a#member@group:a#member"},
+			check:  "group:a#member@user:1",
+			want:   false,
+		},
+		{
+			name:   "parents of each other",
+			tuples: []string{"folder:a#parent@folder:b", "folder:b#parent@folder:a"},
+			check:  "folder:a#viewer@user:1",
+			want:   false,
+		},
+		{
+			name:   "viewer found past parent cycle",
+			tuples: []string{"folder:a#parent@folder:b", "folder:b#parent@folder:a", "folder:b#viewer@user:1"},
+			check:  "folder:a#viewer@user:1",
+			want:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestRelation(t, testSchema, CacheParams{}, tt.tuples...)
+
+			allowed, _, err := r.Check(context.Background(), parseTuple(t, tt.check), "")
+			if err != nil {
+				t.Fatalf("Check(%s): %v", tt.check, err)
+			}
+			if allowed != tt.want {
+				t.Errorf("Check(%s) = %t, want %t", tt.check, allowed, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckTooDeep(t *testing.T) {
+	var tuples []string
+	for i := 0; i <= maxDepth; i++ {
+		tuples = append(tuples, fmt.Sprintf("group:g%d#member@group:g%d#member", i, i+1))
+	}
+	tuples = append(tuples, fmt.Sprintf("group:g%d#member@user:1", maxDepth+1))
+
+	r, _ := newTestRelation(t, testSchema, CacheParams{}, tuples...)
+
+	_, _, err := r.Check(context.Background(), parseTuple(t, "group:g0#member@user:1"), "")
+	if !errors.Is(err, service.ErrRelationTooDeep) {
+		t.Fatalf("Check() error = %v, want %v", err, service.ErrRelationTooDeep)
+	}
+
+	// the same chain within the limit is followed
+	allowed, _, err := r.Check(context.Background(), parseTuple(t, "group:g2#member@user:1"), "")
+	if err != nil || !allowed {
+		t.Fatalf("Check() = %t, %v, want true", allowed, err)
+	}
+}
+
+func TestCheckSchemaErrors(t *testing.T) {
+	r, _ := newTestRelation(t, testSchema, CacheParams{})
+
+	for _, check := range []string{
+		"team:eng#member@user:1",
+		"document:readme#reader@user:1",
+		"document:readme#viewer@team:eng",
+		"document:readme#viewer@group:eng#admin",
+	} {
+		var schemaErr *service.SchemaError
+		if _, _, err := r.Check(context.Background(), parseTuple(t, check), ""); !errors.As(err, &schemaErr) {
+			t.Errorf("Check(%s) error = %v, want schema error", check, err)
+		}
+	}
+}
+
+func TestLookupResources(t *testing.T) {
+	r, _ := newTestRelation(t, testSchema, CacheParams{},
+		"group:eng#member@user:1",
+		"folder:root#viewer@user:2",
+		"document:readme#editor@group:eng#member",
+		"document:draft#owner@user:1",
+		"document:draft#banned@user:2",
+		"document:draft#parent@folder:root",
+		"document:spec#parent@folder:root",
+		"document:notes#viewer@user:3",
+	)
+
+	tests := []struct {
+		name     string
+		relation string
+		subject  string
+		want     []string
+	}{
+		{name: "computed and subject set", relation: "viewer", subject: "user:1", want: []string{"draft", "readme"}},
+		{name: "tuple to userset with exclusion", relation: "viewer", subject: "user:2", want: []string{"spec"}},
+		{name: "direct", relation: "viewer", subject: "user:3", want: []string{"notes"}},
+		{name: "intersection", relation: "publisher", subject: "user:1", want: nil},
+		{name: "nothing", relation: "viewer", subject: "user:4", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := rebac.ParseSubject(tt.subject)
+			if err != nil {
+				t.Fatalf("ParseSubject: %v", err)
+			}
+
+			got, _, err := r.LookupResources(context.Background(), "document", tt.relation, subject, "")
+			if err != nil {
+				t.Fatalf("LookupResources: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("LookupResources() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckConsistencyToken(t *testing.T) {
+	ctx := context.Background()
+
+	r, store := newTestRelation(t, testSchema, CacheParams{TTL: time.Hour, Size: 100})
+	check := parseTuple(t, "document:readme#viewer@user:1")
+
+	allowed, first, err := r.Check(ctx, check, "")
+	if err != nil || allowed {
+		t.Fatalf("Check() = %t, %v, want false", allowed, err)
+	}
+
+	token, err := r.WriteTuples(ctx, []models.RelationTuple{parseTuple(t, "document:readme#viewer@user:1")})
+	if err != nil {
+		t.Fatalf("WriteTuples: %v", err)
+	}
+
+	// without token the cached result may be returned, along with the token of its revision
+	reads := store.readCount()
+	allowed, stale, err := r.Check(ctx, check, "")
+	if err != nil || allowed {
+		t.Fatalf("Check() = %t, %v, want cached false", allowed, err)
+	}
+	if stale != first {
+		t.Errorf("token of cached check = %q, want %q", stale, first)
+	}
+	if store.readCount() != reads {
+		t.Error("cached check read tuples")
+	}
+
+	// a token newer than the cached revision skips the cache
+	allowed, fresh, err := r.Check(ctx, check, token)
+	if err != nil || !allowed {
+		t.Fatalf("Check() with token = %t, %v, want true", allowed, err)
+	}
+	if fresh != token {
+		t.Errorf("token of check = %q, want %q", fresh, token)
+	}
+	if store.readCount() == reads {
+		t.Error("check with newer token didn't read tuples")
+	}
+
+	// the fresh result replaces the cached one
+	reads = store.readCount()
+	for _, consistencyToken := range []string{"", first, token} {
+		allowed, got, err := r.Check(ctx, check, consistencyToken)
+		if err != nil || !allowed {
+			t.Fatalf("Check() with token %q = %t, %v, want true", consistencyToken, allowed, err)
+		}
+		if got != token {
+			t.Errorf("token of check = %q, want %q", got, token)
+		}
+	}
+	if store.readCount() != reads {
+		t.Error("check at cached revision read tuples")
+	}
+}
+
+func TestCheckInvalidConsistencyToken(t *testing.T) {
+	r, _ := newTestRelation(t, testSchema, CacheParams{TTL: time.Hour, Size: 100}, "document:readme#viewer@user:1")
+	check := parseTuple(t, "document:readme#viewer@user:1")
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "malformed", token: "not a token"},
+		{name: "ahead of storage", token: rebac.EncodeToken(2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := r.Check(context.Background(), check, tt.token); !errors.Is(err, service.ErrInvalidConsistencyToken) {
+				t.Errorf("Check() error = %v, want %v", err, service.ErrInvalidConsistencyToken)
+			}
+			subject := models.Subject{Namespace: "user", ID: "1"}
+			if _, _, err := r.LookupResources(context.Background(), "document", "viewer", subject, tt.token); !errors.Is(err, service.ErrInvalidConsistencyToken) {
+				t.Errorf("LookupResources() error = %v, want %v", err, service.ErrInvalidConsistencyToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package relation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"sso/internal/domain/models"
+	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/rebac"
+	"sso/internal/service"
+	"sso/internal/storage"
+)
+
+type Relation struct {
+	log           *slog.Logger
+	tupleSaver    TupleSaver
+	tupleProvider TupleProvider
+	schema        *rebac.Schema
+	checks        *checkCache
+}
+
+// TupleSaver writes and deletes relation tuples atomically, each call makes a new revision
+type TupleSaver interface {
+	WriteTuples(ctx context.Context, tuples []models.RelationTuple) (int64, error)
+	DeleteTuples(ctx context.Context, tuples []models.RelationTuple) (int64, error)
+}
+
+type TupleProvider interface {
+	Tuples(ctx context.Context, filter models.TupleFilter) ([]models.RelationTuple, error)
+	ObjectIDs(ctx context.Context, namespace string) ([]string, error)
+	RelationRevision(ctx context.Context) (int64, error)
+}
+
+// New returns a new instance of the Relation service, tuples and checks must fit the schema
+func New(
+	log *slog.Logger,
+	tupleSaver TupleSaver,
+	tupleProvider TupleProvider,
+	schema *rebac.Schema,
+	cacheParams CacheParams,
+) *Relation {
+	return &Relation{
+		log:           log,
+		tupleSaver:    tupleSaver,
+		tupleProvider: tupleProvider,
+		schema:        schema,
+		checks:        newCheckCache(cacheParams),
+	}
+}
+
+// WriteTuples saves the tuples and returns consistency token of the write
+//
+// If any tuple doesn't fit namespace config or already exists, returns error and saves nothing
+func (r *Relation) WriteTuples(ctx context.Context, tuples []models.RelationTuple) (string, error) {
+	const op = "services.relation.WriteTuples"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.Int("tuples", len(tuples)),
+	)
+
+	if err := r.validateTuples(tuples); err != nil {
+		log.Warn("tuple doesn't fit namespace config", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	revision, err := r.tupleSaver.WriteTuples(ctx, tuples)
+	if err != nil {
+		if errors.Is(err, storage.ErrTupleExists) {
+			log.Warn("tuple already exists", sl.Err(err))
+			return "", fmt.Errorf("%s: %w", op, service.ErrTupleExists)
+		}
+
+		log.Error("failed to write tuples", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("tuples written", slog.Int64("revision", revision))
+
+	return rebac.EncodeToken(revision), nil
+}
+
+// DeleteTuples deletes the tuples and returns consistency token of the deletion
+//
+// If any tuple doesn't exist, returns error and deletes nothing
+func (r *Relation) DeleteTuples(ctx context.Context, tuples []models.RelationTuple) (string, error) {
+	const op = "services.relation.DeleteTuples"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.Int("tuples", len(tuples)),
+	)
+
+	revision, err := r.tupleSaver.DeleteTuples(ctx, tuples)
+	if err != nil {
+		if errors.Is(err, storage.ErrTupleNotFound) {
+			log.Warn("tuple not found", sl.Err(err))
+			return "", fmt.Errorf("%s: %w", op, service.ErrTupleNotFound)
+		}
+
+		log.Error("failed to delete tuples", sl.Err(err))
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("tuples deleted", slog.Int64("revision", revision))
+
+	return rebac.EncodeToken(revision), nil
+}
+
+// ReadTuples returns tuples matching the filter as stored, without following rewrites of relations,
+// and consistency token of the revision they were read at
+func (r *Relation) ReadTuples(ctx context.Context, filter models.TupleFilter) ([]models.RelationTuple, string, error) {
+	const op = "services.relation.ReadTuples"
+
+	log := r.log.With(
+		slog.String("op", op),
+		slog.String("namespace", filter.Namespace),
+	)
+
+	// revision is taken first, so tuples are at least as fresh as it
+	revision, err := r.tupleProvider.RelationRevision(ctx)
+	if err != nil {
+		log.Error("failed to get revision", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	tuples, err := r.tupleProvider.Tuples(ctx, filter)
+	if err != nil {
+		log.Error("failed to read tuples", sl.Err(err))
+		return nil, "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tuples, rebac.EncodeToken(revision), nil
+}
+
+// validateTuples checks every tuple fits namespace config
+func (r *Relation) validateTuples(tuples []models.RelationTuple) error {
+	for _, tuple := range tuples {
+		if err := r.schema.ValidateTuple(tuple); err != nil {
+			return &service.SchemaError{Reason: rebac.FormatTuple(tuple) + ": " + err.Error()}
+		}
+	}
+	return nil
+}
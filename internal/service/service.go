@@ -1,13 +1,108 @@
 package service
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
 
 var (
-	ErrInvalidCredentials = errors.New("invalid credentials")
-	ErrUserExists         = errors.New("user already exists")
-	ErrAdminExists        = errors.New("admin already exists")
-	ErrAppNotFound        = errors.New("app not found")
-	ErrAppAlreadyExists   = errors.New("app already exists")
-	ErrUserNotFound       = errors.New("user not found")
-	ErrAdminNotFound      = errors.New("admin not found")
+	ErrInvalidCredentials  = errors.New("invalid credentials")
+	ErrUserExists          = errors.New("user already exists")
+	ErrAdminExists         = errors.New("admin already exists")
+	ErrAppNotFound         = errors.New("app not found")
+	ErrAppAlreadyExists    = errors.New("app already exists")
+	ErrUserNotFound        = errors.New("user not found")
+	ErrAdminNotFound       = errors.New("admin not found")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrInvalidTokenTTL     = errors.New("invalid token ttl")
+	ErrInvalidRedirectURI  = errors.New("invalid redirect uri")
+	ErrInvalidGrant        = errors.New("invalid grant")
+	ErrInvalidClient       = errors.New("invalid client")
+	ErrInvalidScope        = errors.New("invalid scope")
+	ErrInsufficientScope   = errors.New("insufficient scope")
+
+	ErrInvalidUserCode      = errors.New("invalid user code")
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("slow down")
+	ErrAccessDenied         = errors.New("access denied")
+	ErrExpiredToken         = errors.New("expired token")
+
+	ErrInvalidTarget = errors.New("invalid target")
+
+	ErrMFAEnabled      = errors.New("mfa already enabled")
+	ErrMFANotEnrolled  = errors.New("mfa not enrolled")
+	ErrMFARequired     = errors.New("mfa code required")
+	ErrInvalidMFACode  = errors.New("invalid mfa code")
+	ErrInvalidMFAToken = errors.New("invalid mfa token")
+
+	ErrPasskeyExists         = errors.New("passkey already exists")
+	ErrNoPasskeys            = errors.New("user has no passkeys")
+	ErrInvalidPasskey        = errors.New("invalid passkey")
+	ErrInvalidPasskeySession = errors.New("invalid passkey session")
+
+	ErrEmailNotVerified         = errors.New("email not verified")
+	ErrInvalidVerificationToken = errors.New("invalid verification token")
+
+	ErrInvalidResetToken = errors.New("invalid reset token")
+
+	ErrTooManyAttempts = errors.New("too many login attempts")
+	ErrAccountLocked   = errors.New("account locked")
+	ErrNotLocked       = errors.New("account not locked")
+
+	ErrRoleExists           = errors.New("role already exists")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrRoleAssigned         = errors.New("role already assigned")
+	ErrRoleNotAssigned      = errors.New("role not assigned")
+	ErrPermissionExists     = errors.New("permission already exists")
+	ErrPermissionNotFound   = errors.New("permission not found")
+	ErrPermissionGranted    = errors.New("permission already granted")
+	ErrPermissionNotGranted = errors.New("permission not granted")
+	ErrBuiltIn              = errors.New("built-in role or permission")
+
+	ErrGroupExists           = errors.New("group already exists")
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrMemberExists          = errors.New("user already in group")
+	ErrMemberNotFound        = errors.New("user not in group")
+	ErrResourceGrantExists   = errors.New("resource grant already exists")
+	ErrResourceGrantNotFound = errors.New("resource grant not found")
+
+	ErrTupleExists             = errors.New("relation tuple already exists")
+	ErrTupleNotFound           = errors.New("relation tuple not found")
+	ErrInvalidConsistencyToken = errors.New("invalid consistency token")
+	ErrRelationTooDeep         = errors.New("relation is nested too deep")
 )
+
+// ThrottleError is returned when login is rejected without checking credentials
+// because of recent failures, Err is ErrTooManyAttempts or ErrAccountLocked
+type ThrottleError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *ThrottleError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", e.Err, e.RetryAfter)
+}
+
+func (e *ThrottleError) Unwrap() error {
+	return e.Err
+}
+
+// PasswordPolicyError is returned when new password fails password policy, Violations describe every failed rule
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return "password " + strings.Join(e.Violations, ", ")
+}
+
+// SchemaError is returned when relation tuple or check doesn't fit namespace config, Reason tells why
+type SchemaError struct {
+	Reason string
+}
+
+func (e *SchemaError) Error() string {
+	return "namespace config: " + e.Reason
+}
@@ -5,9 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
 
 	"sso/internal/domain/models"
+	"sso/internal/lib/jwt"
 	"sso/internal/lib/logger/sl"
+	"sso/internal/lib/oidc"
 	"sso/internal/service"
 	"sso/internal/storage"
 )
@@ -19,6 +23,7 @@ type UserInfo struct {
 
 type UserProvider interface {
 	User(ctx context.Context, email string) (models.User, error)
+	UserByID(ctx context.Context, userID int64) (models.User, error)
 	Admin(ctx context.Context, email string) (models.Admin, error)
 }
 
@@ -33,9 +38,9 @@ func New(
 	}
 }
 
-// Admin checks if user is admin
+// Admin returns roles of the user and permissions granted by them
 //
-// If user with given userID doesn't exist, returns error
+// If user with given email doesn't exist or has no roles, returns error
 func (u *UserInfo) Admin(
 	ctx context.Context,
 	email string,
@@ -60,7 +65,7 @@ func (u *UserInfo) Admin(
 		return models.Admin{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	log.Info("checked if user is admin", slog.Bool("is_admin", admin.Level > 1))
+	log.Info("checked if user is admin", slog.Any("roles", admin.Roles))
 
 	return admin, nil
 }
@@ -96,3 +101,42 @@ func (u *UserInfo) User(
 
 	return user, nil
 }
+
+// Claims returns OpenID Connect claims about the owner of the token released for its scope
+//
+// If token wasn't issued with openid scope, returns error
+// If user doesn't exist, returns error
+func (u *UserInfo) Claims(
+	ctx context.Context,
+	token *jwt.Token,
+) (map[string]any, error) {
+	const op = "services.userInfo.Claims"
+
+	log := u.log.With(
+		slog.String("op", op),
+		slog.Int64("uid", token.UID),
+	)
+
+	log.Info("getting user claims")
+
+	scopes := strings.Fields(token.Scope)
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		log.Warn("token has no openid scope")
+		return nil, fmt.Errorf("%s: %w", op, service.ErrInsufficientScope)
+	}
+
+	user, err := u.userProvider.UserByID(ctx, token.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Warn("user not found", sl.Err(err))
+			return nil, fmt.Errorf("%s: %w", op, service.ErrUserNotFound)
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	log.Info("user claims found")
+
+	return oidc.UserClaims(user, scopes), nil
+}
@@ -5,6 +5,8 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"sso/internal/domain/models"
@@ -73,11 +75,65 @@ func (s *Storage) UpdateUserVisitTime(ctx context.Context, email string, visitTi
 	return nil
 }
 
+// VerifyEmail marks email of the user as verified
+//
+// If user doesn't exist or has another email now, returns error
+func (s *Storage) VerifyEmail(ctx context.Context, userID int64, email string) error {
+	const op = "storage.sqlite.VerifyEmail"
+
+	stmt, err := s.db.Prepare("UPDATE users SET email_verified = TRUE WHERE id = ? AND email = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, userID, email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
+// UpdatePassHash replaces password hash of the user, if it's still oldHash
+//
+// If user doesn't exist or hash has been changed since, returns error
+func (s *Storage) UpdatePassHash(ctx context.Context, userID int64, oldHash, newHash []byte) error {
+	const op = "storage.sqlite.UpdatePassHash"
+
+	stmt, err := s.db.Prepare("UPDATE users SET pass_hash = ? WHERE id = ? AND pass_hash = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, newHash, userID, oldHash)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	return nil
+}
+
 // User returns user model from db by email
 func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.sqlite.User"
 
-	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, created_at, visited_at FROM users WHERE email = ?")
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified, created_at, visited_at FROM users WHERE email = ?")
 	if err != nil {
 		return models.User{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -85,7 +141,30 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	row := stmt.QueryRowContext(ctx, email)
 
 	var user models.User
-	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.CreatedAt, &user.VisitedAt)
+	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified, &user.CreatedAt, &user.VisitedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+		}
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return user, nil
+}
+
+// UserByID returns user model from db by id
+func (s *Storage) UserByID(ctx context.Context, userID int64) (models.User, error) {
+	const op = "storage.sqlite.UserByID"
+
+	stmt, err := s.db.Prepare("SELECT id, email, pass_hash, email_verified, created_at, visited_at FROM users WHERE id = ?")
+	if err != nil {
+		return models.User{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+
+	var user models.User
+	err = row.Scan(&user.ID, &user.Email, &user.PassHash, &user.EmailVerified, &user.CreatedAt, &user.VisitedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.User{}, fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
@@ -96,54 +175,159 @@ func (s *Storage) User(ctx context.Context, email string) (models.User, error) {
 	return user, nil
 }
 
-// IsAdmin returns information whether the user is an admin
+// Admin returns the user with given email with their roles and permissions granted by them,
+// only roles of the service itself are considered
+//
+// If user has no roles, returns error
 func (s *Storage) Admin(ctx context.Context, email string) (models.Admin, error) {
 	const op = "storage.sqlite.Admin"
 
-	stmt, err := s.db.Prepare("SELECT id, email, level FROM admins WHERE email = ?")
+	userID, roles, permissions, err := s.userRoles(ctx, "users.email = ? AND roles.app_id = 0", email)
 	if err != nil {
 		return models.Admin{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	row := stmt.QueryRowContext(ctx, email)
+	if len(roles) == 0 {
+		return models.Admin{}, fmt.Errorf("%s: %w", op, storage.ErrAdminNotFound)
+	}
+
+	return models.Admin{ID: userID, Email: email, Roles: roles, Permissions: permissions}, nil
+}
+
+// AppRoles returns roles of the user defined by the app and permissions granted by them, user may have none
+func (s *Storage) AppRoles(ctx context.Context, userID int64, appID int) (models.AppRoles, error) {
+	const op = "storage.sqlite.AppRoles"
 
-	var admin models.Admin
-	err = row.Scan(&admin.ID, &admin.Email, &admin.Level)
+	_, roles, permissions, err := s.userRoles(ctx, "users.id = ? AND roles.app_id = ?", userID, appID)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return models.Admin{}, fmt.Errorf("%s: %w", op, storage.ErrAdminNotFound)
+		return models.AppRoles{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return models.AppRoles{AppID: appID, Roles: roles, Permissions: permissions}, nil
+}
+
+// userRoles returns ID of the user, names of their roles matching the condition and sorted
+// permissions granted by these roles. Roles held through groups are included
+func (s *Storage) userRoles(ctx context.Context, where string, args ...any) (int64, []string, []string, error) {
+	stmt, err := s.db.Prepare(`SELECT users.id, roles.name, COALESCE(GROUP_CONCAT(permissions.name, ' '), '')
+		FROM users
+		JOIN role_holders ON role_holders.user_id = users.id
+		JOIN roles ON roles.id = role_holders.role_id
+		LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.app_id = roles.app_id
+		WHERE ` + where + `
+		GROUP BY roles.id
+		ORDER BY roles.name`)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer rows.Close()
+
+	var (
+		userID      int64
+		roles       []string
+		permissions []string
+	)
+	for rows.Next() {
+		var role, granted string
+		if err := rows.Scan(&userID, &role, &granted); err != nil {
+			return 0, nil, nil, err
+		}
+
+		roles = append(roles, role)
+		for _, permission := range strings.Fields(granted) {
+			if !slices.Contains(permissions, permission) {
+				permissions = append(permissions, permission)
+			}
 		}
-		return models.Admin{}, fmt.Errorf("%s: %w", op, err)
 	}
+	if err := rows.Err(); err != nil {
+		return 0, nil, nil, err
+	}
+	slices.Sort(permissions)
 
-	return admin, nil
+	return userID, roles, permissions, nil
 }
 
-func (s *Storage) SaveApp(ctx context.Context, name, apiKey string) error {
+func (s *Storage) SaveApp(ctx context.Context, app models.App, redirectURIs []string) (int, error) {
 	const op = "storage.sqlite.SaveApp"
 
-	stmt, err := s.db.Prepare("INSERT INTO apps(name, apiKey) VALUES(?, ?)")
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("%s: %w", op, err)
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
+	defer tx.Rollback()
 
-	_, err = stmt.ExecContext(ctx, name, apiKey)
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO apps(name, apiKey, token_ttl, client_secret_hash, scopes) VALUES(?, ?, ?, ?, ?)",
+		app.Name, app.ApiKey, int64(app.TokenTTL.Seconds()), app.SecretHash, strings.Join(app.Scopes, " "),
+	)
 	if err != nil {
 		var sqliteErr sqlite3.Error
 		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return fmt.Errorf("%s: %w", op, storage.ErrAppExists)
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrAppExists)
 		}
-		return fmt.Errorf("%s: %w", op, err)
+		return 0, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return nil
+	appID, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, uri := range redirectURIs {
+		_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO app_redirect_uris(app_id, uri) VALUES(?, ?)", appID, uri)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return int(appID), nil
+}
+
+// AppRedirectURIs returns redirect URIs registered for the app
+func (s *Storage) AppRedirectURIs(ctx context.Context, appID int) ([]string, error) {
+	const op = "storage.sqlite.AppRedirectURIs"
+
+	stmt, err := s.db.Prepare("SELECT uri FROM app_redirect_uris WHERE app_id = ?")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		uris = append(uris, uri)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return uris, nil
 }
 
-// App returns app model from db by appID
 func (s *Storage) AppByID(ctx context.Context, appID int) (models.App, error) {
 	const op = "storage.sqlite.AppByID"
 
-	stmt, err := s.db.Prepare("SELECT id, name, apiKey FROM apps WHERE id = ?")
+	stmt, err := s.db.Prepare("SELECT id, name, apiKey, token_ttl, client_secret_hash, scopes FROM apps WHERE id = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -151,13 +335,17 @@ func (s *Storage) AppByID(ctx context.Context, appID int) (models.App, error) {
 	row := stmt.QueryRowContext(ctx, appID)
 
 	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.ApiKey)
+	var tokenTTL int64
+	var scopes string
+	err = row.Scan(&app.ID, &app.Name, &app.ApiKey, &tokenTTL, &app.SecretHash, &scopes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
 		}
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
+	app.TokenTTL = time.Duration(tokenTTL) * time.Second
+	app.Scopes = strings.Fields(scopes)
 
 	return app, nil
 }
@@ -166,7 +354,7 @@ func (s *Storage) AppByID(ctx context.Context, appID int) (models.App, error) {
 func (s *Storage) AppByKey(ctx context.Context, apiKey string) (models.App, error) {
 	const op = "storage.sqlite.AppByKey"
 
-	stmt, err := s.db.Prepare("SELECT id, name, apiKey FROM apps WHERE apiKey = ?")
+	stmt, err := s.db.Prepare("SELECT id, name, apiKey, token_ttl, client_secret_hash, scopes FROM apps WHERE apiKey = ?")
 	if err != nil {
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
@@ -174,54 +362,2464 @@ func (s *Storage) AppByKey(ctx context.Context, apiKey string) (models.App, erro
 	row := stmt.QueryRowContext(ctx, apiKey)
 
 	var app models.App
-	err = row.Scan(&app.ID, &app.Name, &app.ApiKey)
+	var tokenTTL int64
+	var scopes string
+	err = row.Scan(&app.ID, &app.Name, &app.ApiKey, &tokenTTL, &app.SecretHash, &scopes)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return models.App{}, fmt.Errorf("%s: %w", op, storage.ErrAppNotFound)
 		}
 		return models.App{}, fmt.Errorf("%s: %w", op, err)
 	}
+	app.TokenTTL = time.Duration(tokenTTL) * time.Second
+	app.Scopes = strings.Fields(scopes)
 
 	return app, nil
 }
 
-// AddModerator adds new moderator to admins db
-func (s *Storage) AddAdmin(ctx context.Context, email string) error {
-	const op = "storage.sqlite.AddAdmin"
+// DeleteAdmin takes away all roles of the service itself from the user with given email, roles of apps are kept.
+// The user also leaves groups of the service, since roles held through them make the user admin too
+//
+// If user has no such roles, returns error
+func (s *Storage) DeleteAdmin(ctx context.Context, email string) error {
+	const op = "storage.sqlite.DeleteAdmin"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
 
-	stmt, err := s.db.Prepare("INSERT INTO admins(email, level) VALUES(?, ?)")
+	userID, err := lookupID(ctx, tx, storage.ErrAdminNotFound, "SELECT id FROM users WHERE email = ?", email)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = stmt.ExecContext(ctx, email, 2)
+	var held bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM role_holders
+		WHERE user_id = ? AND role_id IN (SELECT id FROM roles WHERE app_id = 0))`, userID).Scan(&held)
 	if err != nil {
-		var sqliteErr sqlite3.Error
-		if errors.As(err, &sqliteErr); sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
-			return fmt.Errorf("%s: %w", op, storage.ErrAdminExists)
-		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if !held {
+		return fmt.Errorf("%s: %w", op, storage.ErrAdminNotFound)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_roles WHERE user_id = ? AND role_id IN (SELECT id FROM roles WHERE app_id = 0)", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM group_members WHERE user_id = ? AND group_id IN (SELECT id FROM groups WHERE app_id = 0)", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
 
-// DeleteModerator deletes moderator from admins db by email
-func (s *Storage) DeleteAdmin(ctx context.Context, email string) error {
-	const op = "storage.sqlite.DeleteAdmin"
+// SaveRefreshToken saves refresh token to db
+func (s *Storage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	const op = "storage.sqlite.SaveRefreshToken"
+
+	stmt, err := s.db.Prepare("INSERT INTO refresh_tokens(token_hash, family, user_id, app_id, scope, expires_at, created_at) VALUES(?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
 
-	stmt, err := s.db.Prepare("DELETE FROM admins WHERE email = ?")
+	_, err = stmt.ExecContext(ctx, token.Hash, token.Family, token.UserID, token.AppID, token.Scope, token.ExpiresAt, token.CreatedAt)
 	if err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = stmt.ExecContext(ctx, email)
+	return nil
+}
+
+// RefreshToken returns refresh token model from db by token hash
+func (s *Storage) RefreshToken(ctx context.Context, hash string) (models.RefreshToken, error) {
+	const op = "storage.sqlite.RefreshToken"
+
+	stmt, err := s.db.Prepare("SELECT id, token_hash, family, user_id, app_id, scope, expires_at, used, revoked, created_at FROM refresh_tokens WHERE token_hash = ?")
+	if err != nil {
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, hash)
+
+	var token models.RefreshToken
+	err = row.Scan(&token.ID, &token.Hash, &token.Family, &token.UserID, &token.AppID, &token.Scope, &token.ExpiresAt, &token.Used, &token.Revoked, &token.CreatedAt)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return fmt.Errorf("%s: %w", op, storage.ErrAdminNotFound)
+			return models.RefreshToken{}, fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenNotFound)
+		}
+		return models.RefreshToken{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, nil
+}
+
+// UseRefreshToken marks refresh token as used
+//
+// If token has already been used, returns storage.ErrRefreshTokenUsed
+func (s *Storage) UseRefreshToken(ctx context.Context, tokenID int64) error {
+	const op = "storage.sqlite.UseRefreshToken"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, tokenID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRefreshTokenUsed)
+	}
+
+	return nil
+}
+
+// RevokeRefreshFamily revokes all refresh tokens of the family
+func (s *Storage) RevokeRefreshFamily(ctx context.Context, family string) error {
+	const op = "storage.sqlite.RevokeRefreshFamily"
+
+	stmt, err := s.db.Prepare("UPDATE refresh_tokens SET revoked = TRUE WHERE family = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, family)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeToken adds token with given jti to the denylist
+//
+// Entry is kept until expiresAt, which must be no earlier than exp of the token plus leeway,
+// after that token is rejected by its exp anyway
+func (s *Storage) RevokeToken(ctx context.Context, jti string, userID int64, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeToken"
+
+	if err := s.deleteExpiredRevocations(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare("INSERT INTO revoked_tokens(jti, user_id, revoked_at, expires_at) VALUES(?, ?, ?, ?) ON CONFLICT DO NOTHING")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, jti, userID, time.Now().UnixMilli(), unixCeil(expiresAt))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokeUserTokens revokes all tokens of the user issued not later than revokedAt
+//
+// Entry is kept until expiresAt, which must be no earlier than exp of the last revoked token plus leeway
+func (s *Storage) RevokeUserTokens(ctx context.Context, userID int64, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeUserTokens"
+
+	if err := s.deleteExpiredRevocations(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare("INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, userID, revokedAt.UnixMilli(), unixCeil(expiresAt))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked returns information whether the token is in the denylist
+// either by its jti or by revocation of all user tokens. Revocation instants and iat are compared
+// in milliseconds, so tokens issued in the same millisecond as revocation are revoked too
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string, userID int64, issuedAt time.Time) (bool, error) {
+	const op = "storage.sqlite.IsTokenRevoked"
+
+	stmt, err := s.db.Prepare(`SELECT EXISTS(
+		SELECT 1 FROM revoked_tokens
+		WHERE expires_at > ? AND (jti = ? OR (jti IS NULL AND user_id = ? AND revoked_at >= ?))
+	)`)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, time.Now().Unix(), jti, userID, issuedAt.UnixMilli())
+
+	var revoked bool
+	if err := row.Scan(&revoked); err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revoked, nil
+}
+
+// unixCeil returns t in Unix seconds rounded up, so revocation isn't dropped
+// before exp of tokens it revokes, which has millisecond precision
+func unixCeil(t time.Time) int64 {
+	seconds := t.Unix()
+	if t.Nanosecond() > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+func (s *Storage) deleteExpiredRevocations(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM revoked_tokens WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SigningKeys returns signing keys that are not retired
+func (s *Storage) SigningKeys(ctx context.Context) ([]models.SigningKey, error) {
+	const op = "storage.sqlite.SigningKeys"
+
+	stmt, err := s.db.Prepare("SELECT kid, algorithm, private_key, state, created_at, COALESCE(rotated_at, 0) FROM signing_keys WHERE state != 'retired'")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var keys []models.SigningKey
+	for rows.Next() {
+		var key models.SigningKey
+		var createdAt, rotatedAt int64
+		if err := rows.Scan(&key.ID, &key.Algorithm, &key.PrivateKey, &key.State, &createdAt, &rotatedAt); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		key.CreatedAt = time.Unix(createdAt, 0)
+		if rotatedAt != 0 {
+			key.RotatedAt = time.Unix(rotatedAt, 0)
 		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return keys, nil
+}
+
+// RotateSigningKey saves new active key, previous active key becomes retiring
+func (s *Storage) RotateSigningKey(ctx context.Context, key models.SigningKey) error {
+	const op = "storage.sqlite.RotateSigningKey"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE signing_keys SET state = 'retiring', rotated_at = ? WHERE state = 'active'", key.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO signing_keys(kid, algorithm, private_key, state, created_at) VALUES(?, ?, ?, 'active', ?)",
+		key.ID, key.Algorithm, key.PrivateKey, key.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("%s: %w", op, err)
 	}
 
 	return nil
 }
+
+// RetireSigningKeys retires keys that were rotated not later than before
+func (s *Storage) RetireSigningKeys(ctx context.Context, before time.Time) error {
+	const op = "storage.sqlite.RetireSigningKeys"
+
+	stmt, err := s.db.Prepare("UPDATE signing_keys SET state = 'retired' WHERE state = 'retiring' AND rotated_at <= ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, before.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveAuthCode saves authorization code to db
+func (s *Storage) SaveAuthCode(ctx context.Context, code models.AuthCode) error {
+	const op = "storage.sqlite.SaveAuthCode"
+
+	stmt, err := s.db.Prepare("INSERT INTO auth_codes(code_hash, family, app_id, user_id, redirect_uri, code_challenge, scope, nonce, expires_at, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		code.Hash, code.Family, code.AppID, code.UserID, code.RedirectURI, code.CodeChallenge, code.Scope, code.Nonce,
+		code.ExpiresAt.Unix(), code.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.deleteExpiredAuthCodes(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AuthCode returns authorization code by its hash
+func (s *Storage) AuthCode(ctx context.Context, hash string) (models.AuthCode, error) {
+	const op = "storage.sqlite.AuthCode"
+
+	stmt, err := s.db.Prepare("SELECT id, code_hash, family, app_id, user_id, redirect_uri, code_challenge, scope, nonce, expires_at, used, created_at FROM auth_codes WHERE code_hash = ?")
+	if err != nil {
+		return models.AuthCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, hash)
+
+	var code models.AuthCode
+	var expiresAt, createdAt int64
+	err = row.Scan(&code.ID, &code.Hash, &code.Family, &code.AppID, &code.UserID, &code.RedirectURI, &code.CodeChallenge, &code.Scope, &code.Nonce, &expiresAt, &code.Used, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.AuthCode{}, fmt.Errorf("%s: %w", op, storage.ErrAuthCodeNotFound)
+		}
+		return models.AuthCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	code.CreatedAt = time.Unix(createdAt, 0)
+
+	return code, nil
+}
+
+// UseAuthCode marks authorization code as used
+//
+// If code is already used, returns error
+func (s *Storage) UseAuthCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.UseAuthCode"
+
+	stmt, err := s.db.Prepare("UPDATE auth_codes SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrAuthCodeUsed)
+	}
+
+	return nil
+}
+
+// deleteExpiredAuthCodes removes authorization codes that can't be exchanged anymore
+func (s *Storage) deleteExpiredAuthCodes(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM auth_codes WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SaveDeviceCode saves pending device code and removes expired ones
+func (s *Storage) SaveDeviceCode(ctx context.Context, code models.DeviceCode) error {
+	const op = "storage.sqlite.SaveDeviceCode"
+
+	if err := s.deleteExpiredDeviceCodes(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare("INSERT INTO device_codes(code_hash, user_code, app_id, scope, status, interval, expires_at, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		code.Hash, code.UserCode, code.AppID, code.Scope, code.Status,
+		int64(code.Interval.Seconds()), code.ExpiresAt.Unix(), code.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeviceCode returns device code by its hash
+func (s *Storage) DeviceCode(ctx context.Context, hash string) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCode"
+
+	code, err := s.deviceCode(ctx, "code_hash", hash)
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// DeviceCodeByUserCode returns device code by the user code shown to the user
+func (s *Storage) DeviceCodeByUserCode(ctx context.Context, userCode string) (models.DeviceCode, error) {
+	const op = "storage.sqlite.DeviceCodeByUserCode"
+
+	code, err := s.deviceCode(ctx, "user_code", userCode)
+	if err != nil {
+		return models.DeviceCode{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return code, nil
+}
+
+// deviceCode returns device code by the value of unique column
+func (s *Storage) deviceCode(ctx context.Context, column string, value string) (models.DeviceCode, error) {
+	stmt, err := s.db.Prepare("SELECT id, code_hash, user_code, app_id, scope, status, user_id, approved_at, interval, last_polled_at, expires_at, created_at FROM device_codes WHERE " + column + " = ?")
+	if err != nil {
+		return models.DeviceCode{}, err
+	}
+
+	row := stmt.QueryRowContext(ctx, value)
+
+	var code models.DeviceCode
+	var approvedAt, interval, lastPolledAt, expiresAt, createdAt int64
+	err = row.Scan(
+		&code.ID, &code.Hash, &code.UserCode, &code.AppID, &code.Scope, &code.Status, &code.UserID,
+		&approvedAt, &interval, &lastPolledAt, &expiresAt, &createdAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.DeviceCode{}, storage.ErrDeviceCodeNotFound
+		}
+		return models.DeviceCode{}, err
+	}
+	if approvedAt != 0 {
+		code.ApprovedAt = time.Unix(approvedAt, 0)
+	}
+	if lastPolledAt != 0 {
+		code.LastPolledAt = time.Unix(lastPolledAt, 0)
+	}
+	code.Interval = time.Duration(interval) * time.Second
+	code.ExpiresAt = time.Unix(expiresAt, 0)
+	code.CreatedAt = time.Unix(createdAt, 0)
+
+	return code, nil
+}
+
+// SetDeviceCodeStatus records user's response to pending device code
+//
+// If code isn't pending anymore or is expired, returns error
+func (s *Storage) SetDeviceCodeStatus(ctx context.Context, codeID int64, status string, userID int64, approvedAt time.Time) error {
+	const op = "storage.sqlite.SetDeviceCodeStatus"
+
+	stmt, err := s.db.Prepare("UPDATE device_codes SET status = ?, user_id = ?, approved_at = ? WHERE id = ? AND status = ? AND expires_at > ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, status, userID, approvedAt.Unix(), codeID, models.DeviceCodePending, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	return nil
+}
+
+// PollDeviceCode records polling time and current polling interval of device code
+func (s *Storage) PollDeviceCode(ctx context.Context, codeID int64, polledAt time.Time, interval time.Duration) error {
+	const op = "storage.sqlite.PollDeviceCode"
+
+	stmt, err := s.db.Prepare("UPDATE device_codes SET last_polled_at = ?, interval = ? WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, polledAt.Unix(), int64(interval.Seconds()), codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteDeviceCode deletes device code once it's exchanged for tokens or denied
+//
+// If code is already deleted, returns error, so it can't be exchanged twice
+func (s *Storage) DeleteDeviceCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.DeleteDeviceCode"
+
+	stmt, err := s.db.Prepare("DELETE FROM device_codes WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrDeviceCodeNotFound)
+	}
+
+	return nil
+}
+
+// deleteExpiredDeviceCodes removes device codes that can't be exchanged anymore
+func (s *Storage) deleteExpiredDeviceCodes(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM device_codes WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SaveTokenExchange saves audit record of token exchange
+func (s *Storage) SaveTokenExchange(ctx context.Context, exchange models.TokenExchange) error {
+	const op = "storage.sqlite.SaveTokenExchange"
+
+	stmt, err := s.db.Prepare("INSERT INTO token_exchanges(jti, client_id, audience, user_id, actor_subject, scope, impersonation, expires_at, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx,
+		exchange.TokenID, exchange.ClientID, exchange.Audience, exchange.UserID, exchange.ActorSubject, exchange.Scope,
+		exchange.Impersonation, exchange.ExpiresAt.Unix(), exchange.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveMFASecret saves not yet confirmed TOTP secret of the user, replacing previous unconfirmed one
+//
+// If MFA is already enabled, returns error
+func (s *Storage) SaveMFASecret(ctx context.Context, mfa models.MFA) error {
+	const op = "storage.sqlite.SaveMFASecret"
+
+	stmt, err := s.db.Prepare(`INSERT INTO user_mfa(user_id, secret, created_at) VALUES(?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, created_at = excluded.created_at WHERE enabled = FALSE`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, mfa.UserID, mfa.Secret, mfa.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAEnabled)
+	}
+
+	return nil
+}
+
+// MFA returns TOTP second factor of the user
+func (s *Storage) MFA(ctx context.Context, userID int64) (models.MFA, error) {
+	const op = "storage.sqlite.MFA"
+
+	stmt, err := s.db.Prepare("SELECT user_id, secret, enabled, last_step, created_at FROM user_mfa WHERE user_id = ?")
+	if err != nil {
+		return models.MFA{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, userID)
+
+	var mfa models.MFA
+	var createdAt int64
+	err = row.Scan(&mfa.UserID, &mfa.Secret, &mfa.Enabled, &mfa.LastStep, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MFA{}, fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+		}
+		return models.MFA{}, fmt.Errorf("%s: %w", op, err)
+	}
+	mfa.CreatedAt = time.Unix(createdAt, 0)
+
+	return mfa, nil
+}
+
+// EnableMFA enables confirmed TOTP second factor of the user and replaces recovery codes with given ones
+//
+// If there is no unconfirmed secret, returns error
+func (s *Storage) EnableMFA(ctx context.Context, userID int64, step int64, recoveryHashes []string) error {
+	const op = "storage.sqlite.EnableMFA"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE user_mfa SET enabled = TRUE, last_step = ? WHERE user_id = ? AND enabled = FALSE", step, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO recovery_codes(user_id, code_hash) VALUES(?, ?)", userID, hash)
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UseMFAStep records time step of accepted TOTP code
+//
+// If the same or a later step was already used, returns error
+func (s *Storage) UseMFAStep(ctx context.Context, userID int64, step int64) error {
+	const op = "storage.sqlite.UseMFAStep"
+
+	stmt, err := s.db.Prepare("UPDATE user_mfa SET last_step = ? WHERE user_id = ? AND last_step < ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAStepUsed)
+	}
+
+	return nil
+}
+
+// RecoveryCodes returns unused recovery codes of the user
+func (s *Storage) RecoveryCodes(ctx context.Context, userID int64) ([]models.RecoveryCode, error) {
+	const op = "storage.sqlite.RecoveryCodes"
+
+	stmt, err := s.db.Prepare("SELECT id, code_hash FROM recovery_codes WHERE user_id = ? AND used = FALSE")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode marks recovery code as used
+//
+// If code is already used, returns error
+func (s *Storage) UseRecoveryCode(ctx context.Context, codeID int64) error {
+	const op = "storage.sqlite.UseRecoveryCode"
+
+	stmt, err := s.db.Prepare("UPDATE recovery_codes SET used = TRUE WHERE id = ? AND used = FALSE")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, codeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrRecoveryCodeNotFound)
+	}
+
+	return nil
+}
+
+// DeleteMFA removes second factor of the user together with recovery codes and pending challenges.
+// Refresh tokens are revoked and access tokens issued not later than revokedAt are denied until expiresAt
+//
+// If user has no second factor, returns error
+func (s *Storage) DeleteMFA(ctx context.Context, userID int64, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.DeleteMFA"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = ?", userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFANotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)", userID, revokedAt.UnixMilli(), unixCeil(expiresAt)); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveMFAChallenge saves MFA challenge and removes expired ones
+func (s *Storage) SaveMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error {
+	const op = "storage.sqlite.SaveMFAChallenge"
+
+	stmt, err := s.db.Prepare("INSERT INTO mfa_challenges(token_hash, user_id, app_id, expires_at, created_at) VALUES(?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, challenge.Hash, challenge.UserID, challenge.AppID, challenge.ExpiresAt.Unix(), challenge.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.deleteExpiredMFAChallenges(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// MFAChallenge returns MFA challenge by hash of its token
+func (s *Storage) MFAChallenge(ctx context.Context, hash string) (models.MFAChallenge, error) {
+	const op = "storage.sqlite.MFAChallenge"
+
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, app_id, attempts, expires_at, created_at FROM mfa_challenges WHERE token_hash = ?")
+	if err != nil {
+		return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, hash)
+
+	var challenge models.MFAChallenge
+	var expiresAt, createdAt int64
+	err = row.Scan(&challenge.ID, &challenge.Hash, &challenge.UserID, &challenge.AppID, &challenge.Attempts, &expiresAt, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+		}
+		return models.MFAChallenge{}, fmt.Errorf("%s: %w", op, err)
+	}
+	challenge.ExpiresAt = time.Unix(expiresAt, 0)
+	challenge.CreatedAt = time.Unix(createdAt, 0)
+
+	return challenge, nil
+}
+
+// AttemptMFAChallenge counts an attempt to pass MFA challenge
+func (s *Storage) AttemptMFAChallenge(ctx context.Context, challengeID int64) error {
+	const op = "storage.sqlite.AttemptMFAChallenge"
+
+	stmt, err := s.db.Prepare("UPDATE mfa_challenges SET attempts = attempts + 1 WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := stmt.ExecContext(ctx, challengeID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteMFAChallenge deletes MFA challenge once it's passed or has no attempts left
+//
+// If challenge is already deleted, returns error, so it can't be passed twice
+func (s *Storage) DeleteMFAChallenge(ctx context.Context, challengeID int64) error {
+	const op = "storage.sqlite.DeleteMFAChallenge"
+
+	stmt, err := s.db.Prepare("DELETE FROM mfa_challenges WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, challengeID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrMFAChallengeNotFound)
+	}
+
+	return nil
+}
+
+// deleteExpiredMFAChallenges removes MFA challenges that can't be passed anymore
+func (s *Storage) deleteExpiredMFAChallenges(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM mfa_challenges WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SavePasskey saves WebAuthn credential of the user
+//
+// If credential is already registered, returns error
+func (s *Storage) SavePasskey(ctx context.Context, passkey models.Passkey) error {
+	const op = "storage.sqlite.SavePasskey"
+
+	stmt, err := s.db.Prepare(`INSERT INTO passkeys(user_id, credential_id, public_key, attestation_type, aaguid,
+		sign_count, transports, backup_eligible, backup_state, created_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(
+		ctx,
+		passkey.UserID,
+		passkey.CredentialID,
+		passkey.PublicKey,
+		passkey.AttestationType,
+		passkey.AAGUID,
+		passkey.SignCount,
+		strings.Join(passkey.Transports, " "),
+		passkey.BackupEligible,
+		passkey.BackupState,
+		passkey.CreatedAt.Unix(),
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrPasskeyExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Passkeys returns all WebAuthn credentials of the user
+func (s *Storage) Passkeys(ctx context.Context, userID int64) ([]models.Passkey, error) {
+	const op = "storage.sqlite.Passkeys"
+
+	stmt, err := s.db.Prepare(`SELECT id, user_id, credential_id, public_key, attestation_type, aaguid, sign_count,
+		transports, backup_eligible, backup_state, created_at, last_used_at FROM passkeys WHERE user_id = ? ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var passkeys []models.Passkey
+	for rows.Next() {
+		var passkey models.Passkey
+		var transports string
+		var createdAt, lastUsedAt int64
+		err := rows.Scan(
+			&passkey.ID,
+			&passkey.UserID,
+			&passkey.CredentialID,
+			&passkey.PublicKey,
+			&passkey.AttestationType,
+			&passkey.AAGUID,
+			&passkey.SignCount,
+			&transports,
+			&passkey.BackupEligible,
+			&passkey.BackupState,
+			&createdAt,
+			&lastUsedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		passkey.Transports = strings.Fields(transports)
+		passkey.CreatedAt = time.Unix(createdAt, 0)
+		if lastUsedAt != 0 {
+			passkey.LastUsedAt = time.Unix(lastUsedAt, 0)
+		}
+
+		passkeys = append(passkeys, passkey)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return passkeys, nil
+}
+
+// UsePasskey records signature counter and backup state reported by the authenticator on use
+//
+// If credential doesn't exist, returns error
+func (s *Storage) UsePasskey(ctx context.Context, credentialID []byte, signCount uint32, backupState bool, usedAt time.Time) error {
+	const op = "storage.sqlite.UsePasskey"
+
+	stmt, err := s.db.Prepare("UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used_at = ? WHERE credential_id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, signCount, backupState, usedAt.Unix(), credentialID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeyNotFound)
+	}
+
+	return nil
+}
+
+// SavePasskeySession saves state of started WebAuthn ceremony
+func (s *Storage) SavePasskeySession(ctx context.Context, session models.PasskeySession) error {
+	const op = "storage.sqlite.SavePasskeySession"
+
+	stmt, err := s.db.Prepare(`INSERT INTO passkey_sessions(token_hash, ceremony, user_id, app_id, mfa_challenge_id, data, expires_at, created_at)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(
+		ctx,
+		session.Hash,
+		session.Ceremony,
+		session.UserID,
+		session.AppID,
+		session.MFAChallengeID,
+		string(session.Data),
+		session.ExpiresAt.Unix(),
+		session.CreatedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.deleteExpiredPasskeySessions(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// PasskeySession returns state of WebAuthn ceremony by hash of its session id
+func (s *Storage) PasskeySession(ctx context.Context, hash string) (models.PasskeySession, error) {
+	const op = "storage.sqlite.PasskeySession"
+
+	stmt, err := s.db.Prepare(`SELECT id, token_hash, ceremony, user_id, app_id, mfa_challenge_id, data, expires_at, created_at
+		FROM passkey_sessions WHERE token_hash = ?`)
+	if err != nil {
+		return models.PasskeySession{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, hash)
+
+	var session models.PasskeySession
+	var data string
+	var expiresAt, createdAt int64
+	err = row.Scan(
+		&session.ID,
+		&session.Hash,
+		&session.Ceremony,
+		&session.UserID,
+		&session.AppID,
+		&session.MFAChallengeID,
+		&data,
+		&expiresAt,
+		&createdAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasskeySession{}, fmt.Errorf("%s: %w", op, storage.ErrPasskeySessionNotFound)
+		}
+		return models.PasskeySession{}, fmt.Errorf("%s: %w", op, err)
+	}
+	session.Data = []byte(data)
+	session.ExpiresAt = time.Unix(expiresAt, 0)
+	session.CreatedAt = time.Unix(createdAt, 0)
+
+	return session, nil
+}
+
+// DeletePasskeySession deletes state of WebAuthn ceremony once it's finished
+//
+// If session is already deleted, returns error, so ceremony can't be finished twice
+func (s *Storage) DeletePasskeySession(ctx context.Context, sessionID int64) error {
+	const op = "storage.sqlite.DeletePasskeySession"
+
+	stmt, err := s.db.Prepare("DELETE FROM passkey_sessions WHERE id = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasskeySessionNotFound)
+	}
+
+	return nil
+}
+
+// deleteExpiredPasskeySessions removes WebAuthn ceremonies that can't be finished anymore
+func (s *Storage) deleteExpiredPasskeySessions(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM passkey_sessions WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SavePasswordReset saves password reset and removes expired ones
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.sqlite.SavePasswordReset"
+
+	stmt, err := s.db.Prepare("INSERT INTO password_resets(token_hash, user_id, expires_at, created_at) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = stmt.ExecContext(ctx, reset.Hash, reset.UserID, reset.ExpiresAt.Unix(), reset.CreatedAt.Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := s.deleteExpiredPasswordResets(ctx); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// PasswordReset returns password reset by hash of its token
+func (s *Storage) PasswordReset(ctx context.Context, hash string) (models.PasswordReset, error) {
+	const op = "storage.sqlite.PasswordReset"
+
+	stmt, err := s.db.Prepare("SELECT id, token_hash, user_id, expires_at, created_at FROM password_resets WHERE token_hash = ?")
+	if err != nil {
+		return models.PasswordReset{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, hash)
+
+	var reset models.PasswordReset
+	var expiresAt, createdAt int64
+	err = row.Scan(&reset.ID, &reset.Hash, &reset.UserID, &expiresAt, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordReset{}, fmt.Errorf("%s: %w", op, storage.ErrPasswordResetNotFound)
+		}
+		return models.PasswordReset{}, fmt.Errorf("%s: %w", op, err)
+	}
+	reset.ExpiresAt = time.Unix(expiresAt, 0)
+	reset.CreatedAt = time.Unix(createdAt, 0)
+
+	return reset, nil
+}
+
+// ResetPassword uses password reset to set new password hash of the user. Other resets of the user are removed,
+// refresh tokens are revoked and access tokens issued not later than revokedAt are denied until expiresAt
+//
+// If reset is already used, returns error, so it can't be used twice
+func (s *Storage) ResetPassword(ctx context.Context, resetID int64, userID int64, passHash []byte, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.ResetPassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM password_resets WHERE id = ? AND user_id = ?", resetID, userID)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrPasswordResetNotFound)
+	}
+
+	if err := changePassword(ctx, tx, userID, passHash, revokedAt, expiresAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// ChangePassword sets new password hash of the user. Password resets of the user are removed,
+// refresh tokens are revoked and access tokens issued not later than revokedAt are denied until expiresAt
+//
+// If user doesn't exist, returns error
+func (s *Storage) ChangePassword(ctx context.Context, userID int64, passHash []byte, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.ChangePassword"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if err := changePassword(ctx, tx, userID, passHash, revokedAt, expiresAt); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// changePassword sets password hash of the user within tx and revokes everything issued with the old password
+func changePassword(ctx context.Context, tx *sql.Tx, userID int64, passHash []byte, revokedAt, expiresAt time.Time) error {
+	res, err := tx.ExecContext(ctx, "UPDATE users SET pass_hash = ? WHERE id = ?", passHash, userID)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrUserNotFound
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ?", userID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)", userID, revokedAt.UnixMilli(), unixCeil(expiresAt))
+
+	return err
+}
+
+// ChangeEmail replaces email of the user with verified new one.
+// Password resets sent to the old email are removed, refresh tokens are revoked and access tokens
+// issued not later than revokedAt, which carry the old email, are denied until expiresAt
+//
+// If user doesn't exist or has another email now, returns error
+// If new email is taken, returns error
+func (s *Storage) ChangeEmail(ctx context.Context, userID int64, oldEmail, newEmail string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.ChangeEmail"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE users SET email = ?, email_verified = TRUE WHERE id = ? AND email = ?", newEmail, userID, oldEmail)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return fmt.Errorf("%s: %w", op, storage.ErrUserExists)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrUserNotFound)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM password_resets WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked = TRUE WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)", userID, revokedAt.UnixMilli(), unixCeil(expiresAt))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// deleteExpiredPasswordResets removes password resets that can't be used anymore
+func (s *Storage) deleteExpiredPasswordResets(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM password_resets WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// LoginAttempts returns failed logins by key, expired ones are reported as not found
+func (s *Storage) LoginAttempts(ctx context.Context, key string) (models.LoginAttempts, error) {
+	const op = "storage.sqlite.LoginAttempts"
+
+	stmt, err := s.db.Prepare("SELECT key, failures, blocked_until, expires_at FROM login_attempts WHERE key = ? AND expires_at > ?")
+	if err != nil {
+		return models.LoginAttempts{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	row := stmt.QueryRowContext(ctx, key, time.Now().Unix())
+
+	var attempts models.LoginAttempts
+	var blockedUntil, expiresAt int64
+	err = row.Scan(&attempts.Key, &attempts.Failures, &blockedUntil, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.LoginAttempts{}, fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptsNotFound)
+		}
+		return models.LoginAttempts{}, fmt.Errorf("%s: %w", op, err)
+	}
+	attempts.BlockedUntil = time.Unix(blockedUntil, 0)
+	attempts.ExpiresAt = time.Unix(expiresAt, 0)
+
+	return attempts, nil
+}
+
+// AddLoginFailure counts failed login by key and returns number of failures, which are kept until expiresAt.
+// Counting starts over once previous failures expire, expired failures of other keys are removed
+func (s *Storage) AddLoginFailure(ctx context.Context, key string, expiresAt time.Time) (int, error) {
+	const op = "storage.sqlite.AddLoginFailure"
+
+	if err := s.deleteExpiredLoginAttempts(ctx); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	stmt, err := s.db.Prepare(`INSERT INTO login_attempts(key, failures, expires_at) VALUES(?, 1, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = CASE WHEN expires_at <= ? THEN 1 ELSE failures + 1 END,
+			blocked_until = CASE WHEN expires_at <= ? THEN 0 ELSE blocked_until END,
+			expires_at = MAX(expires_at, excluded.expires_at)
+		RETURNING failures`)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	currentTime := time.Now().Unix()
+	row := stmt.QueryRowContext(ctx, key, expiresAt.Unix(), currentTime, currentTime)
+
+	var failures int
+	if err := row.Scan(&failures); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return failures, nil
+}
+
+// BlockLogin rejects logins by key until given time, failures are kept at least as long
+func (s *Storage) BlockLogin(ctx context.Context, key string, until time.Time) error {
+	const op = "storage.sqlite.BlockLogin"
+
+	stmt, err := s.db.Prepare("UPDATE login_attempts SET blocked_until = ?, expires_at = MAX(expires_at, ?) WHERE key = ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, until.Unix(), until.Unix(), key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptsNotFound)
+	}
+
+	return nil
+}
+
+// DeleteLoginAttempts forgets failed logins by key, which also lifts its block
+//
+// If there are no failures by key, returns error
+func (s *Storage) DeleteLoginAttempts(ctx context.Context, key string) error {
+	const op = "storage.sqlite.DeleteLoginAttempts"
+
+	stmt, err := s.db.Prepare("DELETE FROM login_attempts WHERE key = ? AND expires_at > ?")
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, key, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrLoginAttemptsNotFound)
+	}
+
+	return nil
+}
+
+// deleteExpiredLoginAttempts removes failed logins that aren't counted anymore
+func (s *Storage) deleteExpiredLoginAttempts(ctx context.Context) error {
+	stmt, err := s.db.Prepare("DELETE FROM login_attempts WHERE expires_at <= ?")
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.ExecContext(ctx, time.Now().Unix())
+
+	return err
+}
+
+// SaveRole saves role without permissions in namespace of role.AppID and returns its ID
+func (s *Storage) SaveRole(ctx context.Context, role models.Role) (int64, error) {
+	const op = "storage.sqlite.SaveRole"
+
+	stmt, err := s.db.Prepare("INSERT INTO roles(app_id, name, description) VALUES(?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, role.AppID, role.Name, role.Description)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrRoleExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// UpdateRole replaces description of the role
+//
+// If role doesn't exist, returns error
+func (s *Storage) UpdateRole(ctx context.Context, appID int, name string, description string) error {
+	const op = "storage.sqlite.UpdateRole"
+
+	if err := s.updateDescription(ctx, "UPDATE roles SET description = ? WHERE app_id = ? AND name = ?", appID, name, description, storage.ErrRoleNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteRole deletes role with its assignments. Access tokens of users who had the role,
+// issued not later than revokedAt, are denied until expiresAt, since they carry the role
+//
+// If role doesn't exist, returns error
+func (s *Storage) DeleteRole(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.DeleteRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	roleID, err := lookupID(ctx, tx, storage.ErrRoleNotFound, "SELECT id FROM roles WHERE app_id = ? AND name = ?", appID, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) SELECT user_id, ?, ? FROM role_holders WHERE role_id = ?",
+		revokedAt.UnixMilli(), unixCeil(expiresAt), roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM user_roles WHERE role_id = ?",
+		"DELETE FROM group_roles WHERE role_id = ?",
+		"DELETE FROM role_permissions WHERE role_id = ?",
+		"DELETE FROM roles WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, roleID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Roles returns all roles of the app with their permissions ordered by name
+func (s *Storage) Roles(ctx context.Context, appID int) ([]models.Role, error) {
+	const op = "storage.sqlite.Roles"
+
+	stmt, err := s.db.Prepare(`SELECT roles.id, roles.app_id, roles.name, roles.description, COALESCE(GROUP_CONCAT(permissions.name, ' '), '')
+		FROM roles
+		LEFT JOIN role_permissions ON role_permissions.role_id = roles.id
+		LEFT JOIN permissions ON permissions.id = role_permissions.permission_id AND permissions.app_id = roles.app_id
+		WHERE roles.app_id = ?
+		GROUP BY roles.id
+		ORDER BY roles.name`)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var role models.Role
+		var permissions string
+		if err := rows.Scan(&role.ID, &role.AppID, &role.Name, &role.Description, &permissions); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		role.Permissions = strings.Fields(permissions)
+		slices.Sort(role.Permissions)
+
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return roles, nil
+}
+
+// SavePermission saves permission in namespace of permission.AppID and returns its ID
+func (s *Storage) SavePermission(ctx context.Context, permission models.Permission) (int64, error) {
+	const op = "storage.sqlite.SavePermission"
+
+	stmt, err := s.db.Prepare("INSERT INTO permissions(app_id, name, description) VALUES(?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, permission.AppID, permission.Name, permission.Description)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrPermissionExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// UpdatePermission replaces description of the permission
+//
+// If permission doesn't exist, returns error
+func (s *Storage) UpdatePermission(ctx context.Context, appID int, name string, description string) error {
+	const op = "storage.sqlite.UpdatePermission"
+
+	if err := s.updateDescription(ctx, "UPDATE permissions SET description = ? WHERE app_id = ? AND name = ?", appID, name, description, storage.ErrPermissionNotFound); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeletePermission deletes permission and takes it away from roles and resources. Access tokens of users
+// who had it, issued not later than revokedAt, are denied until expiresAt
+//
+// If permission doesn't exist, returns error
+func (s *Storage) DeletePermission(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.DeletePermission"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	permissionID, err := lookupID(ctx, tx, storage.ErrPermissionNotFound, "SELECT id FROM permissions WHERE app_id = ? AND name = ?", appID, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO revoked_tokens(user_id, revoked_at, expires_at)
+		SELECT DISTINCT role_holders.user_id, ?, ? FROM role_holders
+		JOIN role_permissions ON role_permissions.role_id = role_holders.role_id
+		WHERE role_permissions.permission_id = ?`,
+		revokedAt.UnixMilli(), unixCeil(expiresAt), permissionID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM role_permissions WHERE permission_id = ?", permissionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM resource_grants WHERE permission_id = ?", permissionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM permissions WHERE id = ?", permissionID); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Permissions returns all permissions of the app ordered by name
+func (s *Storage) Permissions(ctx context.Context, appID int) ([]models.Permission, error) {
+	const op = "storage.sqlite.Permissions"
+
+	stmt, err := s.db.Prepare("SELECT id, app_id, name, description FROM permissions WHERE app_id = ? ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	rows, err := stmt.QueryContext(ctx, appID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var permissions []models.Permission
+	for rows.Next() {
+		var permission models.Permission
+		if err := rows.Scan(&permission.ID, &permission.AppID, &permission.Name, &permission.Description); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		permissions = append(permissions, permission)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return permissions, nil
+}
+
+// GrantPermission adds permission to the role, both are looked up in namespace of the app
+//
+// If role or permission doesn't exist, or the role already has the permission, returns error
+func (s *Storage) GrantPermission(ctx context.Context, appID int, role string, permission string) error {
+	const op = "storage.sqlite.GrantPermission"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	roleID, err := lookupID(ctx, tx, storage.ErrRoleNotFound, "SELECT id FROM roles WHERE app_id = ? AND name = ?", appID, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	permissionID, err := lookupID(ctx, tx, storage.ErrPermissionNotFound, "SELECT id FROM permissions WHERE app_id = ? AND name = ?", appID, permission)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO role_permissions(role_id, permission_id) VALUES(?, ?)", roleID, permissionID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrPermissionGranted)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RevokePermission takes permission away from the role. Access tokens of users with the role,
+// issued not later than revokedAt, are denied until expiresAt
+//
+// If the role doesn't have the permission, returns error
+func (s *Storage) RevokePermission(ctx context.Context, appID int, role string, permission string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokePermission"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var roleID int64
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM role_permissions
+		WHERE role_id = (SELECT id FROM roles WHERE app_id = ? AND name = ?)
+		AND permission_id = (SELECT id FROM permissions WHERE app_id = ? AND name = ?)
+		RETURNING role_id`,
+		appID, role, appID, permission,
+	).Scan(&roleID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrPermissionNotGranted)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) SELECT user_id, ?, ? FROM role_holders WHERE role_id = ?",
+		revokedAt.UnixMilli(), unixCeil(expiresAt), roleID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AssignRole assigns the role defined by the app to the user with given email
+//
+// If user or role doesn't exist, or the user already has the role, returns error
+func (s *Storage) AssignRole(ctx context.Context, email string, appID int, role string) error {
+	const op = "storage.sqlite.AssignRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	userID, err := lookupID(ctx, tx, storage.ErrUserNotFound, "SELECT id FROM users WHERE email = ?", email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	roleID, err := lookupID(ctx, tx, storage.ErrRoleNotFound, "SELECT id FROM roles WHERE app_id = ? AND name = ?", appID, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO user_roles(user_id, role_id) VALUES(?, ?)", userID, roleID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleAssigned)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UnassignRole takes the role defined by the app away from the user with given email. Access tokens of the user,
+// issued not later than revokedAt, are denied until expiresAt
+//
+// If the user doesn't have the role, returns error
+func (s *Storage) UnassignRole(ctx context.Context, email string, appID int, role string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.UnassignRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM user_roles
+		WHERE user_id = (SELECT id FROM users WHERE email = ?) AND role_id = (SELECT id FROM roles WHERE app_id = ? AND name = ?)
+		RETURNING user_id`,
+		email, appID, role,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleNotAssigned)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)", userID, revokedAt.UnixMilli(), unixCeil(expiresAt))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveGroup saves group without members in namespace of group.AppID and returns its ID
+func (s *Storage) SaveGroup(ctx context.Context, group models.Group) (int64, error) {
+	const op = "storage.sqlite.SaveGroup"
+
+	stmt, err := s.db.Prepare("INSERT INTO groups(app_id, name, description) VALUES(?, ?, ?)")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := stmt.ExecContext(ctx, group.AppID, group.Name, group.Description)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrGroupExists)
+		}
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return id, nil
+}
+
+// DeleteGroup deletes group with its members, roles and resource grants. Access tokens of members,
+// issued not later than revokedAt, are denied until expiresAt, since they carry roles of the group
+//
+// If group doesn't exist, returns error
+func (s *Storage) DeleteGroup(ctx context.Context, appID int, name string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.DeleteGroup"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	groupID, err := lookupID(ctx, tx, storage.ErrGroupNotFound, "SELECT id FROM groups WHERE app_id = ? AND name = ?", appID, name)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) SELECT user_id, ?, ? FROM group_members WHERE group_id = ?",
+		revokedAt.UnixMilli(), unixCeil(expiresAt), groupID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, query := range []string{
+		"DELETE FROM group_members WHERE group_id = ?",
+		"DELETE FROM group_roles WHERE group_id = ?",
+		"DELETE FROM resource_grants WHERE group_id = ?",
+		"DELETE FROM groups WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, query, groupID); err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AddGroupMember adds the user with given email to the group of the app
+//
+// If user or group doesn't exist, or the user is already a member, returns error
+func (s *Storage) AddGroupMember(ctx context.Context, appID int, group string, email string) error {
+	const op = "storage.sqlite.AddGroupMember"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	groupID, err := lookupID(ctx, tx, storage.ErrGroupNotFound, "SELECT id FROM groups WHERE app_id = ? AND name = ?", appID, group)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	userID, err := lookupID(ctx, tx, storage.ErrUserNotFound, "SELECT id FROM users WHERE email = ?", email)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO group_members(group_id, user_id) VALUES(?, ?)", groupID, userID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrMemberExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// RemoveGroupMember removes the user with given email from the group of the app. Access tokens of the user,
+// issued not later than revokedAt, are denied until expiresAt
+//
+// If the user isn't a member, returns error
+func (s *Storage) RemoveGroupMember(ctx context.Context, appID int, group string, email string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.RemoveGroupMember"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var userID int64
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM group_members
+		WHERE group_id = (SELECT id FROM groups WHERE app_id = ? AND name = ?) AND user_id = (SELECT id FROM users WHERE email = ?)
+		RETURNING user_id`,
+		appID, group, email,
+	).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrMemberNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) VALUES(?, ?, ?)", userID, revokedAt.UnixMilli(), unixCeil(expiresAt))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// AssignGroupRole assigns the role to the group, both are looked up in namespace of the app
+//
+// If group or role doesn't exist, or the group already has the role, returns error
+func (s *Storage) AssignGroupRole(ctx context.Context, appID int, group string, role string) error {
+	const op = "storage.sqlite.AssignGroupRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	groupID, err := lookupID(ctx, tx, storage.ErrGroupNotFound, "SELECT id FROM groups WHERE app_id = ? AND name = ?", appID, group)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	roleID, err := lookupID(ctx, tx, storage.ErrRoleNotFound, "SELECT id FROM roles WHERE app_id = ? AND name = ?", appID, role)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO group_roles(group_id, role_id) VALUES(?, ?)", groupID, roleID)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleAssigned)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// UnassignGroupRole takes the role away from the group. Access tokens of members,
+// issued not later than revokedAt, are denied until expiresAt
+//
+// If the group doesn't have the role, returns error
+func (s *Storage) UnassignGroupRole(ctx context.Context, appID int, group string, role string, revokedAt, expiresAt time.Time) error {
+	const op = "storage.sqlite.UnassignGroupRole"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	var groupID int64
+	err = tx.QueryRowContext(ctx,
+		`DELETE FROM group_roles
+		WHERE group_id = (SELECT id FROM groups WHERE app_id = ? AND name = ?) AND role_id = (SELECT id FROM roles WHERE app_id = ? AND name = ?)
+		RETURNING group_id`,
+		appID, group, appID, role,
+	).Scan(&groupID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%s: %w", op, storage.ErrRoleNotAssigned)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO revoked_tokens(user_id, revoked_at, expires_at) SELECT user_id, ?, ? FROM group_members WHERE group_id = ?",
+		revokedAt.UnixMilli(), unixCeil(expiresAt), groupID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// SaveResourceGrant gives permission on the resource to the user or group of the grant,
+// permission and group are looked up in namespace of the app
+//
+// If permission, user or group doesn't exist, or the grant already exists, returns error
+func (s *Storage) SaveResourceGrant(ctx context.Context, grant models.ResourceGrant) error {
+	const op = "storage.sqlite.SaveResourceGrant"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	permissionID, userID, groupID, err := lookupGrant(ctx, tx, grant)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO resource_grants(permission_id, resource, user_id, group_id) VALUES(?, ?, ?, ?)",
+		permissionID, grant.Resource, userID, groupID,
+	)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+			return fmt.Errorf("%s: %w", op, storage.ErrResourceGrantExists)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// DeleteResourceGrant takes permission on the resource away from the user or group of the grant
+//
+// If there is no such grant, returns error
+func (s *Storage) DeleteResourceGrant(ctx context.Context, grant models.ResourceGrant) error {
+	const op = "storage.sqlite.DeleteResourceGrant"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	permissionID, userID, groupID, err := lookupGrant(ctx, tx, grant)
+	if err != nil {
+		if errors.Is(err, storage.ErrPermissionNotFound) || errors.Is(err, storage.ErrUserNotFound) || errors.Is(err, storage.ErrGroupNotFound) {
+			return fmt.Errorf("%s: %w", op, storage.ErrResourceGrantNotFound)
+		}
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"DELETE FROM resource_grants WHERE permission_id = ? AND resource = ? AND user_id = ? AND group_id = ?",
+		permissionID, grant.Resource, userID, groupID,
+	)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("%s: %w", op, storage.ErrResourceGrantNotFound)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// PermissionGrants returns every way the user holds the permission of the app: roles held directly
+// or through groups, and, if resource isn't empty, grants on the resource to the user or their groups
+//
+// If user or permission doesn't exist, returns error
+func (s *Storage) PermissionGrants(ctx context.Context, userID int64, appID int, permission string, resource string) ([]models.Grant, error) {
+	const op = "storage.sqlite.PermissionGrants"
+
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := lookupID(ctx, tx, storage.ErrUserNotFound, "SELECT id FROM users WHERE id = ?", userID); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	permissionID, err := lookupID(ctx, tx, storage.ErrPermissionNotFound, "SELECT id FROM permissions WHERE app_id = ? AND name = ?", appID, permission)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	// direct roles go first, then roles of groups and resource grants, so the simplest way is reported first.
+	// Resource grants are never given on empty resource, so they don't match checks without resource
+	rows, err := tx.QueryContext(ctx,
+		`SELECT roles.name, '', '', 0 FROM user_roles
+		JOIN roles ON roles.id = user_roles.role_id
+		JOIN role_permissions ON role_permissions.role_id = roles.id
+		WHERE user_roles.user_id = ? AND roles.app_id = ? AND role_permissions.permission_id = ?
+		UNION ALL
+		SELECT roles.name, groups.name, '', 1 FROM group_members
+		JOIN groups ON groups.id = group_members.group_id
+		JOIN group_roles ON group_roles.group_id = groups.id
+		JOIN roles ON roles.id = group_roles.role_id
+		JOIN role_permissions ON role_permissions.role_id = roles.id
+		WHERE group_members.user_id = ? AND roles.app_id = ? AND role_permissions.permission_id = ?
+		UNION ALL
+		SELECT '', '', resource, 2 FROM resource_grants
+		WHERE resource_grants.user_id = ? AND permission_id = ? AND resource = ?
+		UNION ALL
+		SELECT '', groups.name, resource_grants.resource, 3 FROM resource_grants
+		JOIN group_members ON group_members.group_id = resource_grants.group_id
+		JOIN groups ON groups.id = group_members.group_id
+		WHERE group_members.user_id = ? AND resource_grants.permission_id = ? AND resource_grants.resource = ?
+		ORDER BY 4, 1, 2`,
+		userID, appID, permissionID,
+		userID, appID, permissionID,
+		userID, permissionID, resource,
+		userID, permissionID, resource,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var grants []models.Grant
+	for rows.Next() {
+		var grant models.Grant
+		// kind only orders grants
+		var kind int
+		if err := rows.Scan(&grant.Role, &grant.Group, &grant.Resource, &kind); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return grants, nil
+}
+
+// lookupGrant returns IDs of permission and subject of the grant, ID of the subject kind not set is 0
+func lookupGrant(ctx context.Context, tx *sql.Tx, grant models.ResourceGrant) (permissionID, userID, groupID int64, err error) {
+	permissionID, err = lookupID(ctx, tx, storage.ErrPermissionNotFound, "SELECT id FROM permissions WHERE app_id = ? AND name = ?", grant.AppID, grant.Permission)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if grant.Group != "" {
+		groupID, err = lookupID(ctx, tx, storage.ErrGroupNotFound, "SELECT id FROM groups WHERE app_id = ? AND name = ?", grant.AppID, grant.Group)
+	} else {
+		userID, err = lookupID(ctx, tx, storage.ErrUserNotFound, "SELECT id FROM users WHERE email = ?", grant.Email)
+	}
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return permissionID, userID, groupID, nil
+}
+
+// WriteTuples saves relation tuples at a new revision and returns it, either all tuples are saved or none
+//
+// If any tuple already exists, returns error
+func (s *Storage) WriteTuples(ctx context.Context, tuples []models.RelationTuple) (int64, error) {
+	const op = "storage.sqlite.WriteTuples"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	revision, err := nextRevision(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, tuple := range tuples {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO relation_tuples(namespace, object_id, relation, subject_namespace, subject_id, subject_relation, revision)
+			VALUES(?, ?, ?, ?, ?, ?, ?)`,
+			tuple.Namespace, tuple.ObjectID, tuple.Relation, tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation, revision,
+		)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey {
+				return 0, fmt.Errorf("%s: %w", op, storage.ErrTupleExists)
+			}
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revision, nil
+}
+
+// DeleteTuples deletes relation tuples at a new revision and returns it, either all tuples are deleted or none
+//
+// If any tuple doesn't exist, returns error
+func (s *Storage) DeleteTuples(ctx context.Context, tuples []models.RelationTuple) (int64, error) {
+	const op = "storage.sqlite.DeleteTuples"
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+	defer tx.Rollback()
+
+	revision, err := nextRevision(ctx, tx)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	for _, tuple := range tuples {
+		res, err := tx.ExecContext(ctx,
+			`DELETE FROM relation_tuples
+			WHERE namespace = ? AND object_id = ? AND relation = ? AND subject_namespace = ? AND subject_id = ? AND subject_relation = ?`,
+			tuple.Namespace, tuple.ObjectID, tuple.Relation, tuple.Subject.Namespace, tuple.Subject.ID, tuple.Subject.Relation,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", op, err)
+		}
+		if affected == 0 {
+			return 0, fmt.Errorf("%s: %w", op, storage.ErrTupleNotFound)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revision, nil
+}
+
+// Tuples returns relation tuples matching the filter, ordered by object, relation and subject
+func (s *Storage) Tuples(ctx context.Context, filter models.TupleFilter) ([]models.RelationTuple, error) {
+	const op = "storage.sqlite.Tuples"
+
+	query := "SELECT namespace, object_id, relation, subject_namespace, subject_id, subject_relation FROM relation_tuples WHERE namespace = ?"
+	args := []any{filter.Namespace}
+	for _, cond := range []struct {
+		column string
+		value  string
+	}{
+		{"object_id", filter.ObjectID},
+		{"relation", filter.Relation},
+		{"subject_namespace", filter.Subject.Namespace},
+		{"subject_id", filter.Subject.ID},
+		{"subject_relation", filter.Subject.Relation},
+	} {
+		if cond.value != "" {
+			query += " AND " + cond.column + " = ?"
+			args = append(args, cond.value)
+		}
+	}
+	query += " ORDER BY object_id, relation, subject_namespace, subject_id, subject_relation"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var tuples []models.RelationTuple
+	for rows.Next() {
+		var tuple models.RelationTuple
+		err := rows.Scan(&tuple.Namespace, &tuple.ObjectID, &tuple.Relation, &tuple.Subject.Namespace, &tuple.Subject.ID, &tuple.Subject.Relation)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		tuples = append(tuples, tuple)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tuples, nil
+}
+
+// ObjectIDs returns IDs of objects of the namespace having any tuple, other objects hold no relations
+func (s *Storage) ObjectIDs(ctx context.Context, namespace string) ([]string, error) {
+	const op = "storage.sqlite.ObjectIDs"
+
+	rows, err := s.db.QueryContext(ctx, "SELECT DISTINCT object_id FROM relation_tuples WHERE namespace = ? ORDER BY object_id", namespace)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return ids, nil
+}
+
+// RelationRevision returns revision of the latest write of relation tuples, zero if there were none
+func (s *Storage) RelationRevision(ctx context.Context) (int64, error) {
+	const op = "storage.sqlite.RelationRevision"
+
+	var revision int64
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(revision), 0) FROM relation_revisions").Scan(&revision); err != nil {
+		return 0, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return revision, nil
+}
+
+// nextRevision starts a new revision of relation tuples in the transaction
+func nextRevision(ctx context.Context, tx *sql.Tx) (int64, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO relation_revisions(changed_at) VALUES(?)", time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// updateDescription sets description of the role or permission with given name in namespace of the app,
+// query takes description, app ID and name
+func (s *Storage) updateDescription(ctx context.Context, query string, appID int, name string, description string, notFound error) error {
+	res, err := s.db.ExecContext(ctx, query, description, appID, name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// lookupID returns ID selected by query, or notFound error if there is no row
+func lookupID(ctx context.Context, tx *sql.Tx, notFound error, query string, args ...any) (int64, error) {
+	var id int64
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, notFound
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
@@ -9,4 +9,45 @@ var (
 	ErrUserNotFound  = errors.New("user not found")
 	ErrAppNotFound   = errors.New("app not found")
 	ErrAppExists     = errors.New("app already exists")
+
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+	ErrRefreshTokenUsed     = errors.New("refresh token already used")
+
+	ErrAuthCodeNotFound = errors.New("auth code not found")
+	ErrAuthCodeUsed     = errors.New("auth code already used")
+
+	ErrDeviceCodeNotFound = errors.New("device code not found")
+
+	ErrMFANotFound          = errors.New("mfa not found")
+	ErrMFAEnabled           = errors.New("mfa already enabled")
+	ErrMFAStepUsed          = errors.New("mfa code already used")
+	ErrRecoveryCodeNotFound = errors.New("recovery code not found")
+	ErrMFAChallengeNotFound = errors.New("mfa challenge not found")
+
+	ErrPasskeyExists          = errors.New("passkey already exists")
+	ErrPasskeyNotFound        = errors.New("passkey not found")
+	ErrPasskeySessionNotFound = errors.New("passkey session not found")
+
+	ErrPasswordResetNotFound = errors.New("password reset not found")
+
+	ErrLoginAttemptsNotFound = errors.New("login attempts not found")
+
+	ErrRoleExists           = errors.New("role already exists")
+	ErrRoleNotFound         = errors.New("role not found")
+	ErrRoleAssigned         = errors.New("role already assigned")
+	ErrRoleNotAssigned      = errors.New("role not assigned")
+	ErrPermissionExists     = errors.New("permission already exists")
+	ErrPermissionNotFound   = errors.New("permission not found")
+	ErrPermissionGranted    = errors.New("permission already granted")
+	ErrPermissionNotGranted = errors.New("permission not granted")
+
+	ErrGroupExists           = errors.New("group already exists")
+	ErrGroupNotFound         = errors.New("group not found")
+	ErrMemberExists          = errors.New("user already in group")
+	ErrMemberNotFound        = errors.New("user not in group")
+	ErrResourceGrantExists   = errors.New("resource grant already exists")
+	ErrResourceGrantNotFound = errors.New("resource grant not found")
+
+	ErrTupleExists   = errors.New("relation tuple already exists")
+	ErrTupleNotFound = errors.New("relation tuple not found")
 )
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS token_exchanges
+(
+    id              INTEGER PRIMARY KEY,
+    jti             TEXT    NOT NULL UNIQUE,
+    client_id       TEXT    NOT NULL,
+    audience        TEXT    NOT NULL,
+    user_id         INTEGER NOT NULL,
+    actor_subject   TEXT    NOT NULL,
+    scope           TEXT    NOT NULL DEFAULT '',
+    impersonation   BOOLEAN NOT NULL DEFAULT FALSE,
+    expires_at      INTEGER NOT NULL,
+    created_at      INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_token_exchanges_user_id ON token_exchanges(user_id);
@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS user_mfa
+(
+    user_id     INTEGER PRIMARY KEY,
+    secret      TEXT    NOT NULL,
+    enabled     BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step   INTEGER NOT NULL DEFAULT 0,
+    created_at  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    id          INTEGER PRIMARY KEY,
+    user_id     INTEGER NOT NULL,
+    code_hash   TEXT    NOT NULL,
+    used        BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_challenges
+(
+    id          INTEGER PRIMARY KEY,
+    token_hash  TEXT    NOT NULL UNIQUE,
+    user_id     INTEGER NOT NULL,
+    app_id      INTEGER NOT NULL,
+    attempts    INTEGER NOT NULL DEFAULT 0,
+    expires_at  INTEGER NOT NULL,
+    created_at  INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS passkey_sessions;
+DROP TABLE IF EXISTS passkeys;
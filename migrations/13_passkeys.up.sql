@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS passkeys
+(
+    id               INTEGER PRIMARY KEY,
+    user_id          INTEGER NOT NULL,
+    credential_id    BLOB    NOT NULL UNIQUE,
+    public_key       BLOB    NOT NULL,
+    attestation_type TEXT    NOT NULL DEFAULT '',
+    aaguid           BLOB,
+    sign_count       INTEGER NOT NULL DEFAULT 0,
+    transports       TEXT    NOT NULL DEFAULT '',
+    backup_eligible  BOOLEAN NOT NULL DEFAULT FALSE,
+    backup_state     BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at       INTEGER NOT NULL,
+    last_used_at     INTEGER NOT NULL DEFAULT 0,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys(user_id);
+
+CREATE TABLE IF NOT EXISTS passkey_sessions
+(
+    id               INTEGER PRIMARY KEY,
+    token_hash       TEXT    NOT NULL UNIQUE,
+    ceremony         TEXT    NOT NULL,
+    user_id          INTEGER NOT NULL DEFAULT 0,
+    app_id           INTEGER NOT NULL DEFAULT 0,
+    mfa_challenge_id INTEGER NOT NULL DEFAULT 0,
+    data             TEXT    NOT NULL,
+    expires_at       INTEGER NOT NULL,
+    created_at       INTEGER NOT NULL
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS login_attempts
+(
+    key           TEXT    PRIMARY KEY,
+    failures      INTEGER NOT NULL,
+    blocked_until INTEGER NOT NULL DEFAULT 0,
+    expires_at    INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_expires_at ON login_attempts(expires_at);
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS admins
+(
+    id INTEGER    PRIMARY KEY,
+    email TEXT    NOT NULL UNIQUE,
+    level INTEGER NOT NULL CHECK(level IN(1, 2, 3))
+);
+CREATE INDEX IF NOT EXISTS idx_email ON admins(email);
+
+-- custom roles have no level, their holders lose admin rights
+INSERT INTO admins (email, level)
+SELECT users.email, MAX(CASE roles.name WHEN 'moderator' THEN 1 WHEN 'admin' THEN 2 ELSE 3 END)
+FROM user_roles
+JOIN users ON users.id = user_roles.user_id
+JOIN roles ON roles.id = user_roles.role_id
+WHERE roles.name IN ('moderator', 'admin', 'superadmin')
+GROUP BY users.email;
+
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS role_permissions;
+DROP TABLE IF EXISTS permissions;
+DROP TABLE IF EXISTS roles;
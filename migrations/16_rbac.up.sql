@@ -0,0 +1,64 @@
+CREATE TABLE IF NOT EXISTS roles
+(
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions
+(
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions
+(
+    role_id       INTEGER NOT NULL,
+    permission_id INTEGER NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_role_permissions_permission_id ON role_permissions(permission_id);
+
+CREATE TABLE IF NOT EXISTS user_roles
+(
+    user_id INTEGER NOT NULL,
+    role_id INTEGER NOT NULL,
+    PRIMARY KEY (user_id, role_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_user_roles_role_id ON user_roles(role_id);
+
+INSERT INTO permissions (name, description)
+VALUES ('users:read', 'Read users and their roles'),
+       ('users:unlock', 'Unlock users locked out after failed logins'),
+       ('users:impersonate', 'Impersonate users with less permissions by token exchange'),
+       ('mfa:reset', 'Reset second factor of users'),
+       ('keys:rotate', 'Rotate token signing keys'),
+       ('admins:manage', 'Grant and take away the admin role'),
+       ('roles:manage', 'Manage roles, permissions and their assignments')
+ON CONFLICT DO NOTHING;
+
+-- roles replace admin levels, level 1 gave no access to admin endpoints
+INSERT INTO roles (name, description)
+VALUES ('moderator', 'Former admin level 1'),
+       ('admin', 'Former admin level 2'),
+       ('superadmin', 'Former admin level 3, has every permission')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT roles.id, permissions.id FROM roles, permissions
+WHERE roles.name = 'superadmin'
+   OR (roles.name = 'admin' AND permissions.name != 'roles:manage')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO user_roles (user_id, role_id)
+SELECT users.id, roles.id FROM admins
+JOIN users ON users.email = admins.email
+JOIN roles ON roles.name = CASE admins.level WHEN 1 THEN 'moderator' WHEN 2 THEN 'admin' ELSE 'superadmin' END
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS admins;
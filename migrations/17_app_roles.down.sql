@@ -0,0 +1,24 @@
+-- roles and permissions of apps are dropped with their assignments
+DELETE FROM user_roles WHERE role_id IN (SELECT id FROM roles WHERE app_id != 0);
+DELETE FROM role_permissions WHERE role_id IN (SELECT id FROM roles WHERE app_id != 0);
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE app_id != 0);
+
+CREATE TABLE IF NOT EXISTS roles_old
+(
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT ''
+);
+INSERT INTO roles_old (id, name, description) SELECT id, name, description FROM roles WHERE app_id = 0;
+DROP TABLE roles;
+ALTER TABLE roles_old RENAME TO roles;
+
+CREATE TABLE IF NOT EXISTS permissions_old
+(
+    id          INTEGER PRIMARY KEY,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT ''
+);
+INSERT INTO permissions_old (id, name, description) SELECT id, name, description FROM permissions WHERE app_id = 0;
+DROP TABLE permissions;
+ALTER TABLE permissions_old RENAME TO permissions;
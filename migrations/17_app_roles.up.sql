@@ -0,0 +1,25 @@
+-- roles and permissions belong to an app, app_id 0 is namespace of the service itself.
+-- Names become unique within namespace, so tables are rebuilt
+CREATE TABLE IF NOT EXISTS roles_new
+(
+    id          INTEGER PRIMARY KEY,
+    app_id      INTEGER NOT NULL DEFAULT 0,
+    name        TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    UNIQUE (app_id, name)
+);
+INSERT INTO roles_new (id, name, description) SELECT id, name, description FROM roles;
+DROP TABLE roles;
+ALTER TABLE roles_new RENAME TO roles;
+
+CREATE TABLE IF NOT EXISTS permissions_new
+(
+    id          INTEGER PRIMARY KEY,
+    app_id      INTEGER NOT NULL DEFAULT 0,
+    name        TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    UNIQUE (app_id, name)
+);
+INSERT INTO permissions_new (id, name, description) SELECT id, name, description FROM permissions;
+DROP TABLE permissions;
+ALTER TABLE permissions_new RENAME TO permissions;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS resource_grants;
+DROP VIEW IF EXISTS role_holders;
+DROP TABLE IF EXISTS group_roles;
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
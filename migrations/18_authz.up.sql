@@ -0,0 +1,50 @@
+-- groups are sets of users of an app namespace, members hold roles assigned to the group
+CREATE TABLE IF NOT EXISTS groups
+(
+    id          INTEGER PRIMARY KEY,
+    app_id      INTEGER NOT NULL DEFAULT 0,
+    name        TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    UNIQUE (app_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS group_members
+(
+    group_id INTEGER NOT NULL,
+    user_id  INTEGER NOT NULL,
+    PRIMARY KEY (group_id, user_id),
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_group_members_user_id ON group_members(user_id);
+
+CREATE TABLE IF NOT EXISTS group_roles
+(
+    group_id INTEGER NOT NULL,
+    role_id  INTEGER NOT NULL,
+    PRIMARY KEY (group_id, role_id),
+    FOREIGN KEY (group_id) REFERENCES groups(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_group_roles_role_id ON group_roles(role_id);
+
+-- role_holders are users holding roles directly or through groups
+CREATE VIEW IF NOT EXISTS role_holders AS
+SELECT user_id, role_id FROM user_roles
+UNION
+SELECT group_members.user_id, group_roles.role_id
+FROM group_members
+JOIN group_roles ON group_roles.group_id = group_members.group_id;
+
+-- resource_grants give permission on a single resource to a user or a group, the other ID is 0
+CREATE TABLE IF NOT EXISTS resource_grants
+(
+    permission_id INTEGER NOT NULL,
+    resource      TEXT    NOT NULL,
+    user_id       INTEGER NOT NULL DEFAULT 0,
+    group_id      INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (permission_id, resource, user_id, group_id),
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_resource_grants_user_id ON resource_grants(user_id);
+CREATE INDEX IF NOT EXISTS idx_resource_grants_group_id ON resource_grants(group_id);
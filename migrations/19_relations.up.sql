@@ -0,0 +1,21 @@
+-- relation_revisions numbers writes of relation tuples, consistency tokens carry the revision
+CREATE TABLE IF NOT EXISTS relation_revisions
+(
+    revision   INTEGER PRIMARY KEY AUTOINCREMENT,
+    changed_at INTEGER NOT NULL
+);
+
+-- relation_tuples relate objects to subjects, subject_relation is set when subject is a set of subjects
+-- holding the relation of the subject object, e.g. group:eng#member
+CREATE TABLE IF NOT EXISTS relation_tuples
+(
+    namespace         TEXT    NOT NULL,
+    object_id         TEXT    NOT NULL,
+    relation          TEXT    NOT NULL,
+    subject_namespace TEXT    NOT NULL,
+    subject_id        TEXT    NOT NULL,
+    subject_relation  TEXT    NOT NULL DEFAULT '',
+    revision          INTEGER NOT NULL,
+    PRIMARY KEY (namespace, object_id, relation, subject_namespace, subject_id, subject_relation)
+);
+CREATE INDEX IF NOT EXISTS idx_relation_tuples_subject ON relation_tuples(subject_namespace, subject_id, subject_relation);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refresh_tokens
+(
+    id          INTEGER   PRIMARY KEY,
+    token_hash  TEXT      NOT NULL UNIQUE,
+    family      TEXT      NOT NULL,
+    user_id     INTEGER   NOT NULL,
+    expires_at  TIMESTAMP NOT NULL,
+    used        BOOLEAN   NOT NULL DEFAULT FALSE,
+    revoked     BOOLEAN   NOT NULL DEFAULT FALSE,
+    created_at  TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family);
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+    id          INTEGER PRIMARY KEY,
+    jti         TEXT    UNIQUE,
+    user_id     INTEGER NOT NULL,
+    -- instant of revocation in milliseconds, it's compared with iat, which has millisecond precision
+    revoked_at  INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_user_id ON revoked_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens(expires_at);
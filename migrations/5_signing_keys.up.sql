@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS signing_keys
+(
+    kid         TEXT    PRIMARY KEY,
+    algorithm   TEXT    NOT NULL,
+    private_key BLOB    NOT NULL,
+    state       TEXT    NOT NULL CHECK(state IN('active', 'retiring', 'retired')),
+    created_at  INTEGER NOT NULL,
+    rotated_at  INTEGER
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_signing_keys_active ON signing_keys(state) WHERE state = 'active';
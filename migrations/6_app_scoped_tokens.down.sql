@@ -0,0 +1,3 @@
+ALTER TABLE refresh_tokens DROP COLUMN app_id;
+
+ALTER TABLE apps DROP COLUMN token_ttl;
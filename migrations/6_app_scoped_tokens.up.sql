@@ -0,0 +1,3 @@
+ALTER TABLE apps ADD COLUMN token_ttl INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE refresh_tokens ADD COLUMN app_id INTEGER NOT NULL DEFAULT 0;
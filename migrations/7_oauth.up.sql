@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS app_redirect_uris
+(
+    id      INTEGER PRIMARY KEY,
+    app_id  INTEGER NOT NULL,
+    uri     TEXT    NOT NULL,
+    UNIQUE (app_id, uri),
+    FOREIGN KEY (app_id) REFERENCES apps(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS auth_codes
+(
+    id              INTEGER PRIMARY KEY,
+    code_hash       TEXT    NOT NULL UNIQUE,
+    family          TEXT    NOT NULL,
+    app_id          INTEGER NOT NULL,
+    user_id         INTEGER NOT NULL,
+    redirect_uri    TEXT    NOT NULL,
+    code_challenge  TEXT    NOT NULL,
+    expires_at      INTEGER NOT NULL,
+    used            BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at      INTEGER NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,2 @@
+ALTER TABLE apps DROP COLUMN scopes;
+ALTER TABLE apps DROP COLUMN client_secret_hash;
@@ -0,0 +1,2 @@
+ALTER TABLE apps ADD COLUMN client_secret_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE apps ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,6 @@
+ALTER TABLE refresh_tokens DROP COLUMN scope;
+
+ALTER TABLE auth_codes DROP COLUMN nonce;
+ALTER TABLE auth_codes DROP COLUMN scope;
+
+ALTER TABLE users DROP COLUMN email_verified;
@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE auth_codes ADD COLUMN scope TEXT NOT NULL DEFAULT '';
+ALTER TABLE auth_codes ADD COLUMN nonce TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE refresh_tokens ADD COLUMN scope TEXT NOT NULL DEFAULT '';
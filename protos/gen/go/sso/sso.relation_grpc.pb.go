@@ -0,0 +1,249 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v4.25.2
+// source: sso/sso.relation.proto
+
+package ssov1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RelationClient is the client API for Relation service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RelationClient interface {
+	WriteTuples(ctx context.Context, in *WriteTuplesRequest, opts ...grpc.CallOption) (*WriteTuplesResponse, error)
+	DeleteTuples(ctx context.Context, in *DeleteTuplesRequest, opts ...grpc.CallOption) (*DeleteTuplesResponse, error)
+	ReadTuples(ctx context.Context, in *ReadTuplesRequest, opts ...grpc.CallOption) (*ReadTuplesResponse, error)
+	Check(ctx context.Context, in *RelationCheckRequest, opts ...grpc.CallOption) (*RelationCheckResponse, error)
+	LookupResources(ctx context.Context, in *LookupResourcesRequest, opts ...grpc.CallOption) (*LookupResourcesResponse, error)
+}
+
+type relationClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRelationClient(cc grpc.ClientConnInterface) RelationClient {
+	return &relationClient{cc}
+}
+
+func (c *relationClient) WriteTuples(ctx context.Context, in *WriteTuplesRequest, opts ...grpc.CallOption) (*WriteTuplesResponse, error) {
+	out := new(WriteTuplesResponse)
+	err := c.cc.Invoke(ctx, "/relation.Relation/WriteTuples", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relationClient) DeleteTuples(ctx context.Context, in *DeleteTuplesRequest, opts ...grpc.CallOption) (*DeleteTuplesResponse, error) {
+	out := new(DeleteTuplesResponse)
+	err := c.cc.Invoke(ctx, "/relation.Relation/DeleteTuples", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relationClient) ReadTuples(ctx context.Context, in *ReadTuplesRequest, opts ...grpc.CallOption) (*ReadTuplesResponse, error) {
+	out := new(ReadTuplesResponse)
+	err := c.cc.Invoke(ctx, "/relation.Relation/ReadTuples", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relationClient) Check(ctx context.Context, in *RelationCheckRequest, opts ...grpc.CallOption) (*RelationCheckResponse, error) {
+	out := new(RelationCheckResponse)
+	err := c.cc.Invoke(ctx, "/relation.Relation/Check", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *relationClient) LookupResources(ctx context.Context, in *LookupResourcesRequest, opts ...grpc.CallOption) (*LookupResourcesResponse, error) {
+	out := new(LookupResourcesResponse)
+	err := c.cc.Invoke(ctx, "/relation.Relation/LookupResources", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// RelationServer is the server API for Relation service.
+// All implementations must embed UnimplementedRelationServer
+// for forward compatibility
+type RelationServer interface {
+	WriteTuples(context.Context, *WriteTuplesRequest) (*WriteTuplesResponse, error)
+	DeleteTuples(context.Context, *DeleteTuplesRequest) (*DeleteTuplesResponse, error)
+	ReadTuples(context.Context, *ReadTuplesRequest) (*ReadTuplesResponse, error)
+	Check(context.Context, *RelationCheckRequest) (*RelationCheckResponse, error)
+	LookupResources(context.Context, *LookupResourcesRequest) (*LookupResourcesResponse, error)
+	mustEmbedUnimplementedRelationServer()
+}
+
+// UnimplementedRelationServer must be embedded to have forward compatible implementations.
+type UnimplementedRelationServer struct {
+}
+
+func (UnimplementedRelationServer) WriteTuples(context.Context, *WriteTuplesRequest) (*WriteTuplesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WriteTuples not implemented")
+}
+func (UnimplementedRelationServer) DeleteTuples(context.Context, *DeleteTuplesRequest) (*DeleteTuplesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteTuples not implemented")
+}
+func (UnimplementedRelationServer) ReadTuples(context.Context, *ReadTuplesRequest) (*ReadTuplesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReadTuples not implemented")
+}
+func (UnimplementedRelationServer) Check(context.Context, *RelationCheckRequest) (*RelationCheckResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Check not implemented")
+}
+func (UnimplementedRelationServer) LookupResources(context.Context, *LookupResourcesRequest) (*LookupResourcesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LookupResources not implemented")
+}
+func (UnimplementedRelationServer) mustEmbedUnimplementedRelationServer() {}
+
+// UnsafeRelationServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RelationServer will
+// result in compilation errors.
+type UnsafeRelationServer interface {
+	mustEmbedUnimplementedRelationServer()
+}
+
+func RegisterRelationServer(s grpc.ServiceRegistrar, srv RelationServer) {
+	s.RegisterService(&Relation_ServiceDesc, srv)
+}
+
+func _Relation_WriteTuples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteTuplesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelationServer).WriteTuples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/relation.Relation/WriteTuples",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelationServer).WriteTuples(ctx, req.(*WriteTuplesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Relation_DeleteTuples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTuplesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelationServer).DeleteTuples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/relation.Relation/DeleteTuples",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelationServer).DeleteTuples(ctx, req.(*DeleteTuplesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Relation_ReadTuples_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReadTuplesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelationServer).ReadTuples(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/relation.Relation/ReadTuples",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelationServer).ReadTuples(ctx, req.(*ReadTuplesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Relation_Check_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RelationCheckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelationServer).Check(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/relation.Relation/Check",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelationServer).Check(ctx, req.(*RelationCheckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Relation_LookupResources_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LookupResourcesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RelationServer).LookupResources(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/relation.Relation/LookupResources",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RelationServer).LookupResources(ctx, req.(*LookupResourcesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Relation_ServiceDesc is the grpc.ServiceDesc for Relation service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Relation_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "relation.Relation",
+	HandlerType: (*RelationServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WriteTuples",
+			Handler:    _Relation_WriteTuples_Handler,
+		},
+		{
+			MethodName: "DeleteTuples",
+			Handler:    _Relation_DeleteTuples_Handler,
+		},
+		{
+			MethodName: "ReadTuples",
+			Handler:    _Relation_ReadTuples_Handler,
+		},
+		{
+			MethodName: "Check",
+			Handler:    _Relation_Check_Handler,
+		},
+		{
+			MethodName: "LookupResources",
+			Handler:    _Relation_LookupResources_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "sso/sso.relation.proto",
+}